@@ -0,0 +1,92 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/distribution/reference"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var authCheckCmd = &cobra.Command{
+	Use:   "check <ref>",
+	Short: "checks which credentials dazzle uses for a repository and what they may do",
+	Long: `Checks which credentials dazzle uses for the registry of a ref and whether they suffice to resolve the ref,
+to read a blob of its manifest and to push to its repository. The push check starts an upload and cancels it
+right away, hence it pushes nothing. --read-only skips it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if rootCfg.Offline {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("auth check needs the registry: %w", dazzle.ErrOffline)}
+		}
+		ref, err := reference.ParseNormalizedNamed(args[0])
+		if err != nil {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("cannot parse ref: %w", err)}
+		}
+
+		res, err := dazzle.CheckAuth(cmd.Context(), log.StandardLogger(), config.LoadDefaultConfigFile(os.Stderr), ref, !rootCfg.ReadOnly)
+		if err != nil {
+			return err
+		}
+
+		creds := "none - anonymous access"
+		if !res.Anonymous {
+			creds = res.Credentials.Source
+			if res.Credentials.Username != "" {
+				creds += fmt.Sprintf(" (user %s)", res.Credentials.Username)
+			}
+		}
+		fmt.Printf("registry:    %s\ncredentials: %s\n\n", res.Host, creds)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tREQUEST\tRESULT")
+		for _, s := range res.Steps {
+			result := "ok"
+			switch {
+			case s.Skipped != "":
+				result = "skipped: " + s.Skipped
+			case s.Err != nil:
+				result = "failed: " + s.Err.Error()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, s.Request, result)
+		}
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+
+		if res.Failed() {
+			return &dazzle.Error{Kind: dazzle.ErrorKindRegistry, Err: fmt.Errorf("credentials for %s do not suffice", res.Host)}
+		}
+		return nil
+	},
+}
+
+func init() {
+	authCmd.AddCommand(authCheckCmd)
+}
@@ -0,0 +1,97 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var baseOutdatedCmd = &cobra.Command{
+	Use:   "outdated <target-ref>",
+	Short: "Checks whether the images the base Dockerfile builds FROM have newer digests",
+	Long: `Checks whether the images the base Dockerfile builds FROM point to newer digests than the ones the base
+image was built on, and reports which chunk hashes and combinations a base bump would invalidate.
+
+Images pinned by digest are compared against the digest their tag points to now. The image of the
+final stage, if not pinned, is compared against the base image built for target-ref.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			return fmt.Errorf("unknown output format %q, must be text or json", output)
+		}
+
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		if err != nil {
+			return err
+		}
+
+		sess, err := dazzle.NewSession(nil, args[0], dazzle.WithResolver(getResolver()))
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+
+		res, err := prj.BaseOutdated(ctx, sess)
+		if err != nil {
+			return err
+		}
+
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "IMAGE\tPINNED\tCURRENT\tSTATE\tREASON")
+		for _, img := range res.Images {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", img.Image, orNone(img.Pinned.String()), orNone(img.Current.String()), img.State, img.Reason)
+		}
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+
+		if !res.Outdated() {
+			return nil
+		}
+		fmt.Println()
+		fmt.Printf("A base bump changes the hashes of all %d chunks: %s\n", len(res.Chunks), strings.Join(res.Chunks, ", "))
+		fmt.Printf("Combinations to rebuild: %s\n", orNone(strings.Join(res.Combinations, ", ")))
+		return nil
+	},
+}
+
+func init() {
+	baseCmd.AddCommand(baseOutdatedCmd)
+
+	baseOutdatedCmd.Flags().StringP("output", "o", "text", "output format: text or json")
+}
@@ -22,11 +22,18 @@ package core
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/moby/buildkit/client"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/gitpod-io/dazzle/pkg/dazzle"
+	"github.com/gitpod-io/dazzle/pkg/test"
 )
 
 // buildCmd represents the build command
@@ -36,31 +43,116 @@ var buildCmd = &cobra.Command{
 	Args:  cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		nocache, _ := cmd.Flags().GetBool("no-cache")
+		notest, _ := cmd.Flags().GetBool("no-test")
+		failFast, _ := cmd.Flags().GetBool("fail-fast")
+		testsOnly, _ := cmd.Flags().GetBool("tests-only")
 		plainOutput, _ := cmd.Flags().GetBool("plain-output")
+		prefixedOutput, _ := cmd.Flags().GetBool("prefixed-output")
 		cwh, _ := cmd.Flags().GetBool("chunked-without-hash")
+		testTimeout, _ := cmd.Flags().GetDuration("test-timeout")
+		verifyBase, _ := cmd.Flags().GetString("verify-base")
+		verifyBaseKey, _ := cmd.Flags().GetString("verify-base-key")
+		ifExists, _ := cmd.Flags().GetString("if-exists")
+		redactHistory, _ := cmd.Flags().GetBool("redact-history")
+		expiresAfter, _ := cmd.Flags().GetDuration("expires-after")
+		solveRetries, _ := cmd.Flags().GetInt("solve-retries")
+		heartbeat, _ := cmd.Flags().GetDuration("heartbeat")
+		platform, _ := cmd.Flags().GetString("platform")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if !dryRun {
+			err := checkWritable("build without --dry-run")
+			if err != nil {
+				return err
+			}
+		}
+		filterExprs, _ := cmd.Flags().GetStringArray("filter")
+		filters, err := test.ParseFilters(filterExprs)
+		if err != nil {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: err}
+		}
+		scan, err := getImageScan(cmd)
+		if err != nil {
+			return err
+		}
 
+		start := time.Now()
 		var targetref = args[0]
+		ctx := cmd.Context()
+
+		_, err = dazzle.FetchImports(ctx, log.StandardLogger(), rootCfg.ContextDir, getResolver(), false)
+		if err != nil {
+			return err
+		}
 		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
 		if err != nil {
 			return err
 		}
 
-		cl, err := client.New(context.Background(), rootCfg.BuildkitAddr, client.WithFailFast())
+		var (
+			cl            *client.Client
+			workerClients []*client.Client
+		)
+		if !dryRun {
+			cl, err = client.New(ctx, rootCfg.BuildkitAddr, client.WithFailFast())
+			if err != nil {
+				return err
+			}
+			workerAddrs, _ := cmd.Flags().GetStringArray("worker-addr")
+			for _, addr := range workerAddrs {
+				wcl, err := client.New(ctx, addr, client.WithFailFast())
+				if err != nil {
+					return err
+				}
+				workerClients = append(workerClients, wcl)
+			}
+		}
+
+		rerunFailed, _ := cmd.Flags().GetBool("rerun-failed")
+		failures, failuresFN, err := loadFailureLog()
 		if err != nil {
 			return err
 		}
 
 		session, err := dazzle.NewSession(cl, targetref,
 			dazzle.WithResolver(getResolver()),
+			dazzle.WithFailureLog(failures, rerunFailed),
 			dazzle.WithNoCache(nocache),
+			dazzle.WithSkipTests(notest),
+			dazzle.WithFailFast(failFast),
+			dazzle.WithTestsOnly(testsOnly),
 			dazzle.WithPlainOutput(plainOutput),
+			dazzle.WithPrefixedOutput(prefixedOutput),
 			dazzle.WithChunkedWithoutHash(cwh),
+			dazzle.WithTestFilters(filters...),
+			dazzle.WithTestTimeout(testTimeout),
+			dazzle.WithIfExists(dazzle.IfExistsPolicy(ifExists)),
+			dazzle.WithRedactHistory(redactHistory),
+			dazzle.WithExpiresAfter(expiresAfter),
+			dazzle.WithWorkerClients(workerClients...),
+			dazzle.WithSolveRetries(solveRetries),
+			dazzle.WithHeartbeat(heartbeat),
+			dazzle.WithPlatform(platform),
+			dazzle.WithBaseVerification(dazzle.BaseVerification{
+				Mode: dazzle.BaseVerificationMode(verifyBase),
+				Key:  verifyBaseKey,
+			}),
 		)
 		if err != nil {
 			return err
 		}
+		if dryRun {
+			return printBuildPlan(ctx, prj, session)
+		}
 
-		err = prj.Build(context.Background(), session)
+		err = prj.Build(ctx, session)
+		if err == nil {
+			err = scan.run(ctx, session)
+		}
+		writeTestReports(cmd, session)
+		writeTimings(cmd, session)
+		writeGitHub(cmd, session)
+		notifyWebhook(cmd, "build", session, time.Since(start), err)
+		saveFailureLog(failures, failuresFN)
 		if err != nil {
 			return err
 		}
@@ -75,6 +167,171 @@ func init() {
 	rootCmd.AddCommand(buildCmd)
 
 	buildCmd.Flags().Bool("no-cache", false, "disables the buildkit build cache")
+	buildCmd.Flags().Bool("no-test", false, "disables the tests")
+	buildCmd.Flags().Bool("tests-only", false, "build the base and test images and run the tests, but do not build or push the chunk images (the full images of dependencies are pushed)")
+	buildCmd.Flags().Bool("fail-fast", true, "stop at the first chunk whose tests fail, otherwise build all chunks whose tests passed and report all failures")
 	buildCmd.Flags().Bool("plain-output", false, "produce plain output")
+	buildCmd.Flags().Bool("prefixed-output", false, "prefix every line of the build output with its chunk")
 	buildCmd.Flags().Bool("chunked-without-hash", false, "disable hash qualification for chunked image")
+	buildCmd.Flags().String("if-exists", string(dazzle.IfExistsSkip), "what to do with images which exist already: skip building them, rebuild them, or verify they were built on the current base image and rebuild them otherwise")
+	buildCmd.Flags().StringArray("worker-addr", nil, "address of another buildkitd, whose default worker builds the chunks which require its worker labels (can be repeated)")
+	buildCmd.Flags().Int("solve-retries", 2, "resume builds whose connection to buildkitd dropped up to this many times")
+	buildCmd.Flags().Duration("heartbeat", 30*time.Second, "check this often that buildkitd still responds and fail the build after two missed checks - 0 disables the check")
+	buildCmd.Flags().String("platform", "", "build for this platform, e.g. linux/arm64, rather than the one dazzle runs on")
+	buildCmd.Flags().Duration("expires-after", 0, "annotate the chunked images and test results with the time after which registry lifecycle policies may remove them, e.g. 336h")
+	buildCmd.Flags().Bool("redact-history", false, "strip the build steps, which can contain build args, from the history of the chunked images")
+	buildCmd.Flags().StringArray("filter", nil, "only run tests matching the filter, e.g. tag=gpu, tag!=slow or desc~=python (can be repeated)")
+	buildCmd.Flags().Bool("rerun-failed", false, "only run the tests which failed in the previous run")
+	buildCmd.Flags().Duration("test-timeout", test.DefaultTimeout, "time each test may take")
+	buildCmd.Flags().String("verify-base", "", "refuse to build the base image on images which are not pinned by digest (pinned) or not signed (cosign)")
+	buildCmd.Flags().String("verify-base-key", "", "public key to verify the cosign signatures of the images the base image builds on")
+	buildCmd.Flags().Bool("dry-run", false, "list the images the build would produce and which of them exist already, without building or pushing anything")
+	buildCmd.Flags().String("output-timings", "", "save the duration of each build phase as JSON file")
+	addTestReportFlags(buildCmd)
+	addGitHubFlag(buildCmd)
+	addNotifyFlag(buildCmd)
+	addScanFlags(buildCmd)
+}
+
+// printBuildPlan prints the images a build would produce
+func printBuildPlan(ctx context.Context, prj *dazzle.Project, sess *dazzle.BuildSession) error {
+	plan, err := prj.Plan(ctx, sess)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHUNK\tREF\tACTION")
+	for _, img := range plan {
+		ref, action := img.Ref, "build"
+		if ref == "" {
+			ref = "(after the base image)"
+		}
+		if img.Exists {
+			action = "exists"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", img.Chunk, ref, action)
+	}
+	return w.Flush()
+}
+
+// addNotifyFlag registers the --notify-webhook flag, which DAZZLE_NOTIFY_WEBHOOK sets for all commands
+func addNotifyFlag(cmd *cobra.Command) {
+	cmd.Flags().String("notify-webhook", "", "POST a JSON summary of the run to this URL, e.g. a Slack incoming webhook")
+	markSharedEnvVar(cmd, "notify-webhook")
+}
+
+// notifyWebhook posts the summary of the session to the webhook configured using --notify-webhook.
+// Failing to notify is logged, but does not fail the run.
+func notifyWebhook(cmd *cobra.Command, command string, sess *dazzle.BuildSession, duration time.Duration, err error) {
+	url, _ := cmd.Flags().GetString("notify-webhook")
+	if url == "" {
+		return
+	}
+
+	// the command context may have been cancelled, but an interrupted run is worth a notification, too
+	nerr := dazzle.PostWebhook(context.Background(), url, sess.Summary(command, duration, err))
+	if nerr != nil {
+		log.WithError(nerr).Error("cannot send notification")
+	}
+}
+
+// addGitHubFlag registers the --github flag
+func addGitHubFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("github", false, "write a job summary to $GITHUB_STEP_SUMMARY and annotate failed tests when running in GitHub Actions")
+}
+
+// writeGitHub appends the job summary of the session to $GITHUB_STEP_SUMMARY and prints
+// an error annotation for each failed test, if --github is set
+func writeGitHub(cmd *cobra.Command, sess *dazzle.BuildSession) {
+	if gh, _ := cmd.Flags().GetBool("github"); !gh {
+		return
+	}
+
+	for _, a := range sess.GitHubAnnotations() {
+		fmt.Println(a)
+	}
+
+	fn := os.Getenv("GITHUB_STEP_SUMMARY")
+	if fn == "" {
+		log.Warn("GITHUB_STEP_SUMMARY is not set - not writing a job summary")
+		return
+	}
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = f.Write(sess.GitHubSummary())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.WithError(err).Error("cannot write GitHub job summary")
+	}
+}
+
+// writeTimings prints how long each phase of the build took and saves the breakdown if requested
+func writeTimings(cmd *cobra.Command, sess *dazzle.BuildSession) {
+	sess.PrintTimings()
+
+	fn, _ := cmd.Flags().GetString("output-timings")
+	if fn == "" {
+		return
+	}
+	fc, err := json.MarshalIndent(sess.Timings(), "", "  ")
+	if err == nil {
+		err = os.WriteFile(fn, fc, 0644)
+	}
+	if err != nil {
+		log.WithError(err).Error("cannot write timings")
+	}
+}
+
+// addTestReportFlags registers the --output-test-<format> flags for all supported report formats
+func addTestReportFlags(cmd *cobra.Command) {
+	cmd.Flags().String("output-test-xml", "", "save test results as JUnit XML file")
+	cmd.Flags().String("output-test-json", "", "save test results as JSON file")
+	cmd.Flags().String("output-test-tap", "", "save test results as TAP file")
+}
+
+// writeTestReports writes the results of all tests run during the session in all requested formats
+func writeTestReports(cmd *cobra.Command, sess *dazzle.BuildSession) {
+	writeReports(cmd, sess.TestResults()...)
+}
+
+// writeReports writes test results in all requested formats
+func writeReports(cmd *cobra.Command, results ...test.Results) {
+	for format, marshal := range test.ReportFormats {
+		fn, _ := cmd.Flags().GetString("output-test-" + format)
+		if fn == "" {
+			continue
+		}
+
+		fc, err := marshal(results...)
+		if err == nil {
+			err = os.WriteFile(fn, fc, 0644)
+		}
+		if err != nil {
+			log.WithError(err).WithField("format", format).Error("cannot write test report")
+		}
+	}
+}
+
+// loadFailureLog loads the log of previously failed tests of the project in the context dir
+func loadFailureLog() (res *test.FailureLog, fn string, err error) {
+	fn, err = test.DefaultFailureLogPath(rootCfg.ContextDir)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err = test.LoadFailureLog(fn)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot load failure log: %w", err)
+	}
+	return res, fn, nil
+}
+
+func saveFailureLog(l *test.FailureLog, fn string) {
+	err := l.Save(fn)
+	if err != nil {
+		log.WithError(err).Warn("cannot save failure log")
+	}
 }
@@ -21,9 +21,9 @@
 package core
 
 import (
-	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/docker/distribution/reference"
 	"github.com/moby/buildkit/client"
@@ -31,6 +31,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/gitpod-io/dazzle/pkg/dazzle"
+	"github.com/gitpod-io/dazzle/pkg/test"
 )
 
 // combineCmd represents the build command
@@ -38,7 +39,12 @@ var combineCmd = &cobra.Command{
 	Use:   "combine <target-ref>",
 	Short: "Combines previously built chunks into a single image",
 	Args:  cobra.MinimumNArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		start := time.Now()
+		err = checkWritable("combine")
+		if err != nil {
+			return err
+		}
 		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
 		if err != nil {
 			return err
@@ -66,18 +72,17 @@ var combineCmd = &cobra.Command{
 				return fmt.Errorf("combination %s not found", cmbn)
 			}
 		} else if chunks, _ := cmd.Flags().GetString("chunks"); chunks != "" {
-			segs := strings.Split(chunks, "=")
-			if len(segs) != 2 {
-				return fmt.Errorf("chunks have invalid format")
-			}
-			cs = []dazzle.ChunkCombination{
-				{
-					Name:   segs[0],
-					Chunks: strings.Split(segs[1], ","),
-				},
+			cmbn, err := prj.ParseChunkCombination(chunks)
+			if err != nil {
+				return err
 			}
+			cs = []dazzle.ChunkCombination{cmbn}
 		} else {
-			return fmt.Errorf("must use one of --all, --combination or --chunks")
+			cmbn, err := prj.DefaultCombination()
+			if err != nil {
+				return err
+			}
+			cs = []dazzle.ChunkCombination{cmbn}
 		}
 
 		bldref, _ := cmd.Flags().GetString("build-ref")
@@ -85,40 +90,105 @@ var combineCmd = &cobra.Command{
 			bldref = targetref.String()
 		}
 
-		cl, err := client.New(context.Background(), rootCfg.BuildkitAddr, client.WithFailFast())
+		ctx := cmd.Context()
+		cl, err := client.New(ctx, rootCfg.BuildkitAddr, client.WithFailFast())
 		if err != nil {
 			return err
 		}
 
 		var opts []dazzle.CombinerOpt
 		notest, _ := cmd.Flags().GetBool("no-test")
-		if !notest {
+		matrix, _ := cmd.Flags().GetBool("test-matrix")
+		if notest && matrix {
+			return fmt.Errorf("cannot use --no-test and --test-matrix together")
+		}
+		if matrix {
+			opts = append(opts, dazzle.WithTestMatrix(cl))
+		} else if !notest {
 			opts = append(opts, dazzle.WithTests(cl))
 		}
 
-		sess, err := dazzle.NewSession(cl, bldref, dazzle.WithResolver(getResolver()))
+		filterExprs, _ := cmd.Flags().GetStringArray("filter")
+		filters, err := test.ParseFilters(filterExprs)
+		if err != nil {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: err}
+		}
+		scan, err := getImageScan(cmd)
+		if err != nil {
+			return err
+		}
+
+		rerunFailed, _ := cmd.Flags().GetBool("rerun-failed")
+		redactHistory, _ := cmd.Flags().GetBool("redact-history")
+		expiresAfter, _ := cmd.Flags().GetDuration("expires-after")
+		platform, _ := cmd.Flags().GetString("platform")
+		failures, failuresFN, err := loadFailureLog()
+		if err != nil {
+			return err
+		}
+		defer saveFailureLog(failures, failuresFN)
+
+		sess, err := dazzle.NewSession(cl, bldref,
+			dazzle.WithResolver(getResolver()),
+			dazzle.WithTestFilters(filters...),
+			dazzle.WithFailureLog(failures, rerunFailed),
+			dazzle.WithRedactHistory(redactHistory),
+			dazzle.WithExpiresAfter(expiresAfter),
+			dazzle.WithPlatform(platform),
+		)
 		if err != nil {
 			return fmt.Errorf("cannot start build session: %w", err)
 		}
-		err = sess.DownloadBaseInfo(context.Background(), prj)
+		err = sess.DownloadBaseInfo(ctx, prj)
 		if err != nil {
 			return fmt.Errorf("cannot download base-image info: %w", err)
 		}
 
+		defer writeTestReports(cmd, sess)
+		defer writeGitHub(cmd, sess)
+		defer func() {
+			notifyWebhook(cmd, "combine", sess, time.Since(start), err)
+		}()
+
+		var (
+			failed     []string
+			failedKind dazzle.ErrorKind
+		)
+		tagTpl, _ := cmd.Flags().GetString("tag")
 		for _, cmb := range cs {
-			destref, err := reference.WithTag(targetref, cmb.Name)
+			tag, err := prj.CombinationTag(cmb, tagTpl, start)
+			if err != nil {
+				return err
+			}
+			destref, err := reference.WithTag(targetref, tag)
 			if err != nil {
 				return fmt.Errorf("cannot produce target reference for chunk %s: %w", cmb.Name, err)
 			}
 
 			log.WithField("combination", cmb.Name).WithField("chunks", cmb.Chunks).WithField("ref", destref.String()).Warn("producing chunk combination")
-			err = prj.Combine(context.Background(), cmb.Chunks, destref, sess, opts...)
+			err = prj.Combine(ctx, cmb.Chunks, destref, sess, opts...)
+			if err != nil && matrix {
+				// keep going so that the matrix covers all combinations
+				log.WithError(err).WithField("combination", cmb.Name).Error("combination failed")
+				if len(failed) == 0 {
+					failedKind = dazzle.KindOf(err)
+				}
+				failed = append(failed, cmb.Name)
+				continue
+			}
 			if err != nil {
 				return err
 			}
 		}
 
-		return nil
+		if matrix {
+			sess.PrintTestMatrix()
+		}
+		if len(failed) > 0 {
+			return &dazzle.Error{Kind: failedKind, Err: fmt.Errorf("combinations failed: %s", strings.Join(failed, ", "))}
+		}
+
+		return scan.run(ctx, sess)
 	},
 }
 
@@ -126,8 +196,21 @@ func init() {
 	rootCmd.AddCommand(combineCmd)
 
 	combineCmd.Flags().Bool("no-test", false, "disables the tests")
-	combineCmd.Flags().String("chunks", "", "combine a set of chunks - format is name=chk1,chk2,chkN")
-	combineCmd.Flags().String("combination", "", "build a specific combination")
+	combineCmd.Flags().String("chunks", "", "combine a set of chunks - format is name=chk1,chk2,chkN, where chunks can be globs, e.g. golang:*")
+	combineCmd.Flags().String("combination", "", "build a specific combination rather than the default one")
 	combineCmd.Flags().Bool("all", false, "build all combinations")
+	combineCmd.Flags().String("tag", "", "template of the tags of the combined images, e.g. {{.Name}}-{{.BaseHash}}-{{.Date}} - overrides the tag templates of the project")
 	combineCmd.Flags().String("build-ref", "", "use a different build-ref than the target-ref")
+	combineCmd.Flags().String("platform", "", "combine the chunks built for this platform, e.g. linux/arm64, rather than the one dazzle runs on")
+	combineCmd.Flags().Duration("expires-after", 0, "annotate the test results and temporary test images with the time after which registry lifecycle policies may remove them, e.g. 336h")
+	combineCmd.Flags().Bool("redact-history", false, "strip the build steps, which can contain build args, from the history of the combined images")
+	combineCmd.Flags().StringArray("filter", nil, "only run tests matching the filter, e.g. tag=gpu, tag!=slow or desc~=python (can be repeated)")
+	combineCmd.Flags().Bool("rerun-failed", false, "only run the tests which failed in the previous run")
+	combineCmd.Flags().Bool("test-matrix", false, "run the tests of all member chunks against each combination, report all failures and cache results per combination and chunk")
+	addTestReportFlags(combineCmd)
+	addGitHubFlag(combineCmd)
+	addNotifyFlag(combineCmd)
+	addScanFlags(combineCmd)
+
+	_ = combineCmd.RegisterFlagCompletionFunc("combination", completeCombinations)
 }
@@ -0,0 +1,150 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion <bash|zsh|fish>",
+	Short: "Generates shell completion scripts",
+	Long: `Generates a shell completion script which completes commands and flags as well as the chunk
+and combination names of the project in the context dir. For example, to enable completion in bash:
+
+	source <(dazzle completion bash)`,
+	Args:                  cobra.ExactValidArgs(1),
+	ValidArgs:             []string{"bash", "zsh", "fish"},
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		default:
+			return fmt.Errorf("unsupported shell %q", args[0])
+		}
+	},
+}
+
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completeArg applies a completion function to the positional argument at index pos only
+func completeArg(pos int, f completionFunc) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != pos {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return f(cmd, args, toComplete)
+	}
+}
+
+// completeArgsFrom applies a completion function to all positional arguments starting at index pos,
+// leaving out values which were given already
+func completeArgsFrom(pos int, f completionFunc) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) < pos {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		res, directive := f(cmd, args, toComplete)
+
+		given := make(map[string]struct{}, len(args)-pos)
+		for _, a := range args[pos:] {
+			given[a] = struct{}{}
+		}
+		filtered := make([]string, 0, len(res))
+		for _, r := range res {
+			if _, ok := given[r]; ok {
+				continue
+			}
+			filtered = append(filtered, r)
+		}
+		return filtered, directive
+	}
+}
+
+// completeChunks completes the chunk names of the project, including their variants
+func completeChunks(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	err := applyConfigOverrides(cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	res, err := dazzle.LoadChunkNames(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeChunkNames completes the chunk names of the project without their variants
+func completeChunkNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	chunks, directive := completeChunks(cmd, args, toComplete)
+
+	var (
+		res  []string
+		seen = make(map[string]struct{}, len(chunks))
+	)
+	for _, chk := range chunks {
+		name := strings.SplitN(chk, ":", 2)[0]
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		res = append(res, name)
+	}
+	return res, directive
+}
+
+// completeCombinations completes the combination names of the project
+func completeCombinations(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	prj, err := loadCompletionProject(cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	res := make([]string, 0, len(prj.Config.Combiner.Combinations))
+	for _, c := range prj.Config.Combiner.Combinations {
+		res = append(res, c.Name)
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+// loadCompletionProject loads the project in the context dir. Completion does not run the persistent pre-run
+// hooks, hence we apply the env vars and user config here.
+func loadCompletionProject(cmd *cobra.Command) (*dazzle.Project, error) {
+	err := applyConfigOverrides(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
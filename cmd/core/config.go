@@ -0,0 +1,179 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+const (
+	// envVarPrefix is the prefix of the env vars which set flags, e.g. DAZZLE_ADDR sets --addr
+	envVarPrefix = "DAZZLE_"
+	// envVarConfig overrides the location of the user config file
+	envVarConfig = "DAZZLE_CONFIG"
+
+	// annotationSharedEnvVar marks a command's flag which is set by the same env var for all commands
+	// that have it, like the flags of the root command are
+	annotationSharedEnvVar = "dazzle_shared_env_var"
+)
+
+// userConfigPath returns the location of the user config file, which is ~/.config/dazzle/config.yaml by default
+func userConfigPath() (string, error) {
+	if fn := os.Getenv(envVarConfig); fn != "" {
+		return fn, nil
+	}
+
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "dazzle", "config.yaml"), nil
+}
+
+// loadUserConfig loads the flag values of the user config file. A missing config file is not an error.
+func loadUserConfig() (map[string]interface{}, error) {
+	fn, err := userConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	fc, err := os.ReadFile(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res map[string]interface{}
+	err = yaml.Unmarshal(fc, &res)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load config from %s: %w", fn, err)
+	}
+	return res, nil
+}
+
+// flagEnvVar returns the name of the env var which sets a flag of a command. The flags of the root command and
+// those marked using markSharedEnvVar are named after the flag only, e.g. DAZZLE_ADDR for --addr. All others are
+// scoped to their command, e.g. DAZZLE_COMBINE_BUILD_REF for --build-ref of dazzle combine, so that an env var
+// like DAZZLE_DELETE does not apply to every command which happens to have a flag of that name.
+func flagEnvVar(cmd *cobra.Command, f *pflag.Flag) string {
+	if cmd.Root().PersistentFlags().Lookup(f.Name) == f || isSharedEnvVar(f) {
+		return unscopedEnvVar(f.Name)
+	}
+	path := strings.TrimPrefix(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()), " ")
+	return unscopedEnvVar(path + "-" + f.Name)
+}
+
+func unscopedEnvVar(name string) string {
+	return envVarPrefix + strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(name))
+}
+
+// markSharedEnvVar makes a flag of a command settable by DAZZLE_<FLAG> rather than DAZZLE_<COMMAND>_<FLAG>
+func markSharedEnvVar(cmd *cobra.Command, name string) {
+	_ = cmd.Flags().SetAnnotation(name, annotationSharedEnvVar, []string{"true"})
+}
+
+func isSharedEnvVar(f *pflag.Flag) bool {
+	_, ok := f.Annotations[annotationSharedEnvVar]
+	return ok
+}
+
+// applyConfigOverrides sets all flags of a command which were not given on the command line from env vars
+// or the user config file. Flags take precedence over env vars, which take precedence over the config file.
+func applyConfigOverrides(cmd *cobra.Command) error {
+	cfg, err := loadUserConfig()
+	if err != nil {
+		return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: err}
+	}
+
+	var ferr error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if ferr != nil || f.Changed || f.Name == "help" {
+			return
+		}
+
+		envVar := flagEnvVar(cmd, f)
+		if val, ok := os.LookupEnv(envVar); ok {
+			ferr = setFlag(cmd.Flags(), f, []string{val})
+			if ferr != nil {
+				ferr = fmt.Errorf("invalid value of %s: %w", envVar, ferr)
+			}
+			return
+		}
+		if unscoped := unscopedEnvVar(f.Name); unscoped != envVar {
+			if _, ok := os.LookupEnv(unscoped); ok {
+				log.WithField("use", envVar).Warnf("ignoring %s: env vars of command flags are named after the command", unscoped)
+			}
+		}
+
+		val, ok := cfg[f.Name]
+		if !ok {
+			return
+		}
+		var vals []string
+		if vs, isList := val.([]interface{}); isList {
+			for _, v := range vs {
+				vals = append(vals, fmt.Sprint(v))
+			}
+		} else {
+			vals = []string{fmt.Sprint(val)}
+		}
+		ferr = setFlag(cmd.Flags(), f, vals)
+		if ferr != nil {
+			ferr = fmt.Errorf("invalid value of %s in user config: %w", f.Name, ferr)
+		}
+	})
+	if ferr != nil {
+		return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: ferr}
+	}
+	return nil
+}
+
+func setFlag(flags *pflag.FlagSet, f *pflag.Flag, vals []string) error {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		err := sv.Replace(vals)
+		if err != nil {
+			return err
+		}
+		f.Changed = true
+		return nil
+	}
+	if len(vals) != 1 {
+		return fmt.Errorf("expected a single value")
+	}
+	return flags.Set(f.Name, vals[0])
+}
@@ -0,0 +1,116 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/docker/distribution/reference"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old-ref> <new-ref>",
+	Short: "Shows how two images differ",
+	Long: `Compares two images, e.g. a chunk image before and after a change, and lists the files which were
+added, removed or changed in each layer as well as changed env vars and labels. Layers which are
+identical in both images are skipped.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			return fmt.Errorf("unknown output format %q, must be text or json", output)
+		}
+
+		oldRef, err := reference.ParseNormalizedNamed(args[0])
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %w", args[0], err)
+		}
+		newRef, err := reference.ParseNormalizedNamed(args[1])
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %w", args[1], err)
+		}
+
+		ctx := cmd.Context()
+
+		diff, err := dazzle.DiffImages(ctx, getResolver(), oldRef, newRef)
+		if err != nil {
+			return err
+		}
+
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(diff)
+		}
+
+		fmt.Printf("size: %.2f MiB -> %.2f MiB\n", float64(diff.Size.Old)/(1024*1024), float64(diff.Size.New)/(1024*1024))
+		for _, l := range diff.Layers {
+			fmt.Printf("layer %d: %s -> %s\n", l.Index, orNone(string(l.Old)), orNone(string(l.New)))
+			for _, f := range l.Added {
+				fmt.Printf("  + %s\n", f)
+			}
+			for _, f := range l.Removed {
+				fmt.Printf("  - %s\n", f)
+			}
+			for _, f := range l.Changed {
+				fmt.Printf("  ~ %s\n", f)
+			}
+		}
+		printKeyValueChanges("env", diff.Env)
+		printKeyValueChanges("labels", diff.Labels)
+		return nil
+	},
+}
+
+func printKeyValueChanges(title string, changes []dazzle.KeyValueChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", title)
+	for _, c := range changes {
+		switch {
+		case c.Old == nil:
+			fmt.Printf("  + %s=%s\n", c.Key, *c.New)
+		case c.New == nil:
+			fmt.Printf("  - %s=%s\n", c.Key, *c.Old)
+		default:
+			fmt.Printf("  ~ %s: %s -> %s\n", c.Key, *c.Old, *c.New)
+		}
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringP("output", "o", "text", "output format: text or json")
+}
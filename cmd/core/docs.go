@@ -0,0 +1,289 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/docker/distribution/reference"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+// defaultDocsTemplate renders the project overview ("index") and a page per chunk ("chunk") as Markdown
+//
+//go:embed docs.md.tmpl
+var defaultDocsTemplate string
+
+// docsIndexPage is the name of the overview page when writing to --out-dir. Repo wikis show Home.md first.
+const docsIndexPage = "Home.md"
+
+type docsData struct {
+	Project      dazzle.ProjectDescription
+	Chunks       []*docsChunk
+	Combinations []*docsCombination
+}
+
+type docsChunk struct {
+	Name         string
+	Link         string
+	Dockerfile   string
+	Combinations []string
+	Variants     []docsVariant
+	TestCount    int
+}
+
+type docsCombination struct {
+	Name   string
+	Link   string
+	Chunks []string
+	// Inventory lists what is installed in the combination image, if requested using --inventory
+	Inventory *dazzle.ImageInventory
+}
+
+type docsVariant struct {
+	Name   string
+	Args   map[string]string
+	Tests  []string
+	Images map[dazzle.ChunkImageType]string
+}
+
+var docsCmd = &cobra.Command{
+	Use:   "docs [target-ref]",
+	Short: "Generates Markdown documentation of the chunks and combinations",
+	Long: `Generates Markdown documentation of the project: an overview of all chunks and combinations,
+and a section per chunk with its variants, build args, tests and Dockerfile. When a target-ref is
+given the sections also list the image refs of each variant.
+
+With --inventory the combination images built for the target-ref are inspected as well, producing a
+section per combination with the OS packages and language runtimes installed in it.
+
+With --out-dir the overview is written to Home.md and each chunk to a page of its own, which suits
+publishing to a repo wiki. --template replaces the built-in template; it must define the templates
+"index", "chunk" and "combination".`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outDir, _ := cmd.Flags().GetString("out-dir")
+		tplFN, _ := cmd.Flags().GetString("template")
+		inventory, _ := cmd.Flags().GetBool("inventory")
+		if inventory && len(args) == 0 {
+			return fmt.Errorf("--inventory requires a target-ref")
+		}
+
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+
+		var sess *dazzle.BuildSession
+		if len(args) > 0 {
+			sess, err = dazzle.NewSession(nil, args[0], dazzle.WithResolver(getResolver()))
+			if err != nil {
+				return err
+			}
+			err = sess.DownloadBaseInfo(ctx, prj)
+			if err != nil {
+				return err
+			}
+		}
+
+		data, err := docsDataOf(prj, sess, outDir != "")
+		if err != nil {
+			return err
+		}
+		if inventory {
+			for _, comb := range data.Combinations {
+				ref, err := reference.WithTag(sess.Dest, comb.Name)
+				if err != nil {
+					return err
+				}
+				log.WithField("ref", ref.String()).Info("taking inventory of combination")
+				comb.Inventory, err = dazzle.InventoryImage(ctx, getResolver(), ref)
+				if err != nil {
+					return fmt.Errorf("cannot take inventory of combination %s: %w", comb.Name, err)
+				}
+			}
+		}
+
+		tplSrc := defaultDocsTemplate
+		if tplFN != "" {
+			fc, err := os.ReadFile(tplFN)
+			if err != nil {
+				return err
+			}
+			tplSrc = string(fc)
+		}
+		tpl, err := template.New("docs").Funcs(template.FuncMap{
+			"join": strings.Join,
+			"trim": strings.TrimSpace,
+		}).Parse(tplSrc)
+		if err != nil {
+			return fmt.Errorf("cannot parse template: %w", err)
+		}
+
+		if outDir == "" {
+			err = tpl.ExecuteTemplate(os.Stdout, "index", data)
+			if err != nil {
+				return err
+			}
+			for _, chk := range data.Chunks {
+				fmt.Println()
+				err = tpl.ExecuteTemplate(os.Stdout, "chunk", chk)
+				if err != nil {
+					return err
+				}
+			}
+			for _, comb := range data.Combinations {
+				if comb.Inventory == nil {
+					continue
+				}
+				fmt.Println()
+				err = tpl.ExecuteTemplate(os.Stdout, "combination", comb)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		err = os.MkdirAll(outDir, 0755)
+		if err != nil {
+			return err
+		}
+		err = writeDocsPage(filepath.Join(outDir, docsIndexPage), tpl, "index", data)
+		if err != nil {
+			return err
+		}
+		for _, chk := range data.Chunks {
+			err = writeDocsPage(filepath.Join(outDir, chk.Name+".md"), tpl, "chunk", chk)
+			if err != nil {
+				return err
+			}
+		}
+		for _, comb := range data.Combinations {
+			if comb.Inventory == nil {
+				continue
+			}
+			err = writeDocsPage(filepath.Join(outDir, comb.Name+".md"), tpl, "combination", comb)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// docsDataOf groups the variants of the project's chunks. Links point to the chunk pages if each chunk
+// gets a page of its own, and to the chunk sections otherwise.
+func docsDataOf(prj *dazzle.Project, sess *dazzle.BuildSession, pages bool) (*docsData, error) {
+	res := &docsData{Project: prj.Describe()}
+
+	idx := make(map[string]*docsChunk)
+	for i, desc := range res.Project.Chunks {
+		name := strings.SplitN(desc.Name, ":", 2)[0]
+		chk, exists := idx[name]
+		if !exists {
+			chk = &docsChunk{
+				Name:       name,
+				Link:       "#" + name,
+				Dockerfile: desc.Dockerfile,
+			}
+			if pages {
+				// repo wikis link pages by their name without extension
+				chk.Link = name
+			}
+			idx[name] = chk
+			res.Chunks = append(res.Chunks, chk)
+		}
+
+		variant := docsVariant{Name: desc.Name, Args: desc.Args, Tests: desc.Tests}
+		if sess != nil {
+			variant.Images = make(map[dazzle.ChunkImageType]string, len(allChunkImageTypes))
+			for _, tpe := range allChunkImageTypes {
+				ref, err := prj.Chunks[i].ImageName(tpe, sess)
+				if err != nil {
+					return nil, err
+				}
+				variant.Images[tpe] = ref.String()
+			}
+		}
+		chk.Variants = append(chk.Variants, variant)
+		chk.TestCount += len(desc.Tests)
+
+		for _, comb := range res.Project.Combinations {
+			for _, c := range comb.Chunks {
+				if c == desc.Name || c == name {
+					chk.Combinations = appendUnique(chk.Combinations, comb.Name)
+					break
+				}
+			}
+		}
+	}
+	for _, comb := range res.Project.Combinations {
+		c := &docsCombination{Name: comb.Name, Link: "#" + comb.Name, Chunks: comb.Chunks}
+		if pages {
+			c.Link = comb.Name
+		}
+		res.Combinations = append(res.Combinations, c)
+	}
+	return res, nil
+}
+
+func appendUnique(s []string, v string) []string {
+	for _, e := range s {
+		if e == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
+
+func writeDocsPage(fn string, tpl *template.Template, name string, data interface{}) (err error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cerr := f.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	return tpl.ExecuteTemplate(f, name, data)
+}
+
+func init() {
+	rootCmd.AddCommand(docsCmd)
+
+	docsCmd.Flags().String("out-dir", "", "write the overview to Home.md and a page per chunk into this directory rather than printing to stdout")
+	docsCmd.Flags().String("template", "", "Go template file which defines the \"index\", \"chunk\" and \"combination\" templates")
+	docsCmd.Flags().Bool("inventory", false, "list the OS packages and language runtimes installed in each combination image")
+}
@@ -0,0 +1,78 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <target-ref> <combination>",
+	Short: "Writes a combination as OCI image layout tar for offline transfer",
+	Long: `Writes a combination which was built using "dazzle combine <target-ref>" as OCI image layout tar,
+together with the base image and the chunked images of its chunks. Use "dazzle import" to push the bundle
+to a registry on the other side of an air gap.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeArg(1, completeCombinations),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("output")
+		if out == "" {
+			return fmt.Errorf("--output is required")
+		}
+
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		if err != nil {
+			return err
+		}
+
+		sess, err := dazzle.NewSession(nil, args[0], dazzle.WithResolver(getResolver()))
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		err = prj.Export(ctx, sess, args[1], f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(out)
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringP("output", "o", "", "path of the bundle to write")
+}
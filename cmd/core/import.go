@@ -0,0 +1,70 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/distribution/reference"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <bundle.tar>",
+	Short: "Pushes a bundle written by dazzle export to a registry",
+	Long: `Pushes the images of a bundle written by "dazzle export" to the repository given by --push, keeping
+their tags and digests. The refs of the pushed images are printed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		push, _ := cmd.Flags().GetString("push")
+		if push == "" {
+			return fmt.Errorf("--push is required")
+		}
+		dest, err := reference.ParseNamed(push)
+		if err != nil {
+			return fmt.Errorf("cannot parse --push: %w", err)
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		ctx := cmd.Context()
+
+		refs, err := dazzle.ImportBundle(ctx, log.StandardLogger(), getResolver(), f, dest)
+		for _, ref := range refs {
+			fmt.Println(ref.String())
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().String("push", "", "repository to push the images to")
+}
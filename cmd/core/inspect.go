@@ -0,0 +1,150 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/distribution/reference"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <ref>",
+	Short: "Prints the dazzle metadata of a chunk or combination image",
+	Long: `Prints the dazzle metadata of a chunk or combination image: the base image it was built on,
+how env vars are combined, which chunk contributed which layer and whether the tests passed
+before the image was pushed.
+
+With --catalog <ref> is a build ref and inspect prints the catalog dazzle pushed under it:
+the base image and the chunks and combinations built on it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		if output != "table" && output != "json" {
+			return fmt.Errorf("unknown output format %q, must be table or json", output)
+		}
+
+		ref, err := reference.ParseNormalizedNamed(args[0])
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %w", args[0], err)
+		}
+
+		ctx := cmd.Context()
+
+		if catalog, _ := cmd.Flags().GetBool("catalog"); catalog {
+			return printCatalog(ctx, ref, output)
+		}
+
+		res, err := dazzle.InspectImage(ctx, dazzle.NewResolverRegistry(getResolver()), ref)
+		if err != nil {
+			return err
+		}
+
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "ref:\t%s\n", res.Ref)
+		fmt.Fprintf(w, "kind:\t%s\n", res.Kind)
+		if res.Chunk != "" {
+			fmt.Fprintf(w, "chunk:\t%s\n", res.Chunk)
+		}
+		if res.BaseRef != "" {
+			fmt.Fprintf(w, "base ref:\t%s\n", res.BaseRef)
+		}
+		fmt.Fprintf(w, "tests:\t%s\n", res.Tests)
+		for _, e := range res.EnvVars {
+			fmt.Fprintf(w, "env var %s:\t%s\n", e.Name, e.Action)
+		}
+		if len(res.Chunks) > 0 {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "CHUNK\tHASH\tLAYERS")
+			for _, c := range res.Chunks {
+				hash := c.Hash
+				if hash == "" {
+					hash = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\n", c.Chunk, hash, c.Layers)
+			}
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "LAYER\tCHUNK\tSIZE\tCREATED BY")
+		for _, l := range res.Layers {
+			chunk := l.Chunk
+			if chunk == "" {
+				chunk = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.2f MiB\t%s\n", l.Digest, chunk, float64(l.Size)/(1024*1024), l.CreatedBy)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.Flags().StringP("output", "o", "table", "output format: table or json")
+	inspectCmd.Flags().Bool("catalog", false, "print the catalog of the chunks and combinations pushed under a build ref")
+}
+
+func printCatalog(ctx context.Context, ref reference.Named, output string) error {
+	res, err := dazzle.PullCatalog(ctx, dazzle.NewResolverRegistry(getResolver()), ref)
+	if err != nil {
+		return fmt.Errorf("cannot pull catalog of %s: %w", ref.String(), err)
+	}
+
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "base:\t%s\n", res.Base)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "CHUNK\tHASH\tREF")
+	for _, c := range res.Chunks {
+		hash := c.Hash
+		if hash == "" {
+			hash = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, hash, c.Ref)
+	}
+	if len(res.Combinations) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "COMBINATION\tCHUNKS\tREF")
+		for _, c := range res.Combinations {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, strings.Join(c.Chunks, ","), c.Ref)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,81 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <dest-ref> <base-ref> <addon-ref>...",
+	Short: "Merges images on top of a base image directly in the registry",
+	Long: `Merges the addon images on top of the base image and pushes the result to dest-ref. Addons which were built
+from the base image have the layers they share with it removed. No Docker daemon is involved: the merged
+manifest is assembled in the registry and blobs are mounted from their source repository where possible.`,
+	Args: cobra.MinimumNArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refs := make([]reference.Named, len(args))
+		for i, arg := range args {
+			ref, err := reference.ParseNamed(arg)
+			if err != nil {
+				return fmt.Errorf("cannot parse %s: %w", arg, err)
+			}
+			refs[i] = ref
+		}
+
+		envFlags, _ := cmd.Flags().GetStringArray("env")
+		envVars := make([]dazzle.EnvVarCombination, 0, len(envFlags))
+		for _, e := range envFlags {
+			name, action, ok := strings.Cut(e, "=")
+			if !ok {
+				return fmt.Errorf("invalid --env %q: must be name=action", e)
+			}
+			a := dazzle.EnvVarCombinationAction(action)
+			switch a {
+			case dazzle.EnvVarCombineMerge, dazzle.EnvVarCombineMergeUnique, dazzle.EnvVarCombineUseLast, dazzle.EnvVarCombineUseFirst:
+			default:
+				return fmt.Errorf("invalid --env %q: unknown action %s", e, action)
+			}
+			envVars = append(envVars, dazzle.EnvVarCombination{Name: name, Action: a})
+		}
+
+		ctx := cmd.Context()
+
+		absref, err := dazzle.MergeImages(ctx, log.StandardLogger(), getResolver(), refs[0], refs[1], refs[2:], envVars)
+		if err != nil {
+			return err
+		}
+		log.WithField("ref", absref.String()).Info("merged image")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	mergeCmd.Flags().StringArray("env", nil, "how to combine an env var set by several images, e.g. PATH=merge (can be repeated, actions are merge, merge-unique, use-last and use-first)")
+}
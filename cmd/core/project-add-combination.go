@@ -30,9 +30,10 @@ import (
 )
 
 var projectAddCombinationCmd = &cobra.Command{
-	Use:   "add-combination <name> <chunk> [chunk ...]",
-	Short: "adds a combination to a project",
-	Args:  cobra.MinimumNArgs(2),
+	Use:               "add-combination <name> <chunk> [chunk ...]",
+	Short:             "adds a combination to a project",
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: completeArgsFrom(1, completeChunks),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg, err := dazzle.LoadProjectConfig(os.DirFS(rootCfg.ContextDir))
 		if os.IsNotExist(err) {
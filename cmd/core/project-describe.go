@@ -0,0 +1,69 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var projectDescribeCmd = &cobra.Command{
+	Use:   "describe",
+	Short: "prints the effective project configuration",
+	Long: `Prints the effective project configuration: the chunks which remain after applying the
+ignore patterns, one per variant, and the combinations with all chunks they reference directly
+or through other combinations.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		if err != nil {
+			return err
+		}
+		desc := prj.Describe()
+
+		switch output {
+		case "yaml":
+			enc := yaml.NewEncoder(os.Stdout)
+			enc.SetIndent(2)
+			return enc.Encode(desc)
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(desc)
+		default:
+			return fmt.Errorf("unknown output format %q, must be yaml or json", output)
+		}
+	},
+}
+
+func init() {
+	projectCmd.AddCommand(projectDescribeCmd)
+
+	projectDescribeCmd.Flags().StringP("output", "o", "yaml", "output format: yaml or json")
+}
@@ -0,0 +1,70 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var projectExportCmd = &cobra.Command{
+	Use:   "export <target-ref>",
+	Short: "prints the chunks with their hashes and image names for external build systems",
+	Long: `Prints the chunks of the project, one per variant, with their hashes, the names of their images,
+their build args and the chunks they depend on, and the ref of the base image. Build systems like
+Bazel or Make can use this to decide what to rebuild. Chunk hashes and image names depend on the
+digest of the base image, hence they are missing until it was built.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "json" {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("unknown format %q, must be json", format)}
+		}
+
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		if err != nil {
+			return err
+		}
+		sess, err := dazzle.NewSession(nil, args[0], dazzle.WithResolver(getResolver()))
+		if err != nil {
+			return err
+		}
+		md, err := prj.Metadata(cmd.Context(), sess, rootCfg.ContextDir)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(md)
+	},
+}
+
+func init() {
+	projectCmd.AddCommand(projectExportCmd)
+
+	projectExportCmd.Flags().String("format", "json", "output format: json")
+}
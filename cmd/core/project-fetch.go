@@ -0,0 +1,57 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var projectFetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "downloads the chunks a project imports",
+	Long: `Downloads the chunks listed under imports in dazzle.yaml from their git repository or OCI artifact
+to .dazzle/imports. Imports which were fetched already are skipped unless --force is given.
+dazzle build fetches missing imports by itself.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+
+		ctx := cmd.Context()
+
+		fetched, err := dazzle.FetchImports(ctx, log.StandardLogger(), rootCfg.ContextDir, getResolver(), force)
+		if err != nil {
+			return err
+		}
+		if len(fetched) == 0 {
+			log.Info("all imports are up to date")
+		}
+		return nil
+	},
+}
+
+func init() {
+	projectCmd.AddCommand(projectFetchCmd)
+
+	projectFetchCmd.Flags().Bool("force", false, "fetch imports even if they are up to date")
+}
@@ -21,9 +21,11 @@
 package core
 
 import (
-	"context"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -31,11 +33,21 @@ import (
 )
 
 var projectHashCmd = &cobra.Command{
-	Use:   "hash <target-ref> [chunk]",
-	Short: "prints the hash of a chunk (or all of them)",
-	Args:  cobra.MinimumNArgs(1),
+	Use:   "hash <target-ref> [chunk...]",
+	Short: "prints the hashes of a chunk (or all of them)",
+	Long: `Prints the hashes of a chunk (or all of them) for debugging the build cache: the hash excluding tests,
+which the chunk images are tagged with, and the hash including tests, which the test image is tagged with.
+Use --manifest to print the manifest the hashes are computed from.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArgsFrom(1, completeChunks),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			return fmt.Errorf("unknown output format %q, must be text or json", output)
+		}
+		withManifest, _ := cmd.Flags().GetBool("manifest")
+
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{Chunks: args[1:]})
 		if err != nil {
 			return err
 		}
@@ -44,7 +56,7 @@ var projectHashCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		err = sess.DownloadBaseInfo(context.Background(), prj)
+		err = sess.DownloadBaseInfo(cmd.Context(), prj)
 		if err != nil {
 			return err
 		}
@@ -75,19 +87,53 @@ var projectHashCmd = &cobra.Command{
 			}
 		}
 
+		res := make([]chunkHashes, 0, len(chunks))
 		for _, c := range chunks {
-			hash, err := c.Hash(os.Stdout, sess)
+			hashes, err := c.Hashes(sess)
 			if err != nil {
 				return err
 			}
-
-			fmt.Printf("%s: %s\n", c.Name, hash)
+			h := chunkHashes{Chunk: c.Name, ChunkHashes: hashes}
+			if withManifest {
+				var mf bytes.Buffer
+				err = c.PrintManifest(&mf, sess)
+				if err != nil {
+					return err
+				}
+				h.Manifest = strings.Split(strings.TrimSpace(mf.String()), "\n")
+			}
+			res = append(res, h)
 		}
 
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		}
+		for _, h := range res {
+			fmt.Printf("%s:\n", h.Chunk)
+			fmt.Printf("  excluding tests: %s\n", h.ExcludeTests)
+			fmt.Printf("  including tests: %s\n", h.WithTests)
+			if withManifest {
+				fmt.Println("  manifest:")
+				for _, l := range h.Manifest {
+					fmt.Printf("    %s\n", l)
+				}
+			}
+		}
 		return nil
 	},
 }
 
+type chunkHashes struct {
+	Chunk string `json:"chunk"`
+	dazzle.ChunkHashes
+	Manifest []string `json:"manifest,omitempty"`
+}
+
 func init() {
 	projectCmd.AddCommand(projectHashCmd)
+
+	projectHashCmd.Flags().Bool("manifest", false, "print the manifest the hashes are computed from")
+	projectHashCmd.Flags().StringP("output", "o", "text", "output format: text or json")
 }
@@ -21,8 +21,9 @@
 package core
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 
@@ -32,23 +33,25 @@ import (
 var projectImageNameOpts struct {
 	ImageType    string
 	ExcludeTests bool
+	Output       string
 }
 
 var projectImageNameCmd = &cobra.Command{
-	Use:   "image-name <target-ref> [chunk]",
-	Short: "prints the image-name of a chunk (or all of them)",
-	Args:  cobra.MinimumNArgs(1),
+	Use:               "image-name <target-ref> [chunk]",
+	Short:             "prints the image-name of a chunk (or all of them)",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArg(1, completeChunks),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{Chunks: args[1:]})
 		if err != nil {
 			return err
 		}
 
-		sess, err := dazzle.NewSession(nil, args[0], dazzle.WithResolver(getResolver()), dazzle.WithNoTests(projectImageNameOpts.ExcludeTests))
+		sess, err := dazzle.NewSession(nil, args[0], dazzle.WithResolver(getResolver()), dazzle.WithSkipTests(projectImageNameOpts.ExcludeTests))
 		if err != nil {
 			return err
 		}
-		err = sess.DownloadBaseInfo(context.Background(), prj)
+		err = sess.DownloadBaseInfo(cmd.Context(), prj)
 		if err != nil {
 			return err
 		}
@@ -79,21 +82,56 @@ var projectImageNameCmd = &cobra.Command{
 			}
 		}
 
-		for _, c := range chunks {
-			img, err := c.ImageName(dazzle.ChunkImageType(projectImageNameOpts.ImageType), sess)
-			if err != nil {
-				return err
+		switch projectImageNameOpts.Output {
+		case "json":
+			res := make([]chunkImageNames, 0, len(chunks))
+			for _, c := range chunks {
+				names := chunkImageNames{Chunk: c.Name, Images: make(map[dazzle.ChunkImageType]string, len(allChunkImageTypes))}
+				for _, tpe := range allChunkImageTypes {
+					img, err := c.ImageName(tpe, sess)
+					if err != nil {
+						return err
+					}
+					names.Images[tpe] = img.String()
+				}
+				res = append(res, names)
 			}
 
-			fmt.Printf("%s: %s\n", c.Name, img)
-		}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		case "text":
+			for _, c := range chunks {
+				img, err := c.ImageName(dazzle.ChunkImageType(projectImageNameOpts.ImageType), sess)
+				if err != nil {
+					return err
+				}
 
-		return nil
+				fmt.Printf("%s: %s\n", c.Name, img)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown output format %q, must be text or json", projectImageNameOpts.Output)
+		}
 	},
 }
 
+// allChunkImageTypes are the image types listed by image-name --output json
+var allChunkImageTypes = []dazzle.ChunkImageType{
+	dazzle.ImageTypeTest,
+	dazzle.ImageTypeFull,
+	dazzle.ImageTypeChunked,
+	dazzle.ImageTypeChunkedNoHash,
+}
+
+type chunkImageNames struct {
+	Chunk  string                           `json:"chunk"`
+	Images map[dazzle.ChunkImageType]string `json:"images"`
+}
+
 func init() {
 	projectCmd.AddCommand(projectImageNameCmd)
 	projectImageNameCmd.Flags().StringVarP(&projectImageNameOpts.ImageType, "type", "t", string(dazzle.ImageTypeChunked), "chunk image type")
 	projectImageNameCmd.Flags().BoolVar(&projectImageNameOpts.ExcludeTests, "no-tests", false, "exclude tests")
+	projectImageNameCmd.Flags().StringVarP(&projectImageNameOpts.Output, "output", "o", "text", "output format: text or json (lists all image types)")
 }
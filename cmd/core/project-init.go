@@ -21,41 +21,40 @@
 package core
 
 import (
+	"bytes"
+	"embed"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"text/template"
 
 	"github.com/spf13/cobra"
 
 	"github.com/gitpod-io/dazzle/pkg/dazzle"
 )
 
+// chunkTemplates contains the built-in chunk templates. Each template has a Dockerfile, a starter
+// test suite in tests.yaml and optionally a chunk.yaml with variants.
+//
+//go:embed templates
+var chunkTemplates embed.FS
+
 // projectInitCmd represents the version command
 var projectInitCmd = &cobra.Command{
 	Use:   "init [chunk]",
 	Short: "Starts a new dazzle project",
-	Args:  cobra.MaximumNArgs(1),
+	Long: `Starts a new dazzle project, or adds a chunk to an existing project.
+
+New chunks are generated from a template (--template): default, go, node, python or the path of
+a directory which contains a Dockerfile, tests.yaml and optionally chunk.yaml. The files are Go
+templates which can refer to the chunk name as {{ .Chunk }}.`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		if len(args) > 0 {
-			chk := args[0]
-			err = os.MkdirAll(filepath.Join("chunks", chk), 0755)
-			if err != nil {
-				return
-			}
-			err = os.WriteFile(filepath.Join("chunks", chk, "Dockerfile"), []byte("ARG base\nFROM ${base}\n\n"), 0755)
-			if err != nil {
-				return
-			}
-
-			err = os.Mkdir("tests", 0755)
-			if err != nil && !os.IsExist(err) {
-				return
-			}
-			err = os.WriteFile(fmt.Sprintf("tests/%s.yaml", chk), []byte("- desc: \"it should say hello\"\n  command: [\"echo\", \"hello\"]\n  assert:\n  - status == 0\n  - stdout.indexOf(\"hello\") != -1\n  - stderr.length == 0"), 0755)
-			if err != nil {
-				return
-			}
-			return
+			tpl, _ := cmd.Flags().GetString("template")
+			return initChunk(args[0], tpl)
 		}
 
 		err = os.Mkdir("base", 0755)
@@ -67,7 +66,7 @@ var projectInitCmd = &cobra.Command{
 			return
 		}
 
-		var cfg dazzle.ProjectConfig
+		cfg := dazzle.ProjectConfig{Version: dazzle.ProjectConfigVersion}
 		err = cfg.Write(".")
 		if err != nil {
 			return
@@ -78,6 +77,67 @@ var projectInitCmd = &cobra.Command{
 	},
 }
 
+// initChunk creates a chunk and its tests from a built-in template or a template directory
+func initChunk(chk, tpl string) error {
+	var src fs.FS
+	if _, err := fs.Stat(chunkTemplates, path.Join("templates", tpl)); err == nil && tpl != "" && tpl != "." {
+		src, _ = fs.Sub(chunkTemplates, path.Join("templates", tpl))
+	} else if stat, err := os.Stat(tpl); err == nil && stat.IsDir() {
+		src = os.DirFS(tpl)
+	} else {
+		return fmt.Errorf("unknown template %s: must be default, go, node, python or a directory", tpl)
+	}
+
+	testsDir := "tests"
+	if cfg, err := dazzle.LoadProjectConfig(os.DirFS(".")); err == nil {
+		testsDir = filepath.FromSlash(cfg.TestsDir())
+	}
+
+	files := map[string]string{
+		"Dockerfile": filepath.Join("chunks", chk, "Dockerfile"),
+		"chunk.yaml": filepath.Join("chunks", chk, "chunk.yaml"),
+		"tests.yaml": filepath.Join(testsDir, chk+".yaml"),
+	}
+	rendered := make(map[string][]byte, len(files))
+	for fn, dst := range files {
+		fc, err := fs.ReadFile(src, fn)
+		if os.IsNotExist(err) && fn == "chunk.yaml" {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("cannot read template: %w", err)
+		}
+
+		t, err := template.New(fn).Parse(string(fc))
+		if err != nil {
+			return fmt.Errorf("cannot parse template %s: %w", fn, err)
+		}
+		var buf bytes.Buffer
+		err = t.Execute(&buf, struct{ Chunk string }{Chunk: chk})
+		if err != nil {
+			return fmt.Errorf("cannot render template %s: %w", fn, err)
+		}
+
+		if _, err := os.Stat(dst); err == nil {
+			return fmt.Errorf("%s exists already", dst)
+		}
+		rendered[dst] = buf.Bytes()
+	}
+
+	for dst, fc := range rendered {
+		err := os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(dst, fc, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func init() {
 	projectCmd.AddCommand(projectInitCmd)
+
+	projectInitCmd.Flags().String("template", "default", "template of new chunks: default, go, node, python or a template directory")
 }
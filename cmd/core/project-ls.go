@@ -0,0 +1,118 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+type projectListing struct {
+	Chunks       []projectListingChunk       `json:"chunks"`
+	Combinations []projectListingCombination `json:"combinations"`
+}
+
+type projectListingChunk struct {
+	Name    string            `json:"name"`
+	Variant string            `json:"variant,omitempty"`
+	Args    map[string]string `json:"args,omitempty"`
+	Tests   int               `json:"tests"`
+}
+
+type projectListingCombination struct {
+	Name   string   `json:"name"`
+	Chunks []string `json:"chunks"`
+}
+
+var projectLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "lists the chunks and combinations of the project",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		if err != nil {
+			return err
+		}
+
+		res := projectListing{
+			Chunks:       make([]projectListingChunk, 0, len(prj.Chunks)),
+			Combinations: make([]projectListingCombination, 0, len(prj.Config.Combiner.Combinations)),
+		}
+		for _, chk := range prj.Chunks {
+			segs := strings.SplitN(chk.Name, ":", 2)
+			c := projectListingChunk{Name: segs[0], Args: chk.Args, Tests: len(chk.Tests)}
+			if len(segs) == 2 {
+				c.Variant = segs[1]
+			}
+			res.Chunks = append(res.Chunks, c)
+		}
+		for _, comb := range prj.Config.Combiner.Combinations {
+			res.Combinations = append(res.Combinations, projectListingCombination{Name: comb.Name, Chunks: comb.Chunks})
+		}
+
+		switch output {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "CHUNK\tVARIANT\tARGS\tTESTS")
+			for _, c := range res.Chunks {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", c.Name, c.Variant, formatArgs(c.Args), c.Tests)
+			}
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "COMBINATION\tCHUNKS")
+			for _, c := range res.Combinations {
+				fmt.Fprintf(w, "%s\t%s\n", c.Name, strings.Join(c.Chunks, ", "))
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("unknown output format %q, must be table or json", output)
+		}
+	},
+}
+
+// formatArgs renders build args as a sorted, comma separated list of key=value pairs
+func formatArgs(args map[string]string) string {
+	res := make([]string, 0, len(args))
+	for k, v := range args {
+		res = append(res, k+"="+v)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ", ")
+}
+
+func init() {
+	projectCmd.AddCommand(projectLsCmd)
+
+	projectLsCmd.Flags().StringP("output", "o", "table", "output format: table or json")
+}
@@ -21,9 +21,11 @@
 package core
 
 import (
-	"context"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -31,11 +33,12 @@ import (
 )
 
 var projectManifestCmd = &cobra.Command{
-	Use:   "manifest <target-ref> [chunk]",
-	Short: "prints the manifest of a chunk (or all of them)",
-	Args:  cobra.MinimumNArgs(1),
+	Use:               "manifest <target-ref> [chunk]",
+	Short:             "prints the manifest of a chunk (or all of them)",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArg(1, completeChunks),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{Chunks: args[1:]})
 		if err != nil {
 			return err
 		}
@@ -44,7 +47,7 @@ var projectManifestCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		err = sess.DownloadBaseInfo(context.Background(), prj)
+		err = sess.DownloadBaseInfo(cmd.Context(), prj)
 		if err != nil {
 			return err
 		}
@@ -75,17 +78,52 @@ var projectManifestCmd = &cobra.Command{
 			}
 		}
 
-		for _, c := range chunks {
-			err = c.PrintManifest(os.Stdout, sess)
-			if err != nil {
-				return err
+		output, _ := cmd.Flags().GetString("output")
+		switch output {
+		case "json":
+			res := make([]chunkManifest, 0, len(chunks))
+			for _, c := range chunks {
+				var mf bytes.Buffer
+				err = c.PrintManifest(&mf, sess)
+				if err != nil {
+					return err
+				}
+				hash, err := c.Hash(nil, sess)
+				if err != nil {
+					return err
+				}
+				res = append(res, chunkManifest{
+					Chunk:    c.Name,
+					Hash:     hash,
+					Manifest: strings.Split(strings.TrimSpace(mf.String()), "\n"),
+				})
 			}
-		}
 
-		return nil
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+		case "text":
+			for _, c := range chunks {
+				err = c.PrintManifest(os.Stdout, sess)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown output format %q, must be text or json", output)
+		}
 	},
 }
 
+type chunkManifest struct {
+	Chunk    string   `json:"chunk"`
+	Hash     string   `json:"hash"`
+	Manifest []string `json:"manifest"`
+}
+
 func init() {
 	projectCmd.AddCommand(projectManifestCmd)
+
+	projectManifestCmd.Flags().StringP("output", "o", "text", "output format: text or json (includes the hash)")
 }
@@ -0,0 +1,59 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var projectMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "upgrades a project to the current layout",
+	Long: `Upgrades a project created for an older version of dazzle to the current layout and records
+the layout version in dazzle.yaml, e.g. moves the base image from _base to base and renames
+<chunk>-tests.yaml test suites to <chunk>.yaml.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		changes, err := dazzle.MigrateProject(rootCfg.ContextDir, dryRun)
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		if err != nil {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: err}
+		}
+		if len(changes) == 0 {
+			fmt.Println("project is up to date")
+		}
+		return nil
+	},
+}
+
+func init() {
+	projectCmd.AddCommand(projectMigrateCmd)
+
+	projectMigrateCmd.Flags().Bool("dry-run", false, "print the changes without making them")
+}
@@ -0,0 +1,89 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var projectRmChunkCmd = &cobra.Command{
+	Use:   "rm-chunk <chunk> [chunk ...]",
+	Short: "removes a chunk from all combinations of a project",
+	Long: `Removes a chunk and all its variants from the combinations of a project.
+With --delete the chunk directory and its tests are deleted as well.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeArgsFrom(0, completeChunkNames),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		del, _ := cmd.Flags().GetBool("delete")
+
+		for _, chk := range args {
+			if chk == "" || chk == "." || chk == ".." || strings.ContainsAny(chk, ":/\\") {
+				return fmt.Errorf("%s is not a chunk name - variants cannot be removed individually", chk)
+			}
+
+			changed, err := dazzle.RemoveChunkReferences(rootCfg.ContextDir, chk)
+			if err != nil {
+				return err
+			}
+			for _, c := range changed {
+				log.WithField("chunk", chk).WithField("combination", c).Info("removed chunk from combination")
+			}
+			if !del {
+				continue
+			}
+
+			err = os.RemoveAll(filepath.Join(rootCfg.ContextDir, "chunks", chk))
+			if err != nil {
+				return err
+			}
+			testsDir, err := projectTestsDir()
+			if err != nil {
+				return err
+			}
+			tfs, err := filepath.Glob(filepath.Join(testsDir, chk+"--*"))
+			if err != nil {
+				return err
+			}
+			for _, tf := range append(tfs, filepath.Join(testsDir, chk+".yaml"), filepath.Join(testsDir, chk)) {
+				err = os.RemoveAll(tf)
+				if err != nil {
+					return err
+				}
+			}
+			log.WithField("chunk", chk).Info("deleted chunk")
+		}
+		return nil
+	},
+}
+
+func init() {
+	projectCmd.AddCommand(projectRmChunkCmd)
+
+	projectRmChunkCmd.Flags().Bool("delete", false, "delete the chunk directory and its tests")
+}
@@ -0,0 +1,67 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var projectSchemaCmd = &cobra.Command{
+	Use:   "schema [project|chunk]",
+	Short: "prints the JSON schema of dazzle.yaml or chunk.yaml",
+	Long: `Prints the JSON schema of dazzle.yaml (project, the default) or chunk.yaml (chunk), e.g. to configure
+editors to complete and check these files.`,
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{"project", "chunk"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind := "project"
+		if len(args) > 0 {
+			kind = args[0]
+		}
+
+		var schema *jsonschema.Schema
+		switch kind {
+		case "project":
+			schema = dazzle.ProjectConfigSchema()
+		case "chunk":
+			schema = dazzle.ChunkConfigSchema()
+		default:
+			return fmt.Errorf("unknown schema %q, must be project or chunk", kind)
+		}
+
+		fc, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(fc))
+		return nil
+	},
+}
+
+func init() {
+	projectCmd.AddCommand(projectSchemaCmd)
+}
@@ -0,0 +1,66 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var projectValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "checks the project for problems without building it",
+	Long: `Checks the project for problems which would otherwise only surface during or after a build:
+fields of dazzle.yaml and chunk.yaml files which violate their schema, e.g. misspelled ones,
+combinations referencing unknown chunks, chunk Dockerfiles which do not build on the base image,
+duplicate variant names, invalid env var combination actions, test files for nonexistent chunks
+and chunks which are not part of any combination.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		strict, _ := cmd.Flags().GetBool("strict")
+
+		problems, err := dazzle.ValidateProject(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		if err != nil {
+			return err
+		}
+
+		var errs int
+		for _, p := range problems {
+			fmt.Println(p)
+			if !p.Warning || strict {
+				errs++
+			}
+		}
+		if errs > 0 {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("project has %d problem(s)", errs)}
+		}
+		return nil
+	},
+}
+
+func init() {
+	projectCmd.AddCommand(projectValidateCmd)
+
+	projectValidateCmd.Flags().Bool("strict", false, "treat warnings as errors")
+}
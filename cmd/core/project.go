@@ -21,7 +21,14 @@
 package core
 
 import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
 	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
 )
 
 var projectCmd = &cobra.Command{
@@ -33,3 +40,16 @@ var projectCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(projectCmd)
 }
+
+// projectTestsDir returns the tests directory of the project in the context dir, which is tests unless
+// dazzle.yaml configures a different one
+func projectTestsDir() (string, error) {
+	cfg, err := dazzle.LoadProjectConfig(os.DirFS(rootCfg.ContextDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return filepath.Join(rootCfg.ContextDir, "tests"), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(rootCfg.ContextDir, filepath.FromSlash(cfg.TestsDir())), nil
+}
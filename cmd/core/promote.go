@@ -0,0 +1,110 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/reference"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote <work-ref> <release-ref>",
+	Short: "Copies combinations to a release repository without rebuilding them",
+	Long: `Copies combinations which were built using "dazzle combine <work-ref>" to the release-ref, keeping their
+tags and digests. Blobs are mounted from the work repository where the registry supports it.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		if err != nil {
+			return err
+		}
+
+		workref, err := reference.ParseNamed(args[0])
+		if err != nil {
+			return fmt.Errorf("cannot parse work-ref: %w", err)
+		}
+		workref = reference.TrimNamed(workref)
+		releaseref, err := reference.ParseNamed(args[1])
+		if err != nil {
+			return fmt.Errorf("cannot parse release-ref: %w", err)
+		}
+		releaseref = reference.TrimNamed(releaseref)
+
+		var names []string
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			for _, c := range prj.Config.Combiner.Combinations {
+				names = append(names, c.Name)
+			}
+		} else if cmbns, _ := cmd.Flags().GetStringArray("combination"); len(cmbns) > 0 {
+			for _, n := range cmbns {
+				var found bool
+				for _, c := range prj.Config.Combiner.Combinations {
+					if c.Name == n {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("combination %s not found", n)}
+				}
+			}
+			names = cmbns
+		} else {
+			return fmt.Errorf("must use one of --all or --combination")
+		}
+
+		ctx := cmd.Context()
+
+		resolver := getResolver()
+		for _, n := range names {
+			src, err := reference.WithTag(workref, n)
+			if err != nil {
+				return err
+			}
+			dest, err := reference.WithTag(releaseref, n)
+			if err != nil {
+				return err
+			}
+
+			log.WithField("combination", n).WithField("src", src.String()).WithField("dest", dest.String()).Warn("promoting combination")
+			absref, err := dazzle.Promote(ctx, log.StandardLogger(), resolver, src, dest)
+			if err != nil {
+				return err
+			}
+			fmt.Println(absref.String())
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(promoteCmd)
+
+	promoteCmd.Flags().Bool("all", false, "promote all combinations")
+	promoteCmd.Flags().StringArray("combination", nil, "promote a specific combination (can be repeated)")
+
+	_ = promoteCmd.RegisterFlagCompletionFunc("combination", completeCombinations)
+}
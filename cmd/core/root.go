@@ -21,8 +21,11 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
@@ -30,15 +33,24 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/gitpod-io/dazzle/pkg/auth"
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
 	"github.com/gitpod-io/dazzle/pkg/fancylog"
 )
 
 var rootCfg struct {
-	Verbose      bool
-	ContextDir   string
-	BuildkitAddr string
+	Verbose       bool
+	ContextDir    string
+	BuildkitAddr  string
+	LogFile       string
+	ReadOnly      bool
+	Offline       bool
+	CacheMetadata bool
 }
 
+// logFile is the file --log-file writes to, which Execute closes once the command has finished
+var logFile *os.File
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "dazzle",
@@ -47,11 +59,27 @@ var rootCmd = &cobra.Command{
 this way we can avoid needless cache invalidation.
 
 THIS IS AN EXPERIEMENT. THINGS WILL BREAK. BEWARE.`,
+	// Execute logs the error, so that it ends up in the --log-file as well
+	SilenceErrors: true,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		formatter := &fancylog.Formatter{}
+		err := applyConfigOverrides(cmd)
+		if err != nil {
+			return err
+		}
+
+		formatter := fancylog.NewFormatter(os.Stderr)
 		log.SetFormatter(formatter)
 		log.SetLevel(log.InfoLevel)
 
+		if rootCfg.LogFile != "" {
+			f, err := os.Create(rootCfg.LogFile)
+			if err != nil {
+				return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("cannot create log file: %w", err)}
+			}
+			logFile = f
+			log.AddHook(fancylog.NewFileHook(f))
+		}
+
 		if rootCfg.Verbose {
 			log.SetLevel(log.DebugLevel)
 		}
@@ -69,40 +97,117 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&rootCfg.Verbose, "verbose", "v", false, "enable verbose logging")
 	rootCmd.PersistentFlags().StringVar(&rootCfg.ContextDir, "context", wd, "context path")
 	rootCmd.PersistentFlags().StringVar(&rootCfg.BuildkitAddr, "addr", "unix:///run/buildkit/buildkitd.sock", "address of buildkitd")
+	rootCmd.PersistentFlags().StringVar(&rootCfg.LogFile, "log-file", "", "also write the log output without colors to this file")
+	rootCmd.PersistentFlags().BoolVar(&rootCfg.ReadOnly, "read-only", false, "never push to a registry, e.g. when using read-only credentials")
+	rootCmd.PersistentFlags().BoolVar(&rootCfg.Offline, "offline", false, "serve registry metadata from the cache of earlier runs and fail on anything which needs the network")
+	rootCmd.PersistentFlags().BoolVar(&rootCfg.CacheMetadata, "cache-metadata", false, "keep the registry metadata in the cache which --offline serves from")
+
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: err}
+	})
+}
+
+// Exit codes which tell CI pipelines why dazzle failed
+const (
+	exitCodeFailure  = 1
+	exitCodeConfig   = 2
+	exitCodeBuild    = 3
+	exitCodeTest     = 4
+	exitCodeRegistry = 5
+)
+
+// exitCode maps an error to the exit code of its kind
+func exitCode(err error) int {
+	switch dazzle.KindOf(err) {
+	case dazzle.ErrorKindConfig:
+		return exitCodeConfig
+	case dazzle.ErrorKindBuild:
+		return exitCodeBuild
+	case dazzle.ErrorKindTest:
+		return exitCodeTest
+	case dazzle.ErrorKindRegistry:
+		return exitCodeRegistry
+	default:
+		return exitCodeFailure
+	}
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// SIGINT and SIGTERM cancel the context of the command, which stops buildkit solves, pushes and tests.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		log.Error(err)
+	}
+	if logFile != nil {
+		cerr := logFile.Close()
+		if cerr != nil {
+			fmt.Fprintf(os.Stderr, "cannot close log file: %v\n", cerr)
+		}
+	}
+	if err != nil {
+		os.Exit(exitCode(err))
 	}
 }
 
+// checkWritable fails commands which cannot do without pushing if --read-only or --offline is set
+// ExecuteTestAdd runs "dazzle test add" with args, i.e. its flags and arguments, and exits like Execute does.
+// It backs the deprecated "dazzle-util test add".
+func ExecuteTestAdd(args []string) {
+	rootCmd.SetArgs(append([]string{"test", "add"}, args...))
+	Execute()
+}
+
+func checkWritable(command string) error {
+	switch {
+	case rootCfg.Offline:
+		return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("%s pushes images: %w", command, dazzle.ErrOffline)}
+	case rootCfg.ReadOnly:
+		return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("%s pushes images: %w", command, dazzle.ErrReadOnly)}
+	default:
+		return nil
+	}
+}
+
+// getResolver returns a resolver which authenticates using the Docker config. With --cache-metadata it keeps the
+// registry metadata in the metadata cache, which it serves from if --offline is set. It refuses to push if --read-only is set.
 func getResolver() remotes.Resolver {
-	dockerCfg := config.LoadDefaultConfigFile(os.Stderr)
+	var resolver remotes.Resolver
+	if !rootCfg.Offline {
+		resolver = getRegistryResolver()
+	}
+	if rootCfg.Offline || rootCfg.CacheMetadata {
+		cacheDir, err := dazzle.DefaultMetadataCacheDir()
+		if err != nil {
+			// without a cache dir there is nothing to serve offline, which the caching resolver reports as such
+			log.WithError(err).Warn("cannot find the metadata cache")
+		}
+		resolver = dazzle.NewCachingResolver(resolver, cacheDir, rootCfg.Offline, log.StandardLogger())
+	}
+	if rootCfg.ReadOnly {
+		return dazzle.NewReadOnlyResolver(resolver)
+	}
+	return resolver
+}
+
+func getRegistryResolver() remotes.Resolver {
 	return docker.NewResolver(docker.ResolverOptions{
-		Authorizer: docker.NewDockerAuthorizer(docker.WithAuthCreds(func(host string) (user, pwd string, err error) {
-			if dockerCfg == nil {
-				return
-			}
+		Authorizer: docker.NewDockerAuthorizer(docker.WithAuthCreds(registryCreds())),
+	})
+}
 
-			if host == "registry-1.docker.io" {
-				host = "https://index.docker.io/v1/"
-			}
-			ac, err := dockerCfg.GetAuthConfig(host)
-			if err != nil {
-				return
-			}
-			if ac.IdentityToken != "" {
-				pwd = ac.IdentityToken
-			} else {
-				user = ac.Username
-				pwd = ac.Password
-			}
-			log.WithField("host", host).Info("authenticating user")
+// registryCreds returns the credentials for a registry host from the environment or the docker config
+func registryCreds() func(host string) (user, pwd string, err error) {
+	dockerCfg := config.LoadDefaultConfigFile(os.Stderr)
+	return func(host string) (user, pwd string, err error) {
+		creds, ok, err := auth.Lookup(dockerCfg, host)
+		if err != nil || !ok {
 			return
-		})),
-	})
+		}
+		log.WithField("host", host).WithField("source", creds.Source).Info("authenticating user")
+		return creds.Username, creds.Password, nil
+	}
 }
@@ -0,0 +1,88 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+// addScanFlags registers the flags which configure the vulnerability scan of pushed images
+func addScanFlags(cmd *cobra.Command) {
+	cmd.Flags().String("scan", "", "scan the pushed images for vulnerabilities using trivy or grype")
+	cmd.Flags().String("scan-server", "", "address of a trivy server to scan with")
+	cmd.Flags().String("scan-fail-on", "", "fail if an image has vulnerabilities of this severity or worse (low, medium, high or critical)")
+	cmd.Flags().String("output-scan", "", "save the vulnerabilities found as JSON file")
+}
+
+// imageScan is the vulnerability scan configured using the scan flags
+type imageScan struct {
+	Scanner dazzle.Scanner
+	FailOn  dazzle.Severity
+	Output  string
+}
+
+// getImageScan returns the configured vulnerability scan, or nil if --scan is not set
+func getImageScan(cmd *cobra.Command) (*imageScan, error) {
+	name, _ := cmd.Flags().GetString("scan")
+	if name == "" {
+		return nil, nil
+	}
+
+	server, _ := cmd.Flags().GetString("scan-server")
+	scanner, err := dazzle.NewScanner(name, server)
+	if err != nil {
+		return nil, &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: err}
+	}
+	res := &imageScan{Scanner: scanner}
+	if failOn, _ := cmd.Flags().GetString("scan-fail-on"); failOn != "" {
+		res.FailOn, err = dazzle.ParseSeverity(failOn)
+		if err != nil {
+			return nil, &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: err}
+		}
+	}
+	res.Output, _ = cmd.Flags().GetString("output-scan")
+	return res, nil
+}
+
+// run scans all images pushed during the session and saves the results if requested
+func (s *imageScan) run(ctx context.Context, sess *dazzle.BuildSession) error {
+	if s == nil {
+		return nil
+	}
+
+	res, err := dazzle.ScanImages(ctx, log.StandardLogger(), s.Scanner, sess.PushedRefs(), s.FailOn)
+	if s.Output != "" {
+		fc, werr := json.MarshalIndent(res, "", "  ")
+		if werr == nil {
+			werr = os.WriteFile(s.Output, fc, 0644)
+		}
+		if werr != nil {
+			log.WithError(werr).Error("cannot write scan results")
+		}
+	}
+	return err
+}
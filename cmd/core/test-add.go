@@ -0,0 +1,286 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gookit/color"
+	"github.com/manifoldco/promptui"
+	"github.com/moby/buildkit/client"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+	"github.com/gitpod-io/dazzle/pkg/test"
+)
+
+var testAddCmd = &cobra.Command{
+	Use:   "add <target-ref>",
+	Short: "Adds a test to the suite of a chunk",
+	Long: `Adds a test to <chunk>.yaml in the tests directory. The test command runs in the test image of the chunk
+using buildkit, and its result is used to check the assertions or to prompt for them.
+
+If --description, --command and --assert are given, no prompts are shown.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chunk, _ := cmd.Flags().GetString("chunk")
+		if chunk == "" {
+			return fmt.Errorf("--chunk is required")
+		}
+		nonInteractive, _ := cmd.Flags().GetBool("non-interactive")
+
+		prj, err := dazzle.LoadFromDir(rootCfg.ContextDir, dazzle.LoadFromDirOpts{})
+		if err != nil {
+			return err
+		}
+
+		// variants share the tests of their chunk
+		fn := filepath.Join(rootCfg.ContextDir, filepath.FromSlash(prj.Config.TestsDir()), strings.SplitN(chunk, ":", 2)[0]+".yaml")
+		fc, err := os.ReadFile(fn)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		suite, err := test.ParseSuite(fc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+
+		desc, _ := cmd.Flags().GetString("description")
+		if desc == "" {
+			if nonInteractive {
+				return fmt.Errorf("--description is required")
+			}
+			p := promptui.Prompt{
+				Label:    "Description",
+				Validate: required,
+			}
+			desc, err = p.Run()
+			if err != nil {
+				return err
+			}
+		}
+		command, _ := cmd.Flags().GetString("command")
+		if command == "" {
+			if nonInteractive {
+				return fmt.Errorf("--command is required")
+			}
+			p := promptui.Prompt{
+				Label: "Test command",
+				Validate: func(s string) error {
+					if err = required(s); err != nil {
+						return err
+					}
+					if _, err = splitCommand(s); err != nil {
+						return err
+					}
+					return nil
+				},
+			}
+			command, err = p.Run()
+			if err != nil {
+				return err
+			}
+		}
+		commandsegs, err := splitCommand(command)
+		if err != nil {
+			return err
+		}
+		user, _ := cmd.Flags().GetString("user")
+		envvars, _ := cmd.Flags().GetStringArray("env")
+		tags, _ := cmd.Flags().GetStringArray("tag")
+		entrypoint, _ := cmd.Flags().GetString("entrypoint")
+		var epsegs []string
+		if entrypoint != "" {
+			epsegs, err = splitCommand(entrypoint)
+			if err != nil {
+				return err
+			}
+		}
+		assertions, _ := cmd.Flags().GetStringArray("assert")
+		if len(assertions) == 0 && nonInteractive {
+			return fmt.Errorf("--assert is required")
+		}
+
+		spec := &test.Spec{
+			Desc:       desc,
+			Command:    commandsegs,
+			User:       user,
+			Env:        envvars,
+			Entrypoint: epsegs,
+			Tags:       tags,
+		}
+
+		ctx := cmd.Context()
+
+		cl, err := client.New(ctx, rootCfg.BuildkitAddr, client.WithFailFast())
+		if err != nil {
+			return err
+		}
+		plainOutput, _ := cmd.Flags().GetBool("plain-output")
+		session, err := dazzle.NewSession(cl, args[0],
+			dazzle.WithResolver(getResolver()),
+			dazzle.WithPlainOutput(plainOutput || nonInteractive),
+		)
+		if err != nil {
+			return err
+		}
+		executor, err := prj.TestExecutor(ctx, session, chunk)
+		if err != nil {
+			return err
+		}
+
+		// run the command with the suite's setup/teardown applied, just like the tests would
+		probe := (&test.Suite{Before: suite.Before, After: suite.After, Tests: []*test.Spec{spec}}).Specs()[0]
+		tr, err := executor.Run(ctx, probe)
+		if err != nil {
+			return err
+		}
+
+		if len(assertions) > 0 {
+			var res test.Result
+			err = test.ValidateAssertions(log.StandardLogger(), &res, assertions, tr)
+			if err != nil {
+				return err
+			}
+			if res.Failure != nil {
+				return fmt.Errorf("assertion failed: %s", res.Failure.Message)
+			}
+			spec.Assertions = assertions
+		} else {
+			err = addAssertions(spec, tr)
+			if err != nil {
+				return err
+			}
+		}
+
+		suite.Tests = append(suite.Tests, spec)
+		fc, err = yaml.Marshal(suite)
+		if err != nil {
+			return err
+		}
+		if !nonInteractive {
+			fmt.Println(string(fc))
+		}
+
+		err = os.MkdirAll(filepath.Dir(fn), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(fn, fc, 0644)
+		if err != nil {
+			return err
+		}
+		log.WithField("chunk", chunk).WithField("file", fn).Info("test added")
+		return nil
+	},
+}
+
+func init() {
+	testCmd.AddCommand(testAddCmd)
+
+	testAddCmd.Flags().String("chunk", "", "chunk whose test image the test runs in, e.g. golang or golang:1.16")
+	testAddCmd.Flags().StringP("description", "d", "", "test description")
+	testAddCmd.Flags().StringP("command", "c", "", "test command to execute")
+	testAddCmd.Flags().StringP("user", "u", "", "user to execute the command as")
+	testAddCmd.Flags().StringArrayP("env", "e", []string{}, "set environment variables (VAR=VALUE) for running the test command")
+	testAddCmd.Flags().String("entrypoint", "", "container entrypoint")
+	testAddCmd.Flags().StringArray("tag", nil, "tag the test (can be repeated)")
+	testAddCmd.Flags().StringArrayP("assert", "a", nil, "assertion the test result must satisfy (can be repeated)")
+	testAddCmd.Flags().Bool("non-interactive", false, "never prompt, fail if --description, --command or --assert is missing")
+	testAddCmd.Flags().Bool("plain-output", false, "produce plain output")
+
+	_ = testAddCmd.RegisterFlagCompletionFunc("chunk", completeChunks)
+}
+
+func required(s string) error {
+	if len(strings.TrimSpace(s)) == 0 {
+		return fmt.Errorf("required")
+	}
+	return nil
+}
+
+func splitCommand(cmd string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(cmd))
+	r.Comma = ' '
+	return r.Read()
+}
+
+func addAssertions(spec *test.Spec, runres *test.RunResult) error {
+	// don't let log messages interfere with prompt
+	log.SetLevel(log.WarnLevel)
+
+	stdout, _ := json.Marshal(string(runres.Stdout))
+	stderr, _ := json.Marshal(string(runres.Stderr))
+	fmt.Println("Available variables are:")
+	color.Info.Print("stdout: ")
+	fmt.Println(string(stdout))
+	color.Info.Print("stderr: ")
+	fmt.Println(string(stderr))
+	color.Info.Print("status: ")
+	fmt.Println(runres.StatusCode)
+
+	for {
+		p := promptui.Prompt{
+			Label:     "Assertion",
+			AllowEdit: true,
+			Validate: func(a string) error {
+				var res test.Result
+				err := test.ValidateAssertions(log.StandardLogger(), &res, []string{a}, runres)
+				if err != nil {
+					return err
+				}
+
+				if res.Failure != nil {
+					return fmt.Errorf(res.Failure.Message)
+				}
+
+				return nil
+			},
+		}
+		a, err := p.Run()
+		if err != nil {
+			return err
+		}
+		spec.Assertions = append(spec.Assertions, a)
+
+		p = promptui.Prompt{
+			Label:     "Add another assertion?",
+			IsConfirm: true,
+			Default:   "y",
+		}
+		cont, err := p.Run()
+		if err != nil && err != promptui.ErrAbort {
+			return err
+		}
+		if strings.TrimSpace(cont) != "" && strings.ToLower(cont) != "y" {
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+	"github.com/gitpod-io/dazzle/pkg/test"
+)
+
+var testLintCmd = &cobra.Command{
+	Use:   "lint [test00.yaml ... testN.yaml]",
+	Short: "validates test suites without running them",
+	Long: `Validates test suites against the test spec schema and checks for duplicate descriptions
+and assertions which do not compile. Without arguments all tests of the project are checked, including
+suites split into a directory per chunk.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fns := args
+		if len(fns) == 0 {
+			testsDir, err := projectTestsDir()
+			if err != nil {
+				return err
+			}
+			for _, pattern := range []string{"*.yaml", filepath.Join("*", "*.yaml")} {
+				m, err := filepath.Glob(filepath.Join(testsDir, pattern))
+				if err != nil {
+					return err
+				}
+				fns = append(fns, m...)
+			}
+		}
+
+		var problems int
+		for _, fn := range fns {
+			fc, err := os.ReadFile(fn)
+			if err != nil {
+				return err
+			}
+			res, err := test.Lint(fc)
+			if err != nil {
+				return fmt.Errorf("%s: %w", fn, err)
+			}
+			for _, p := range res {
+				fmt.Printf("%s: %s\n", fn, p)
+			}
+			problems += len(res)
+		}
+		if problems > 0 {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("found %d problem(s) in %d file(s)", problems, len(fns))}
+		}
+		return nil
+	},
+}
+
+func init() {
+	testCmd.AddCommand(testLintCmd)
+}
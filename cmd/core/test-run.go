@@ -0,0 +1,166 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/client"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+	"github.com/gitpod-io/dazzle/pkg/test"
+	"github.com/gitpod-io/dazzle/pkg/test/docker"
+	"github.com/gitpod-io/dazzle/pkg/test/kubernetes"
+)
+
+var testRunCmd = &cobra.Command{
+	Use:   "run <test00.yaml> ... <testN.yaml>",
+	Short: "runs test suites against an existing image",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ref, _ := cmd.Flags().GetString("image")
+		if ref == "" {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("--image is required")}
+		}
+		filterExprs, _ := cmd.Flags().GetStringArray("filter")
+		filters, err := test.ParseFilters(filterExprs)
+		if err != nil {
+			return &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: err}
+		}
+		allowEnv, _ := cmd.Flags().GetStringArray("allow-env")
+
+		testTimeout, _ := cmd.Flags().GetDuration("test-timeout")
+
+		ctx := cmd.Context()
+		executor, err := newTestExecutor(ctx, cmd, ref)
+		if err != nil {
+			return err
+		}
+
+		rerunFailed, _ := cmd.Flags().GetBool("rerun-failed")
+		failures, failuresFN, err := loadFailureLog()
+		if err != nil {
+			return err
+		}
+		defer saveFailureLog(failures, failuresFN)
+
+		var (
+			results []test.Results
+			failed  []string
+		)
+		for _, fn := range args {
+			fc, err := os.ReadFile(fn)
+			if err != nil {
+				return err
+			}
+			suite, err := test.ParseSuite(fc)
+			if err != nil {
+				return fmt.Errorf("%s: %w", fn, err)
+			}
+			tests, err := test.InterpolateSpecs(test.FilterSpecs(suite.Specs(), filters), allowEnv)
+			if err != nil {
+				return fmt.Errorf("%s: %w", fn, err)
+			}
+			suiteID, _ := filepath.Abs(fn)
+			if rerunFailed {
+				tests = failures.Failed(suiteID, tests)
+			}
+
+			res, ok := test.RunTests(ctx, executor, tests, test.WithTimeout(testTimeout))
+			failures.Update(suiteID, res)
+			res.Name = strings.TrimSuffix(filepath.Base(fn), filepath.Ext(fn))
+			results = append(results, res)
+			if !ok {
+				failed = append(failed, res.Name)
+			}
+		}
+		writeReports(cmd, results...)
+
+		if len(failed) > 0 {
+			return &dazzle.Error{Kind: dazzle.ErrorKindTest, Err: fmt.Errorf("%s failed the tests of %s", ref, strings.Join(failed, ", "))}
+		}
+		return nil
+	},
+}
+
+// newTestExecutor produces the executor selected using --executor for the image under test
+func newTestExecutor(ctx context.Context, cmd *cobra.Command, ref string) (test.Executor, error) {
+	executor, _ := cmd.Flags().GetString("executor")
+	switch executor {
+	case "docker":
+		cl, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("cannot create Docker client: %w", err)}
+		}
+		_, err = cl.Ping(ctx)
+		if err != nil {
+			return nil, &dazzle.Error{Kind: dazzle.ErrorKindConfig, Err: fmt.Errorf("the docker executor requires a Docker daemon: %w", err)}
+		}
+		if pull, _ := cmd.Flags().GetBool("pull"); pull {
+			log.WithField("ref", ref).Info("pulling image")
+			err := docker.PullImage(ctx, cl, ref, registryCreds())
+			if err != nil {
+				return nil, err
+			}
+		}
+		cfg, err := docker.InspectImage(ctx, cl, ref)
+		if err != nil {
+			return nil, err
+		}
+		return docker.NewExecutor(cl, ref, cfg), nil
+	case "kubernetes":
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse image ref: %w", err)
+		}
+		var cfg ociv1.Image
+		_, _, err = dazzle.NewResolverRegistry(getResolver()).Pull(ctx, named, &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get image config: %w", err)
+		}
+		namespace, _ := cmd.Flags().GetString("namespace")
+		return kubernetes.NewExecutor(ref, &cfg, kubernetes.WithNamespace(namespace)), nil
+	default:
+		return nil, fmt.Errorf("unknown executor %q: must be docker or kubernetes", executor)
+	}
+}
+
+func init() {
+	testCmd.AddCommand(testRunCmd)
+
+	testRunCmd.Flags().String("image", "", "image to run the tests against")
+	testRunCmd.Flags().String("executor", "docker", "how to run the tests: docker or kubernetes")
+	testRunCmd.Flags().Bool("pull", false, "pull the image before running the tests (docker executor only)")
+	testRunCmd.Flags().String("namespace", "", "namespace to run test pods in (kubernetes executor only)")
+	testRunCmd.Flags().StringArray("filter", nil, "only run tests matching the filter, e.g. tag=gpu, tag!=slow or desc~=python (can be repeated)")
+	testRunCmd.Flags().StringArray("allow-env", nil, "allow tests to reference this host environment variable as ${NAME} (can be repeated)")
+	testRunCmd.Flags().Bool("rerun-failed", false, "only run the tests which failed in the previous run")
+	testRunCmd.Flags().Duration("test-timeout", test.DefaultTimeout, "time each test may take")
+	addTestReportFlags(testRunCmd)
+}
@@ -22,21 +22,94 @@ package core
 
 import (
 	"fmt"
+	"runtime"
+	"runtime/debug"
 
 	"github.com/spf13/cobra"
 )
 
-var version = "unknown"
+// version, commit and date are set at build time using -ldflags "-X ..."
+var (
+	version = "unknown"
+	commit  = ""
+	date    = ""
+)
+
+const buildkitModule = "github.com/moby/buildkit"
+
+type versionInfo struct {
+	Version  string
+	Commit   string
+	Date     string
+	Go       string
+	Buildkit string
+}
+
+// getVersionInfo completes the version set at build time with the VCS information and dependencies
+// the Go toolchain embeds into the binary
+func getVersionInfo() versionInfo {
+	res := versionInfo{
+		Version:  version,
+		Commit:   commit,
+		Date:     date,
+		Go:       runtime.Version(),
+		Buildkit: "unknown",
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return res
+	}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && res.Commit == "":
+			res.Commit = s.Value
+		case s.Key == "vcs.time" && res.Date == "":
+			res.Date = s.Value
+		}
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path != buildkitModule {
+			continue
+		}
+		res.Buildkit = dep.Version
+		if dep.Replace != nil {
+			res.Buildkit = dep.Replace.Version
+		}
+	}
+	return res
+}
 
 // versionCmd represents the version command
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Prints the dazzling version",
+	Long: `Prints the version of dazzle, the commit and date it was built from, and the versions of Go
+and the buildkit client it was built with. Please include this when filing an issue.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println(version)
+		if short, _ := cmd.Flags().GetBool("short"); short {
+			fmt.Println(version)
+			return
+		}
+
+		nfo := getVersionInfo()
+		fmt.Printf("version:         %s\n", nfo.Version)
+		fmt.Printf("commit:          %s\n", orUnknown(nfo.Commit))
+		fmt.Printf("build date:      %s\n", orUnknown(nfo.Date))
+		fmt.Printf("go:              %s\n", nfo.Go)
+		fmt.Printf("buildkit client: %s\n", nfo.Buildkit)
 	},
 }
 
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
 func init() {
 	rootCmd.AddCommand(versionCmd)
+
+	versionCmd.Flags().Bool("short", false, "print the version only")
 }
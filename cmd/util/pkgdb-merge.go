@@ -0,0 +1,89 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+var dpkgStatusMergeCmd = &cobra.Command{
+	Use:   "dpkg-status-merge <dest> <status00> ... <statusN>",
+	Short: "Merges dpkg status databases",
+	Long: `Merges dpkg status databases (/var/lib/dpkg/status), e.g. those of the chunks of a combination,
+and writes the result to dest. Packages listed in several databases are taken from the last one,
+and conflicting versions are reported. Dest may be one of the inputs.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		mergePackageDBs(cmd, args, dazzle.MergeDpkgStatus)
+	},
+}
+
+var apkDBMergeCmd = &cobra.Command{
+	Use:   "apk-db-merge <dest> <installed00> ... <installedN>",
+	Short: "Merges apk databases of installed packages",
+	Long: `Merges apk databases of installed packages (/lib/apk/db/installed), e.g. those of the chunks
+of a combination, and writes the result to dest. Packages listed in several databases are taken
+from the last one, and conflicting versions are reported. Dest may be one of the inputs.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		mergePackageDBs(cmd, args, dazzle.MergeApkInstalled)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dpkgStatusMergeCmd)
+	rootCmd.AddCommand(apkDBMergeCmd)
+
+	for _, cmd := range []*cobra.Command{dpkgStatusMergeCmd, apkDBMergeCmd} {
+		cmd.Flags().Bool("strict", false, "fail instead of overwriting a package with a different version")
+	}
+}
+
+// mergePackageDBs merges the databases args[1:] into args[0] and reports conflicting package versions
+func mergePackageDBs(cmd *cobra.Command, args []string, merge func(dbs ...[]byte) ([]byte, []dazzle.PackageConflict)) {
+	srcs := args[1:]
+	dbs := make([][]byte, 0, len(srcs))
+	for _, fn := range srcs {
+		fc, err := os.ReadFile(fn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dbs = append(dbs, fc)
+	}
+
+	res, conflicts := merge(dbs...)
+	for _, c := range conflicts {
+		log.WithField("package", c.Name).WithField("old", c.Old).WithField("new", c.New).WithField("source", srcs[c.Source]).Warn("conflicting package versions")
+	}
+	if strict, _ := cmd.Flags().GetBool("strict"); strict && len(conflicts) > 0 {
+		log.Fatalf("%d packages have conflicting versions", len(conflicts))
+	}
+
+	err := os.WriteFile(args[0], res, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
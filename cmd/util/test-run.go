@@ -21,13 +21,12 @@
 package util
 
 import (
-	"context"
-	"encoding/xml"
 	"os"
+	"path/filepath"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 
 	"github.com/gitpod-io/dazzle/pkg/fancylog"
 	"github.com/gitpod-io/dazzle/pkg/test"
@@ -38,36 +37,84 @@ var testRunCmd = &cobra.Command{
 	Short: "Runs a dazzle test suite",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		log.SetFormatter(&fancylog.Formatter{})
+		log.SetFormatter(fancylog.NewFormatter(os.Stderr))
 
-		testFiles := args
-		var tests []*test.Spec
+		filterExprs, _ := cmd.Flags().GetStringArray("filter")
+		filters, err := test.ParseFilters(filterExprs)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		allowEnv, _ := cmd.Flags().GetStringArray("allow-env")
+		maxOutput, _ := cmd.Flags().GetInt("max-output")
+
+		rerunFailed, _ := cmd.Flags().GetBool("rerun-failed")
+		failuresFN, err := test.DefaultFailureLogPath(".")
+		if err != nil {
+			log.Fatal(err)
+		}
+		failures, err := test.LoadFailureLog(failuresFN)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		for _, fn := range testFiles {
+		var (
+			results []test.Results
+			success = true
+		)
+		for _, fn := range args {
 			fc, err := os.ReadFile(fn)
 			if err != nil {
 				log.Fatal(err)
 			}
 
-			var t []*test.Spec
-			err = yaml.Unmarshal(fc, &t)
+			suite, err := test.ParseSuite(fc)
 			if err != nil {
 				log.WithField("file", fn).Fatal(err)
 			}
 
-			tests = append(tests, t...)
+			tests, err := test.InterpolateSpecs(test.FilterSpecs(suite.Specs(), filters), allowEnv)
+			if err != nil {
+				log.WithField("file", fn).Fatal(err)
+			}
+			if maxOutput != 0 {
+				for _, t := range tests {
+					if t.MaxOutput == 0 {
+						t.MaxOutput = maxOutput
+					}
+				}
+			}
+			suiteID, _ := filepath.Abs(fn)
+			if rerunFailed {
+				tests = failures.Failed(suiteID, tests)
+			}
+
+			res, ok := test.RunTests(cmd.Context(), test.LocalExecutor{}, tests)
+			failures.Update(suiteID, res)
+			res.Name = strings.TrimSuffix(filepath.Base(fn), filepath.Ext(fn))
+			results = append(results, res)
+			if !ok {
+				success = false
+			}
 		}
 
-		results, success := test.RunTests(context.Background(), test.LocalExecutor{}, tests)
+		err = failures.Save(failuresFN)
+		if err != nil {
+			log.WithError(err).Warn("cannot save failure log")
+		}
+
+		for format, marshal := range test.ReportFormats {
+			fn, _ := cmd.Flags().GetString("output-test-" + format)
+			if fn == "" {
+				continue
+			}
 
-		xmlout, _ := cmd.Flags().GetString("output-test-xml")
-		if xmlout != "" {
-			fc, err := xml.MarshalIndent(results, "  ", "    ")
+			fc, err := marshal(results...)
 			if err != nil {
 				log.Fatal(err)
 			}
 
-			err = os.WriteFile(xmlout, fc, 0644)
+			err = os.WriteFile(fn, fc, 0644)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -85,4 +132,10 @@ func init() {
 	testCmd.AddCommand(testRunCmd)
 
 	testRunCmd.Flags().String("output-test-xml", "", "save result as JUnit XML file")
+	testRunCmd.Flags().String("output-test-json", "", "save result as JSON file")
+	testRunCmd.Flags().String("output-test-tap", "", "save result as TAP file")
+	testRunCmd.Flags().StringArray("allow-env", nil, "allow tests to reference this host environment variable as ${NAME} (can be repeated)")
+	testRunCmd.Flags().Int("max-output", 0, "number of bytes of stdout/stderr captured per test unless the test sets maxOutput (default 1MiB, -1 for unlimited)")
+	testRunCmd.Flags().Bool("rerun-failed", false, "only run the tests which failed in the previous run")
+	testRunCmd.Flags().StringArray("filter", nil, "only run tests matching the filter, e.g. tag=gpu, tag!=slow or desc~=python (can be repeated)")
 }
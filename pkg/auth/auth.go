@@ -0,0 +1,145 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package auth finds the credentials dazzle authenticates to registries with. Besides the docker config,
+// credentials can come from environment variables, so that CI jobs need not run docker login.
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/cli/cli/config/configfile"
+)
+
+const (
+	// EnvUsername and EnvPassword hold the credentials for the registry named by EnvHost, or for all
+	// registries if EnvHost is empty
+	EnvUsername = "DAZZLE_REGISTRY_USERNAME"
+	EnvPassword = "DAZZLE_REGISTRY_PASSWORD"
+	// EnvHost limits EnvUsername and EnvPassword to one registry, e.g. eu.gcr.io
+	EnvHost = "DAZZLE_REGISTRY_HOST"
+)
+
+// Credentials authenticate to a registry
+type Credentials struct {
+	Username string
+	Password string
+	// Source tells where the credentials came from, e.g. $DAZZLE_REGISTRY_EU_GCR_IO_USERNAME
+	Source string
+}
+
+// Lookup returns the credentials for a registry host. Environment variables take precedence over the
+// docker config, which may be nil. It returns false if there are no credentials for the host.
+func Lookup(cfg *configfile.ConfigFile, host string) (Credentials, bool, error) {
+	if creds, ok := FromEnv(host); ok {
+		return creds, true, nil
+	}
+	return fromDockerConfig(cfg, host)
+}
+
+// FromEnv returns the credentials for a registry host from the environment. These are
+// DAZZLE_REGISTRY_<HOST>_USERNAME and DAZZLE_REGISTRY_<HOST>_PASSWORD, where <HOST> is the host in upper
+// case with all other characters than letters and digits replaced by an underscore, e.g.
+// DAZZLE_REGISTRY_EU_GCR_IO_USERNAME, or else DAZZLE_REGISTRY_USERNAME and DAZZLE_REGISTRY_PASSWORD.
+func FromEnv(host string) (Credentials, bool) {
+	return fromEnv(host, os.LookupEnv)
+}
+
+func fromEnv(host string, lookupEnv func(string) (string, bool)) (Credentials, bool) {
+	host = normalizeHost(host)
+
+	prefix := "DAZZLE_REGISTRY_" + envHost(host) + "_"
+	if creds, ok := envPair(prefix+"USERNAME", prefix+"PASSWORD", lookupEnv); ok {
+		return creds, true
+	}
+
+	if scope, ok := lookupEnv(EnvHost); ok && scope != "" && normalizeHost(scope) != host {
+		return Credentials{}, false
+	}
+	return envPair(EnvUsername, EnvPassword, lookupEnv)
+}
+
+// envPair returns the credentials of a username and password variable. Either suffices, as some registries
+// take a token as password for any username.
+func envPair(usernameVar, passwordVar string, lookupEnv func(string) (string, bool)) (Credentials, bool) {
+	username, _ := lookupEnv(usernameVar)
+	password, _ := lookupEnv(passwordVar)
+	if username == "" && password == "" {
+		return Credentials{}, false
+	}
+	return Credentials{Username: username, Password: password, Source: "$" + usernameVar}, true
+}
+
+// normalizeHost names Docker Hub by docker.io, whichever of its hosts is used
+func normalizeHost(host string) string {
+	host = strings.ToLower(host)
+	switch host {
+	case "registry-1.docker.io", "index.docker.io", "https://index.docker.io/v1/":
+		return "docker.io"
+	}
+	return host
+}
+
+// envHost turns a host into the part of an environment variable name which names it, e.g. eu.gcr.io into EU_GCR_IO
+func envHost(host string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, host)
+}
+
+func fromDockerConfig(cfg *configfile.ConfigFile, host string) (Credentials, bool, error) {
+	if cfg == nil {
+		return Credentials{}, false, nil
+	}
+
+	if normalizeHost(host) == "docker.io" {
+		host = "https://index.docker.io/v1/"
+	}
+	ac, err := cfg.GetAuthConfig(host)
+	if err != nil {
+		return Credentials{}, false, fmt.Errorf("cannot read credentials for %s from docker config: %w", host, err)
+	}
+	creds := Credentials{Username: ac.Username, Password: ac.Password}
+	if ac.IdentityToken != "" {
+		creds = Credentials{Password: ac.IdentityToken}
+	}
+	if creds.Username == "" && creds.Password == "" {
+		return Credentials{}, false, nil
+	}
+
+	switch {
+	case cfg.CredentialHelpers[host] != "":
+		creds.Source = fmt.Sprintf("docker-credential-%s (%s)", cfg.CredentialHelpers[host], cfg.Filename)
+	case cfg.CredentialsStore != "":
+		creds.Source = fmt.Sprintf("docker-credential-%s (%s)", cfg.CredentialsStore, cfg.Filename)
+	default:
+		creds.Source = cfg.Filename
+	}
+	return creds, true, nil
+}
@@ -0,0 +1,152 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		host        string
+		env         map[string]string
+		expectation *Credentials
+	}{
+		{
+			name: "global",
+			host: "eu.gcr.io",
+			env:  map[string]string{"DAZZLE_REGISTRY_USERNAME": "_json_key", "DAZZLE_REGISTRY_PASSWORD": "secret"},
+			expectation: &Credentials{
+				Username: "_json_key",
+				Password: "secret",
+				Source:   "$DAZZLE_REGISTRY_USERNAME",
+			},
+		},
+		{
+			name: "per host",
+			host: "localhost:5000",
+			env: map[string]string{
+				"DAZZLE_REGISTRY_USERNAME":                "global",
+				"DAZZLE_REGISTRY_PASSWORD":                "global",
+				"DAZZLE_REGISTRY_LOCALHOST_5000_USERNAME": "local",
+				"DAZZLE_REGISTRY_LOCALHOST_5000_PASSWORD": "secret",
+			},
+			expectation: &Credentials{
+				Username: "local",
+				Password: "secret",
+				Source:   "$DAZZLE_REGISTRY_LOCALHOST_5000_USERNAME",
+			},
+		},
+		{
+			name: "docker hub",
+			host: "registry-1.docker.io",
+			env:  map[string]string{"DAZZLE_REGISTRY_DOCKER_IO_USERNAME": "gitpod", "DAZZLE_REGISTRY_DOCKER_IO_PASSWORD": "token"},
+			expectation: &Credentials{
+				Username: "gitpod",
+				Password: "token",
+				Source:   "$DAZZLE_REGISTRY_DOCKER_IO_USERNAME",
+			},
+		},
+		{
+			name: "scoped global",
+			host: "eu.gcr.io",
+			env:  map[string]string{"DAZZLE_REGISTRY_HOST": "eu.gcr.io", "DAZZLE_REGISTRY_PASSWORD": "token"},
+			expectation: &Credentials{
+				Password: "token",
+				Source:   "$DAZZLE_REGISTRY_USERNAME",
+			},
+		},
+		{
+			name: "other host than the scope",
+			host: "registry-1.docker.io",
+			env:  map[string]string{"DAZZLE_REGISTRY_HOST": "eu.gcr.io", "DAZZLE_REGISTRY_USERNAME": "_json_key", "DAZZLE_REGISTRY_PASSWORD": "secret"},
+		},
+		{
+			name: "none",
+			host: "eu.gcr.io",
+			env:  map[string]string{"DAZZLE_REGISTRY_GCR_IO_USERNAME": "_json_key"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds, ok := fromEnv(test.host, func(name string) (string, bool) {
+				v, ok := test.env[name]
+				return v, ok
+			})
+			var act *Credentials
+			if ok {
+				act = &creds
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("fromEnv() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFromDockerConfig(t *testing.T) {
+	cfg := configfile.New("/home/gitpod/.docker/config.json")
+	cfg.AuthConfigs = map[string]types.AuthConfig{
+		"eu.gcr.io":                   {Username: "_json_key", Password: "secret"},
+		"https://index.docker.io/v1/": {IdentityToken: "token"},
+	}
+
+	tests := []struct {
+		name        string
+		host        string
+		expectation *Credentials
+	}{
+		{
+			name:        "username and password",
+			host:        "eu.gcr.io",
+			expectation: &Credentials{Username: "_json_key", Password: "secret", Source: "/home/gitpod/.docker/config.json"},
+		},
+		{
+			name:        "docker hub identity token",
+			host:        "registry-1.docker.io",
+			expectation: &Credentials{Password: "token", Source: "/home/gitpod/.docker/config.json"},
+		},
+		{
+			name: "unknown host",
+			host: "ghcr.io",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds, ok, err := fromDockerConfig(cfg, test.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var act *Credentials
+			if ok {
+				act = &creds
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("fromDockerConfig() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
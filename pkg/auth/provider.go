@@ -0,0 +1,118 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	dockerauth "github.com/containerd/containerd/remotes/docker/auth"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/moby/buildkit/session"
+	sessionauth "github.com/moby/buildkit/session/auth"
+	"github.com/moby/buildkit/session/auth/authprovider"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewProvider returns the session attachable through which buildkitd asks for registry credentials. It
+// answers from the environment like FromEnv, and from the docker config for all other registries.
+func NewProvider(cfg *configfile.ConfigFile) session.Attachable {
+	dp := authprovider.NewDockerAuthProvider(cfg)
+	srv, ok := dp.(sessionauth.AuthServer)
+	if !ok {
+		return dp
+	}
+	return &provider{AuthServer: srv}
+}
+
+// provider answers for the registries with credentials in the environment and leaves the others to the
+// docker config provider it embeds
+type provider struct {
+	sessionauth.AuthServer
+}
+
+func (p *provider) Register(server *grpc.Server) {
+	sessionauth.RegisterAuthServer(server, p)
+}
+
+func (p *provider) Credentials(ctx context.Context, req *sessionauth.CredentialsRequest) (*sessionauth.CredentialsResponse, error) {
+	creds, ok := FromEnv(req.Host)
+	if !ok {
+		return p.AuthServer.Credentials(ctx, req)
+	}
+	return &sessionauth.CredentialsResponse{Username: creds.Username, Secret: creds.Password}, nil
+}
+
+func (p *provider) FetchToken(ctx context.Context, req *sessionauth.FetchTokenRequest) (*sessionauth.FetchTokenResponse, error) {
+	creds, ok := FromEnv(req.Host)
+	if !ok {
+		return p.AuthServer.FetchToken(ctx, req)
+	}
+
+	to := dockerauth.TokenOptions{
+		Realm:    req.Realm,
+		Service:  req.Service,
+		Scopes:   req.Scopes,
+		Username: creds.Username,
+		Secret:   creds.Password,
+	}
+	resp, err := dockerauth.FetchTokenWithOAuth(ctx, http.DefaultClient, nil, req.ClientID, to)
+	if err == nil {
+		return tokenResponse(resp.AccessToken, resp.ExpiresIn, resp.IssuedAt), nil
+	}
+
+	// registries which do not support OAuth hand out tokens for basic auth
+	var unexpected remoteserrors.ErrUnexpectedStatus
+	if !errors.As(err, &unexpected) || (unexpected.StatusCode != http.StatusNotFound && unexpected.StatusCode != http.StatusMethodNotAllowed) {
+		return nil, err
+	}
+	tr, err := dockerauth.FetchToken(ctx, http.DefaultClient, nil, to)
+	if err != nil {
+		return nil, err
+	}
+	token := tr.Token
+	if token == "" {
+		token = tr.AccessToken
+	}
+	return tokenResponse(token, tr.ExpiresIn, tr.IssuedAt), nil
+}
+
+// GetTokenAuthority lets buildkitd fetch tokens through FetchToken for registries with credentials in the
+// environment, as the token authority of the docker config provider derives from the docker config
+func (p *provider) GetTokenAuthority(ctx context.Context, req *sessionauth.GetTokenAuthorityRequest) (*sessionauth.GetTokenAuthorityResponse, error) {
+	if _, ok := FromEnv(req.Host); ok {
+		return nil, status.Errorf(codes.Unavailable, "credentials for %s come from the environment", req.Host)
+	}
+	return p.AuthServer.GetTokenAuthority(ctx, req)
+}
+
+func tokenResponse(token string, expiresIn int, issuedAt time.Time) *sessionauth.FetchTokenResponse {
+	resp := &sessionauth.FetchTokenResponse{Token: token, ExpiresIn: int64(expiresIn)}
+	if !issuedAt.IsZero() {
+		resp.IssuedAt = issuedAt.Unix()
+	}
+	return resp
+}
@@ -0,0 +1,243 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/containerd/containerd/images"
+	containerdref "github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gitpod-io/dazzle/pkg/auth"
+)
+
+// AuthCheck is the result of CheckAuth
+type AuthCheck struct {
+	// Host is the registry the check ran against
+	Host string
+	// Credentials are the credentials dazzle authenticated with, unless Anonymous is true
+	Credentials auth.Credentials
+	Anonymous   bool
+	Steps       []AuthCheckStep
+}
+
+// AuthCheckStep is a request CheckAuth sent to the registry
+type AuthCheckStep struct {
+	// Name is resolve, blob or push
+	Name string
+	// Request is the method and path of the request, e.g. HEAD /v2/gitpod/workspace-full/manifests/latest
+	Request string
+	// Skipped tells why the step did not run, if it did not
+	Skipped string
+	// Err is nil if the registry granted the request
+	Err error
+}
+
+// Failed returns true if a step of the check failed
+func (c *AuthCheck) Failed() bool {
+	for _, s := range c.Steps {
+		if s.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAuth checks the credentials for the registry of ref by resolving ref, checking that a blob of its manifest
+// exists and starting an upload to its repository. The upload is cancelled right away, hence CheckAuth pushes
+// nothing. Unless push is true it skips the upload.
+func CheckAuth(ctx context.Context, logger log.FieldLogger, cfg *configfile.ConfigFile, ref reference.Named, push bool) (*AuthCheck, error) {
+	ref = reference.TagNameOnly(ref)
+	host := reference.Domain(ref)
+	creds, ok, err := auth.Lookup(cfg, host)
+	if err != nil {
+		return nil, withKind(ErrorKindConfig, err)
+	}
+	res := &AuthCheck{Host: host, Credentials: creds, Anonymous: !ok}
+
+	hosts := docker.ConfigureDefaultRegistries(
+		docker.WithAuthorizer(docker.NewDockerAuthorizer(docker.WithAuthCreds(func(string) (string, string, error) {
+			return creds.Username, creds.Password, nil
+		}))),
+		docker.WithPlainHTTP(docker.MatchLocalhost),
+	)
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: hosts})
+	repo := "/v2/" + reference.Path(ref)
+
+	var tag string
+	if tagged, ok := ref.(reference.Tagged); ok {
+		tag = tagged.Tag()
+	}
+	if digested, ok := ref.(reference.Digested); ok {
+		tag = digested.Digest().String()
+	}
+	resolve := AuthCheckStep{Name: "resolve", Request: "HEAD " + path.Join(repo, "manifests", tag)}
+	_, desc, err := resolver.Resolve(ctx, ref.String())
+	resolve.Err = err
+	res.Steps = append(res.Steps, resolve)
+
+	blob := AuthCheckStep{Name: "blob", Request: "HEAD " + path.Join(repo, "blobs", "<digest>")}
+	if err != nil {
+		blob.Skipped = "cannot resolve " + ref.String()
+	} else {
+		var dgst digest.Digest
+		dgst, err = manifestBlob(ctx, resolverRegistry{resolver: resolver}, ref, desc)
+		if err == nil {
+			blobPath := path.Join(repo, "blobs", dgst.String())
+			blob.Request = "HEAD " + blobPath
+			_, err = registryRequest(ctx, hosts, ref, http.MethodHead, blobPath, false, http.StatusOK)
+		}
+		blob.Err = err
+	}
+	res.Steps = append(res.Steps, blob)
+
+	uploads := path.Join(repo, "blobs", "uploads") + "/"
+	upload := AuthCheckStep{Name: "push", Request: "POST " + uploads}
+	if push {
+		upload.Err = startUpload(ctx, logger, hosts, ref, uploads)
+	} else {
+		upload.Skipped = "push check disabled"
+	}
+	res.Steps = append(res.Steps, upload)
+
+	return res, nil
+}
+
+// manifestBlob returns the digest of the config of the manifest desc describes, or of the manifest of the
+// registry's platform if desc describes an image index
+func manifestBlob(ctx context.Context, r resolverRegistry, ref reference.Named, desc ociv1.Descriptor) (digest.Digest, error) {
+	fetcher, err := r.resolver.Fetcher(ctx, ref.String())
+	if err != nil {
+		return "", err
+	}
+	if desc.MediaType == ociv1.MediaTypeImageIndex || desc.MediaType == images.MediaTypeDockerSchema2ManifestList {
+		desc, err = r.platformManifest(ctx, fetcher, ref, desc)
+		if err != nil {
+			return "", err
+		}
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	var mf ociv1.Manifest
+	err = json.NewDecoder(rc).Decode(&mf)
+	if err != nil {
+		return "", fmt.Errorf("cannot read manifest of %s: %w", ref, err)
+	}
+	return mf.Config.Digest, nil
+}
+
+// registryRequest sends a request to the repository of ref, authenticating like containerd does, and returns the
+// response unless the registry answered with another status than expected
+func registryRequest(ctx context.Context, hosts docker.RegistryHosts, ref reference.Named, method, path string, push bool, expected ...int) (*http.Response, error) {
+	rhs, err := hosts(reference.Domain(ref))
+	if err != nil {
+		return nil, err
+	}
+	if len(rhs) == 0 {
+		return nil, fmt.Errorf("no registry host for %s", reference.Domain(ref))
+	}
+	rh := rhs[0]
+
+	refspec, err := containerdref.Parse(ref.String())
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = docker.ContextWithRepositoryScope(ctx, refspec, push)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(rh.Scheme + "://" + rh.Host)
+	if err != nil {
+		return nil, err
+	}
+	u, err = u.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		err = rh.Authorizer.Authorize(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := rh.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			// the first response tells how to authenticate
+			err = rh.Authorizer.AddResponses(ctx, []*http.Response{resp})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, code := range expected {
+			if resp.StatusCode == code {
+				return resp, nil
+			}
+		}
+		return nil, remoteserrors.NewUnexpectedStatusErr(resp)
+	}
+}
+
+// startUpload starts an upload to the repository of ref, which only credentials which may push to the repository
+// can do, and cancels it right away. Hence nothing is pushed.
+func startUpload(ctx context.Context, logger log.FieldLogger, hosts docker.RegistryHosts, ref reference.Named, uploads string) error {
+	resp, err := registryRequest(ctx, hosts, ref, http.MethodPost, uploads, true, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil
+	}
+	loc, err := resp.Request.URL.Parse(location)
+	if err != nil {
+		return nil
+	}
+	_, err = registryRequest(ctx, hosts, ref, http.MethodDelete, loc.RequestURI(), true, http.StatusNoContent, http.StatusAccepted, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		logger.WithError(err).WithField("upload", location).Debug("cannot cancel upload")
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/docker/distribution/reference"
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeAuthRegistry serves one image and lets only the user with password secret start uploads, which must be cancelled
+func fakeAuthRegistry(t *testing.T) *httptest.Server {
+	cfgDigest := digest.FromString("config")
+	mf, err := json.Marshal(ociv1.Manifest{
+		MediaType: ociv1.MediaTypeImageManifest,
+		Config:    ociv1.Descriptor{MediaType: ociv1.MediaTypeImageConfig, Digest: cfgDigest, Size: 6},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfDigest := digest.FromBytes(mf)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/gitpod/workspace/manifests/latest" || r.URL.Path == "/v2/gitpod/workspace/manifests/"+mfDigest.String():
+			w.Header().Set("Content-Type", ociv1.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", mfDigest.String())
+			w.Header().Set("Content-Length", strconv.Itoa(len(mf)))
+			if r.Method == http.MethodHead {
+				return
+			}
+			_, _ = w.Write(mf)
+		case r.URL.Path == "/v2/gitpod/workspace/blobs/"+cfgDigest.String() && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/gitpod/workspace/blobs/uploads/" && r.Method == http.MethodPost:
+			if _, pwd, ok := r.BasicAuth(); !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			} else if pwd != "secret" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Location", "/v2/gitpod/workspace/blobs/uploads/1")
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/v2/gitpod/workspace/blobs/uploads/1" && r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasPrefix(r.URL.Path, "/v2/gitpod/workspace/blobs/uploads/"):
+			t.Errorf("unexpected upload request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCheckAuth(t *testing.T) {
+	srv := fakeAuthRegistry(t)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	type step struct {
+		Name    string
+		Skipped string
+		Failed  bool
+	}
+	tests := []struct {
+		name        string
+		ref         string
+		creds       *types.AuthConfig
+		push        bool
+		source      string
+		expectation []step
+	}{
+		{
+			name:   "push",
+			ref:    host + "/gitpod/workspace",
+			creds:  &types.AuthConfig{Username: "gitpod", Password: "secret"},
+			push:   true,
+			source: "/home/gitpod/.docker/config.json",
+			expectation: []step{
+				{Name: "resolve"},
+				{Name: "blob"},
+				{Name: "push"},
+			},
+		},
+		{
+			name: "anonymous",
+			ref:  host + "/gitpod/workspace:latest",
+			push: true,
+			expectation: []step{
+				{Name: "resolve"},
+				{Name: "blob"},
+				{Name: "push", Failed: true},
+			},
+		},
+		{
+			name:   "wrong password",
+			ref:    host + "/gitpod/workspace",
+			creds:  &types.AuthConfig{Username: "gitpod", Password: "wrong"},
+			push:   true,
+			source: "/home/gitpod/.docker/config.json",
+			expectation: []step{
+				{Name: "resolve"},
+				{Name: "blob"},
+				{Name: "push", Failed: true},
+			},
+		},
+		{
+			name: "no push",
+			ref:  host + "/gitpod/workspace",
+			expectation: []step{
+				{Name: "resolve"},
+				{Name: "blob"},
+				{Name: "push", Skipped: "push check disabled"},
+			},
+		},
+		{
+			name: "unknown image",
+			ref:  host + "/gitpod/other",
+			expectation: []step{
+				{Name: "resolve", Failed: true},
+				{Name: "blob", Skipped: "cannot resolve " + host + "/gitpod/other:latest"},
+				{Name: "push", Skipped: "push check disabled"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := configfile.New("/home/gitpod/.docker/config.json")
+			if test.creds != nil {
+				cfg.AuthConfigs[host] = *test.creds
+			}
+			ref, err := reference.ParseNamed(test.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := CheckAuth(context.Background(), log.StandardLogger(), cfg, ref, test.push)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Credentials.Source != test.source || res.Anonymous != (test.creds == nil) {
+				t.Errorf("CheckAuth() credentials = %+v, anonymous %v, expected source %q", res.Credentials, res.Anonymous, test.source)
+			}
+			var (
+				act    = make([]step, 0, len(res.Steps))
+				failed bool
+			)
+			for _, s := range res.Steps {
+				act = append(act, step{Name: s.Name, Skipped: s.Skipped, Failed: s.Err != nil})
+				failed = failed || s.Err != nil
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("CheckAuth() mismatch (-want +got):\n%s", diff)
+			}
+			if res.Failed() != failed {
+				t.Errorf("CheckAuth().Failed() = %v, expected %v", res.Failed(), failed)
+			}
+		})
+	}
+}
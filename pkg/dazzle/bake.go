@@ -0,0 +1,135 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// bakeBaseContext is the name of the build context which provides the base image to the chunk targets
+const bakeBaseContext = "dazzle-base"
+
+// BakeFile is a docker buildx bake definition in its JSON form
+type BakeFile struct {
+	Group  map[string]BakeGroup  `json:"group"`
+	Target map[string]BakeTarget `json:"target"`
+}
+
+// BakeGroup is a set of bake targets built together
+type BakeGroup struct {
+	Targets []string `json:"targets"`
+}
+
+// BakeTarget is a single image bake builds
+type BakeTarget struct {
+	Context string `json:"context"`
+	// DockerfileInline is the Dockerfile as dazzle builds it, with includes spliced in and templates rendered
+	DockerfileInline string            `json:"dockerfile-inline"`
+	Contexts         map[string]string `json:"contexts,omitempty"`
+	Args             map[string]string `json:"args,omitempty"`
+}
+
+var bakeInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// bakeName turns a chunk or combination name into a valid bake target or group name, e.g. go:1.19 into go--1_19
+func bakeName(name string) string {
+	return bakeInvalidChars.ReplaceAllString(strings.ReplaceAll(name, ":", "--"), "_")
+}
+
+// Bake produces a docker buildx bake definition which builds the same images as dazzle: a base target,
+// one target per chunk which builds on the base target and the targets of its dependencies, and a group per combination. The default group
+// builds all chunks. Contexts are relative to contextBase, the project directory.
+//
+// Unlike dazzle, bake builds chunks as full images including the base layers and does not run tests.
+func (p *Project) Bake(contextBase string) (*BakeFile, error) {
+	res := &BakeFile{
+		Group:  make(map[string]BakeGroup),
+		Target: make(map[string]BakeTarget),
+	}
+	target := func(chk ProjectChunk) (BakeTarget, error) {
+		ctx, err := filepath.Rel(contextBase, chk.ContextPath)
+		if err != nil {
+			return BakeTarget{}, err
+		}
+		t := BakeTarget{
+			Context:          filepath.ToSlash(ctx),
+			DockerfileInline: string(chk.Dockerfile),
+		}
+		if len(chk.Args) > 0 {
+			t.Args = make(map[string]string, len(chk.Args)+1)
+			for k, v := range chk.Args {
+				t.Args[k] = v
+			}
+		}
+		return t, nil
+	}
+
+	base, err := target(p.Base)
+	if err != nil {
+		return nil, err
+	}
+	res.Target["base"] = base
+
+	var all []string
+	for _, chk := range p.Chunks {
+		name := bakeName(chk.Name)
+		if _, exists := res.Target[name]; exists {
+			return nil, fmt.Errorf("chunk %s: bake target %s exists already", chk.Name, name)
+		}
+		t, err := target(chk)
+		if err != nil {
+			return nil, err
+		}
+		// chunks build FROM ${base}, which we point to the base target
+		if t.Args == nil {
+			t.Args = make(map[string]string, 1)
+		}
+		t.Args["base"] = bakeBaseContext
+		t.Contexts = map[string]string{bakeBaseContext: "target:base"}
+		// the images of the dependencies are the targets of their chunks
+		for _, dep := range chk.DependsOn {
+			depctx := bakeBaseContext + "-" + bakeName(dep.Chunk)
+			t.Args[dep.Arg] = depctx
+			t.Contexts[depctx] = "target:" + bakeName(dep.Chunk)
+		}
+		res.Target[name] = t
+		all = append(all, name)
+	}
+	sort.Strings(all)
+	res.Group["default"] = BakeGroup{Targets: all}
+
+	for _, comb := range p.Config.Combiner.Combinations {
+		name := bakeName(comb.Name)
+		if name == "default" {
+			return nil, fmt.Errorf("combination %s: bake group %s is reserved for all chunks", comb.Name, name)
+		}
+		targets := make([]string, 0, len(comb.Chunks))
+		for _, c := range comb.Chunks {
+			targets = append(targets, bakeName(c))
+		}
+		sort.Strings(targets)
+		res.Group[name] = BakeGroup{Targets: targets}
+	}
+	return res, nil
+}
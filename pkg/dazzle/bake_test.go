@@ -0,0 +1,73 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProjectBake(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"dazzle.yaml":              {Data: []byte("combiner:\n  combinations:\n  - name: minimal\n    chunks: [golang:1.16]\n  - name: full\n    ref: [minimal]\n    chunks: [node]\n")},
+		"base/Dockerfile":          {Data: []byte("FROM alpine")},
+		"chunks/golang/Dockerfile": {Data: []byte("ARG base\nFROM ${base}")},
+		"chunks/golang/chunk.yaml": {Data: []byte("variants:\n- name: \"1.16\"\n  args:\n    GO_VERSION: \"1.16\"\n")},
+		"chunks/node/Dockerfile":   {Data: []byte("ARG base\nFROM ${base}")},
+		"chunks/node/chunk.yaml":   {Data: []byte("dependsOn:\n- chunk: golang:1.16\n")},
+	}
+	prj, err := LoadFromDir("", LoadFromDirOpts{FS: func(string) fs.FS { return mapFS }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectation := &BakeFile{
+		Group: map[string]BakeGroup{
+			"default": {Targets: []string{"golang--1_16", "node"}},
+			"full":    {Targets: []string{"golang--1_16", "node"}},
+			"minimal": {Targets: []string{"golang--1_16"}},
+		},
+		Target: map[string]BakeTarget{
+			"base": {Context: "base", DockerfileInline: "FROM alpine"},
+			"golang--1_16": {
+				Context:          "chunks/golang",
+				DockerfileInline: "ARG base\nFROM ${base}",
+				Contexts:         map[string]string{"dazzle-base": "target:base"},
+				Args:             map[string]string{"GO_VERSION": "1.16", "base": "dazzle-base"},
+			},
+			"node": {
+				Context:          "chunks/node",
+				DockerfileInline: "ARG base\nFROM ${base}",
+				Contexts:         map[string]string{"dazzle-base": "target:base", "dazzle-base-golang--1_16": "target:golang--1_16"},
+				Args:             map[string]string{"base": "dazzle-base", "GOLANG_1_16_IMAGE": "dazzle-base-golang--1_16"},
+			},
+		},
+	}
+	act, err := prj.Bake("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expectation, act); diff != "" {
+		t.Errorf("Bake() mismatch (-want +got):\n%s", diff)
+	}
+}
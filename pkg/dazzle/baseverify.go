@@ -0,0 +1,170 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"gopkg.in/yaml.v3"
+)
+
+const lockFileName = "dazzle.lock"
+
+// LockFile pins the images the base Dockerfile builds FROM to digests without changing the Dockerfile
+type LockFile struct {
+	// Images maps images as written in the base Dockerfile, with build args expanded, to their digest
+	Images map[string]digest.Digest `yaml:"images"`
+}
+
+// loadLockFile loads the dazzle.lock of a project. A missing lock file is not an error.
+func loadLockFile(dir fs.FS) (*LockFile, error) {
+	fc, err := fs.ReadFile(dir, lockFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res LockFile
+	err = yaml.Unmarshal(fc, &res)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load %s: %w", lockFileName, err)
+	}
+	for img, dgst := range res.Images {
+		if err := dgst.Validate(); err != nil {
+			return nil, fmt.Errorf("cannot load %s: invalid digest for %s: %w", lockFileName, img, err)
+		}
+	}
+	return &res, nil
+}
+
+// BaseVerificationMode determines how the images the base Dockerfile builds FROM are verified
+type BaseVerificationMode string
+
+const (
+	// BaseVerificationNone builds the base image without verifying the images it builds FROM
+	BaseVerificationNone BaseVerificationMode = ""
+	// BaseVerificationPinned requires every image to be pinned by digest in the Dockerfile or dazzle.lock
+	BaseVerificationPinned BaseVerificationMode = "pinned"
+	// BaseVerificationCosign requires every image to carry a cosign signature made with a key
+	BaseVerificationCosign BaseVerificationMode = "cosign"
+)
+
+// BaseVerification configures the verification of the images the base Dockerfile builds FROM
+type BaseVerification struct {
+	Mode BaseVerificationMode
+	// Key is the public key cosign verifies signatures with, as path or KMS URI
+	Key string
+
+	run runFunc
+}
+
+// WithBaseVerification makes dazzle refuse to build a base image on images it cannot verify
+func WithBaseVerification(v BaseVerification) BuildOpt {
+	return func(b *buildOpts) error {
+		switch v.Mode {
+		case BaseVerificationNone, BaseVerificationPinned:
+		case BaseVerificationCosign:
+			if v.Key == "" {
+				return fmt.Errorf("cosign base verification requires a key")
+			}
+		default:
+			return fmt.Errorf("unknown base verification %q, must be %s or %s", v.Mode, BaseVerificationPinned, BaseVerificationCosign)
+		}
+		if v.run == nil {
+			v.run = runCommand
+		}
+		b.BaseVerification = v
+		return nil
+	}
+}
+
+// verifyBase verifies the images the base Dockerfile builds FROM as configured for the session
+func (p *Project) verifyBase(ctx context.Context, sess *BuildSession) (err error) {
+	v := sess.opts.BaseVerification
+	if v.Mode == BaseVerificationNone {
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			err = withKind(ErrorKindBuild, fmt.Errorf("refusing to build base image: %w", err))
+		}
+	}()
+
+	froms, err := parseFromImages(p.Base.Dockerfile)
+	if err != nil {
+		return fmt.Errorf("cannot parse base Dockerfile: %w", err)
+	}
+	for _, from := range froms {
+		ref, err := reference.ParseNormalizedNamed(from.Image)
+		if err != nil {
+			return err
+		}
+		var locked digest.Digest
+		if p.baseLock != nil {
+			locked = p.baseLock.Images[from.Image]
+		}
+
+		switch v.Mode {
+		case BaseVerificationPinned:
+			err = verifyPinned(ctx, sess, ref, locked)
+		case BaseVerificationCosign:
+			target := ref.String()
+			if _, pinned := ref.(reference.Digested); !pinned && locked != "" {
+				dref, err := reference.WithDigest(ref, locked)
+				if err != nil {
+					return err
+				}
+				target = dref.String()
+			}
+			_, err = v.run(ctx, "cosign", "verify", "--key", v.Key, target)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot verify %s: %w", from.Image, err)
+		}
+		sess.opts.Logger.WithField("image", from.Image).WithField("mode", v.Mode).Info("verified base Dockerfile image")
+	}
+	return nil
+}
+
+// verifyPinned checks that an image is pinned by digest, either in the Dockerfile or in dazzle.lock.
+// Images pinned in the lock file only are built using their tag, which must still point to the locked digest.
+func verifyPinned(ctx context.Context, sess *BuildSession, ref reference.Named, locked digest.Digest) error {
+	if _, pinned := ref.(reference.Digested); pinned {
+		return nil
+	}
+	if locked == "" {
+		return fmt.Errorf("image is neither pinned by digest nor listed in %s", lockFileName)
+	}
+	_, desc, err := sess.opts.Resolver.Resolve(ctx, reference.TagNameOnly(ref).String())
+	if err != nil {
+		return err
+	}
+	if desc.Digest != locked {
+		return fmt.Errorf("image points to %s, but %s pins %s", desc.Digest, lockFileName, locked)
+	}
+	return nil
+}
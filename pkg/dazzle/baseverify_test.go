@@ -0,0 +1,156 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestVerifyBase(t *testing.T) {
+	var (
+		current = digest.FromString("current")
+		older   = digest.FromString("older")
+	)
+	type Expectation struct {
+		Error string
+		Calls [][]string
+	}
+	tests := []struct {
+		name        string
+		mode        BaseVerificationMode
+		dockerfile  string
+		lock        map[string]digest.Digest
+		expectation Expectation
+	}{
+		{
+			name:       "no verification",
+			dockerfile: "FROM ubuntu:22.04\n",
+		},
+		{
+			name:       "pinned in Dockerfile",
+			mode:       BaseVerificationPinned,
+			dockerfile: "FROM ubuntu:22.04@" + older.String() + "\n",
+		},
+		{
+			name:       "pinned in lock",
+			mode:       BaseVerificationPinned,
+			dockerfile: "FROM ubuntu:22.04\n",
+			lock:       map[string]digest.Digest{"ubuntu:22.04": current},
+		},
+		{
+			name:        "lock outdated",
+			mode:        BaseVerificationPinned,
+			dockerfile:  "FROM ubuntu:22.04\n",
+			lock:        map[string]digest.Digest{"ubuntu:22.04": older},
+			expectation: Expectation{Error: "refusing to build base image: cannot verify ubuntu:22.04: image points to " + current.String() + ", but dazzle.lock pins " + older.String()},
+		},
+		{
+			name:        "not pinned",
+			mode:        BaseVerificationPinned,
+			dockerfile:  "FROM golang:1.19 AS build\nFROM ubuntu:22.04@" + older.String() + "\n",
+			expectation: Expectation{Error: "refusing to build base image: cannot verify golang:1.19: image is neither pinned by digest nor listed in dazzle.lock"},
+		},
+		{
+			name:       "cosign",
+			mode:       BaseVerificationCosign,
+			dockerfile: "FROM golang:1.19 AS build\nFROM ubuntu:22.04\n",
+			lock:       map[string]digest.Digest{"ubuntu:22.04": current},
+			expectation: Expectation{Calls: [][]string{
+				{"cosign", "verify", "--key", "cosign.pub", "docker.io/library/golang:1.19"},
+				{"cosign", "verify", "--key", "cosign.pub", "docker.io/library/ubuntu:22.04@" + current.String()},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var act Expectation
+			run := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+				act.Calls = append(act.Calls, append([]string{name}, args...))
+				return nil, nil
+			}
+
+			sess, err := NewSession(nil, "localhost:9999/test",
+				WithResolver(staticResolver{desc: ocispec.Descriptor{Digest: current}}),
+				WithBaseVerification(BaseVerification{Mode: test.mode, Key: "cosign.pub", run: run}),
+			)
+			if err != nil {
+				t.Fatalf("could not create session: %v", err)
+			}
+			p := &Project{Base: ProjectChunk{Name: "base", Dockerfile: []byte(test.dockerfile)}}
+			if test.lock != nil {
+				p.baseLock = &LockFile{Images: test.lock}
+			}
+
+			err = p.verifyBase(context.Background(), sess)
+			if err != nil {
+				act.Error = err.Error()
+				if KindOf(err) != ErrorKindBuild {
+					t.Errorf("verifyBase() error kind = %v, want %v", KindOf(err), ErrorKindBuild)
+				}
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("verifyBase() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadLockFile(t *testing.T) {
+	dgst := digest.FromString("ubuntu")
+	tests := []struct {
+		name   string
+		files  fstest.MapFS
+		expect *LockFile
+		err    string
+	}{
+		{name: "missing", files: fstest.MapFS{}},
+		{
+			name:   "valid",
+			files:  fstest.MapFS{"dazzle.lock": {Data: []byte("images:\n  ubuntu:22.04: " + dgst.String() + "\n")}},
+			expect: &LockFile{Images: map[string]digest.Digest{"ubuntu:22.04": dgst}},
+		},
+		{
+			name:  "invalid digest",
+			files: fstest.MapFS{"dazzle.lock": {Data: []byte("images:\n  ubuntu:22.04: latest\n")}},
+			err:   "cannot load dazzle.lock: invalid digest for ubuntu:22.04: invalid checksum digest format",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act, err := loadLockFile(test.files)
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+			}
+			if diff := cmp.Diff(test.err, errmsg); diff != "" {
+				t.Errorf("loadLockFile() error mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expect, act); diff != "" {
+				t.Errorf("loadLockFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
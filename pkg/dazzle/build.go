@@ -27,25 +27,28 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/containerd/console"
+	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/errdefs"
 	clog "github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/platforms"
 	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
 	"github.com/docker/cli/cli/config"
 	"github.com/docker/distribution/reference"
-	"github.com/mattn/go-isatty"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/session"
-	"github.com/moby/buildkit/session/auth/authprovider"
-	"github.com/moby/buildkit/util/progress/progressui"
 	"github.com/opencontainers/go-digest"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/gitpod-io/dazzle/pkg/auth"
 	"github.com/gitpod-io/dazzle/pkg/test"
 	"github.com/gitpod-io/dazzle/pkg/test/buildkit"
 )
@@ -57,16 +60,44 @@ var (
 const (
 	mfAnnotationBaseRef = "dazzle.gitpod.io/base-ref"
 	mfAnnotationEnvVar  = "dazzle.gitpod.io/env-"
+	// mfAnnotationChunk names the chunk of a chunk image, or the chunk which contributed a layer of a combination
+	mfAnnotationChunk = "dazzle.gitpod.io/chunk"
+	// mfAnnotationTests records the TestStatus of an image at the time it was pushed
+	mfAnnotationTests = "dazzle.gitpod.io/tests"
+	// mfAnnotationChunkHash is the hash of the chunk which contributed a layer of a combination
+	mfAnnotationChunkHash = "dazzle.gitpod.io/chunk-hash"
+	// cfgLabelChunks lists the members of a combination in layer order, see ChunkProvenance
+	cfgLabelChunks = "dazzle.gitpod.io/chunks"
+	// mfAnnotationExpiresAfter is how long after it was pushed an intermediate image may be removed, e.g. 336h
+	mfAnnotationExpiresAfter = "dazzle.gitpod.io/expires-after"
 )
 
 type buildOpts struct {
 	CacheRef           reference.Named
 	NoCache            bool
 	NoTests            bool
+	TestsOnly          bool
+	FailFast           bool
 	Resolver           remotes.Resolver
 	PlainOutput        bool
+	PrefixedOutput     bool
 	ChunkedWithoutHash bool
 	Registry           Registry
+	TestFilters        []*test.Filter
+	TestEnv            []string
+	FailureLog         *test.FailureLog
+	RerunFailed        bool
+	TestTimeout        time.Duration
+	Platform           ociv1.Platform
+	BaseVerification   BaseVerification
+	Logger             log.FieldLogger
+	ProgressWriter     io.Writer
+	IfExists           IfExistsPolicy
+	RedactHistory      bool
+	ExpiresAfter       time.Duration
+	WorkerClients      []*client.Client
+	SolveRetries       int
+	Heartbeat          time.Duration
 }
 
 // BuildOpt modifies build behaviour
@@ -102,6 +133,30 @@ func WithPlainOutput(enable bool) BuildOpt {
 	}
 }
 
+// WithLogger makes the session log to l instead of the standard logger
+func WithLogger(l log.FieldLogger) BuildOpt {
+	return func(b *buildOpts) error {
+		b.Logger = l
+		return nil
+	}
+}
+
+// WithProgressWriter makes the session write the buildkit progress to w instead of stderr
+func WithProgressWriter(w io.Writer) BuildOpt {
+	return func(b *buildOpts) error {
+		b.ProgressWriter = w
+		return nil
+	}
+}
+
+// WithPrefixedOutput prefixes every line of the build output with the chunk it belongs to
+func WithPrefixedOutput(enable bool) BuildOpt {
+	return func(b *buildOpts) error {
+		b.PrefixedOutput = enable
+		return nil
+	}
+}
+
 // WithNoCache disables the buildkit build cache
 func WithNoCache(enable bool) BuildOpt {
 	return func(b *buildOpts) error {
@@ -111,9 +166,75 @@ func WithNoCache(enable bool) BuildOpt {
 }
 
 // WithNoTests disables the build-time tests
+//
+// Deprecated: use WithSkipTests
 func WithNoTests(enable bool) BuildOpt {
+	return WithSkipTests(enable)
+}
+
+// WithSkipTests builds the chunks without running their tests. Chunk hashes then exclude the tests.
+func WithSkipTests(enable bool) BuildOpt {
 	return func(b *buildOpts) error {
-		b.NoCache = enable
+		b.NoTests = enable
+		return nil
+	}
+}
+
+// WithTestsOnly runs the tests of each chunk but neither builds nor pushes the chunk images,
+// e.g. to validate a pull request before the images are published by another pipeline.
+// The base and test images are pushed under their hash tags nonetheless, and so are the full images of
+// the chunks others depend on: buildkit pulls them from the registry when it builds the images on top of them.
+func WithTestsOnly(enable bool) BuildOpt {
+	return func(b *buildOpts) error {
+		b.TestsOnly = enable
+		return nil
+	}
+}
+
+// WithFailFast makes a build stop at the first chunk whose tests fail, which is the default.
+// Otherwise the build tests all chunks, builds those whose tests passed and reports all failures at the end.
+func WithFailFast(enable bool) BuildOpt {
+	return func(b *buildOpts) error {
+		b.FailFast = enable
+		return nil
+	}
+}
+
+// WithTestFilters restricts the tests that run during build and combination to those matching all filters.
+// Test results of a filtered run are not stored in the registry.
+func WithTestFilters(filters ...*test.Filter) BuildOpt {
+	return func(b *buildOpts) error {
+		b.TestFilters = filters
+		return nil
+	}
+}
+
+// WithTestEnv allows test specs to reference the given host environment variables as ${NAME}.
+// The variables listed in the project's dazzle.yaml are always allowed.
+func WithTestEnv(allowlist ...string) BuildOpt {
+	return func(b *buildOpts) error {
+		b.TestEnv = append(b.TestEnv, allowlist...)
+		return nil
+	}
+}
+
+// WithFailureLog records failing tests in the log. If rerunFailed is true, only tests which failed
+// previously according to the log run. Test results of such a run are not stored in the registry.
+func WithFailureLog(l *test.FailureLog, rerunFailed bool) BuildOpt {
+	return func(b *buildOpts) error {
+		b.FailureLog = l
+		b.RerunFailed = rerunFailed
+		return nil
+	}
+}
+
+// WithTestTimeout limits the time each test may take. Zero means test.DefaultTimeout.
+func WithTestTimeout(timeout time.Duration) BuildOpt {
+	return func(b *buildOpts) error {
+		if timeout < 0 {
+			return fmt.Errorf("test timeout must not be negative")
+		}
+		b.TestTimeout = timeout
 		return nil
 	}
 }
@@ -126,6 +247,101 @@ func WithChunkedWithoutHash(enable bool) BuildOpt {
 	}
 }
 
+// WithRedactHistory strips the build steps, which can contain build args, from the history of
+// the chunked and combined images dazzle pushes
+func WithRedactHistory(enable bool) BuildOpt {
+	return func(b *buildOpts) error {
+		b.RedactHistory = enable
+		return nil
+	}
+}
+
+// WithExpiresAfter stamps the chunked images and test results dazzle pushes with the time after which they
+// may be removed, so that registry lifecycle policies can clean them up. Zero means they never expire.
+func WithExpiresAfter(d time.Duration) BuildOpt {
+	return func(b *buildOpts) error {
+		if d < 0 {
+			return fmt.Errorf("expires-after must not be negative")
+		}
+		b.ExpiresAfter = d
+		return nil
+	}
+}
+
+// WithWorkerClients adds buildkit daemons for the chunks which require worker labels. buildkitd builds on its
+// default worker only, hence such chunks use the first daemon whose default worker has their labels.
+func WithWorkerClients(cls ...*client.Client) BuildOpt {
+	return func(b *buildOpts) error {
+		b.WorkerClients = append(b.WorkerClients, cls...)
+		return nil
+	}
+}
+
+// WithSolveRetries retries builds whose connection to buildkitd dropped up to n times. The retries resume from
+// the steps which buildkitd has cached already.
+func WithSolveRetries(n int) BuildOpt {
+	return func(b *buildOpts) error {
+		if n < 0 {
+			return fmt.Errorf("solve retries must not be negative")
+		}
+		b.SolveRetries = n
+		return nil
+	}
+}
+
+// WithHeartbeat makes builds check every interval that buildkitd still responds, and fail if it does not rather
+// than wait for a connection which is gone. Zero disables the heartbeat.
+func WithHeartbeat(interval time.Duration) BuildOpt {
+	return func(b *buildOpts) error {
+		if interval < 0 {
+			return fmt.Errorf("heartbeat interval must not be negative")
+		}
+		b.Heartbeat = interval
+		return nil
+	}
+}
+
+// WithPlatform sets the platform to build for, e.g. linux/arm64. Chunks which do not support the platform
+// are skipped. Defaults to the platform dazzle runs on, which an empty platform keeps.
+func WithPlatform(platform string) BuildOpt {
+	return func(b *buildOpts) error {
+		if platform == "" {
+			return nil
+		}
+		p, err := platforms.Parse(platform)
+		if err != nil {
+			return withKind(ErrorKindConfig, fmt.Errorf("invalid platform %q: %w", platform, err))
+		}
+		b.Platform = platforms.Normalize(p)
+		return nil
+	}
+}
+
+// IfExistsPolicy decides what a build does with images which exist in the registry already
+type IfExistsPolicy string
+
+const (
+	// IfExistsSkip trusts existing images and does not build them again. This is the default.
+	IfExistsSkip IfExistsPolicy = "skip"
+	// IfExistsRebuild builds and pushes all images, regardless of whether they exist already
+	IfExistsRebuild IfExistsPolicy = "rebuild"
+	// IfExistsVerify pulls existing images and trusts them only if they were built on the current base image
+	IfExistsVerify IfExistsPolicy = "verify"
+)
+
+// WithIfExists sets the policy for images which exist in the registry already
+func WithIfExists(policy IfExistsPolicy) BuildOpt {
+	return func(b *buildOpts) error {
+		switch policy {
+		case IfExistsSkip, IfExistsRebuild, IfExistsVerify:
+		default:
+			return withKind(ErrorKindConfig, fmt.Errorf("unknown if-exists policy %q: must be one of %s, %s or %s", policy, IfExistsSkip, IfExistsRebuild, IfExistsVerify))
+		}
+		b.IfExists = policy
+		return nil
+	}
+}
+
 // Build builds all images in a project
 func (p *Project) Build(ctx context.Context, session *BuildSession) error {
 	ctx = clog.WithLogger(ctx, log.NewEntry(log.New()))
@@ -140,17 +356,33 @@ func (p *Project) Build(ctx context.Context, session *BuildSession) error {
 	if session.opts.CacheRef == nil {
 		session.opts.CacheRef = baseref
 	}
+	session.testEnv = session.projectTestEnv(p)
 
-	log.WithField("ref", baseref.String()).Warn("building base image")
+	err = p.verifyBase(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	session.opts.Logger.WithField("ref", baseref.String()).Warn("building base image")
+	baseDone := session.timePhase("base", p.Base.Name)
 	absbaseref, err := p.Base.buildAsBase(ctx, baseref, session)
+	baseDone()
 	if err != nil {
 		return fmt.Errorf("cannot build base image: %w", err)
 	}
 
-	_, basemf, basecfg, err := getImageMetadata(ctx, absbaseref, session.opts.Registry)
+	_, basemf, basecfg, err := session.imageMetadata(ctx, absbaseref)
 	if err != nil {
 		return fmt.Errorf("cannot fetch base image: %w", err)
 	}
+	err = checkImagePlatform(absbaseref.String(), basecfg, session.opts.Platform)
+	if err != nil {
+		return err
+	}
+	err = checkBaseLayers(p.Config.Layers, len(basemf.Layers))
+	if err != nil {
+		return err
+	}
 	if session.opts.ChunkedWithoutHash && len(p.Config.Combiner.EnvVars) > 0 {
 		basemf.Annotations = make(map[string]string)
 		for _, e := range p.Config.Combiner.EnvVars {
@@ -169,18 +401,84 @@ func (p *Project) Build(ctx context.Context, session *BuildSession) error {
 	}
 	session.baseBuildFinished(absbaseref, basemf, basecfg)
 
-	for _, chk := range p.Chunks {
+	chunks, err := orderChunks(p.Chunks)
+	if err != nil {
+		return withKind(ErrorKindConfig, err)
+	}
+	var (
+		failed  []string
+		layers  = make(map[string]int, len(p.Chunks))
+		skipped = make(map[string]bool)
+		needed  = make(map[string]bool)
+	)
+	for _, chk := range chunks {
+		for _, dep := range chk.deps {
+			needed[dep.Name] = true
+		}
+	}
+	for _, chk := range chunks {
+		if !chk.SupportsPlatform(session.opts.Platform) {
+			session.opts.Logger.WithField("chunk", chk.Name).WithField("platform", platforms.Format(session.opts.Platform)).Warn("skipping chunk which does not support the platform")
+			skipped[chk.Name] = true
+			continue
+		}
+		if dep := chk.skippedDependency(skipped); dep != "" {
+			session.opts.Logger.WithField("chunk", chk.Name).WithField("dependency", dep).Warn("skipping chunk whose dependency was not built")
+			skipped[chk.Name] = true
+			continue
+		}
+
 		_, _, err := chk.test(ctx, session)
+		if err != nil && !session.opts.FailFast && errors.Is(err, ErrTestsFailed) {
+			session.opts.Logger.WithField("chunk", chk.Name).Error("tests failed, not building the chunk")
+			failed = append(failed, chk.Name)
+			skipped[chk.Name] = true
+			continue
+		}
 		if err != nil {
 			return fmt.Errorf("cannot test chunk %s: %w", chk.Name, err)
 		}
+		if session.opts.TestsOnly {
+			if needed[chk.Name] {
+				// the chunks which depend on this one are tested against its full image, which buildkit can
+				// only pull from the registry - hence we must push it despite building for the tests only
+				fullRef, didBuild, err := chk.buildImage(ctx, ImageTypeFull, session)
+				if err != nil {
+					return fmt.Errorf("cannot build chunk %s: %w", chk.Name, err)
+				}
+				if didBuild {
+					session.opts.Logger.WithField("chunk", chk.Name).WithField("ref", fullRef).Warn("pushed the full image of a dependency although only testing, as the chunks depending on it are tested against it")
+				}
+			}
+			continue
+		}
 
-		_, _, err = chk.build(ctx, session)
+		chkRef, _, err := chk.build(ctx, session)
 		if err != nil {
 			return fmt.Errorf("cannot build chunk %s: %w", chk.Name, err)
 		}
+		if mf, ok := session.chunks[chkRef.String()]; ok {
+			layers[chk.Name] = len(mf.Layers)
+		}
 	}
 
+	if !session.opts.TestsOnly {
+		// fail now rather than when combining the chunks into an image which cannot be pulled
+		for _, cmb := range p.Config.Combiner.Combinations {
+			err = checkCombinationLayers(cmb.Name, len(basemf.Layers), cmb.Chunks, layers)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = session.pushCatalog(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return withKind(ErrorKindTest, fmt.Errorf("chunks failed the tests: %s: %w", strings.Join(failed, ", "), ErrTestsFailed))
+	}
 	return nil
 }
 
@@ -193,7 +491,12 @@ func NewSession(cl *client.Client, targetRef string, options ...BuildOpt) (*Buil
 	}
 
 	opts := buildOpts{
-		Resolver: docker.NewResolver(docker.ResolverOptions{}),
+		Resolver:       docker.NewResolver(docker.ResolverOptions{}),
+		Platform:       platforms.DefaultSpec(),
+		Logger:         log.StandardLogger(),
+		ProgressWriter: os.Stderr,
+		FailFast:       true,
+		IfExists:       IfExistsSkip,
 	}
 	for _, o := range options {
 		err := o(&opts)
@@ -201,6 +504,16 @@ func NewSession(cl *client.Client, targetRef string, options ...BuildOpt) (*Buil
 			return nil, err
 		}
 	}
+	if opts.NoTests && opts.TestsOnly {
+		return nil, withKind(ErrorKindConfig, fmt.Errorf("cannot skip tests and run tests only at the same time"))
+	}
+	if r, ok := opts.Registry.(resolverRegistry); ok {
+		// refs which point to an image index resolve to the manifest of the platform we build for
+		platform := opts.Platform
+		r.platform = &platform
+		r.logger = opts.Logger
+		opts.Registry = r
+	}
 
 	return &BuildSession{
 		Client: cl,
@@ -220,16 +533,68 @@ type BuildSession struct {
 	baseMF  *ociv1.Manifest
 	baseCfg *ociv1.Image
 	chunks  map[string]*ociv1.Manifest
+	// combinations lists the combinations pushed during the session
+	combinations []CatalogCombination
+
+	// metadataMu guards metadata, the manifests and configs pulled during the session by digest
+	metadataMu sync.Mutex
+	metadata   map[digest.Digest]cachedImageMetadata
+
+	// testResultsMu guards testResults and testMatrix
+	testResultsMu sync.Mutex
+	testResults   []test.Results
+	testMatrix    []MatrixCell
+
+	// testPlatformOnce guards testPlatformSkip, the reason why the tests cannot run on the session's platform
+	testPlatformOnce sync.Once
+	testPlatformSkip string
+
+	// workersMu guards workers, the workers of each buildkit daemon listed during the session
+	workersMu sync.Mutex
+	workers   map[*client.Client][]*client.WorkerInfo
+
+	// testEnv is the allowlist of host env vars the tests of the project may reference
+	testEnv []string
+
+	// timingsMu guards timings, the durations of the phases of the build
+	timingsMu sync.Mutex
+	timings   []PhaseTiming
+
+	// progressMu guards progress, the build steps buildkit ran per chunk when using prefixed output
+	progressMu sync.Mutex
+	progress   map[string]ProgressSummary
 }
 
 type removeBaseLayerOpts struct {
 	resolver remotes.Resolver
-	registry Registry
+	sess     *BuildSession
 	baseref  reference.Reference
 	basemf   *ociv1.Manifest
 	basecfg  *ociv1.Image
 	chunkref reference.Named
 	dest     reference.NamedTagged
+	chunk    string
+	tests    TestStatus
+}
+
+// retentionAnnotations produces the manifest annotations of intermediate images, which are nil unless they expire
+func (s *BuildSession) retentionAnnotations() map[string]string {
+	if s.opts.ExpiresAfter == 0 {
+		return nil
+	}
+	return map[string]string{mfAnnotationExpiresAfter: formatExpiresAfter(s.opts.ExpiresAfter)}
+}
+
+// formatExpiresAfter formats a duration without its trailing zero units, e.g. 336h rather than 336h0m0s
+func formatExpiresAfter(d time.Duration) string {
+	res := d.String()
+	if strings.HasSuffix(res, "m0s") {
+		res = strings.TrimSuffix(res, "0s")
+	}
+	if strings.HasSuffix(res, "h0m") {
+		res = strings.TrimSuffix(res, "0m")
+	}
+	return res
 }
 
 // PrintBuildInfo logs information about the built chunks
@@ -245,7 +610,15 @@ func (s *BuildSession) PrintBuildInfo() {
 		for _, l := range s.chunks[c].Layers {
 			size += l.Size
 		}
-		log.WithField("chunk", c).WithField("size_mb", float64(size)/(1024.0*1024.0)).Info("chunk built")
+		entry := s.opts.Logger.WithField("chunk", c).WithField("size_mb", float64(size)/(1024.0*1024.0))
+		sum, ok := s.progress[s.chunks[c].Annotations[mfAnnotationChunk]]
+		if ok && sum.Steps > 0 {
+			entry = entry.WithField("steps", sum.Steps).WithField("cached", sum.Cached).WithField("cache_hit_ratio", fmt.Sprintf("%.0f%%", 100*sum.CacheHitRatio()))
+		}
+		entry.Info("chunk built")
+		for _, e := range sum.Executed {
+			s.opts.Logger.WithField("chunk", c).WithField("instruction", e).Info("not cached")
+		}
 	}
 }
 
@@ -253,6 +626,94 @@ func (s *BuildSession) recordChunk(name string, mf *ociv1.Manifest) {
 	s.chunks[name] = mf
 }
 
+func (s *BuildSession) recordCombination(name, ref string, chunks []string) {
+	s.combinations = append(s.combinations, CatalogCombination{Name: name, Ref: ref, Chunks: chunks})
+}
+
+// PushedRefs returns the refs of the chunked images and combinations pushed during this session
+func (s *BuildSession) PushedRefs() []string {
+	res := make([]string, 0, len(s.chunks)+len(s.combinations))
+	for ref := range s.chunks {
+		res = append(res, ref)
+	}
+	sort.Strings(res)
+	for _, c := range s.combinations {
+		res = append(res, c.Ref)
+	}
+	return res
+}
+
+// projectTestEnv returns the allowlist of host env vars the tests of the project may reference: those of the
+// session and those of the project config. It never modifies the options of the session, which may be reused.
+func (s *BuildSession) projectTestEnv(p *Project) []string {
+	res := make([]string, 0, len(s.opts.TestEnv)+len(p.Config.Tests.Env))
+	res = append(res, s.opts.TestEnv...)
+	return append(res, p.Config.Tests.Env...)
+}
+
+// selectTests returns the tests of a suite which should run according to the test filters and failure log
+func (s *BuildSession) selectTests(suite string, specs []*test.Spec) []*test.Spec {
+	specs = test.FilterSpecs(specs, s.opts.TestFilters)
+	if s.opts.RerunFailed && s.opts.FailureLog != nil {
+		specs = s.opts.FailureLog.Failed(suite, specs)
+	}
+	return specs
+}
+
+func (s *BuildSession) recordTestResults(name string, res test.Results) {
+	res.Name = name
+	if s.opts.FailureLog != nil {
+		s.opts.FailureLog.Update(name, res)
+	}
+
+	s.testResultsMu.Lock()
+	defer s.testResultsMu.Unlock()
+	s.testResults = append(s.testResults, res)
+}
+
+// TestResults returns the results of all tests run during this session, one entry per chunk or combination
+func (s *BuildSession) TestResults() []test.Results {
+	s.testResultsMu.Lock()
+	defer s.testResultsMu.Unlock()
+
+	res := make([]test.Results, len(s.testResults))
+	copy(res, s.testResults)
+	return res
+}
+
+func (s *BuildSession) recordMatrixCell(c MatrixCell) {
+	s.testResultsMu.Lock()
+	defer s.testResultsMu.Unlock()
+	s.testMatrix = append(s.testMatrix, c)
+}
+
+// TestMatrix returns the outcome of all combination/chunk pairs tested using WithTestMatrix during this session
+func (s *BuildSession) TestMatrix() []MatrixCell {
+	s.testResultsMu.Lock()
+	defer s.testResultsMu.Unlock()
+
+	res := make([]MatrixCell, len(s.testMatrix))
+	copy(res, s.testMatrix)
+	return res
+}
+
+// PrintTestMatrix logs the outcome of all combination/chunk pairs tested during this session
+func (s *BuildSession) PrintTestMatrix() {
+	for _, c := range s.TestMatrix() {
+		entry := s.opts.Logger.WithField("combination", c.Combination).WithField("chunk", c.Chunk).WithField("tests", c.Tests)
+		switch {
+		case !c.Passed:
+			entry.Error("failed")
+		case c.Skipped:
+			entry.Warn("skipped: platform")
+		case c.Cached:
+			entry.Info("passed (cached)")
+		default:
+			entry.Info("passed")
+		}
+	}
+}
+
 // DownloadBaseInfo downloads the base image info
 func (s *BuildSession) DownloadBaseInfo(ctx context.Context, p *Project) (err error) {
 	defer func() {
@@ -265,9 +726,13 @@ func (s *BuildSession) DownloadBaseInfo(ctx context.Context, p *Project) (err er
 	if err != nil {
 		return err
 	}
-	log.WithField("ref", baseref).WithField("dest", s.Dest).Debug("downloading base image info")
+	s.opts.Logger.WithField("ref", baseref).WithField("dest", s.Dest).Debug("downloading base image info")
 
-	absrefs, mf, cfg, err := getImageMetadata(ctx, baseref, s.opts.Registry)
+	absrefs, mf, cfg, err := s.imageMetadata(ctx, baseref)
+	if err != nil {
+		return err
+	}
+	err = checkImagePlatform(absrefs.String(), cfg, s.opts.Platform)
 	if err != nil {
 		return err
 	}
@@ -283,34 +748,19 @@ func (s *BuildSession) baseBuildFinished(ref reference.Digested, mf *ociv1.Manif
 }
 
 func removeBaseLayer(ctx context.Context, opts removeBaseLayerOpts) (chkmf *ociv1.Manifest, didbuild bool, err error) {
-	_, chkmf, chkcfg, err := getImageMetadata(ctx, opts.chunkref, opts.registry)
+	// anything but a chunk which does not match its base is a failure to push the chunked image
+	defer func() {
+		err = registryError(opts.dest.String(), err)
+	}()
+
+	_, chkmf, chkcfg, err := opts.sess.imageMetadata(ctx, opts.chunkref)
 	if err != nil {
 		return
 	}
 
-	for i := range opts.basemf.Layers {
-		if len(chkmf.Layers) < i {
-			err = fmt.Errorf("chunk was not built from base image (too few layers)")
-			return
-		}
-		if len(chkcfg.RootFS.DiffIDs) < i {
-			err = fmt.Errorf("chunk was not built from base image (too few diffIDs)")
-			return
-		}
-		var (
-			bl = opts.basemf.Layers[i]
-			bd = opts.basecfg.RootFS.DiffIDs[i]
-			cl = chkmf.Layers[i]
-			cd = chkcfg.RootFS.DiffIDs[i]
-		)
-		if bl.Digest.String() != cl.Digest.String() {
-			err = fmt.Errorf("chunk was not built from base image: digest mismatch on layer %d: base %s != chunk %s", i, bl.Digest.String(), cl.Digest.String())
-			return
-		}
-		if bd.String() != cd.String() {
-			err = fmt.Errorf("chunk was not built from base image: digest mismatch on diffID %d: base %s != chunk %s", i, bd.String(), cd.String())
-			return
-		}
+	err = checkBaseChain(opts.basemf, opts.basecfg, chkmf, chkcfg)
+	if err != nil {
+		return
 	}
 
 	n := len(opts.basecfg.RootFS.DiffIDs)
@@ -319,6 +769,9 @@ func removeBaseLayer(ctx context.Context, opts removeBaseLayerOpts) (chkmf *ociv
 		DiffIDs: chkcfg.RootFS.DiffIDs[n:],
 	}
 	chkcfg.History = chkcfg.History[len(opts.basecfg.History):]
+	if opts.sess.opts.RedactHistory {
+		chkcfg.History = redactHistory(chkcfg.History)
+	}
 	ncfg, err := json.Marshal(chkcfg)
 	if err != nil {
 		return
@@ -341,6 +794,11 @@ func removeBaseLayer(ctx context.Context, opts removeBaseLayerOpts) (chkmf *ociv
 		chkmf.Annotations = make(map[string]string)
 	}
 	chkmf.Annotations[mfAnnotationBaseRef] = opts.baseref.String()
+	chkmf.Annotations[mfAnnotationChunk] = opts.chunk
+	chkmf.Annotations[mfAnnotationTests] = string(opts.tests)
+	for k, v := range opts.sess.retentionAnnotations() {
+		chkmf.Annotations[k] = v
+	}
 	nmf, err := json.Marshal(chkmf)
 	if err != nil {
 		return
@@ -352,14 +810,20 @@ func removeBaseLayer(ctx context.Context, opts removeBaseLayerOpts) (chkmf *ociv
 		Size:      int64(len(nmf)),
 	}
 
-	if _, dstmf, _, err := getImageMetadata(ctx, opts.dest, opts.registry); err == nil {
-		if dstmf.Config.Digest == chkmf.Config.Digest {
+	if _, dstmf, _, err := opts.sess.imageMetadata(ctx, opts.dest); err == nil && opts.sess.opts.IfExists != IfExistsRebuild {
+		upToDate := dstmf.Config.Digest == chkmf.Config.Digest && dstmf.Annotations[mfAnnotationTests] == chkmf.Annotations[mfAnnotationTests]
+		if upToDate && opts.sess.opts.IfExists == IfExistsVerify && dstmf.Annotations[mfAnnotationBaseRef] != chkmf.Annotations[mfAnnotationBaseRef] {
+			opts.sess.opts.Logger.WithField("ref", opts.dest.String()).WithField("base-ref", dstmf.Annotations[mfAnnotationBaseRef]).Warn("existing chunked image was built on another base image, pushing it again")
+			upToDate = false
+		}
+		if upToDate {
 			// config is already pushed to remote from a previous run.
 			// We just assume that the manifest must be up to date, too and stop here.
 			return dstmf, false, nil
 		}
 	}
 	didbuild = true
+	defer opts.sess.timePhase("push", opts.dest.String())()
 
 	pusher, err := opts.resolver.Pusher(ctx, opts.dest.String())
 	if err != nil {
@@ -370,8 +834,19 @@ func removeBaseLayer(ctx context.Context, opts removeBaseLayerOpts) (chkmf *ociv
 		return
 	}
 
-	log.WithField("step", 0).WithField("dest", opts.dest.String()).Info("pushing config")
-	cfgw, err := pusher.Push(ctx, chkmf.Config)
+	cfgref, err := reference.WithDigest(reference.TrimNamed(opts.dest), chkmf.Config.Digest)
+	if err != nil {
+		return
+	}
+
+	opts.sess.opts.Logger.WithField("step", 0).WithField("dest", opts.dest.String()).Info("pushing config")
+	var cfgw content.Writer
+	if contentExists(ctx, opts.sess.opts.Logger, opts.resolver, cfgref.String(), chkmf.Config.Digest) {
+		opts.sess.opts.Logger.WithField("dest", opts.dest.String()).Debug("config exists already")
+		err = errdefs.ErrAlreadyExists
+	} else {
+		cfgw, err = pusher.Push(ctx, chkmf.Config)
+	}
 	if errdefs.IsAlreadyExists(err) {
 		// nothing to do
 	} else if err != nil {
@@ -390,9 +865,9 @@ func removeBaseLayer(ctx context.Context, opts removeBaseLayerOpts) (chkmf *ociv
 		}
 	}
 
-	log.WithField("step", 1).WithField("dest", opts.dest.String()).Info("pushing layers")
+	opts.sess.opts.Logger.WithField("step", 1).WithField("dest", opts.dest.String()).Info("pushing layers")
 	for i, l := range chkmf.Layers {
-		log.WithField("layer", l.Digest).WithField("step", 2+i).Info("copying layer")
+		opts.sess.opts.Logger.WithField("layer", l.Digest).WithField("step", 2+i).Info("copying layer")
 		// this is just needed if the chunk and dest are not in the same repo
 		err = copyLayer(ctx, fetcher, pusher, l)
 		if err != nil {
@@ -400,10 +875,16 @@ func removeBaseLayer(ctx context.Context, opts removeBaseLayerOpts) (chkmf *ociv
 		}
 	}
 
-	log.WithField("step", 3+len(chkmf.Layers)).WithField("dest", opts.dest.String()).Info("pushing manifest")
-	mfw, err := pusher.Push(ctx, mfdesc)
+	opts.sess.opts.Logger.WithField("step", 3+len(chkmf.Layers)).WithField("dest", opts.dest.String()).Info("pushing manifest")
+	var mfw content.Writer
+	if contentExists(ctx, opts.sess.opts.Logger, opts.resolver, opts.dest.String(), mfdesc.Digest) {
+		opts.sess.opts.Logger.WithField("dest", opts.dest.String()).Debug("manifest exists already")
+		err = errdefs.ErrAlreadyExists
+	} else {
+		mfw, err = pusher.Push(ctx, mfdesc)
+	}
 	if errdefs.IsAlreadyExists(err) {
-		// nothiong to do
+		// nothing to do
 	} else if err != nil {
 		err = fmt.Errorf("cannot push image manifest: %w", err)
 		return
@@ -423,13 +904,106 @@ func removeBaseLayer(ctx context.Context, opts removeBaseLayerOpts) (chkmf *ociv
 	return chkmf, true, nil
 }
 
-func copyLayer(ctx context.Context, fetcher remotes.Fetcher, pusher remotes.Pusher, desc ociv1.Descriptor) (err error) {
-	rc, err := fetcher.Fetch(ctx, desc)
+// redactHistory strips the build steps from the history of an image, keeping only the instruction,
+// e.g. RUN. Build steps echo the build args (RUN |1 TOKEN=secret /bin/sh -c ...), which is why ARG
+// entries are dropped altogether. Only empty layers are dropped, so the history still matches the layers.
+func redactHistory(hist []ociv1.History) []ociv1.History {
+	res := make([]ociv1.History, 0, len(hist))
+	for _, h := range hist {
+		instr := historyInstruction(h.CreatedBy)
+		if h.EmptyLayer && instr == "ARG" {
+			continue
+		}
+		h.CreatedBy = instr
+		res = append(res, h)
+	}
+	return res
+}
+
+// historyInstruction returns the Dockerfile instruction of a build step as recorded by buildkit,
+// or an empty string if the step does not start with one (e.g. /bin/sh -c #(nop) of the legacy builder)
+func historyInstruction(createdBy string) string {
+	fields := strings.Fields(createdBy)
+	if len(fields) == 0 || strings.Trim(fields[0], "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return ""
+	}
+	return fields[0]
+}
+
+// checkBaseChain checks that an image starts with the layers and diffIDs of the base image
+func checkBaseChain(basemf *ociv1.Manifest, basecfg *ociv1.Image, mf *ociv1.Manifest, cfg *ociv1.Image) error {
+	for i := range basemf.Layers {
+		if len(mf.Layers) <= i {
+			return withKind(ErrorKindBuild, fmt.Errorf("%w (too few layers)", ErrChunkNotFromBase))
+		}
+		if len(cfg.RootFS.DiffIDs) <= i || len(basecfg.RootFS.DiffIDs) <= i {
+			return withKind(ErrorKindBuild, fmt.Errorf("%w (too few diffIDs)", ErrChunkNotFromBase))
+		}
+		var (
+			bl = basemf.Layers[i]
+			bd = basecfg.RootFS.DiffIDs[i]
+			cl = mf.Layers[i]
+			cd = cfg.RootFS.DiffIDs[i]
+		)
+		if bl.Digest.String() != cl.Digest.String() {
+			return withKind(ErrorKindBuild, fmt.Errorf("%w: digest mismatch on layer %d: base %s != chunk %s", ErrChunkNotFromBase, i, bl.Digest.String(), cl.Digest.String()))
+		}
+		if bd.String() != cd.String() {
+			return withKind(ErrorKindBuild, fmt.Errorf("%w: digest mismatch on diffID %d: base %s != chunk %s", ErrChunkNotFromBase, i, bd.String(), cd.String()))
+		}
+	}
+	return nil
+}
+
+// useExisting returns the digested ref of an image which exists in the registry already if the session's
+// IfExistsPolicy allows using it instead of building the image again
+func (s *BuildSession) useExisting(ctx context.Context, ref reference.Named) (absref reference.Digested, ok bool) {
+	_, desc, err := s.opts.Resolver.Resolve(ctx, ref.String())
 	if err != nil {
-		return
+		return nil, false
 	}
-	defer rc.Close()
+	absref, err = reference.WithDigest(ref, desc.Digest)
+	if err != nil {
+		return nil, false
+	}
+
+	switch s.opts.IfExists {
+	case IfExistsRebuild:
+		s.opts.Logger.WithField("ref", ref.String()).Info("image exists already, rebuilding it")
+		return nil, false
+	case IfExistsVerify:
+		err = s.verifyExisting(ctx, absref)
+		if err != nil {
+			s.opts.Logger.WithError(err).WithField("ref", ref.String()).Warn("existing image failed verification, rebuilding it")
+			return nil, false
+		}
+	}
+	return absref, true
+}
 
+// verifyExisting checks that an existing image has a diffID for every layer and was built on the session's base image,
+// i.e. its base-ref annotation (if any) points to the base image and it starts with the base image's layers.
+// Before the base image is known, e.g. when verifying the base image itself, only the diffIDs are checked.
+func (s *BuildSession) verifyExisting(ctx context.Context, ref reference.Digested) error {
+	_, mf, cfg, err := s.imageMetadata(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if len(mf.Layers) != len(cfg.RootFS.DiffIDs) {
+		return withKind(ErrorKindBuild, fmt.Errorf("image has %d layers but %d diffIDs", len(mf.Layers), len(cfg.RootFS.DiffIDs)))
+	}
+	if baseref, ok := mf.Annotations[mfAnnotationBaseRef]; ok && (s.baseRef == nil || baseref != s.baseRef.String()) {
+		return withKind(ErrorKindBuild, fmt.Errorf("%w: image was built on %s", ErrChunkNotFromBase, baseref))
+	}
+	if s.baseMF == nil || s.baseCfg == nil {
+		return nil
+	}
+	return checkBaseChain(s.baseMF, s.baseCfg, mf, cfg)
+}
+
+// copyLayer copies a blob unless it exists at the destination already. We push before fetching so
+// that blobs which exist, or which the registry mounts from another repository, are not downloaded.
+func copyLayer(ctx context.Context, fetcher remotes.Fetcher, pusher remotes.Pusher, desc ociv1.Descriptor) (err error) {
 	w, err := pusher.Push(ctx, desc)
 	if errdefs.IsAlreadyExists(err) {
 		return nil
@@ -439,6 +1013,12 @@ func copyLayer(ctx context.Context, fetcher remotes.Fetcher, pusher remotes.Push
 	}
 	defer w.Close()
 
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
 	_, err = io.Copy(w, rc)
 	if err != nil {
 		return
@@ -457,6 +1037,79 @@ func getImageMetadata(ctx context.Context, ref reference.Reference, registry Reg
 	return
 }
 
+// cachedImageMetadata is the serialized manifest and config of an image, so that every caller
+// gets its own copy which it can modify
+type cachedImageMetadata struct {
+	Manifest []byte
+	Config   []byte
+}
+
+// imageMetadata is getImageMetadata memoized by digest for the duration of the session. Tags are resolved
+// on every call because they can move, but the manifest and config of an image are downloaded only once.
+func (s *BuildSession) imageMetadata(ctx context.Context, ref reference.Reference) (absref reference.Digested, manifest *ociv1.Manifest, config *ociv1.Image, err error) {
+	if r, ok := ref.(reference.Digested); ok {
+		absref = r
+	} else if r, ok := ref.(reference.Named); ok {
+		_, desc, err := s.opts.Resolver.Resolve(ctx, ref.String())
+		if err != nil {
+			return nil, nil, nil, registryError(ref.String(), err)
+		}
+		if desc.Digest != "" {
+			absref, err = reference.WithDigest(r, desc.Digest)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	if absref == nil {
+		defer s.timePhase("metadata pull", ref.String())()
+		return getImageMetadata(ctx, ref, s.opts.Registry)
+	}
+
+	s.metadataMu.Lock()
+	cached, ok := s.metadata[absref.Digest()]
+	s.metadataMu.Unlock()
+	if !ok {
+		done := s.timePhase("metadata pull", absref.String())
+		absref, manifest, config, err = getImageMetadata(ctx, absref, s.opts.Registry)
+		done()
+		if err != nil {
+			return
+		}
+		cached.Manifest, err = json.Marshal(manifest)
+		if err != nil {
+			return
+		}
+		cached.Config, err = json.Marshal(config)
+		if err != nil {
+			return
+		}
+
+		s.metadataMu.Lock()
+		if s.metadata == nil {
+			s.metadata = make(map[digest.Digest]cachedImageMetadata)
+		}
+		s.metadata[absref.Digest()] = cached
+		s.metadataMu.Unlock()
+		return
+	}
+
+	s.opts.Logger.WithField("ref", absref.String()).Debug("using image metadata pulled earlier in this session")
+	var (
+		mf  ociv1.Manifest
+		cfg ociv1.Image
+	)
+	err = json.Unmarshal(cached.Manifest, &mf)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(cached.Config, &cfg)
+	if err != nil {
+		return
+	}
+	return absref, &mf, &cfg, nil
+}
+
 // BaseRef returns the ref of the base image of a project
 func (p *Project) BaseRef(build reference.Named) (reference.NamedTagged, error) {
 	hash, err := p.Base.hash("", true)
@@ -467,10 +1120,8 @@ func (p *Project) BaseRef(build reference.Named) (reference.NamedTagged, error)
 }
 
 func (p *ProjectChunk) buildAsBase(ctx context.Context, dest reference.Named, sess *BuildSession) (absref reference.Digested, err error) {
-	_, desc, err := sess.opts.Resolver.Resolve(ctx, dest.String())
-	if err == nil {
-		// if err == nil the image exists already
-		return reference.WithDigest(dest, desc.Digest)
+	if absref, ok := sess.useExisting(ctx, dest); ok {
+		return absref, nil
 	}
 
 	eg, ctx := errgroup.WithContext(ctx)
@@ -488,16 +1139,22 @@ func (p *ProjectChunk) buildAsBase(ctx context.Context, dest reference.Named, se
 		}
 	)
 
+	dockerfileDir, err := p.writeDockerfile()
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dockerfileDir)
+
 	rchan := make(chan map[string]string, 1)
 	eg.Go(func() error {
-		dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
-		resp, err := sess.Client.Solve(ctx, nil, client.SolveOpt{
+		dockerConfig := config.LoadDefaultConfigFile(sess.opts.ProgressWriter)
+		resp, err := sess.solve(ctx, sess.Client, client.SolveOpt{
 			Frontend:      "dockerfile.v0",
 			CacheImports:  []client.CacheOptionsEntry{cacheImport},
 			CacheExports:  []client.CacheOptionsEntry{cacheExport},
-			FrontendAttrs: make(map[string]string),
+			FrontendAttrs: map[string]string{"platform": platforms.Format(sess.opts.Platform)},
 			Session: []session.Attachable{
-				authprovider.NewDockerAuthProvider(dockerConfig),
+				auth.NewProvider(dockerConfig),
 			},
 			Exports: []client.ExportEntry{
 				{
@@ -511,7 +1168,7 @@ func (p *ProjectChunk) buildAsBase(ctx context.Context, dest reference.Named, se
 			},
 			LocalDirs: map[string]string{
 				"context":    p.ContextPath,
-				"dockerfile": p.ContextPath,
+				"dockerfile": dockerfileDir,
 			},
 		}, ch)
 		if err != nil {
@@ -521,23 +1178,11 @@ func (p *ProjectChunk) buildAsBase(ctx context.Context, dest reference.Named, se
 		return nil
 	})
 	eg.Go(func() error {
-		var c console.Console
-
-		isTTY := isatty.IsTerminal(os.Stderr.Fd())
-		if !sess.opts.PlainOutput && isTTY {
-			cf, err := console.ConsoleFromFile(os.Stderr)
-			if err != nil {
-				return err
-			}
-			c = cf
-		}
-
-		// not using shared context to not disrupt display but let is finish reporting errors
-		_, err := progressui.DisplaySolveStatus(context.TODO(), "", c, os.Stderr, ch)
-		return err
+		return sess.displayStatus(baseLayerOwner, ch)
 	})
 	err = eg.Wait()
 	if err != nil {
+		err = withKind(ErrorKindBuild, err)
 		return
 	}
 
@@ -554,6 +1199,21 @@ func (p *ProjectChunk) buildAsBase(ctx context.Context, dest reference.Named, se
 	return resref, nil
 }
 
+// writeDockerfile writes the Dockerfile of the chunk as it was loaded, i.e. the variant's Dockerfile with
+// templates rendered, to a temporary directory for buildkit to read it from. The caller removes the directory.
+func (p *ProjectChunk) writeDockerfile() (dir string, err error) {
+	dir, err = os.MkdirTemp("", "dazzle-dockerfile-*")
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(filepath.Join(dir, "Dockerfile"), p.Dockerfile, 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
 func (p *ProjectChunk) test(ctx context.Context, sess *BuildSession) (ok bool, didRun bool, err error) {
 	if sess == nil {
 		return false, false, errors.New("cannot test without a session")
@@ -562,7 +1222,11 @@ func (p *ProjectChunk) test(ctx context.Context, sess *BuildSession) (ok bool, d
 		return true, false, nil
 	}
 
-	resultRef, err := p.ImageName(imageTypeTestResult, sess)
+	tests, err := test.InterpolateSpecs(sess.selectTests(p.Name, p.Tests), sess.testEnv)
+	if err != nil {
+		return false, false, err
+	}
+	resultRef, err := p.testResultRef(test.ResolvedEnv(p.Tests, sess.testEnv), sess)
 	if err != nil {
 		return false, false, err
 	}
@@ -575,26 +1239,39 @@ func (p *ProjectChunk) test(ctx context.Context, sess *BuildSession) (ok bool, d
 		return true, false, nil
 	}
 
-	// build temp image for testing
-	testRef, _, err := p.buildImage(ctx, ImageTypeTest, sess)
-	if err != nil {
-		return false, false, err
+	if len(tests) == 0 {
+		sess.opts.Logger.WithField("chunk", p.Name).Info("no tests selected")
+		return true, false, nil
+	}
+
+	if reason := sess.testPlatformSkipReason(ctx); reason != "" {
+		sess.opts.Logger.WithField("chunk", p.Name).WithField("tests", len(tests)).Warn("skipping tests which cannot run on this platform")
+		sess.recordTestResults(p.Name, test.SkipTests(tests, reason))
+		return true, false, nil
 	}
 
-	_, _, imgcfg, err := getImageMetadata(ctx, testRef, sess.opts.Registry)
+	executor, err := p.testExecutor(ctx, sess)
 	if err != nil {
 		return false, false, err
 	}
 
-	log.WithField("chunk", p.Name).Warn("running tests")
-	executor := buildkit.NewExecutor(sess.Client, testRef.String(), imgcfg)
-	_, ok = test.RunTests(ctx, executor, p.Tests)
+	sess.opts.Logger.WithField("chunk", p.Name).WithField("tests", len(tests)).Warn("running tests")
+	testsDone := sess.timePhase("tests", p.Name)
+	results, ok := test.RunTests(ctx, executor, tests, test.WithTimeout(sess.opts.TestTimeout), test.WithLogger(sess.opts.Logger))
+	testsDone()
+	sess.recordTestResults(p.Name, results)
 	if !ok {
-		return false, true, fmt.Errorf("%s: tests failed", p.Name)
+		return false, true, withKind(ErrorKindTest, fmt.Errorf("%s: %w", p.Name, ErrTestsFailed))
+	}
+	if len(tests) != len(p.Tests) {
+		// only a subset of the tests ran - we must not mark the chunk as tested
+		return true, true, nil
 	}
 
 	// tests have passed - mark them as such
-	_, err = pushTestResult(ctx, sess.opts.Registry, resultRef, StoredTestResult{true})
+	pushDone := sess.timePhase("push", resultRef.String())
+	_, err = pushTestResult(ctx, sess.opts.Registry, resultRef, StoredTestResult{true}, sess.retentionAnnotations())
+	pushDone()
 	if err != nil && !errdefs.IsAlreadyExists(err) {
 		return true, true, err
 	}
@@ -602,6 +1279,98 @@ func (p *ProjectChunk) test(ctx context.Context, sess *BuildSession) (ok bool, d
 	return true, true, nil
 }
 
+// testStatus describes the tests which ran before the chunk is built. Chunks are only built once their tests passed.
+func (p *ProjectChunk) testStatus(sess *BuildSession) TestStatus {
+	switch {
+	case len(p.Tests) == 0:
+		return TestStatusNone
+	case sess.opts.NoTests, sess.testPlatformSkip != "":
+		return TestStatusSkipped
+	case len(sess.selectTests(p.Name, p.Tests)) != len(p.Tests):
+		return TestStatusPartial
+	default:
+		return TestStatusPassed
+	}
+}
+
+// testPlatformSkipReason checks once per session whether the buildkit workers can run containers of the platform the
+// session builds for, natively or using emulation. If they cannot it returns the reason why the tests are skipped.
+func (s *BuildSession) testPlatformSkipReason(ctx context.Context) string {
+	s.testPlatformOnce.Do(func() {
+		if s.Client == nil {
+			return
+		}
+		platform := platforms.Format(s.opts.Platform)
+		ok, emulated, err := buildkit.SupportsPlatform(ctx, s.Client, s.opts.Platform)
+		switch {
+		case err != nil:
+			// older buildkit daemons might not list their workers - just try to run the tests
+			s.opts.Logger.WithError(err).Debug("cannot list buildkit workers")
+		case !ok:
+			s.opts.Logger.WithField("platform", platform).Warn("the buildkit workers cannot run this platform, install QEMU binfmt handlers to test it using emulation")
+			s.testPlatformSkip = fmt.Sprintf("platform: %s is not supported by the buildkit workers", platform)
+		case emulated:
+			s.opts.Logger.WithField("platform", platform).Info("running tests using emulation")
+		}
+	})
+	return s.testPlatformSkip
+}
+
+// testExecutor builds the test image of the chunk and produces an executor which runs tests in it
+func (p *ProjectChunk) testExecutor(ctx context.Context, sess *BuildSession) (*buildkit.Executor, error) {
+	testRef, _, err := p.buildImage(ctx, ImageTypeTest, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, imgcfg, err := sess.imageMetadata(ctx, testRef)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := sess.clientFor(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return buildkit.NewExecutor(cl, testRef.String(), imgcfg), nil
+}
+
+// TestExecutor produces an executor which runs tests in the test image of a chunk,
+// building the base and test image if need be.
+func (p *Project) TestExecutor(ctx context.Context, sess *BuildSession, chunk string) (test.Executor, error) {
+	ctx = clog.WithLogger(ctx, log.NewEntry(log.New()))
+
+	var chk *ProjectChunk
+	for i := range p.Chunks {
+		if p.Chunks[i].Name == chunk {
+			chk = &p.Chunks[i]
+			break
+		}
+	}
+	if chk == nil {
+		return nil, fmt.Errorf("chunk %s not found", chunk)
+	}
+
+	baseref, err := p.BaseRef(sess.Dest)
+	if err != nil {
+		return nil, err
+	}
+	err = p.verifyBase(ctx, sess)
+	if err != nil {
+		return nil, err
+	}
+	absbaseref, err := p.Base.buildAsBase(ctx, baseref, sess)
+	if err != nil {
+		return nil, fmt.Errorf("cannot build base image: %w", err)
+	}
+	_, basemf, basecfg, err := sess.imageMetadata(ctx, absbaseref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch base image: %w", err)
+	}
+	sess.baseBuildFinished(absbaseref, basemf, basecfg)
+
+	return chk.testExecutor(ctx, sess)
+}
+
 func (p *ProjectChunk) build(ctx context.Context, sess *BuildSession) (chkRef reference.NamedTagged, didBuild bool, err error) {
 	// build actual full image
 	fullRef, didBuild, err := p.buildImage(ctx, ImageTypeFull, sess)
@@ -618,9 +1387,15 @@ func (p *ProjectChunk) build(ctx context.Context, sess *BuildSession) (chkRef re
 	if err != nil {
 		return
 	}
-	log.WithField("chunk", p.Name).WithField("ref", chkRef).Warn("building chunked image")
-	opts := removeBaseLayerOpts{sess.opts.Resolver, sess.opts.Registry, sess.baseRef, sess.baseMF, sess.baseCfg, fullRef, chkRef}
+	sess.opts.Logger.WithField("chunk", p.Name).WithField("ref", chkRef).Warn("building chunked image")
+	opts := removeBaseLayerOpts{sess.opts.Resolver, sess, sess.baseRef, sess.baseMF, sess.baseCfg, fullRef, chkRef, p.Name, p.testStatus(sess)}
+	chunkedDone := sess.timePhase(string(chktpe)+" image", p.Name)
 	mf, didBuild, err := removeBaseLayer(ctx, opts)
+	chunkedDone()
+	if err != nil {
+		return
+	}
+	err = p.checkSizeBudget(mf, sess)
 	if err != nil {
 		return
 	}
@@ -636,14 +1411,19 @@ func (p *ProjectChunk) buildImage(ctx context.Context, tpe ChunkImageType, sess
 		return
 	}
 
-	_, _, err = sess.opts.Resolver.Resolve(ctx, tgt.String())
-	if err == nil {
+	if _, ok := sess.useExisting(ctx, tgt); ok {
 		// image is already built
 		return tgt, false, nil
 	}
 
-	log.WithField("chunk", p.Name).WithField("ref", tgt).Warnf("building %s image", tpe)
+	cl, err := sess.clientFor(ctx, p)
+	if err != nil {
+		return
+	}
+
+	sess.opts.Logger.WithField("chunk", p.Name).WithField("ref", tgt).Warnf("building %s image", tpe)
 	didBuild = true
+	defer sess.timePhase(string(tpe)+" image", p.Name)()
 
 	eg, ctx := errgroup.WithContext(ctx)
 	ch := make(chan *client.SolveStatus)
@@ -670,21 +1450,35 @@ func (p *ProjectChunk) buildImage(ctx context.Context, tpe ChunkImageType, sess
 
 	attrs := map[string]string{
 		"build-arg:base": sess.baseRef.String(),
+		"platform":       platforms.Format(sess.opts.Platform),
 	}
 	for k, v := range p.Args {
 		attrs["build-arg:"+k] = v
 	}
+	depArgs, err := p.dependencyArgs(sess)
+	if err != nil {
+		return
+	}
+	for k, v := range depArgs {
+		attrs["build-arg:"+k] = v
+	}
+
+	dockerfileDir, err := p.writeDockerfile()
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dockerfileDir)
 
 	rchan := make(chan map[string]string, 1)
 	eg.Go(func() error {
-		dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
-		resp, err := sess.Client.Solve(ctx, nil, client.SolveOpt{
+		dockerConfig := config.LoadDefaultConfigFile(sess.opts.ProgressWriter)
+		resp, err := sess.solve(ctx, cl, client.SolveOpt{
 			Frontend:      "dockerfile.v0",
 			FrontendAttrs: attrs,
 			CacheImports:  cacheImports,
 			CacheExports:  cacheExports,
 			Session: []session.Attachable{
-				authprovider.NewDockerAuthProvider(dockerConfig),
+				auth.NewProvider(dockerConfig),
 			},
 			Exports: []client.ExportEntry{
 				{
@@ -698,7 +1492,7 @@ func (p *ProjectChunk) buildImage(ctx context.Context, tpe ChunkImageType, sess
 			},
 			LocalDirs: map[string]string{
 				"context":    p.ContextPath,
-				"dockerfile": p.ContextPath,
+				"dockerfile": dockerfileDir,
 			},
 		}, ch)
 		if err != nil {
@@ -708,23 +1502,11 @@ func (p *ProjectChunk) buildImage(ctx context.Context, tpe ChunkImageType, sess
 		return nil
 	})
 	eg.Go(func() error {
-		var c console.Console
-
-		isTTY := isatty.IsTerminal(os.Stderr.Fd())
-		if !sess.opts.PlainOutput && isTTY {
-			cf, err := console.ConsoleFromFile(os.Stderr)
-			if err != nil {
-				return err
-			}
-			c = cf
-		}
-
-		// not using shared context to not disrupt display but let is finish reporting errors
-		_, err := progressui.DisplaySolveStatus(context.TODO(), "", c, os.Stderr, ch)
-		return err
+		return sess.displayStatus(p.Name, ch)
 	})
 	err = eg.Wait()
 	if err != nil {
+		err = withKind(ErrorKindBuild, err)
 		return
 	}
 
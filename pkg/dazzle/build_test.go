@@ -38,8 +38,12 @@ import (
 	"github.com/docker/distribution/reference"
 	"github.com/google/go-cmp/cmp"
 	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/gitpod-io/dazzle/pkg/test"
 )
 
 func TestProjectChunk_test(t *testing.T) {
@@ -151,7 +155,7 @@ func TestProjectChunk_test(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			chks, err := loadChunks(fstest.MapFS(tt.fields.FS), "", tt.fields.Base, tt.fields.Chunk)
+			chks, err := loadChunks(fstest.MapFS(tt.fields.FS), "", tt.fields.Base, tt.fields.Chunk, defaultTestsDir)
 			if err != nil {
 				t.Errorf("could not load chunks:%v", err)
 				return
@@ -217,6 +221,378 @@ func (t fakeResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, e
 	return nil, nil
 }
 
+// digestResolver resolves every ref to the same manifest digest
+type digestResolver struct {
+	fakeResolver
+}
+
+func (t digestResolver) Resolve(ctx context.Context, ref string) (name string, desc ocispec.Descriptor, err error) {
+	return ref, ocispec.Descriptor{Digest: "sha256:b25ab047a146b43a7a1bdd2b3346a05fd27dd2730af8ab06a9b8acca0f15b378"}, nil
+}
+
+// countingRegistry counts the images pulled from it
+type countingRegistry struct {
+	fakeRegistry
+	pulls int
+}
+
+func (t *countingRegistry) Pull(ctx context.Context, ref reference.Reference, cfg interface{}) (manifest *ociv1.Manifest, absref reference.Digested, err error) {
+	t.pulls++
+	cfg.(*ociv1.Image).Architecture = "amd64"
+	absref, _ = ref.(reference.Digested)
+	return &ociv1.Manifest{Annotations: map[string]string{"pull": fmt.Sprint(t.pulls)}}, absref, nil
+}
+
+func TestBuildSession_imageMetadata(t *testing.T) {
+	ctx := context.Background()
+	sess, err := NewSession(nil, "localhost:9999/test")
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	registry := &countingRegistry{}
+	sess.opts.Resolver = digestResolver{}
+	sess.opts.Registry = registry
+
+	ref, err := reference.ParseNamed("localhost:9999/test:base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		absref, mf, cfg, err := sess.imageMetadata(ctx, ref)
+		if err != nil {
+			t.Fatalf("imageMetadata() error = %v", err)
+		}
+		if diff := cmp.Diff("localhost:9999/test:base@sha256:b25ab047a146b43a7a1bdd2b3346a05fd27dd2730af8ab06a9b8acca0f15b378", absref.String()); diff != "" {
+			t.Errorf("imageMetadata() absref mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(map[string]string{"pull": "1"}, mf.Annotations); diff != "" {
+			t.Errorf("imageMetadata() manifest mismatch (-want +got):\n%s", diff)
+		}
+		if cfg.Architecture != "amd64" {
+			t.Errorf("imageMetadata() config architecture = %q, want amd64", cfg.Architecture)
+		}
+
+		// callers modify the metadata, which must not leak into the cache
+		mf.Annotations["pull"] = "modified"
+	}
+	if registry.pulls != 1 {
+		t.Errorf("imageMetadata() pulled %d times, want 1", registry.pulls)
+	}
+}
+
+func TestBuildSession_Timings(t *testing.T) {
+	sess, err := NewSession(nil, "localhost:9999/test")
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	sess.timings = []PhaseTiming{
+		{Phase: "base", Subject: "base", Duration: 2 * time.Second},
+		{Phase: "tests", Subject: "foo", Duration: 5 * time.Second},
+		{Phase: "push", Subject: "localhost:9999/test:foo", Duration: 1500 * time.Millisecond},
+	}
+	sess.timePhase("metadata pull", "localhost:9999/test:base")()
+
+	act := sess.Timings()
+	var phases []string
+	for _, tm := range act {
+		phases = append(phases, tm.Phase)
+	}
+	if diff := cmp.Diff([]string{"tests", "base", "push", "metadata pull"}, phases); diff != "" {
+		t.Errorf("Timings() mismatch (-want +got):\n%s", diff)
+	}
+
+	fc, err := json.Marshal(act[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(`{"phase":"push","subject":"localhost:9999/test:foo","duration":"1.5s","seconds":1.5}`, string(fc)); diff != "" {
+		t.Errorf("PhaseTiming JSON mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewSession_testOptions(t *testing.T) {
+	type testOptions struct {
+		NoCache, NoTests, TestsOnly, FailFast bool
+	}
+	tests := []struct {
+		name        string
+		opts        []BuildOpt
+		expectation testOptions
+		errKind     ErrorKind
+	}{
+		{
+			name:        "defaults",
+			expectation: testOptions{FailFast: true},
+		},
+		{
+			name:        "no tests",
+			opts:        []BuildOpt{WithNoTests(true)},
+			expectation: testOptions{NoTests: true, FailFast: true},
+		},
+		{
+			name:        "tests only without fail fast",
+			opts:        []BuildOpt{WithTestsOnly(true), WithFailFast(false)},
+			expectation: testOptions{TestsOnly: true},
+		},
+		{
+			name:    "skip tests and tests only",
+			opts:    []BuildOpt{WithSkipTests(true), WithTestsOnly(true)},
+			errKind: ErrorKindConfig,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess, err := NewSession(nil, "localhost:9999/test", test.opts...)
+			if test.errKind != ErrorKindUnknown {
+				if KindOf(err) != test.errKind {
+					t.Errorf("NewSession() error kind = %v, expected %v (error: %v)", KindOf(err), test.errKind, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not create session: %v", err)
+			}
+
+			act := testOptions{
+				NoCache:   sess.opts.NoCache,
+				NoTests:   sess.opts.NoTests,
+				TestsOnly: sess.opts.TestsOnly,
+				FailFast:  sess.opts.FailFast,
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("NewSession() options mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	sess, err := NewSession(nil, "localhost:9999/test", WithLogger(logger))
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	sess.timings = []PhaseTiming{{Phase: "base", Subject: "base", Duration: 2 * time.Second}}
+	sess.PrintTimings()
+
+	var act []string
+	for _, e := range hook.AllEntries() {
+		act = append(act, fmt.Sprintf("%s phase=%v", e.Message, e.Data["phase"]))
+	}
+	if diff := cmp.Diff([]string{"timing phase=base"}, act); diff != "" {
+		t.Errorf("logged entries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// metadataRegistry serves the same manifest and config for every image
+type metadataRegistry struct {
+	fakeRegistry
+	mf  ociv1.Manifest
+	cfg ociv1.Image
+}
+
+func (t metadataRegistry) Pull(ctx context.Context, ref reference.Reference, cfg interface{}) (manifest *ociv1.Manifest, absref reference.Digested, err error) {
+	*cfg.(*ociv1.Image) = t.cfg
+	absref, _ = ref.(reference.Digested)
+	mf := t.mf
+	return &mf, absref, nil
+}
+
+func TestBuildSession_useExisting(t *testing.T) {
+	baseref, err := reference.ParseNamed("localhost:9999/test:base--abc@sha256:b25ab047a146b43a7a1bdd2b3346a05fd27dd2730af8ab06a9b8acca0f15b378")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		basemf    = &ociv1.Manifest{Layers: []ociv1.Descriptor{{Digest: "sha256:aaaa"}}}
+		basecfg   = &ociv1.Image{RootFS: ociv1.RootFS{DiffIDs: []digest.Digest{"sha256:1111"}}}
+		onBase    = ociv1.Manifest{Layers: []ociv1.Descriptor{{Digest: "sha256:aaaa"}, {Digest: "sha256:bbbb"}}}
+		onBaseCfg = ociv1.Image{RootFS: ociv1.RootFS{DiffIDs: []digest.Digest{"sha256:1111", "sha256:2222"}}}
+	)
+
+	tests := []struct {
+		name        string
+		policy      IfExistsPolicy
+		noBase      bool
+		mf          ociv1.Manifest
+		cfg         ociv1.Image
+		expectation bool
+	}{
+		{name: "skip", policy: IfExistsSkip, expectation: true},
+		{name: "rebuild", policy: IfExistsRebuild, mf: onBase, cfg: onBaseCfg, expectation: false},
+		{name: "verify on base", policy: IfExistsVerify, mf: onBase, cfg: onBaseCfg, expectation: true},
+		{
+			name:   "verify base-ref annotation",
+			policy: IfExistsVerify,
+			mf: ociv1.Manifest{
+				Annotations: map[string]string{mfAnnotationBaseRef: baseref.String()},
+				Layers:      []ociv1.Descriptor{{Digest: "sha256:aaaa"}, {Digest: "sha256:bbbb"}},
+			},
+			cfg:         onBaseCfg,
+			expectation: true,
+		},
+		{
+			name:   "verify other base-ref annotation",
+			policy: IfExistsVerify,
+			mf: ociv1.Manifest{
+				Annotations: map[string]string{mfAnnotationBaseRef: "localhost:9999/test:base--def"},
+				Layers:      []ociv1.Descriptor{{Digest: "sha256:aaaa"}, {Digest: "sha256:bbbb"}},
+			},
+			cfg:         onBaseCfg,
+			expectation: false,
+		},
+		{
+			name:        "verify other base layer",
+			policy:      IfExistsVerify,
+			mf:          ociv1.Manifest{Layers: []ociv1.Descriptor{{Digest: "sha256:cccc"}, {Digest: "sha256:bbbb"}}},
+			cfg:         onBaseCfg,
+			expectation: false,
+		},
+		{
+			name:        "verify other base diffID",
+			policy:      IfExistsVerify,
+			mf:          onBase,
+			cfg:         ociv1.Image{RootFS: ociv1.RootFS{DiffIDs: []digest.Digest{"sha256:3333", "sha256:2222"}}},
+			expectation: false,
+		},
+		{
+			name:        "verify missing diffIDs",
+			policy:      IfExistsVerify,
+			mf:          onBase,
+			cfg:         ociv1.Image{RootFS: ociv1.RootFS{DiffIDs: []digest.Digest{"sha256:1111"}}},
+			expectation: false,
+		},
+		{
+			name:        "verify base image",
+			policy:      IfExistsVerify,
+			noBase:      true,
+			mf:          *basemf,
+			cfg:         *basecfg,
+			expectation: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess, err := NewSession(nil, "localhost:9999/test", WithIfExists(test.policy))
+			if err != nil {
+				t.Fatalf("could not create session: %v", err)
+			}
+			sess.opts.Resolver = digestResolver{}
+			sess.opts.Registry = metadataRegistry{mf: test.mf, cfg: test.cfg}
+			if !test.noBase {
+				sess.baseBuildFinished(baseref.(reference.Digested), basemf, basecfg)
+			}
+
+			ref, err := reference.ParseNamed("localhost:9999/test:foo--123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, act := sess.useExisting(context.Background(), ref)
+			if act != test.expectation {
+				t.Errorf("useExisting() = %v, expected %v", act, test.expectation)
+			}
+		})
+	}
+}
+
+func TestWithIfExists(t *testing.T) {
+	_, err := NewSession(nil, "localhost:9999/test", WithIfExists("sometimes"))
+	if KindOf(err) != ErrorKindConfig {
+		t.Errorf("NewSession() error kind = %v, expected %v (error: %v)", KindOf(err), ErrorKindConfig, err)
+	}
+}
+
+func TestProjectChunk_testStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		chunk        ProjectChunk
+		opts         []BuildOpt
+		platformSkip string
+		expectation  TestStatus
+	}{
+		{name: "no tests", chunk: ProjectChunk{Name: "foo"}, expectation: TestStatusNone},
+		{name: "passed", chunk: ProjectChunk{Name: "foo", Tests: []*test.Spec{{Desc: "a"}}}, expectation: TestStatusPassed},
+		{name: "no-test", chunk: ProjectChunk{Name: "foo", Tests: []*test.Spec{{Desc: "a"}}}, opts: []BuildOpt{WithNoTests(true)}, expectation: TestStatusSkipped},
+		{
+			name:         "platform not supported",
+			chunk:        ProjectChunk{Name: "foo", Tests: []*test.Spec{{Desc: "a"}}},
+			platformSkip: "platform: linux/arm64 is not supported by the buildkit workers",
+			expectation:  TestStatusSkipped,
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			sess, err := NewSession(nil, "localhost:9999/test", tst.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess.testPlatformSkip = tst.platformSkip
+			if act := tst.chunk.testStatus(sess); act != tst.expectation {
+				t.Errorf("testStatus() = %s, want %s", act, tst.expectation)
+			}
+		})
+	}
+}
+
+func TestBuildSession_testPlatformSkipReason(t *testing.T) {
+	// without a buildkit client there are no workers to ask, and the tests are not skipped
+	sess, err := NewSession(nil, "localhost:9999/test", WithPlatform("linux/s390x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason := sess.testPlatformSkipReason(context.Background()); reason != "" {
+		t.Errorf("testPlatformSkipReason() = %q, want no reason", reason)
+	}
+}
+
+func TestBuildSession_retentionAnnotations(t *testing.T) {
+	tests := []struct {
+		ExpiresAfter time.Duration
+		Expectation  map[string]string
+	}{
+		{ExpiresAfter: 0, Expectation: nil},
+		{ExpiresAfter: 14 * 24 * time.Hour, Expectation: map[string]string{mfAnnotationExpiresAfter: "336h"}},
+		{ExpiresAfter: 90 * time.Minute, Expectation: map[string]string{mfAnnotationExpiresAfter: "1h30m"}},
+		{ExpiresAfter: 45 * time.Second, Expectation: map[string]string{mfAnnotationExpiresAfter: "45s"}},
+	}
+	for _, test := range tests {
+		t.Run(test.ExpiresAfter.String(), func(t *testing.T) {
+			sess, err := NewSession(nil, "localhost:9999/test", WithExpiresAfter(test.ExpiresAfter))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.Expectation, sess.retentionAnnotations()); diff != "" {
+				t.Errorf("retentionAnnotations() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	_, err := NewSession(nil, "localhost:9999/test", WithExpiresAfter(-time.Hour))
+	if err == nil {
+		t.Errorf("NewSession() with negative expires-after succeeded")
+	}
+}
+
+func TestRedactHistory(t *testing.T) {
+	hist := []ociv1.History{
+		{CreatedBy: "ARG TOKEN=secret", Comment: "buildkit.dockerfile.v0", EmptyLayer: true},
+		{CreatedBy: "ENV GOPATH=/go", Comment: "buildkit.dockerfile.v0", EmptyLayer: true},
+		{CreatedBy: "RUN |1 TOKEN=secret /bin/sh -c install-go # buildkit", Comment: "buildkit.dockerfile.v0"},
+		{CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+		{CreatedBy: "ARG VERSION=1.19"},
+	}
+	expect := []ociv1.History{
+		{CreatedBy: "ENV", Comment: "buildkit.dockerfile.v0", EmptyLayer: true},
+		{CreatedBy: "RUN", Comment: "buildkit.dockerfile.v0"},
+		{},
+		{CreatedBy: "ARG"},
+	}
+	act := redactHistory(hist)
+	if diff := cmp.Diff(expect, act); diff != "" {
+		t.Errorf("redactHistory() mismatch (-want +got):\n%s", diff)
+	}
+}
+
 type tagResponse struct {
 	Name string
 	Tags []string
@@ -0,0 +1,382 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Export writes a combination as an OCI image layout tar to out, so that it can be moved to a registry
+// which the build registry cannot reach. Next to the combination the bundle holds the base image and the
+// chunked images of its chunks, which share their layers with the combination. Each image is named by its
+// tag in the bundle's index.
+func (p *Project) Export(ctx context.Context, sess *BuildSession, combination string, out io.Writer) (err error) {
+	var comb *ChunkCombination
+	for i, c := range p.Config.Combiner.Combinations {
+		if c.Name == combination {
+			comb = &p.Config.Combiner.Combinations[i]
+			break
+		}
+	}
+	if comb == nil {
+		return withKind(ErrorKindConfig, fmt.Errorf("combination %s not found", combination))
+	}
+
+	err = sess.DownloadBaseInfo(ctx, p)
+	if err != nil {
+		return withKind(ErrorKindRegistry, err)
+	}
+
+	baseref, err := p.BaseRef(sess.Dest)
+	if err != nil {
+		return err
+	}
+	refs := []reference.NamedTagged{baseref}
+	for _, cn := range comb.Chunks {
+		var chk *ProjectChunk
+		for i, c := range p.Chunks {
+			if c.Name == cn {
+				chk = &p.Chunks[i]
+				break
+			}
+		}
+		if chk == nil {
+			return withKind(ErrorKindConfig, fmt.Errorf("chunk %s not found", cn))
+		}
+		if !chk.SupportsPlatform(sess.opts.Platform) {
+			// not part of the combination on this platform
+			continue
+		}
+		ref, err := chk.ImageName(ImageTypeChunked, sess)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+	}
+	cref, err := reference.WithTag(reference.TrimNamed(sess.Dest), comb.Name)
+	if err != nil {
+		return err
+	}
+	refs = append(refs, cref)
+
+	return ExportBundle(ctx, sess.opts.Logger, sess.opts.Resolver, out, refs)
+}
+
+// ExportBundle writes the images of refs as an OCI image layout tar to out. Manifests are copied verbatim
+// so that the images keep their digests, and blobs shared between the images are written once.
+func ExportBundle(ctx context.Context, logger log.FieldLogger, resolver remotes.Resolver, out io.Writer, refs []reference.NamedTagged) (err error) {
+	defer func() {
+		if err != nil {
+			err = withKind(ErrorKindRegistry, fmt.Errorf("cannot export bundle: %w", err))
+		}
+	}()
+
+	var (
+		tw      = tar.NewWriter(out)
+		written = make(map[digest.Digest]struct{})
+		index   = ociv1.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ociv1.MediaTypeImageIndex,
+		}
+	)
+	for _, ref := range refs {
+		logger.WithField("ref", ref.String()).Info("exporting image")
+		mfdesc, rawmf, mf, err := fetchManifest(ctx, resolver, ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+		fetcher, err := resolver.Fetcher(ctx, ref.String())
+		if err != nil {
+			return err
+		}
+
+		for _, desc := range append([]ociv1.Descriptor{mf.Config}, mf.Layers...) {
+			if _, exists := written[desc.Digest]; exists {
+				continue
+			}
+			logger.WithField("blob", desc.Digest).Debug("exporting blob")
+			rc, err := fetcher.Fetch(ctx, desc)
+			if err != nil {
+				return err
+			}
+			err = writeBundleBlob(tw, desc, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			written[desc.Digest] = struct{}{}
+		}
+		if _, exists := written[mfdesc.Digest]; !exists {
+			err = writeBundleBlob(tw, mfdesc, bytes.NewReader(rawmf))
+			if err != nil {
+				return err
+			}
+			written[mfdesc.Digest] = struct{}{}
+		}
+
+		index.Manifests = append(index.Manifests, ociv1.Descriptor{
+			MediaType:   mfdesc.MediaType,
+			Digest:      mfdesc.Digest,
+			Size:        mfdesc.Size,
+			Annotations: map[string]string{ociv1.AnnotationRefName: ref.Tag()},
+		})
+	}
+
+	layout, err := json.Marshal(ociv1.ImageLayout{Version: ociv1.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	err = writeBundleFile(tw, ociv1.ImageLayoutFile, layout)
+	if err != nil {
+		return err
+	}
+	idx, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	err = writeBundleFile(tw, "index.json", idx)
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeBundleBlob adds a blob to an OCI image layout tar and checks its digest on the way
+func writeBundleBlob(tw *tar.Writer, desc ociv1.Descriptor, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join("blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded()),
+		Size:     desc.Size,
+		Mode:     0644,
+	})
+	if err != nil {
+		return err
+	}
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(tw, verifier), r)
+	if err != nil {
+		return err
+	}
+	if n != desc.Size || !verifier.Verified() {
+		return fmt.Errorf("blob %s does not match its descriptor", desc.Digest)
+	}
+	return nil
+}
+
+func writeBundleFile(tw *tar.Writer, name string, content []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(content)),
+		Mode:     0644,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
+
+// ImportBundle pushes the images of an OCI image layout tar written by ExportBundle to the repository of dest,
+// each under the name it has in the bundle's index. It returns the refs of the pushed images.
+func ImportBundle(ctx context.Context, logger log.FieldLogger, resolver remotes.Resolver, in io.Reader, dest reference.Named) (res []reference.Digested, err error) {
+	defer func() {
+		if err != nil {
+			err = withKind(ErrorKindRegistry, fmt.Errorf("cannot import bundle: %w", err))
+		}
+	}()
+
+	dir, err := os.MkdirTemp("", "dazzle-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	blobs := bundleBlobs(dir)
+	index, err := readBundle(in, blobs)
+	if err != nil {
+		return nil, err
+	}
+
+	dest = reference.TrimNamed(dest)
+	for _, mfdesc := range index.Manifests {
+		name := mfdesc.Annotations[ociv1.AnnotationRefName]
+		if name == "" {
+			return res, fmt.Errorf("manifest %s has no %s annotation", mfdesc.Digest, ociv1.AnnotationRefName)
+		}
+		ref, err := reference.WithTag(dest, name)
+		if err != nil {
+			return res, err
+		}
+
+		rawmf, err := os.ReadFile(blobs.path(mfdesc.Digest))
+		if err != nil {
+			return res, err
+		}
+		var mf ociv1.Manifest
+		err = json.Unmarshal(rawmf, &mf)
+		if err != nil {
+			return res, fmt.Errorf("cannot unmarshal manifest %s: %w", mfdesc.Digest, err)
+		}
+
+		pusher, err := resolver.Pusher(ctx, ref.String())
+		if err != nil {
+			return res, err
+		}
+		for _, desc := range append([]ociv1.Descriptor{mf.Config}, mf.Layers...) {
+			logger.WithField("blob", desc.Digest).WithField("dest", ref.String()).Debug("pushing blob")
+			err = copyLayer(ctx, blobs, pusher, desc)
+			if err != nil {
+				return res, err
+			}
+		}
+
+		logger.WithField("dest", ref.String()).WithField("digest", mfdesc.Digest).Info("pushing manifest")
+		mfdesc.Annotations = nil
+		err = pushManifest(ctx, pusher, mfdesc, rawmf)
+		if err != nil {
+			return res, err
+		}
+		absref, err := reference.WithDigest(ref, mfdesc.Digest)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, absref)
+	}
+	return res, nil
+}
+
+// bundleBlobs is a directory holding the blobs of an extracted bundle, which doubles as fetcher for copyLayer
+type bundleBlobs string
+
+func (b bundleBlobs) path(dgst digest.Digest) string {
+	return filepath.Join(string(b), dgst.Algorithm().String()+"-"+dgst.Encoded())
+}
+
+func (b bundleBlobs) Fetch(ctx context.Context, desc ociv1.Descriptor) (io.ReadCloser, error) {
+	return os.Open(b.path(desc.Digest))
+}
+
+// readBundle extracts the blobs of an OCI image layout tar into blobs, verifying their digests, and returns
+// the index of the bundle. Files other than the layout, index and blobs are ignored.
+func readBundle(in io.Reader, blobs bundleBlobs) (*ociv1.Index, error) {
+	var (
+		tr     = tar.NewReader(in)
+		layout *ociv1.ImageLayout
+		index  *ociv1.Index
+		found  = make(map[digest.Digest]struct{})
+	)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		switch {
+		case name == ociv1.ImageLayoutFile:
+			layout = new(ociv1.ImageLayout)
+			err = json.NewDecoder(tr).Decode(layout)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read %s: %w", name, err)
+			}
+		case name == "index.json":
+			index = new(ociv1.Index)
+			err = json.NewDecoder(tr).Decode(index)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read %s: %w", name, err)
+			}
+		case strings.HasPrefix(name, "blobs/"):
+			segs := strings.Split(name, "/")
+			if len(segs) != 3 {
+				continue
+			}
+			dgst, err := digest.Parse(segs[1] + ":" + segs[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid blob %s: %w", name, err)
+			}
+			err = extractBundleBlob(tr, blobs.path(dgst), dgst)
+			if err != nil {
+				return nil, err
+			}
+			found[dgst] = struct{}{}
+		}
+	}
+
+	if layout == nil || index == nil {
+		return nil, fmt.Errorf("not an OCI image layout: %s or index.json missing", ociv1.ImageLayoutFile)
+	}
+	if layout.Version != ociv1.ImageLayoutVersion {
+		return nil, fmt.Errorf("unsupported OCI image layout version %s", layout.Version)
+	}
+	for _, mfdesc := range index.Manifests {
+		if _, exists := found[mfdesc.Digest]; !exists {
+			return nil, fmt.Errorf("manifest %s is missing from the bundle", mfdesc.Digest)
+		}
+	}
+	return index, nil
+}
+
+func extractBundleBlob(r io.Reader, fn string, dgst digest.Digest) (err error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cerr := f.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	verifier := dgst.Verifier()
+	_, err = io.Copy(io.MultiWriter(f, verifier), r)
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob %s does not match its digest", dgst)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReadBundle(t *testing.T) {
+	var (
+		layer   = []byte("layer content")
+		layerd  = ociv1.Descriptor{Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+		mf      = []byte(`{"schemaVersion":2}`)
+		mfd     = ociv1.Descriptor{MediaType: ociv1.MediaTypeImageManifest, Digest: digest.FromBytes(mf), Size: int64(len(mf)), Annotations: map[string]string{ociv1.AnnotationRefName: "full"}}
+		layout  = []byte(`{"imageLayoutVersion":"1.0.0"}`)
+		index   = ociv1.Index{Manifests: []ociv1.Descriptor{mfd}}
+		idx, _  = json.Marshal(index)
+		rawblob = func(name string, content []byte) func(*tar.Writer) error {
+			return func(tw *tar.Writer) error {
+				err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(content)), Mode: 0644})
+				if err != nil {
+					return err
+				}
+				_, err = tw.Write(content)
+				return err
+			}
+		}
+	)
+
+	tests := []struct {
+		Name        string
+		Files       []func(*tar.Writer) error
+		Expectation *ociv1.Index
+		Blobs       []digest.Digest
+		Error       bool
+	}{
+		{
+			Name: "valid bundle",
+			Files: []func(*tar.Writer) error{
+				func(tw *tar.Writer) error { return writeBundleBlob(tw, layerd, bytes.NewReader(layer)) },
+				func(tw *tar.Writer) error { return writeBundleBlob(tw, mfd, bytes.NewReader(mf)) },
+				func(tw *tar.Writer) error { return writeBundleFile(tw, ociv1.ImageLayoutFile, layout) },
+				func(tw *tar.Writer) error { return writeBundleFile(tw, "index.json", idx) },
+			},
+			Expectation: &index,
+			Blobs:       []digest.Digest{layerd.Digest, mfd.Digest},
+		},
+		{
+			Name: "leading dot",
+			Files: []func(*tar.Writer) error{
+				rawblob("./blobs/sha256/"+mfd.Digest.Encoded(), mf),
+				rawblob("./"+ociv1.ImageLayoutFile, layout),
+				rawblob("./index.json", idx),
+			},
+			Expectation: &index,
+			Blobs:       []digest.Digest{mfd.Digest},
+		},
+		{
+			Name: "corrupt blob",
+			Files: []func(*tar.Writer) error{
+				rawblob("blobs/sha256/"+layerd.Digest.Encoded(), []byte("something else")),
+				func(tw *tar.Writer) error { return writeBundleBlob(tw, mfd, bytes.NewReader(mf)) },
+				func(tw *tar.Writer) error { return writeBundleFile(tw, ociv1.ImageLayoutFile, layout) },
+				func(tw *tar.Writer) error { return writeBundleFile(tw, "index.json", idx) },
+			},
+			Error: true,
+		},
+		{
+			Name: "invalid blob name",
+			Files: []func(*tar.Writer) error{
+				rawblob("blobs/sha256/..", mf),
+			},
+			Error: true,
+		},
+		{
+			Name: "missing manifest",
+			Files: []func(*tar.Writer) error{
+				func(tw *tar.Writer) error { return writeBundleFile(tw, ociv1.ImageLayoutFile, layout) },
+				func(tw *tar.Writer) error { return writeBundleFile(tw, "index.json", idx) },
+			},
+			Error: true,
+		},
+		{
+			Name: "missing layout",
+			Files: []func(*tar.Writer) error{
+				func(tw *tar.Writer) error { return writeBundleBlob(tw, mfd, bytes.NewReader(mf)) },
+				func(tw *tar.Writer) error { return writeBundleFile(tw, "index.json", idx) },
+			},
+			Error: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, f := range test.Files {
+				err := f(tw)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := tw.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blobs := bundleBlobs(t.TempDir())
+			act, err := readBundle(&buf, blobs)
+			if test.Error {
+				if err == nil {
+					t.Error("readBundle() succeeded but should have failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("readBundle() mismatch (-want +got):\n%s", diff)
+			}
+			for _, dgst := range test.Blobs {
+				if _, err := os.Stat(blobs.path(dgst)); err != nil {
+					t.Errorf("blob %s was not extracted: %v", dgst, err)
+				}
+			}
+		})
+	}
+}
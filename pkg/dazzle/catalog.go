@@ -0,0 +1,163 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/docker/distribution/reference"
+)
+
+// catalogTag is the tag of the catalog under the build ref
+const catalogTag = "dazzle-catalog"
+
+// Catalog lists the images dazzle pushed under a build ref, so that tools can discover
+// chunk sets without computing their hashes from the project
+type Catalog struct {
+	// Base is the digested ref of the base image the chunks were built on
+	Base         string               `yaml:"base" json:"base"`
+	Chunks       []CatalogChunk       `yaml:"chunks" json:"chunks"`
+	Combinations []CatalogCombination `yaml:"combinations,omitempty" json:"combinations,omitempty"`
+}
+
+// CatalogChunk is a chunk image listed in a catalog
+type CatalogChunk struct {
+	Name string `yaml:"name" json:"name"`
+	Ref  string `yaml:"ref" json:"ref"`
+	// Hash is the chunk hash the ref is tagged with, empty for chunks built without hash
+	Hash string `yaml:"hash,omitempty" json:"hash,omitempty"`
+}
+
+// CatalogCombination is a combination listed in a catalog
+type CatalogCombination struct {
+	Name   string   `yaml:"name" json:"name"`
+	Ref    string   `yaml:"ref" json:"ref"`
+	Chunks []string `yaml:"chunks" json:"chunks"`
+}
+
+// CatalogRef returns the ref of the catalog of a build ref
+func CatalogRef(build reference.Named) (reference.NamedTagged, error) {
+	return reference.WithTag(reference.TrimNamed(build), catalogTag)
+}
+
+// PullCatalog downloads the catalog of a build ref
+func PullCatalog(ctx context.Context, registry Registry, build reference.Named) (*Catalog, error) {
+	ref, err := CatalogRef(build)
+	if err != nil {
+		return nil, err
+	}
+	var res Catalog
+	_, _, err = registry.Pull(ctx, ref, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// catalog lists the images pushed during this session
+func (s *BuildSession) catalog() *Catalog {
+	res := &Catalog{
+		Chunks:       make([]CatalogChunk, 0, len(s.chunks)),
+		Combinations: s.combinations,
+	}
+	if s.baseRef != nil {
+		res.Base = s.baseRef.String()
+	}
+	for ref, mf := range s.chunks {
+		chk := CatalogChunk{
+			Name: mf.Annotations[mfAnnotationChunk],
+			Ref:  ref,
+		}
+		if r, err := reference.ParseNamed(ref); err == nil {
+			if t, ok := r.(reference.Tagged); ok {
+				if idx := strings.LastIndex(t.Tag(), "--"); idx >= 0 {
+					chk.Hash = t.Tag()[idx+2:]
+				}
+			}
+		}
+		res.Chunks = append(res.Chunks, chk)
+	}
+	sort.Slice(res.Chunks, func(i, j int) bool { return res.Chunks[i].Name < res.Chunks[j].Name })
+	return res
+}
+
+// mergeCatalog adds the images of cur to prev. Images listed in prev are dropped if they
+// were built on a different base image, and replaced if cur lists an image of the same name.
+func mergeCatalog(prev, cur *Catalog) *Catalog {
+	if prev == nil || prev.Base != cur.Base {
+		return cur
+	}
+
+	res := &Catalog{Base: cur.Base, Chunks: make([]CatalogChunk, 0, len(prev.Chunks)+len(cur.Chunks))}
+	chunks := make(map[string]CatalogChunk)
+	for _, c := range append(prev.Chunks, cur.Chunks...) {
+		chunks[c.Name] = c
+	}
+	for _, c := range chunks {
+		res.Chunks = append(res.Chunks, c)
+	}
+	sort.Slice(res.Chunks, func(i, j int) bool { return res.Chunks[i].Name < res.Chunks[j].Name })
+
+	combinations := make(map[string]CatalogCombination)
+	for _, c := range append(prev.Combinations, cur.Combinations...) {
+		combinations[c.Name] = c
+	}
+	for _, c := range combinations {
+		res.Combinations = append(res.Combinations, c)
+	}
+	sort.Slice(res.Combinations, func(i, j int) bool { return res.Combinations[i].Name < res.Combinations[j].Name })
+	return res
+}
+
+// pushCatalog adds the images pushed during this session to the catalog of the build ref
+func (s *BuildSession) pushCatalog(ctx context.Context) error {
+	if s.baseRef == nil {
+		return nil
+	}
+	ref, err := CatalogRef(s.Dest)
+	if err != nil {
+		return err
+	}
+
+	prev, err := PullCatalog(ctx, s.opts.Registry, s.Dest)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("cannot pull catalog: %w", err)
+	}
+	content, err := json.Marshal(mergeCatalog(prev, s.catalog()))
+	if err != nil {
+		return err
+	}
+
+	s.opts.Logger.WithField("ref", ref.String()).Debug("pushing catalog")
+	_, err = s.opts.Registry.Push(ctx, ref, storeInRegistryOptions{
+		Config:          content,
+		ConfigMediaType: mediaTypeCatalog,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot push catalog: %w", err)
+	}
+	return nil
+}
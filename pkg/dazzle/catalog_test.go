@@ -0,0 +1,139 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/google/go-cmp/cmp"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestBuildSession_catalog(t *testing.T) {
+	sess, err := NewSession(nil, "localhost:9999/test")
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	baseRef, err := reference.ParseNamed("localhost:9999/test:base--abc@sha256:b25ab047a146b43a7a1bdd2b3346a05fd27dd2730af8ab06a9b8acca0f15b378")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.baseRef = baseRef.(reference.Digested)
+	sess.recordChunk("localhost:9999/test:node--def", &ociv1.Manifest{Annotations: map[string]string{mfAnnotationChunk: "node"}})
+	sess.recordChunk("localhost:9999/test:golang-1.16--123", &ociv1.Manifest{Annotations: map[string]string{mfAnnotationChunk: "golang:1.16"}})
+	sess.recordChunk("localhost:9999/test/python:latest", &ociv1.Manifest{Annotations: map[string]string{mfAnnotationChunk: "python"}})
+	sess.recordCombination("full", "localhost:9999/test:full", []string{"golang:1.16", "node"})
+
+	expectation := &Catalog{
+		Base: baseRef.String(),
+		Chunks: []CatalogChunk{
+			{Name: "golang:1.16", Ref: "localhost:9999/test:golang-1.16--123", Hash: "123"},
+			{Name: "node", Ref: "localhost:9999/test:node--def", Hash: "def"},
+			{Name: "python", Ref: "localhost:9999/test/python:latest"},
+		},
+		Combinations: []CatalogCombination{
+			{Name: "full", Ref: "localhost:9999/test:full", Chunks: []string{"golang:1.16", "node"}},
+		},
+	}
+	if diff := cmp.Diff(expectation, sess.catalog()); diff != "" {
+		t.Errorf("catalog() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMergeCatalog(t *testing.T) {
+	prev := &Catalog{
+		Base: "localhost:9999/test:base--abc@sha256:1",
+		Chunks: []CatalogChunk{
+			{Name: "golang", Ref: "localhost:9999/test:golang--1", Hash: "1"},
+			{Name: "node", Ref: "localhost:9999/test:node--2", Hash: "2"},
+		},
+		Combinations: []CatalogCombination{
+			{Name: "full", Ref: "localhost:9999/test:full", Chunks: []string{"golang", "node"}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		prev        *Catalog
+		cur         *Catalog
+		expectation *Catalog
+	}{
+		{
+			name:        "no previous catalog",
+			cur:         prev,
+			expectation: prev,
+		},
+		{
+			name: "rebuilt chunk",
+			prev: prev,
+			cur: &Catalog{
+				Base:   prev.Base,
+				Chunks: []CatalogChunk{{Name: "node", Ref: "localhost:9999/test:node--3", Hash: "3"}},
+			},
+			expectation: &Catalog{
+				Base: prev.Base,
+				Chunks: []CatalogChunk{
+					{Name: "golang", Ref: "localhost:9999/test:golang--1", Hash: "1"},
+					{Name: "node", Ref: "localhost:9999/test:node--3", Hash: "3"},
+				},
+				Combinations: prev.Combinations,
+			},
+		},
+		{
+			name: "new combination",
+			prev: prev,
+			cur: &Catalog{
+				Base:         prev.Base,
+				Chunks:       []CatalogChunk{},
+				Combinations: []CatalogCombination{{Name: "go", Ref: "localhost:9999/test:go", Chunks: []string{"golang"}}},
+			},
+			expectation: &Catalog{
+				Base:   prev.Base,
+				Chunks: prev.Chunks,
+				Combinations: []CatalogCombination{
+					{Name: "full", Ref: "localhost:9999/test:full", Chunks: []string{"golang", "node"}},
+					{Name: "go", Ref: "localhost:9999/test:go", Chunks: []string{"golang"}},
+				},
+			},
+		},
+		{
+			name: "new base",
+			prev: prev,
+			cur: &Catalog{
+				Base:   "localhost:9999/test:base--def@sha256:2",
+				Chunks: []CatalogChunk{{Name: "node", Ref: "localhost:9999/test:node--4", Hash: "4"}},
+			},
+			expectation: &Catalog{
+				Base:   "localhost:9999/test:base--def@sha256:2",
+				Chunks: []CatalogChunk{{Name: "node", Ref: "localhost:9999/test:node--4", Hash: "4"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act := mergeCatalog(test.prev, test.cur)
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("mergeCatalog() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
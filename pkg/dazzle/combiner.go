@@ -22,25 +22,38 @@ package dazzle
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
 	"github.com/docker/distribution/reference"
+	"github.com/minio/highwayhash"
 	"github.com/moby/buildkit/client"
 	"github.com/opencontainers/go-digest"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/gitpod-io/dazzle/pkg/test"
 	"github.com/gitpod-io/dazzle/pkg/test/buildkit"
 )
 
+// maxConcurrentMetadataPulls limits the number of chunk manifests and configs Combine pulls at the same time
+const maxConcurrentMetadataPulls = 8
+
 type combinerOpts struct {
 	BuildkitClient *client.Client
 	RunTests       bool
+	TestMatrix     bool
 	TempBuild      bool
+
+	// Name is the name of the combination used when reporting test results
+	Name string
 }
 
 // CombinerOpt configrues the combiner
@@ -55,9 +68,23 @@ func WithTests(cl *client.Client) CombinerOpt {
 	}
 }
 
-func asTempBuild(o *combinerOpts) error {
-	o.TempBuild = true
-	return nil
+// WithTestMatrix runs the tests of every member chunk against the combination, reports all
+// failing chunks rather than stopping at the first one, and caches the outcome per combination and chunk.
+func WithTestMatrix(cl *client.Client) CombinerOpt {
+	return func(o *combinerOpts) error {
+		o.BuildkitClient = cl
+		o.RunTests = true
+		o.TestMatrix = true
+		return nil
+	}
+}
+
+func asTempBuildOf(name string) CombinerOpt {
+	return func(o *combinerOpts) error {
+		o.TempBuild = true
+		o.Name = name
+		return nil
+	}
 }
 
 // Combine combines a set of previously built chunks into a single image while maintaining
@@ -71,6 +98,19 @@ func (p *Project) Combine(ctx context.Context, chunks []string, dest reference.N
 		}
 	}
 
+	if options.Name == "" {
+		options.Name = combinationName(dest)
+	}
+	// the tests run against a temporary combination first, so the final image is pushed only once they passed
+	testStatus := TestStatusSkipped
+	if options.RunTests && !options.TempBuild {
+		testStatus = TestStatusPassed
+		if len(sess.opts.TestFilters) > 0 || sess.opts.RerunFailed {
+			testStatus = TestStatusPartial
+		}
+	}
+	testEnv := sess.projectTestEnv(p)
+
 	if options.RunTests && !options.TempBuild {
 		// We have to push the combination result. To avoid overwriting the target but have the tests fail
 		// we combine and test with a temp name first, then do the real thing.
@@ -78,12 +118,15 @@ func (p *Project) Combine(ctx context.Context, chunks []string, dest reference.N
 		if err != nil {
 			return err
 		}
-		err = p.Combine(ctx, chunks, tmpdest, sess, append(opts, asTempBuild)...)
+		err = p.Combine(ctx, chunks, tmpdest, sess, append(opts, asTempBuildOf(options.Name))...)
 		if err != nil {
 			return err
 		}
 
 		options.RunTests = false
+		if sess.testPlatformSkip != "" {
+			testStatus = TestStatusSkipped
+		}
 	}
 
 	cs := make([]ProjectChunk, len(chunks))
@@ -98,36 +141,89 @@ func (p *Project) Combine(ctx context.Context, chunks []string, dest reference.N
 		}
 
 		if !found {
-			return fmt.Errorf("chunk %s not found", cn)
+			return withKind(ErrorKindConfig, fmt.Errorf("chunk %s not found", cn))
+		}
+	}
+	supported := cs[:0]
+	for _, c := range cs {
+		if c.SupportsPlatform(sess.opts.Platform) {
+			supported = append(supported, c)
+			continue
+		}
+		if !options.TempBuild {
+			sess.opts.Logger.WithField("chunk", c.Name).WithField("platform", platforms.Format(sess.opts.Platform)).Warn("excluding chunk which does not support the platform from the combination")
 		}
 	}
+	cs = supported
 
 	var (
 		mfs  = make([]*ociv1.Manifest, 0, len(chunks)+1)
 		cfgs = make([]*ociv1.Image, 0, len(chunks)+1)
 	)
 
-	log.WithField("ref", sess.baseRef.String()).Info("integrating base metadata")
 	basemf, basecfg := sess.baseMF, sess.baseCfg
-	if basemf == nil || basecfg == nil {
-		return fmt.Errorf("base image not resolved")
+	if sess.baseRef == nil || basemf == nil || basecfg == nil {
+		return ErrBaseNotResolved
 	}
+	sess.opts.Logger.WithField("ref", sess.baseRef.String()).Info("integrating base metadata")
 
 	mfs = append(mfs, basemf)
 	cfgs = append(cfgs, basecfg)
 
-	for _, c := range cs {
-		cref, err := c.ImageName(ImageTypeChunked, sess)
-		if err != nil {
-			return err
-		}
-		log.WithField("ref", cref.String()).Info("pulling chunk metadata")
-		_, mf, cfg, err := getImageMetadata(ctx, cref, sess.opts.Registry)
+	// the image names are resolved before pulling: computing them caches the hashes of the chunks and of the
+	// chunks they depend on, which the goroutines would otherwise write concurrently
+	crefs := make([]reference.NamedTagged, len(cs))
+	for i, c := range cs {
+		crefs[i], err = c.ImageName(ImageTypeChunked, sess)
 		if err != nil {
 			return err
 		}
-		mfs = append(mfs, mf)
-		cfgs = append(cfgs, cfg)
+	}
+
+	// the metadata is pulled concurrently, but kept in the order of the chunks which determines the layer order
+	var (
+		chunkMFs  = make([]*ociv1.Manifest, len(cs))
+		chunkCfgs = make([]*ociv1.Image, len(cs))
+	)
+	eg, egctx := errgroup.WithContext(ctx)
+	eg.SetLimit(maxConcurrentMetadataPulls)
+	for i := range cs {
+		i, cref := i, crefs[i]
+		eg.Go(func() error {
+			sess.opts.Logger.WithField("ref", cref.String()).Info("pulling chunk metadata")
+			_, mf, cfg, err := sess.imageMetadata(egctx, cref)
+			if err != nil {
+				return err
+			}
+			chunkMFs[i], chunkCfgs[i] = mf, cfg
+			return nil
+		})
+	}
+	err = eg.Wait()
+	if err != nil {
+		return err
+	}
+	mfs = append(mfs, chunkMFs...)
+	cfgs = append(cfgs, chunkCfgs...)
+
+	layers := make(map[string]int, len(cs))
+	names := make([]string, len(cs))
+	for i, c := range cs {
+		layers[c.Name] = len(chunkMFs[i].Layers)
+		names[i] = c.Name
+	}
+	err = checkCombinationLayers(options.Name, len(basemf.Layers), names, layers)
+	if err != nil {
+		return err
+	}
+
+	members, err := p.combinationMembers(cs, mfs, sess)
+	if err != nil {
+		return err
+	}
+	labels, err := combinationLabels(members)
+	if err != nil {
+		return err
 	}
 
 	var (
@@ -136,12 +232,25 @@ func (p *Project) Combine(ctx context.Context, chunks []string, dest reference.N
 		allHist  []ociv1.History
 	)
 	for i, m := range mfs {
-		allLayer = append(allLayer, m.Layers...)
+		for _, l := range m.Layers {
+			allLayer = append(allLayer, withLayerOwner(l, members[i]))
+		}
 		allDiffs = append(allDiffs, cfgs[i].RootFS.DiffIDs...)
-		allHist = append(allHist, cfgs[i].History...)
+		hist := cfgs[i].History
+		if sess.opts.RedactHistory {
+			hist = redactHistory(hist)
+		}
+		if i > 0 {
+			hist = chunkHistory(cs[i-1].Name, hist)
+		}
+		allHist = append(allHist, hist...)
 	}
 
-	env, err := mergeEnv(basecfg, cfgs, p.Config.Combiner.EnvVars)
+	envVars, err := combinationEnvVars(p.Config.Combiner.EnvVars, cs)
+	if err != nil {
+		return withKind(ErrorKindConfig, err)
+	}
+	env, err := mergeEnv(sess.opts.Logger, basecfg, cfgs, envVars)
 	if err != nil {
 		return
 	}
@@ -158,8 +267,8 @@ func (p *Project) Combine(ctx context.Context, chunks []string, dest reference.N
 			Entrypoint:   basecfg.Config.Entrypoint,
 			ExposedPorts: mergeExposedPorts(basecfg, cfgs),
 			Env:          env,
-			// Labels:       mergeLabels(basecfg, cfgs),
-			User: basecfg.Config.User,
+			Labels:       labels,
+			User:         basecfg.Config.User,
 			// Volumes:      mergeVolumes(basecfg, cfgs),
 			WorkingDir: basecfg.Config.WorkingDir,
 		},
@@ -177,14 +286,20 @@ func (p *Project) Combine(ctx context.Context, chunks []string, dest reference.N
 		Digest:    digest.FromBytes(serializedCcfg),
 		Size:      int64(len(serializedCcfg)),
 	}
-	log.WithField("content", string(serializedCcfg)).Debug("produced config")
+	sess.opts.Logger.WithField("content", string(serializedCcfg)).Debug("produced config")
 
 	cmf := ociv1.Manifest{
 		Versioned:   basemf.Versioned,
-		Annotations: mergeAnnotations(basemf, mfs),
+		Annotations: combinationAnnotations(basemf, mfs, testStatus),
 		Config:      ccfgdesc,
 		Layers:      allLayer,
 	}
+	if options.TempBuild {
+		// the temporary combination only exists to run the tests against
+		for k, v := range sess.retentionAnnotations() {
+			cmf.Annotations[k] = v
+		}
+	}
 	serializedMf, err := json.Marshal(cmf)
 	if err != nil {
 		return
@@ -195,54 +310,271 @@ func (p *Project) Combine(ctx context.Context, chunks []string, dest reference.N
 		Size:      int64(len(serializedMf)),
 		Platform:  basemf.Config.Platform,
 	}
-	log.WithField("content", string(serializedMf)).Debug("produced manifest")
+	sess.opts.Logger.WithField("content", string(serializedMf)).Debug("produced manifest")
 
-	log.WithField("dest", dest.String()).Info("pushing combined image")
-	pusher, err := sess.opts.Resolver.Pusher(ctx, dest.String())
+	sess.opts.Logger.WithField("dest", dest.String()).Info("pushing combined image")
+	err = pushCombination(ctx, sess.opts.Resolver, dest, ccfgdesc, serializedCcfg, cmfdesc, serializedMf)
 	if err != nil {
+		return registryError(dest.String(), err)
+	}
+	if !options.TempBuild {
+		sess.recordCombination(options.Name, dest.String(), names)
+		err = sess.pushCatalog(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !options.RunTests {
 		return
 	}
-	ccfgw, err := pusher.Push(ctx, ccfgdesc)
+
+	chash, err := combinationHash(&cmf, &ccfg)
 	if err != nil {
-		return
+		return err
+	}
+	ct := combinationTest{
+		Name:   options.Name,
+		Ref:    dest,
+		Config: &ccfg,
+		Hash:   chash,
+		Client: options.BuildkitClient,
+		Env:    testEnv,
+	}
+	if options.TestMatrix {
+		return ct.matrix(ctx, cs, sess)
+	}
+
+	for _, chk := range cs {
+		cell, err := ct.run(ctx, chk, sess)
+		if err != nil {
+			return err
+		}
+		if !cell.Passed {
+			return withKind(ErrorKindTest, ErrTestsFailed)
+		}
+	}
+
+	return
+}
+
+// pushCombination pushes the config and manifest of a combination. Its layers are present in the registry already.
+func pushCombination(ctx context.Context, resolver remotes.Resolver, dest reference.Named, cfgdesc ociv1.Descriptor, cfg []byte, mfdesc ociv1.Descriptor, mf []byte) error {
+	pusher, err := resolver.Pusher(ctx, dest.String())
+	if err != nil {
+		return err
+	}
+	cfgw, err := pusher.Push(ctx, cfgdesc)
+	if err != nil {
+		return err
+	}
+	_, err = cfgw.Write(cfg)
+	if err != nil {
+		return err
+	}
+	err = cfgw.Commit(ctx, cfgdesc.Size, cfgdesc.Digest)
+	if err != nil {
+		return err
+	}
+	mfw, err := pusher.Push(ctx, mfdesc)
+	if err != nil {
+		return err
 	}
-	_, err = ccfgw.Write(serializedCcfg)
+	_, err = mfw.Write(mf)
+	if err != nil {
+		return err
+	}
+	return mfw.Commit(ctx, mfdesc.Size, mfdesc.Digest)
+}
+
+// combinationTest runs chunk tests against a combined image
+type combinationTest struct {
+	Name   string
+	Ref    reference.Named
+	Config *ociv1.Image
+	Hash   string
+	Client *client.Client
+	Env    []string
+}
+
+// run runs the tests of a chunk against the combination unless they have passed against the same
+// combination content before
+func (ct combinationTest) run(ctx context.Context, chk ProjectChunk, sess *BuildSession) (res MatrixCell, err error) {
+	suite := fmt.Sprintf("%s in %s", chk.Name, ct.Name)
+	tests, err := test.InterpolateSpecs(sess.selectTests(suite, chk.Tests), ct.Env)
 	if err != nil {
 		return
 	}
-	err = ccfgw.Commit(ctx, cmf.Config.Size, cmf.Config.Digest)
+	res = MatrixCell{
+		Combination: ct.Name,
+		Chunk:       chk.Name,
+		Tests:       len(tests),
+	}
+	if len(tests) == 0 {
+		res.Passed = true
+		return
+	}
+
+	resultRef, err := chk.combinationTestResultRef(ct.Hash, test.ResolvedEnv(chk.Tests, ct.Env), sess)
 	if err != nil {
 		return
 	}
-	mfw, err := pusher.Push(ctx, cmfdesc)
+	// when only a subset of the tests runs we neither use nor store the cached result
+	filtered := len(tests) != len(chk.Tests)
+	if !filtered {
+		r, err := pullTestResult(ctx, sess.opts.Registry, resultRef)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return res, err
+		}
+		if r != nil && r.Passed {
+			sess.opts.Logger.WithField("combination", ct.Name).WithField("chunk", chk.Name).Info("tests have passed against this combination before")
+			res.Passed, res.Cached = true, true
+			return res, nil
+		}
+	}
+
+	if reason := sess.testPlatformSkipReason(ctx); reason != "" {
+		sess.opts.Logger.WithField("combination", ct.Name).WithField("chunk", chk.Name).WithField("tests", len(tests)).Warn("skipping tests which cannot run on this platform")
+		sess.recordTestResults(suite, test.SkipTests(tests, reason))
+		res.Passed, res.Skipped = true, true
+		return res, nil
+	}
+
+	sess.opts.Logger.WithField("combination", ct.Name).WithField("chunk", chk.Name).WithField("tests", len(tests)).Warn("running tests")
+	executor := buildkit.NewExecutor(ct.Client, ct.Ref.String(), ct.Config)
+	results, ok := test.RunTests(ctx, executor, tests, test.WithTimeout(sess.opts.TestTimeout), test.WithLogger(sess.opts.Logger))
+	sess.recordTestResults(suite, results)
+	res.Passed = ok
+	if !ok || filtered {
+		return res, nil
+	}
+
+	_, err = pushTestResult(ctx, sess.opts.Registry, resultRef, StoredTestResult{true}, sess.retentionAnnotations())
+	if err != nil && !errdefs.IsAlreadyExists(err) {
+		return res, err
+	}
+	return res, nil
+}
+
+// combinationHash computes a hash of the content of a combined image. Unlike the manifest digest
+// it does not change when the same chunks are combined again.
+func combinationHash(mf *ociv1.Manifest, cfg *ociv1.Image) (res string, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cannot compute combination hash: %w", err)
+		}
+	}()
+
+	hash, err := highwayhash.New(hashKey)
 	if err != nil {
 		return
 	}
-	_, err = mfw.Write(serializedMf)
+	for _, l := range mf.Layers {
+		fmt.Fprintf(hash, "Layer: %s\n", l.Digest)
+	}
+	imgcfg, err := json.Marshal(cfg.Config)
 	if err != nil {
 		return
 	}
-	err = mfw.Commit(ctx, int64(len(serializedMf)), cmfdesc.Digest)
+	fmt.Fprintf(hash, "Config: %s\n", imgcfg)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// combinationTestResultRef produces the name under which the test result of this chunk against a combination is stored.
+// env are the values of the host env vars the tests reference, see test.ResolvedEnv.
+func (p *ProjectChunk) combinationTestResultRef(combinationHash string, env []string, sess *BuildSession) (reference.NamedTagged, error) {
+	if sess.baseRef == nil {
+		return nil, ErrBaseNotResolved
+	}
+
+	chkhash, err := p.hash(sess.baseRef.String(), false)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("cannot compute chunk hash: %w", err)
+	}
+	hash, err := highwayhash.New(hashKey)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(hash, "Combination: %s\nChunk: %s\n", combinationHash, chkhash)
+	for _, e := range env {
+		fmt.Fprintf(hash, "Env: %s\n", e)
 	}
 
-	if options.RunTests {
-		for _, chk := range cs {
-			if len(chk.Tests) == 0 {
-				continue
-			}
+	safeName := strings.ReplaceAll(p.Name, ":", "-")
+	return reference.WithTag(sess.Dest, fmt.Sprintf("%s--%s--%s", safeName, hex.EncodeToString(hash.Sum(nil)), imageTypeCombinationTestResult))
+}
 
-			executor := buildkit.NewExecutor(options.BuildkitClient, dest.String(), &ccfg)
-			_, ok := test.RunTests(ctx, executor, chk.Tests)
-			if !ok {
-				return fmt.Errorf("tests failed")
-			}
+// combinationName returns the tag of a combination reference, which by convention is the name of the combination
+func combinationName(dest reference.Named) string {
+	if tagged, ok := dest.(reference.Tagged); ok {
+		return tagged.Tag()
+	}
+	return dest.String()
+}
+
+// combinationAnnotations produces the manifest annotations of a combination, which unlike its members is not a chunk
+func combinationAnnotations(base *ociv1.Manifest, others []*ociv1.Manifest, tests TestStatus) map[string]string {
+	res := mergeAnnotations(base, others)
+	delete(res, mfAnnotationChunk)
+	// combinations are no intermediate images, even though their chunks are
+	delete(res, mfAnnotationExpiresAfter)
+	res[mfAnnotationTests] = string(tests)
+	return res
+}
+
+// combinationMembers describes the base and chunks of a combination in layer order. mfs are the
+// manifests of the base followed by those of the chunks.
+func (p *Project) combinationMembers(cs []ProjectChunk, mfs []*ociv1.Manifest, sess *BuildSession) ([]ChunkProvenance, error) {
+	basehash, err := p.Base.hash("", true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute base hash: %w", err)
+	}
+	res := make([]ChunkProvenance, 0, len(mfs))
+	res = append(res, ChunkProvenance{Chunk: baseLayerOwner, Hash: basehash, Layers: len(mfs[0].Layers)})
+	for i, c := range cs {
+		hash, err := c.hash(sess.baseRef.String(), true)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compute chunk hash: %w", err)
 		}
+		res = append(res, ChunkProvenance{Chunk: c.Name, Hash: hash, Layers: len(mfs[i+1].Layers)})
+	}
+	return res, nil
+}
 
+// combinationLabels produces the config labels of a combination. Unlike the layer annotations
+// they survive copying the image with tools which drop descriptor annotations, e.g. docker push.
+func combinationLabels(members []ChunkProvenance) (map[string]string, error) {
+	chunks, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
 	}
+	return map[string]string{cfgLabelChunks: string(chunks)}, nil
+}
 
-	return
+// chunkHistory prefixes the created_by entries of a chunk's history with the chunk name and the
+// step within the chunk, so that the history of a combination tells which chunk produced a layer
+func chunkHistory(chunk string, hist []ociv1.History) []ociv1.History {
+	res := make([]ociv1.History, len(hist))
+	for i, h := range hist {
+		h.CreatedBy = strings.TrimSpace(fmt.Sprintf("dazzle:chunk=%s step=%d %s", chunk, i+1, h.CreatedBy))
+		res[i] = h
+	}
+	return res
+}
+
+// withLayerOwner annotates a layer with the chunk it stems from
+func withLayerOwner(l ociv1.Descriptor, owner ChunkProvenance) ociv1.Descriptor {
+	annotations := make(map[string]string, len(l.Annotations)+2)
+	for k, v := range l.Annotations {
+		annotations[k] = v
+	}
+	annotations[mfAnnotationChunk] = owner.Chunk
+	if owner.Hash != "" {
+		annotations[mfAnnotationChunkHash] = owner.Hash
+	}
+	l.Annotations = annotations
+	return l
 }
 
 func mergeAnnotations(base *ociv1.Manifest, others []*ociv1.Manifest) map[string]string {
@@ -277,13 +609,49 @@ func mergeExposedPorts(base *ociv1.Image, others []*ociv1.Image) map[string]stru
 	return res
 }
 
-func mergeEnv(base *ociv1.Image, others []*ociv1.Image, vars []EnvVarCombination) ([]string, error) {
-	envs := make(map[string]string)
+// combinationEnvVars merges the env var combination hints of chunks with those of the project.
+// The project's combiner.envvars take precedence, and chunks must not disagree on the remaining ones.
+func combinationEnvVars(project []EnvVarCombination, chunks []ProjectChunk) ([]EnvVarCombination, error) {
+	res := append([]EnvVarCombination(nil), project...)
+	declared := make(map[string]string, len(project))
+	for _, e := range project {
+		declared[e.Name] = ""
+	}
+	for _, c := range chunks {
+		for _, e := range c.EnvVars {
+			by, exists := declared[e.Name]
+			if !exists {
+				declared[e.Name] = c.Name
+				res = append(res, e)
+				continue
+			}
+			if by == "" {
+				continue
+			}
+			for _, r := range res {
+				if r.Name == e.Name && r.Action != e.Action {
+					return nil, fmt.Errorf("chunks %s and %s declare different actions for env var %s (%s and %s), configure it in combiner.envvars", by, c.Name, e.Name, r.Action, e.Action)
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+func mergeEnv(logger log.FieldLogger, base *ociv1.Image, others []*ociv1.Image, vars []EnvVarCombination) ([]string, error) {
+	var (
+		envs = make(map[string]string)
+		// order keeps the env vars in the order of the images, so that the combined config and its hash are stable
+		order []string
+	)
 	for _, e := range base.Config.Env {
 		segs := strings.Split(e, "=")
 		if len(segs) != 2 {
 			return nil, fmt.Errorf("env var %s in invalid", e)
 		}
+		if _, exists := envs[segs[0]]; !exists {
+			order = append(order, segs[0])
+		}
 		envs[segs[0]] = segs[1]
 	}
 
@@ -335,7 +703,7 @@ func mergeEnv(base *ociv1.Image, others []*ociv1.Image, vars []EnvVarCombination
 					}
 					envs[k] = strings.Join(vss, ":")
 				}
-				log.WithFields(log.Fields{
+				logger.WithFields(log.Fields{
 					"action":     action,
 					"name":       k,
 					"image-vars": envValue,
@@ -345,16 +713,13 @@ func mergeEnv(base *ociv1.Image, others []*ociv1.Image, vars []EnvVarCombination
 				continue
 			}
 			envs[k] = v
+			order = append(order, k)
 		}
 	}
 
-	var (
-		res = make([]string, len(envs))
-		i   = 0
-	)
-	for k, v := range envs {
-		res[i] = fmt.Sprintf("%s=%s", k, v)
-		i++
+	res := make([]string, 0, len(order))
+	for _, k := range order {
+		res = append(res, fmt.Sprintf("%s=%s", k, envs[k]))
 	}
 	return res, nil
 }
@@ -22,9 +22,12 @@ package dazzle
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
 )
 
 func TestMergeEnv(t *testing.T) {
@@ -167,10 +170,37 @@ func TestMergeEnv(t *testing.T) {
 			},
 			expect: []string{"PATH=first:second:third:common-value"},
 		},
+		{
+			name: "keeps order",
+			base: &ociv1.Image{
+				Config: ociv1.ImageConfig{
+					Env: []string{"PATH=/bin", "LANG=C", "HOME=/root"},
+				},
+			},
+			others: []*ociv1.Image{
+				{
+					Config: ociv1.ImageConfig{
+						Env: []string{"GOPATH=/go", "PATH=/go/bin", "CARGO_HOME=/cargo"},
+					},
+				},
+				{
+					Config: ociv1.ImageConfig{
+						Env: []string{"NODE_VERSION=20", "HOME=/home/gitpod"},
+					},
+				},
+			},
+			vars: []EnvVarCombination{
+				{
+					Name:   "PATH",
+					Action: EnvVarCombineMerge,
+				},
+			},
+			expect: []string{"PATH=/bin:/go/bin", "LANG=C", "HOME=/root", "GOPATH=/go", "CARGO_HOME=/cargo", "NODE_VERSION=20"},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			envs, err := mergeEnv(test.base, test.others, test.vars)
+			envs, err := mergeEnv(log.StandardLogger(), test.base, test.others, test.vars)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -180,3 +210,126 @@ func TestMergeEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestCombinationEnvVars(t *testing.T) {
+	var (
+		pathMerge  = EnvVarCombination{Name: "PATH", Action: EnvVarCombineMerge}
+		pathUnique = EnvVarCombination{Name: "PATH", Action: EnvVarCombineMergeUnique}
+		goPath     = EnvVarCombination{Name: "GOPATH", Action: EnvVarCombineUseLast}
+	)
+	tests := []struct {
+		name    string
+		project []EnvVarCombination
+		chunks  []ProjectChunk
+		expect  []EnvVarCombination
+		err     bool
+	}{
+		{
+			name:    "project only",
+			project: []EnvVarCombination{pathMerge},
+			chunks:  []ProjectChunk{{Name: "foo"}},
+			expect:  []EnvVarCombination{pathMerge},
+		},
+		{
+			name:   "chunk hints",
+			chunks: []ProjectChunk{{Name: "foo", EnvVars: []EnvVarCombination{pathUnique}}, {Name: "go", EnvVars: []EnvVarCombination{goPath, pathUnique}}},
+			expect: []EnvVarCombination{pathUnique, goPath},
+		},
+		{
+			name:    "project takes precedence",
+			project: []EnvVarCombination{pathMerge},
+			chunks:  []ProjectChunk{{Name: "foo", EnvVars: []EnvVarCombination{pathUnique}}, {Name: "go", EnvVars: []EnvVarCombination{goPath}}},
+			expect:  []EnvVarCombination{pathMerge, goPath},
+		},
+		{
+			name:   "conflicting chunks",
+			chunks: []ProjectChunk{{Name: "foo", EnvVars: []EnvVarCombination{pathUnique}}, {Name: "bar", EnvVars: []EnvVarCombination{pathMerge}}},
+			err:    true,
+		},
+		{
+			name:    "conflicting chunks resolved by project",
+			project: []EnvVarCombination{pathMerge},
+			chunks:  []ProjectChunk{{Name: "foo", EnvVars: []EnvVarCombination{pathUnique}}, {Name: "bar", EnvVars: []EnvVarCombination{pathMerge}}},
+			expect:  []EnvVarCombination{pathMerge},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := combinationEnvVars(test.project, test.chunks)
+			if (err != nil) != test.err {
+				t.Fatalf("combinationEnvVars() error = %v, wantErr %v", err, test.err)
+			}
+			if diff := cmp.Diff(test.expect, res); len(diff) != 0 {
+				t.Errorf("combinationEnvVars() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCombinationHash(t *testing.T) {
+	var (
+		earlier = time.Unix(1600000000, 0)
+		later   = time.Unix(1700000000, 0)
+		layers  = []ociv1.Descriptor{
+			{Digest: digest.FromString("base")},
+			{Digest: digest.FromString("chunk")},
+		}
+	)
+	hash := func(t *testing.T, layers []ociv1.Descriptor, cfg ociv1.Image) string {
+		res, err := combinationHash(&ociv1.Manifest{Layers: layers}, &cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	ref := hash(t, layers, ociv1.Image{Created: &earlier, Config: ociv1.ImageConfig{Env: []string{"PATH=/bin"}}})
+	if h := hash(t, layers, ociv1.Image{Created: &later, Config: ociv1.ImageConfig{Env: []string{"PATH=/bin"}}}); h != ref {
+		t.Errorf("hash changed with creation time: %s != %s", h, ref)
+	}
+	if h := hash(t, layers, ociv1.Image{Created: &earlier, Config: ociv1.ImageConfig{Env: []string{"PATH=/usr/bin"}}}); h == ref {
+		t.Errorf("hash did not change with config")
+	}
+	if h := hash(t, layers[:1], ociv1.Image{Created: &earlier, Config: ociv1.ImageConfig{Env: []string{"PATH=/bin"}}}); h == ref {
+		t.Errorf("hash did not change with layers")
+	}
+
+	var (
+		base   = &ociv1.Image{Config: ociv1.ImageConfig{Env: []string{"PATH=/bin", "LANG=C", "HOME=/root"}}}
+		others = []*ociv1.Image{{Config: ociv1.ImageConfig{Env: []string{"GOPATH=/go", "PATH=/go/bin", "TZ=UTC"}}}}
+		vars   = []EnvVarCombination{{Name: "PATH", Action: EnvVarCombineMerge}}
+	)
+	var merged string
+	for i := 0; i < 20; i++ {
+		env, err := mergeEnv(log.StandardLogger(), base, others, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := hash(t, layers, ociv1.Image{Config: ociv1.ImageConfig{Env: env}})
+		if merged == "" {
+			merged = h
+		} else if h != merged {
+			t.Fatalf("hash of merged env changed between calls: %s != %s", h, merged)
+		}
+	}
+}
+
+func TestChunkHistory(t *testing.T) {
+	hist := []ociv1.History{
+		{CreatedBy: "ENV GOPATH=/go", EmptyLayer: true},
+		{CreatedBy: "RUN /bin/sh -c install-go # buildkit"},
+		{},
+	}
+	act := chunkHistory("golang:1.19", hist)
+	expect := []ociv1.History{
+		{CreatedBy: "dazzle:chunk=golang:1.19 step=1 ENV GOPATH=/go", EmptyLayer: true},
+		{CreatedBy: "dazzle:chunk=golang:1.19 step=2 RUN /bin/sh -c install-go # buildkit"},
+		{CreatedBy: "dazzle:chunk=golang:1.19 step=3"},
+	}
+	if diff := cmp.Diff(expect, act); diff != "" {
+		t.Errorf("chunkHistory() mismatch (-want +got):\n%s", diff)
+	}
+	if hist[1].CreatedBy != "RUN /bin/sh -c install-go # buildkit" {
+		t.Errorf("chunkHistory() modified its input")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// withDependencyArgs sets the build arg of dependencies which do not name one
+func withDependencyArgs(deps []ChunkDependency) []ChunkDependency {
+	if len(deps) == 0 {
+		return nil
+	}
+	res := make([]ChunkDependency, len(deps))
+	for i, dep := range deps {
+		if dep.Arg == "" {
+			dep.Arg = dependencyArg(dep.Chunk)
+		}
+		res[i] = dep
+	}
+	return res
+}
+
+// dependencyArg is the default build arg which receives the image of a chunk, e.g. GOLANG_1_16_IMAGE for golang:1.16
+func dependencyArg(chunk string) string {
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, chunk) + "_IMAGE"
+}
+
+// resolveDependencies links each chunk to the chunks it depends on and makes sure the dependencies do not form a cycle
+func (p *Project) resolveDependencies() error {
+	idx := make(map[string]int, len(p.Chunks))
+	for i, chk := range p.Chunks {
+		idx[chk.Name] = i
+	}
+
+	for i := range p.Chunks {
+		chk := &p.Chunks[i]
+		chk.deps = make([]*ProjectChunk, 0, len(chk.DependsOn))
+		args := make(map[string]bool, len(chk.DependsOn))
+		for _, dep := range chk.DependsOn {
+			j, ok := idx[dep.Chunk]
+			switch {
+			case ok:
+			case containsIgnored(p.ignored, dep.Chunk):
+				return fmt.Errorf("chunk %s depends on %s, which is ignored", chk.Name, dep.Chunk)
+			default:
+				return fmt.Errorf("chunk %s depends on %s, which does not exist", chk.Name, dep.Chunk)
+			}
+			if j == i {
+				return fmt.Errorf("chunk %s depends on itself", chk.Name)
+			}
+			if args[dep.Arg] {
+				return fmt.Errorf("chunk %s uses build arg %s for more than one dependency", chk.Name, dep.Arg)
+			}
+			if _, ok := chk.Args[dep.Arg]; ok {
+				return fmt.Errorf("chunk %s sets build arg %s, which receives the image of %s", chk.Name, dep.Arg, dep.Chunk)
+			}
+			args[dep.Arg] = true
+			chk.deps = append(chk.deps, &p.Chunks[j])
+		}
+	}
+
+	_, err := orderChunks(p.Chunks)
+	return err
+}
+
+func containsIgnored(ignored []string, chunk string) bool {
+	for _, n := range ignored {
+		if n == chunk {
+			return true
+		}
+	}
+	return false
+}
+
+// orderChunks sorts the chunks so that each chunk comes after the chunks it depends on. Otherwise the chunks keep their order.
+func orderChunks(chunks []ProjectChunk) ([]ProjectChunk, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var (
+		res    = make([]ProjectChunk, 0, len(chunks))
+		state  = make(map[string]int, len(chunks))
+		byName = make(map[string]ProjectChunk, len(chunks))
+		visit  func(chk ProjectChunk, path []string) error
+	)
+	for _, chk := range chunks {
+		byName[chk.Name] = chk
+	}
+	visit = func(chk ProjectChunk, path []string) error {
+		path = append(path, chk.Name)
+		switch state[chk.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("chunks depend on each other: %s", strings.Join(path, " -> "))
+		}
+		state[chk.Name] = visiting
+		for _, dep := range chk.deps {
+			d, ok := byName[dep.Name]
+			if !ok {
+				// not among the chunks to order, e.g. because it does not support the platform
+				continue
+			}
+			err := visit(d, path)
+			if err != nil {
+				return err
+			}
+		}
+		state[chk.Name] = visited
+		res = append(res, chk)
+		return nil
+	}
+	for _, chk := range chunks {
+		err := visit(chk, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// skippedDependency returns the name of a dependency of the chunk which is skipped, or an empty string if there is none
+func (p *ProjectChunk) skippedDependency(skipped map[string]bool) string {
+	for _, dep := range p.deps {
+		if skipped[dep.Name] {
+			return dep.Name
+		}
+	}
+	return ""
+}
+
+// dependencyArgs returns the build args which receive the refs of the full images of the chunk's dependencies
+func (p *ProjectChunk) dependencyArgs(sess *BuildSession) (map[string]string, error) {
+	res := make(map[string]string, len(p.deps))
+	for i, dep := range p.deps {
+		ref, err := dep.ImageName(ImageTypeFull, sess)
+		if err != nil {
+			return nil, err
+		}
+		res[p.DependsOn[i].Arg] = ref.String()
+	}
+	return res, nil
+}
@@ -0,0 +1,179 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadFromDir_dependencies(t *testing.T) {
+	type Expectation struct {
+		Err       string
+		Order     []string
+		DependsOn map[string][]ChunkDependency
+	}
+	tests := []struct {
+		Name        string
+		FS          map[string]*fstest.MapFile
+		Expectation Expectation
+	}{
+		{
+			Name: "ordered",
+			FS: map[string]*fstest.MapFile{
+				"chunks/app/chunk.yaml":    {Data: []byte("dependsOn:\n- chunk: tools\n- chunk: golang:1.16\n  arg: GO\n")},
+				"chunks/tools/chunk.yaml":  {Data: []byte("dependsOn:\n- chunk: golang:1.16\n")},
+				"chunks/golang/chunk.yaml": {Data: []byte("variants:\n- name: \"1.16\"\n")},
+			},
+			Expectation: Expectation{
+				Order: []string{"golang:1.16", "tools", "app"},
+				DependsOn: map[string][]ChunkDependency{
+					"app":   {{Chunk: "tools", Arg: "TOOLS_IMAGE"}, {Chunk: "golang:1.16", Arg: "GO"}},
+					"tools": {{Chunk: "golang:1.16", Arg: "GOLANG_1_16_IMAGE"}},
+				},
+			},
+		},
+		{
+			Name: "unknown chunk",
+			FS: map[string]*fstest.MapFile{
+				"chunks/app/chunk.yaml": {Data: []byte("dependsOn:\n- chunk: rust\n")},
+			},
+			Expectation: Expectation{Err: "chunk app depends on rust, which does not exist"},
+		},
+		{
+			Name: "ignored chunk",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":           {Data: []byte("ignore: [tools]\n")},
+				"chunks/app/chunk.yaml": {Data: []byte("dependsOn:\n- chunk: tools\n")},
+			},
+			Expectation: Expectation{Err: "chunk app depends on tools, which is ignored"},
+		},
+		{
+			Name: "itself",
+			FS: map[string]*fstest.MapFile{
+				"chunks/app/chunk.yaml": {Data: []byte("dependsOn:\n- chunk: app\n")},
+			},
+			Expectation: Expectation{Err: "chunk app depends on itself"},
+		},
+		{
+			Name: "cycle",
+			FS: map[string]*fstest.MapFile{
+				"chunks/app/chunk.yaml":   {Data: []byte("dependsOn:\n- chunk: tools\n")},
+				"chunks/tools/chunk.yaml": {Data: []byte("dependsOn:\n- chunk: app\n")},
+			},
+			Expectation: Expectation{Err: "chunks depend on each other: app -> tools -> app"},
+		},
+		{
+			Name: "arg used twice",
+			FS: map[string]*fstest.MapFile{
+				"chunks/app/chunk.yaml": {Data: []byte("dependsOn:\n- chunk: tools\n  arg: DEP\n- chunk: golang\n  arg: DEP\n")},
+			},
+			Expectation: Expectation{Err: "chunk app uses build arg DEP for more than one dependency"},
+		},
+		{
+			Name: "arg set by variant",
+			FS: map[string]*fstest.MapFile{
+				"chunks/app/chunk.yaml": {Data: []byte("dependsOn:\n- chunk: tools\nvariants:\n- name: v1\n  args:\n    TOOLS_IMAGE: foo\n")},
+			},
+			Expectation: Expectation{Err: "chunk app:v1 sets build arg TOOLS_IMAGE, which receives the image of tools"},
+		},
+		{
+			Name: "base",
+			FS: map[string]*fstest.MapFile{
+				"base/chunk.yaml": {Data: []byte("dependsOn:\n- chunk: tools\n")},
+			},
+			Expectation: Expectation{Err: "the base image cannot depend on chunks"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dir := fstest.MapFS{
+				"dazzle.yaml":              {Data: []byte("combiner: {}\n")},
+				"base/Dockerfile":          {Data: []byte("FROM alpine")},
+				"chunks/app/Dockerfile":    {Data: []byte("FROM ubuntu")},
+				"chunks/tools/Dockerfile":  {Data: []byte("FROM ubuntu")},
+				"chunks/golang/Dockerfile": {Data: []byte("FROM ubuntu")},
+			}
+			for fn, f := range test.FS {
+				dir[fn] = f
+			}
+
+			var act Expectation
+			prj, err := LoadFromDir("", LoadFromDirOpts{FS: func(string) fs.FS { return dir }})
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				chunks, err := orderChunks(prj.Chunks)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, chk := range chunks {
+					act.Order = append(act.Order, chk.Name)
+					if len(chk.DependsOn) > 0 {
+						if act.DependsOn == nil {
+							act.DependsOn = make(map[string][]ChunkDependency)
+						}
+						act.DependsOn[chk.Name] = chk.DependsOn
+					}
+				}
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("LoadFromDir() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProjectChunk_hashDependencies(t *testing.T) {
+	load := func(golang string) *Project {
+		dir := fstest.MapFS{
+			"dazzle.yaml":              {Data: []byte("combiner: {}\n")},
+			"base/Dockerfile":          {Data: []byte("FROM alpine")},
+			"chunks/app/Dockerfile":    {Data: []byte("ARG GOLANG_IMAGE\nFROM ${GOLANG_IMAGE}")},
+			"chunks/app/chunk.yaml":    {Data: []byte("dependsOn:\n- chunk: golang\n")},
+			"chunks/golang/Dockerfile": {Data: []byte(golang)},
+		}
+		prj, err := LoadFromDir("", LoadFromDirOpts{FS: func(string) fs.FS { return dir }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prj
+	}
+	hash := func(prj *Project) string {
+		h, err := prj.Chunks[0].hash("", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	if prj := load("FROM ubuntu"); prj.Chunks[0].Name != "app" {
+		t.Fatalf("expected app to be the first chunk, got %s", prj.Chunks[0].Name)
+	}
+	if hash(load("FROM ubuntu")) == hash(load("FROM debian")) {
+		t.Errorf("hash() of a chunk does not change with the chunk it depends on")
+	}
+}
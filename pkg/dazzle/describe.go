@@ -0,0 +1,112 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+// ProjectDescription is the effective configuration of a project, after ignore patterns,
+// variants and combination references have been applied
+type ProjectDescription struct {
+	Base         ChunkDescription         `yaml:"base" json:"base"`
+	Chunks       []ChunkDescription       `yaml:"chunks" json:"chunks"`
+	Ignored      []string                 `yaml:"ignored,omitempty" json:"ignored,omitempty"`
+	Combinations []CombinationDescription `yaml:"combinations" json:"combinations"`
+	EnvVars      []EnvVarDescription      `yaml:"envvars,omitempty" json:"envvars,omitempty"`
+	Tests        TestsDescription         `yaml:"tests" json:"tests"`
+}
+
+// ChunkDescription describes a single chunk or variant of a chunk
+type ChunkDescription struct {
+	Name        string                  `yaml:"name" json:"name"`
+	ContextPath string                  `yaml:"contextPath" json:"contextPath"`
+	Args        map[string]string       `yaml:"args,omitempty" json:"args,omitempty"`
+	Platforms   []string                `yaml:"platforms,omitempty" json:"platforms,omitempty"`
+	EnvVars     []EnvVarDescription     `yaml:"envvars,omitempty" json:"envvars,omitempty"`
+	DependsOn   []DependencyDescription `yaml:"dependsOn,omitempty" json:"dependsOn,omitempty"`
+	Dockerfile  string                  `yaml:"dockerfile" json:"dockerfile"`
+	Tests       []string                `yaml:"tests,omitempty" json:"tests,omitempty"`
+}
+
+// DependencyDescription describes a chunk another chunk depends on and the build arg which receives its image
+type DependencyDescription struct {
+	Chunk string `yaml:"chunk" json:"chunk"`
+	Arg   string `yaml:"arg" json:"arg"`
+}
+
+// CombinationDescription describes a combination with all chunks it references directly or through other combinations
+type CombinationDescription struct {
+	Name   string   `yaml:"name" json:"name"`
+	Chunks []string `yaml:"chunks" json:"chunks"`
+}
+
+// EnvVarDescription describes how an env var is combined
+type EnvVarDescription struct {
+	Name   string                  `yaml:"name" json:"name"`
+	Action EnvVarCombinationAction `yaml:"action" json:"action"`
+}
+
+// TestsDescription describes the project-wide test configuration
+type TestsDescription struct {
+	Env       []string `yaml:"env,omitempty" json:"env,omitempty"`
+	MaxOutput int      `yaml:"maxOutput,omitempty" json:"maxOutput,omitempty"`
+}
+
+// Describe produces the effective configuration of the project
+func (p *Project) Describe() ProjectDescription {
+	res := ProjectDescription{
+		Base:         describeChunk(p.Base),
+		Chunks:       make([]ChunkDescription, 0, len(p.Chunks)),
+		Ignored:      p.ignored,
+		Combinations: make([]CombinationDescription, 0, len(p.Config.Combiner.Combinations)),
+		Tests: TestsDescription{
+			Env:       p.Config.Tests.Env,
+			MaxOutput: p.Config.Tests.MaxOutput,
+		},
+	}
+	for _, chk := range p.Chunks {
+		res.Chunks = append(res.Chunks, describeChunk(chk))
+	}
+	for _, c := range p.Config.Combiner.Combinations {
+		res.Combinations = append(res.Combinations, CombinationDescription{Name: c.Name, Chunks: c.Chunks})
+	}
+	for _, e := range p.Config.Combiner.EnvVars {
+		res.EnvVars = append(res.EnvVars, EnvVarDescription(e))
+	}
+	return res
+}
+
+func describeChunk(chk ProjectChunk) ChunkDescription {
+	res := ChunkDescription{
+		Name:        chk.Name,
+		ContextPath: chk.ContextPath,
+		Args:        chk.Args,
+		Platforms:   chk.Platforms,
+		Dockerfile:  string(chk.Dockerfile),
+	}
+	for _, e := range chk.EnvVars {
+		res.EnvVars = append(res.EnvVars, EnvVarDescription(e))
+	}
+	for _, d := range chk.DependsOn {
+		res.DependsOn = append(res.DependsOn, DependencyDescription(d))
+	}
+	for _, t := range chk.Tests {
+		res.Tests = append(res.Tests, t.Desc)
+	}
+	return res
+}
@@ -0,0 +1,62 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProjectDescribe(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"dazzle.yaml":              {Data: []byte("ignore:\n- golang:1.15\ncombiner:\n  combinations:\n  - name: minimal\n    chunks: [golang:1.16]\n  - name: full\n    ref: [minimal]\n    chunks: [node]\n  envvars:\n  - name: PATH\n    action: merge-unique\ntests:\n  maxOutput: 1024\n")},
+		"base/Dockerfile":          {Data: []byte("FROM alpine")},
+		"chunks/golang/Dockerfile": {Data: []byte("ARG base\nFROM ${base}")},
+		"chunks/golang/chunk.yaml": {Data: []byte("variants:\n- name: \"1.15\"\n  args:\n    GO_VERSION: \"1.15\"\n- name: \"1.16\"\n  args:\n    GO_VERSION: \"1.16\"\n")},
+		"chunks/node/Dockerfile":   {Data: []byte("ARG base\nFROM ${base}")},
+		"tests/node.yaml":          {Data: []byte("- desc: it should have node\n  command: [node, --version]\n  assert: [status == 0]\n")},
+	}
+	prj, err := LoadFromDir("", LoadFromDirOpts{FS: func(string) fs.FS { return mapFS }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectation := ProjectDescription{
+		Base: ChunkDescription{Name: "base", ContextPath: "base", Dockerfile: "FROM alpine"},
+		Chunks: []ChunkDescription{
+			{Name: "golang:1.16", ContextPath: "chunks/golang", Args: map[string]string{"GO_VERSION": "1.16"}, Dockerfile: "ARG base\nFROM ${base}"},
+			{Name: "node", ContextPath: "chunks/node", Dockerfile: "ARG base\nFROM ${base}", Tests: []string{"it should have node"}},
+		},
+		Ignored: []string{"golang:1.15"},
+		Combinations: []CombinationDescription{
+			{Name: "full", Chunks: []string{"golang:1.16", "node"}},
+			{Name: "minimal", Chunks: []string{"golang:1.16"}},
+		},
+		EnvVars: []EnvVarDescription{{Name: "PATH", Action: EnvVarCombineMergeUnique}},
+		Tests:   TestsDescription{MaxOutput: 1024},
+	}
+	if diff := cmp.Diff(expectation, prj.Describe()); diff != "" {
+		t.Errorf("Describe() mismatch (-want +got):\n%s", diff)
+	}
+}
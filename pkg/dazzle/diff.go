@@ -0,0 +1,264 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ImageDiff describes how one image differs from another
+type ImageDiff struct {
+	Old    string           `json:"old"`
+	New    string           `json:"new"`
+	Size   SizeChange       `json:"size"`
+	Layers []LayerDiff      `json:"layers"`
+	Env    []KeyValueChange `json:"env,omitempty"`
+	Labels []KeyValueChange `json:"labels,omitempty"`
+}
+
+// SizeChange is the compressed size of both images in bytes
+type SizeChange struct {
+	Old int64 `json:"old"`
+	New int64 `json:"new"`
+}
+
+// LayerDiff lists the files which differ between the layers at the same position of both images.
+// Old or New are empty if only one of the images has a layer at that position.
+type LayerDiff struct {
+	Index   int           `json:"index"`
+	Old     digest.Digest `json:"old,omitempty"`
+	New     digest.Digest `json:"new,omitempty"`
+	Added   []string      `json:"added,omitempty"`
+	Removed []string      `json:"removed,omitempty"`
+	Changed []string      `json:"changed,omitempty"`
+}
+
+// KeyValueChange is an env var or label which differs between both images. Old or New are nil
+// if the key exists in only one of them.
+type KeyValueChange struct {
+	Key string  `json:"key"`
+	Old *string `json:"old,omitempty"`
+	New *string `json:"new,omitempty"`
+}
+
+// DiffImages compares two images layer by layer. Layers with the same digest are skipped, the files
+// of all other layers are downloaded and compared by content, mode and link target.
+func DiffImages(ctx context.Context, resolver remotes.Resolver, oldRef, newRef reference.Named) (*ImageDiff, error) {
+	var (
+		registry       = NewResolverRegistry(resolver)
+		oldCfg, newCfg ociv1.Image
+	)
+	oldMF, _, err := registry.Pull(ctx, oldRef, &oldCfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot pull %s: %w", oldRef, err)
+	}
+	newMF, _, err := registry.Pull(ctx, newRef, &newCfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot pull %s: %w", newRef, err)
+	}
+
+	res := &ImageDiff{
+		Old:    oldRef.String(),
+		New:    newRef.String(),
+		Layers: []LayerDiff{},
+		Env:    diffKeyValues(envMap(oldCfg.Config.Env), envMap(newCfg.Config.Env)),
+		Labels: diffKeyValues(oldCfg.Config.Labels, newCfg.Config.Labels),
+	}
+	for _, l := range oldMF.Layers {
+		res.Size.Old += l.Size
+	}
+	for _, l := range newMF.Layers {
+		res.Size.New += l.Size
+	}
+
+	for i := 0; i < len(oldMF.Layers) || i < len(newMF.Layers); i++ {
+		var (
+			ld           = LayerDiff{Index: i}
+			oldFiles     map[string]string
+			newFiles     map[string]string
+			oldOk, newOk = i < len(oldMF.Layers), i < len(newMF.Layers)
+		)
+		if oldOk {
+			ld.Old = oldMF.Layers[i].Digest
+		}
+		if newOk {
+			ld.New = newMF.Layers[i].Digest
+		}
+		if ld.Old == ld.New {
+			continue
+		}
+
+		if oldOk {
+			oldFiles, err = listLayer(ctx, resolver, oldRef, oldMF.Layers[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		if newOk {
+			newFiles, err = listLayer(ctx, resolver, newRef, newMF.Layers[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		ld.Added, ld.Removed, ld.Changed = diffFiles(oldFiles, newFiles)
+		res.Layers = append(res.Layers, ld)
+	}
+
+	return res, nil
+}
+
+// listLayer downloads a layer and returns a fingerprint of each file it contains
+func listLayer(ctx context.Context, resolver remotes.Resolver, ref reference.Named, desc ociv1.Descriptor) (res map[string]string, err error) {
+	err = readLayer(ctx, resolver, ref, desc, func(r io.Reader) (err error) {
+		res, err = fingerprintFiles(r)
+		return err
+	})
+	return res, err
+}
+
+// readLayer downloads a layer and passes its uncompressed tar stream to fn
+func readLayer(ctx context.Context, resolver remotes.Resolver, ref reference.Named, desc ociv1.Descriptor, fn func(r io.Reader) error) error {
+	fetcher, err := resolver.Fetcher(ctx, ref.String())
+	if err != nil {
+		return err
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return fmt.Errorf("cannot fetch layer %s: %w", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	switch desc.MediaType {
+	case ociv1.MediaTypeImageLayerGzip, images.MediaTypeDockerSchema2LayerGzip:
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			return fmt.Errorf("cannot decompress layer %s: %w", desc.Digest, err)
+		}
+		defer gz.Close()
+		r = gz
+	case ociv1.MediaTypeImageLayer, images.MediaTypeDockerSchema2Layer:
+	default:
+		return fmt.Errorf("layer %s has unsupported media type %s", desc.Digest, desc.MediaType)
+	}
+
+	err = fn(r)
+	if err != nil {
+		return fmt.Errorf("cannot read layer %s: %w", desc.Digest, err)
+	}
+	return nil
+}
+
+// fingerprintFiles reads a tar archive and returns a fingerprint of the type, mode, link target
+// and content of each file it contains, ignoring modification times
+func fingerprintFiles(r io.Reader) (map[string]string, error) {
+	res := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		h := sha256.New()
+		_, err = io.Copy(h, tr)
+		if err != nil {
+			return nil, err
+		}
+		name := "/" + strings.TrimSuffix(strings.TrimPrefix(hdr.Name, "./"), "/")
+		res[name] = fmt.Sprintf("%c %o %d:%d %s %x", hdr.Typeflag, hdr.Mode, hdr.Uid, hdr.Gid, hdr.Linkname, h.Sum(nil))
+	}
+	return res, nil
+}
+
+// diffFiles compares two sets of file fingerprints and returns the sorted paths which differ
+func diffFiles(oldFiles, newFiles map[string]string) (added, removed, changed []string) {
+	for p, nf := range newFiles {
+		of, exists := oldFiles[p]
+		if !exists {
+			added = append(added, p)
+		} else if of != nf {
+			changed = append(changed, p)
+		}
+	}
+	for p := range oldFiles {
+		if _, exists := newFiles[p]; !exists {
+			removed = append(removed, p)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return
+}
+
+// diffKeyValues returns the sorted keys which differ between two maps
+func diffKeyValues(oldKV, newKV map[string]string) []KeyValueChange {
+	var res []KeyValueChange
+	for k, nv := range newKV {
+		nv := nv
+		ov, exists := oldKV[k]
+		if !exists {
+			res = append(res, KeyValueChange{Key: k, New: &nv})
+		} else if ov != nv {
+			ov := ov
+			res = append(res, KeyValueChange{Key: k, Old: &ov, New: &nv})
+		}
+	}
+	for k, ov := range oldKV {
+		ov := ov
+		if _, exists := newKV[k]; !exists {
+			res = append(res, KeyValueChange{Key: k, Old: &ov})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+func envMap(env []string) map[string]string {
+	res := make(map[string]string, len(env))
+	for _, e := range env {
+		segs := strings.SplitN(e, "=", 2)
+		if len(segs) == 2 {
+			res[segs[0]] = segs[1]
+		} else {
+			res[segs[0]] = ""
+		}
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffFiles(t *testing.T) {
+	type file struct {
+		Name     string
+		Content  string
+		Mode     int64
+		Linkname string
+	}
+	layer := func(files ...file) map[string]string {
+		var buf bytes.Buffer
+		w := tar.NewWriter(&buf)
+		for _, f := range files {
+			hdr := &tar.Header{Name: f.Name, Mode: f.Mode, Size: int64(len(f.Content)), Typeflag: tar.TypeReg}
+			if f.Linkname != "" {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, f.Linkname, 0
+			}
+			if err := w.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte(f.Content)); err != nil && f.Linkname == "" {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		res, err := fingerprintFiles(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	type Expectation struct {
+		Added, Removed, Changed []string
+	}
+	var act Expectation
+	act.Added, act.Removed, act.Changed = diffFiles(
+		layer(
+			file{Name: "usr/bin/foo", Content: "foo", Mode: 0755},
+			file{Name: "usr/bin/bar", Content: "bar", Mode: 0755},
+			file{Name: "etc/config", Content: "a=1", Mode: 0644},
+			file{Name: "usr/bin/link", Linkname: "foo"},
+			file{Name: "tmp/gone", Content: "", Mode: 0644},
+		),
+		layer(
+			file{Name: "usr/bin/foo", Content: "foo", Mode: 0755},
+			file{Name: "usr/bin/bar", Content: "bar", Mode: 0700},
+			file{Name: "./etc/config", Content: "a=2", Mode: 0644},
+			file{Name: "usr/bin/link", Linkname: "bar"},
+			file{Name: "usr/bin/new", Content: "new", Mode: 0755},
+		),
+	)
+	expectation := Expectation{
+		Added:   []string{"/usr/bin/new"},
+		Removed: []string{"/tmp/gone"},
+		Changed: []string{"/etc/config", "/usr/bin/bar", "/usr/bin/link"},
+	}
+	if diff := cmp.Diff(expectation, act); diff != "" {
+		t.Errorf("diffFiles() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiffKeyValues(t *testing.T) {
+	str := func(s string) *string { return &s }
+	act := diffKeyValues(
+		envMap([]string{"PATH=/usr/bin", "HOME=/root", "GONE=1"}),
+		envMap([]string{"PATH=/go/bin:/usr/bin", "HOME=/root", "NEW"}),
+	)
+	expectation := []KeyValueChange{
+		{Key: "GONE", Old: str("1")},
+		{Key: "NEW", New: str("")},
+		{Key: "PATH", Old: str("/usr/bin"), New: str("/go/bin:/usr/bin")},
+	}
+	if diff := cmp.Diff(expectation, act); diff != "" {
+		t.Errorf("diffKeyValues() mismatch (-want +got):\n%s", diff)
+	}
+}
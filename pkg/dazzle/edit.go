@@ -0,0 +1,164 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RemoveCombination removes a combination from the dazzle.yaml in dir. Unlike ProjectConfig.Write
+// this keeps the order, comments and unknown fields of the remaining config.
+func RemoveCombination(dir, name string) error {
+	return editProjectConfig(dir, func(root *yaml.Node) error {
+		combs := yamlSequence(root, "combiner", "combinations")
+		if combs == nil {
+			return fmt.Errorf("combination %s not found", name)
+		}
+
+		idx := -1
+		for i, c := range combs.Content {
+			var cn string
+			if n := yamlMappingValue(c, "name"); n != nil {
+				cn = n.Value
+			}
+			if cn == name {
+				idx = i
+				continue
+			}
+			if ref := yamlMappingValue(c, "ref"); ref != nil {
+				for _, r := range ref.Content {
+					if r.Value == name {
+						return fmt.Errorf("combination %s is referenced by %s", name, cn)
+					}
+				}
+			}
+		}
+		if idx < 0 {
+			return fmt.Errorf("combination %s not found", name)
+		}
+		combs.Content = append(combs.Content[:idx], combs.Content[idx+1:]...)
+		return nil
+	})
+}
+
+// RemoveChunkReferences removes a chunk and all its variants from the combinations in the dazzle.yaml in dir,
+// keeping the formatting of the remaining config. It returns the names of the combinations it changed.
+func RemoveChunkReferences(dir, chunk string) (changed []string, err error) {
+	err = editProjectConfig(dir, func(root *yaml.Node) error {
+		combs := yamlSequence(root, "combiner", "combinations")
+		if combs == nil {
+			return nil
+		}
+
+		for _, c := range combs.Content {
+			chunks := yamlMappingValue(c, "chunks")
+			if chunks == nil {
+				continue
+			}
+
+			remaining := chunks.Content[:0]
+			for _, chk := range chunks.Content {
+				if chk.Value == chunk || strings.HasPrefix(chk.Value, chunk+":") {
+					continue
+				}
+				remaining = append(remaining, chk)
+			}
+			if len(remaining) == len(chunks.Content) {
+				continue
+			}
+			chunks.Content = remaining
+			if n := yamlMappingValue(c, "name"); n != nil {
+				changed = append(changed, n.Value)
+			}
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// editProjectConfig applies an edit to the YAML document of the dazzle.yaml in dir
+func editProjectConfig(dir string, edit func(root *yaml.Node) error) error {
+	fn := filepath.Join(dir, "dazzle.yaml")
+	fc, err := os.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(fc, &doc)
+	if err != nil {
+		return fmt.Errorf("cannot load config from %s: %w", fn, err)
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	err = edit(doc.Content[0])
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err = enc.Encode(&doc)
+	if err != nil {
+		return err
+	}
+	err = enc.Close()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fn, buf.Bytes(), 0644)
+}
+
+// yamlSequence follows a path of mapping keys and returns the sequence node at its end, or nil
+func yamlSequence(node *yaml.Node, path ...string) *yaml.Node {
+	for _, p := range path {
+		node = yamlMappingValue(node, p)
+		if node == nil {
+			return nil
+		}
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node
+}
+
+// yamlMappingValue returns the value of key in a mapping node, or nil
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
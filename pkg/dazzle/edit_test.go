@@ -0,0 +1,130 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const editTestConfig = `# the combinations we publish
+combiner:
+  combinations:
+  - name: minimal
+    chunks:
+    - golang:1.16
+    - golang:1.17
+  - name: full
+    ref:
+    - minimal
+    chunks:
+    - node # LTS only
+  - name: other
+    chunks:
+    - node
+ignore:
+- _wip
+`
+
+func TestRemoveCombination(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Combination string
+		Err         string
+		Expectation string
+	}{
+		{
+			Name:        "unreferenced combination",
+			Combination: "other",
+			Expectation: "# the combinations we publish\ncombiner:\n  combinations:\n    - name: minimal\n      chunks:\n        - golang:1.16\n        - golang:1.17\n    - name: full\n      ref:\n        - minimal\n      chunks:\n        - node # LTS only\nignore:\n  - _wip\n",
+		},
+		{
+			Name:        "referenced combination",
+			Combination: "minimal",
+			Err:         "combination minimal is referenced by full",
+			Expectation: editTestConfig,
+		},
+		{
+			Name:        "unknown combination",
+			Combination: "foo",
+			Err:         "combination foo not found",
+			Expectation: editTestConfig,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, "dazzle.yaml"), []byte(editTestConfig), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var errMsg string
+			err = RemoveCombination(dir, test.Combination)
+			if err != nil {
+				errMsg = err.Error()
+			}
+			if errMsg != test.Err {
+				t.Errorf("RemoveCombination() error = %q, want %q", errMsg, test.Err)
+			}
+
+			act, err := os.ReadFile(filepath.Join(dir, "dazzle.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.Expectation, string(act)); diff != "" {
+				t.Errorf("RemoveCombination() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRemoveChunkReferences(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "dazzle.yaml"), []byte(editTestConfig), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := RemoveChunkReferences(dir, "golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"minimal"}, changed); diff != "" {
+		t.Errorf("RemoveChunkReferences() changed mismatch (-want +got):\n%s", diff)
+	}
+
+	cfg, err := LoadProjectConfig(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]ChunkCombination{
+		{Name: "minimal", Chunks: []string{}},
+		{Name: "full", Ref: []string{"minimal"}, Chunks: []string{"node"}},
+		{Name: "other", Chunks: []string{"node"}},
+	}, cfg.Combiner.Combinations); diff != "" {
+		t.Errorf("RemoveChunkReferences() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import "errors"
+
+// ErrorKind classifies why a dazzle operation failed
+type ErrorKind int
+
+const (
+	// ErrorKindUnknown is the kind of all errors which were not classified
+	ErrorKindUnknown ErrorKind = iota
+	// ErrorKindConfig means the project or its configuration is invalid
+	ErrorKindConfig
+	// ErrorKindBuild means an image failed to build
+	ErrorKindBuild
+	// ErrorKindTest means tests failed
+	ErrorKindTest
+	// ErrorKindRegistry means pulling from or pushing to a registry failed
+	ErrorKindRegistry
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindConfig:
+		return "config"
+	case ErrorKindBuild:
+		return "build"
+	case ErrorKindTest:
+		return "test"
+	case ErrorKindRegistry:
+		return "registry"
+	default:
+		return "unknown"
+	}
+}
+
+// Error is an error of a known kind
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of an error, or ErrorKindUnknown if it was not classified
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return ErrorKindUnknown
+}
+
+// Errors which tell why a build, combination or test run failed. They are wrapped, hence
+// check for them using errors.Is.
+var (
+	// ErrTestsFailed means tests of a chunk or combination did not pass
+	ErrTestsFailed = errors.New("tests failed")
+	// ErrChunkNotFromBase means a chunk image does not build on the layers of the base image
+	ErrChunkNotFromBase = errors.New("chunk was not built from base image")
+	// ErrBaseNotResolved means an operation needs the base image, which the session neither built nor downloaded
+	ErrBaseNotResolved = errors.New("base image not resolved")
+	// ErrSizeBudgetExceeded means the layers of a chunk are larger than the maxSize of its chunk.yaml
+	ErrSizeBudgetExceeded = errors.New("chunk exceeds its size budget")
+	// ErrVulnerabilitiesFound means the vulnerability scan found vulnerabilities above the threshold
+	ErrVulnerabilitiesFound = errors.New("images have vulnerabilities")
+	// ErrReadOnly means an operation attempted to push to a registry which dazzle may only pull from
+	ErrReadOnly = errors.New("registry is read-only")
+	// ErrOffline means an operation needs the network, but dazzle runs offline and the metadata cache cannot serve it
+	ErrOffline = errors.New("offline and not in the metadata cache")
+	// ErrBuildkitGone means buildkitd stopped responding while it was building
+	ErrBuildkitGone = errors.New("buildkitd went away")
+)
+
+// RegistryError is a failure to pull from or push to a registry. Its kind is ErrorKindRegistry.
+type RegistryError struct {
+	// Ref is the image or content the registry operation failed on
+	Ref string
+	Err error
+}
+
+func (e *RegistryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RegistryError) Unwrap() error {
+	return e.Err
+}
+
+// registryError classifies an error of a registry operation on ref unless it is nil or classified already
+func registryError(ref string, err error) error {
+	if err == nil || KindOf(err) != ErrorKindUnknown {
+		return err
+	}
+	var re *RegistryError
+	if !errors.As(err, &re) {
+		err = &RegistryError{Ref: ref, Err: err}
+	}
+	return withKind(ErrorKindRegistry, err)
+}
+
+// withKind classifies an error unless it is nil or classified already
+func withKind(kind ErrorKind, err error) error {
+	if err == nil || KindOf(err) != ErrorKindUnknown {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
@@ -0,0 +1,102 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		expect ErrorKind
+	}{
+		{name: "nil", err: nil, expect: ErrorKindUnknown},
+		{name: "unclassified", err: fmt.Errorf("foo"), expect: ErrorKindUnknown},
+		{name: "classified", err: withKind(ErrorKindTest, fmt.Errorf("foo")), expect: ErrorKindTest},
+		{name: "wrapped", err: fmt.Errorf("cannot build chunk: %w", withKind(ErrorKindRegistry, fmt.Errorf("foo"))), expect: ErrorKindRegistry},
+		{name: "first classification wins", err: withKind(ErrorKindBuild, withKind(ErrorKindTest, fmt.Errorf("foo"))), expect: ErrorKindTest},
+		{name: "nil stays nil", err: withKind(ErrorKindBuild, nil), expect: ErrorKindUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act := KindOf(test.err)
+			if act != test.expect {
+				t.Errorf("KindOf() = %v, expected %v", act, test.expect)
+			}
+		})
+	}
+}
+
+func TestRegistryError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		expectRef string
+		expectMsg string
+	}{
+		{name: "nil", err: registryError("localhost:9999/test:foo", nil)},
+		{name: "unclassified", err: registryError("localhost:9999/test:foo", fmt.Errorf("unauthorized")), expectRef: "localhost:9999/test:foo", expectMsg: "unauthorized"},
+		{name: "wrapped", err: fmt.Errorf("cannot build chunk: %w", registryError("localhost:9999/test:foo", fmt.Errorf("unauthorized"))), expectRef: "localhost:9999/test:foo", expectMsg: "cannot build chunk: unauthorized"},
+		{name: "innermost ref wins", err: registryError("localhost:9999/test:bar", fmt.Errorf("cannot promote: %w", &RegistryError{Ref: "localhost:9999/test:foo", Err: fmt.Errorf("unauthorized")})), expectRef: "localhost:9999/test:foo", expectMsg: "cannot promote: unauthorized"},
+		{name: "classified already", err: registryError("localhost:9999/test:foo", withKind(ErrorKindBuild, ErrChunkNotFromBase)), expectMsg: "chunk was not built from base image"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.err == nil {
+				return
+			}
+			if test.err.Error() != test.expectMsg {
+				t.Errorf("Error() = %q, expected %q", test.err.Error(), test.expectMsg)
+			}
+
+			var re *RegistryError
+			if !errors.As(test.err, &re) {
+				if test.expectRef != "" {
+					t.Errorf("expected a RegistryError for %s", test.expectRef)
+				}
+				return
+			}
+			if re.Ref != test.expectRef {
+				t.Errorf("RegistryError.Ref = %q, expected %q", re.Ref, test.expectRef)
+			}
+			if KindOf(test.err) != ErrorKindRegistry {
+				t.Errorf("KindOf() = %v, expected %v", KindOf(test.err), ErrorKindRegistry)
+			}
+		})
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	err := fmt.Errorf("cannot test chunk foo: %w", withKind(ErrorKindTest, fmt.Errorf("%s: %w", "foo", ErrTestsFailed)))
+	if !errors.Is(err, ErrTestsFailed) {
+		t.Errorf("errors.Is(%v, ErrTestsFailed) = false, expected true", err)
+	}
+	if errors.Is(err, ErrChunkNotFromBase) {
+		t.Errorf("errors.Is(%v, ErrChunkNotFromBase) = true, expected false", err)
+	}
+	if KindOf(err) != ErrorKindTest {
+		t.Errorf("KindOf() = %v, expected %v", KindOf(err), ErrorKindTest)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build ignore
+// +build ignore
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/alecthomas/jsonschema"
+
+	"github.com/gitpod-io/dazzle/pkg/dazzle"
+)
+
+func main() {
+	var schema *jsonschema.Schema
+	switch os.Args[1] {
+	case "project":
+		schema = dazzle.ProjectConfigSchema()
+	case "chunk":
+		schema = dazzle.ChunkConfigSchema()
+	default:
+		log.Fatalf("unknown schema %s, must be project or chunk", os.Args[1])
+	}
+
+	fc, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(string(fc))
+}
@@ -0,0 +1,133 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gitpod-io/dazzle/pkg/test"
+)
+
+// GitHubSummary renders the chunks built and the tests run during this session as Markdown
+// for a GitHub Actions job summary
+func (s *BuildSession) GitHubSummary() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("## dazzle\n\n")
+
+	keys := make([]string, 0, len(s.chunks))
+	for c := range s.chunks {
+		keys = append(keys, c)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		buf.WriteString("| Chunk | Size |\n| --- | ---: |\n")
+		for _, c := range keys {
+			var size int64
+			for _, l := range s.chunks[c].Layers {
+				size += l.Size
+			}
+			fmt.Fprintf(&buf, "| `%s` | %.1f MiB |\n", c, float64(size)/(1024.0*1024.0))
+		}
+		buf.WriteString("\n")
+	}
+
+	if cache := s.CacheStats(); len(cache) > 0 {
+		buf.WriteString("| Build cache | Cached steps | Not cached |\n| --- | ---: | --- |\n")
+		for _, c := range cache {
+			executed := make([]string, len(c.Executed))
+			for i, e := range c.Executed {
+				executed[i] = "`" + strings.ReplaceAll(strings.Join(strings.Fields(e), " "), "|", "\\|") + "`"
+			}
+			fmt.Fprintf(&buf, "| %s | %d/%d (%.0f%%) | %s |\n", c.Chunk, c.Cached, c.Steps, 100*c.HitRatio, strings.Join(executed, "<br>"))
+		}
+		buf.WriteString("\n")
+	}
+
+	results := s.TestResults()
+	if len(results) == 0 {
+		return buf.Bytes()
+	}
+	var failed []string
+	buf.WriteString("| Tests | Passed | Failed | Skipped |\n| --- | ---: | ---: | ---: |\n")
+	for _, suite := range results {
+		counts := make(map[test.Outcome]int)
+		for _, r := range suite.Result {
+			if r == nil {
+				continue
+			}
+			outcome := r.Outcome()
+			counts[outcome]++
+			if msg, ok := failureMessage(r); ok {
+				failed = append(failed, fmt.Sprintf("- **%s**: %s (%s)", suite.Name, r.Desc, strings.ReplaceAll(msg, "\n", " ")))
+			}
+		}
+		fmt.Fprintf(&buf, "| %s | %d | %d | %d |\n", suite.Name, counts[test.OutcomePassed], counts[test.OutcomeFailed]+counts[test.OutcomeError], counts[test.OutcomeSkipped])
+	}
+	if len(failed) > 0 {
+		buf.WriteString("\n### Failed tests\n\n")
+		buf.WriteString(strings.Join(failed, "\n"))
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// GitHubAnnotations produces an ::error workflow command for each test which failed during this session
+func (s *BuildSession) GitHubAnnotations() []string {
+	var res []string
+	for _, suite := range s.TestResults() {
+		for _, r := range suite.Result {
+			if r == nil {
+				continue
+			}
+			msg, ok := failureMessage(r)
+			if !ok {
+				continue
+			}
+			title := fmt.Sprintf("%s: %s", suite.Name, r.Desc)
+			res = append(res, fmt.Sprintf("::error title=%s::%s", escapeGitHubProperty(title), escapeGitHubData(msg)))
+		}
+	}
+	return res
+}
+
+// failureMessage returns the message of a failed test or one which could not be run
+func failureMessage(r *test.Result) (msg string, failed bool) {
+	switch r.Outcome() {
+	case test.OutcomeFailed:
+		return r.Failure.Message, true
+	case test.OutcomeError:
+		return r.Error.Message, true
+	default:
+		return "", false
+	}
+}
+
+// escapeGitHubData escapes the message of a workflow command
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeGitHubProperty escapes a property value of a workflow command
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,62 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/gitpod-io/dazzle/pkg/test"
+)
+
+func TestGitHubSummary(t *testing.T) {
+	sess, err := NewSession(nil, "localhost:9999/test")
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	sess.recordChunk("localhost:9999/test:foo--abc", &ociv1.Manifest{Layers: []ociv1.Descriptor{{Size: 1024 * 1024}, {Size: 512 * 1024}}})
+	sess.recordTestResults("foo", test.Results{Result: []*test.Result{
+		{Desc: "it runs"},
+		{Desc: "it prints: 1, 2", Failure: &test.ErrResult{Message: "100% wrong\nsee output"}},
+		{Desc: "it is skipped", Skipped: true},
+	}})
+	sess.recordProgress("foo", ProgressSummary{Steps: 4, Cached: 3, Executed: []string{"RUN apt-get update &&\n    apt-get install -y foo | tee log"}})
+	sess.recordProgress("foo", ProgressSummary{Steps: 4, Cached: 3, Executed: []string{"RUN apt-get update &&\n    apt-get install -y foo | tee log"}})
+
+	expectSummary := "## dazzle\n\n" +
+		"| Chunk | Size |\n| --- | ---: |\n" +
+		"| `localhost:9999/test:foo--abc` | 1.5 MiB |\n\n" +
+		"| Build cache | Cached steps | Not cached |\n| --- | ---: | --- |\n" +
+		"| foo | 6/8 (75%) | `RUN apt-get update && apt-get install -y foo \\| tee log` |\n\n" +
+		"| Tests | Passed | Failed | Skipped |\n| --- | ---: | ---: | ---: |\n" +
+		"| foo | 1 | 1 | 1 |\n\n" +
+		"### Failed tests\n\n" +
+		"- **foo**: it prints: 1, 2 (100% wrong see output)\n"
+	if diff := cmp.Diff(expectSummary, string(sess.GitHubSummary())); diff != "" {
+		t.Errorf("GitHubSummary() mismatch (-want +got):\n%s", diff)
+	}
+
+	expectAnnotations := []string{"::error title=foo%3A it prints%3A 1%2C 2::100%25 wrong%0Asee output"}
+	if diff := cmp.Diff(expectAnnotations, sess.GitHubAnnotations()); diff != "" {
+		t.Errorf("GitHubAnnotations() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// importsDir holds the fetched imports of a project, each laid out like a project with a single chunk
+	importsDir = ".dazzle/imports"
+	// importSourceFN records which source an import was fetched from
+	importSourceFN = ".source"
+)
+
+// ChunkImport imports a chunk from another dazzle project, stored in a git repository or an OCI artifact
+type ChunkImport struct {
+	// Git is the URL of a git repository
+	Git string `yaml:"git,omitempty"`
+	// Ref is the branch, tag or commit to import from the git repository, defaults to its default branch
+	Ref string `yaml:"ref,omitempty"`
+	// OCI is the ref of an OCI artifact whose layers are tar archives of the project
+	OCI string `yaml:"oci,omitempty"`
+	// Path is the path of the chunk directory within the project, e.g. chunks/go.
+	// The chunk's tests are expected in the project's tests directory.
+	Path string `yaml:"path" jsonschema:"required"`
+	// Name is the name of the chunk in this project, defaults to the last element of the path
+	Name string `yaml:"name,omitempty"`
+}
+
+// ChunkName is the name of the imported chunk in this project
+func (i ChunkImport) ChunkName() string {
+	if i.Name != "" {
+		return i.Name
+	}
+	return path.Base(i.Path)
+}
+
+// source identifies what was fetched, so that fetching again is only necessary when the import changed
+func (i ChunkImport) source() string {
+	if i.OCI != "" {
+		return fmt.Sprintf("oci %s %s", i.OCI, i.Path)
+	}
+	return fmt.Sprintf("git %s %s %s", i.Git, i.Ref, i.Path)
+}
+
+func (i ChunkImport) validate() error {
+	switch {
+	case i.Git == "" && i.OCI == "":
+		return fmt.Errorf("import of %s: one of git or oci is required", i.Path)
+	case i.Git != "" && i.OCI != "":
+		return fmt.Errorf("import of %s: git and oci are mutually exclusive", i.Path)
+	case i.OCI != "" && i.Ref != "":
+		return fmt.Errorf("import of %s: ref applies to git imports only, use the tag or digest of the oci ref instead", i.Path)
+	case i.Path == "" || !fs.ValidPath(i.Path) || i.Path == ".":
+		return fmt.Errorf("import of %q: path must point to a chunk directory within the project", i.Path)
+	}
+	name := i.ChunkName()
+	if name == "" || name == "." || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") || strings.ContainsAny(name, ":/\\") {
+		return fmt.Errorf("import of %s: %q is not a valid chunk name", i.Path, name)
+	}
+	return nil
+}
+
+func validateImports(imports []ChunkImport) error {
+	names := make(map[string]struct{}, len(imports))
+	for _, imp := range imports {
+		err := imp.validate()
+		if err != nil {
+			return err
+		}
+		if _, exists := names[imp.ChunkName()]; exists {
+			return fmt.Errorf("chunk %s is imported more than once", imp.ChunkName())
+		}
+		names[imp.ChunkName()] = struct{}{}
+	}
+	return nil
+}
+
+// openImport returns the directory of a fetched import
+func openImport(dir fs.FS, imp ChunkImport) (fs.FS, error) {
+	name := imp.ChunkName()
+	idir := path.Join(importsDir, name)
+	src, err := fs.ReadFile(dir, path.Join(idir, importSourceFN))
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && string(src) != imp.source()) {
+		return nil, fmt.Errorf("chunk %s is imported but not fetched, run dazzle project fetch", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fs.Sub(dir, idir)
+}
+
+// loadImportedChunks loads the chunks of a fetched import
+func loadImportedChunks(dir fs.FS, contextBase string, imp ChunkImport) ([]ProjectChunk, error) {
+	name := imp.ChunkName()
+	sub, err := openImport(dir, imp)
+	if err != nil {
+		return nil, err
+	}
+	res, err := loadChunks(sub, filepath.Join(contextBase, importsDir, name), chunksDir, name, defaultTestsDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load imported chunk %s: %w", name, err)
+	}
+	return res, nil
+}
+
+// FetchImports downloads the chunks the project in contextBase imports, unless they were fetched already
+// or force is true. Git imports require a git binary. It returns the names of the chunks it fetched.
+func FetchImports(ctx context.Context, logger log.FieldLogger, contextBase string, resolver remotes.Resolver, force bool) (fetched []string, err error) {
+	defer func() {
+		err = withKind(ErrorKindConfig, err)
+	}()
+
+	cfg, err := LoadProjectConfig(os.DirFS(contextBase))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, imp := range cfg.Imports {
+		name := imp.ChunkName()
+		idir := filepath.Join(contextBase, importsDir, name)
+		if src, err := os.ReadFile(filepath.Join(idir, importSourceFN)); err == nil && string(src) == imp.source() && !force {
+			logger.WithField("chunk", name).Debug("import is up to date")
+			continue
+		}
+
+		logger.WithField("chunk", name).WithField("source", imp.source()).Info("fetching import")
+		err = fetchImport(ctx, resolver, imp, idir)
+		if err != nil {
+			return fetched, fmt.Errorf("cannot fetch import of chunk %s: %w", name, err)
+		}
+		fetched = append(fetched, name)
+	}
+	return fetched, nil
+}
+
+func fetchImport(ctx context.Context, resolver remotes.Resolver, imp ChunkImport, dst string) error {
+	tmp, err := os.MkdirTemp("", "dazzle-import-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	srcdir := filepath.Join(tmp, "src")
+	if imp.OCI != "" {
+		err = fetchOCIImport(ctx, resolver, imp.OCI, srcdir)
+	} else {
+		err = fetchGitImport(ctx, imp.Git, imp.Ref, srcdir)
+	}
+	if err != nil {
+		return err
+	}
+
+	// lay the import out like a project with a single chunk, so that it loads like any other chunk
+	var (
+		name     = imp.ChunkName()
+		outdir   = filepath.Join(tmp, "out")
+		chunkdir = filepath.Join(srcdir, filepath.FromSlash(imp.Path))
+		srcroot  = filepath.Dir(filepath.Dir(chunkdir))
+		testsdir = filepath.Join(srcroot, defaultTestsDir)
+		srcname  = filepath.Base(chunkdir)
+	)
+	if stat, err := os.Stat(chunkdir); err != nil {
+		return fmt.Errorf("%s not found: %w", imp.Path, err)
+	} else if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory", imp.Path)
+	}
+	if cfg, err := LoadProjectConfig(os.DirFS(srcroot)); err == nil {
+		testsdir = filepath.Join(srcroot, filepath.FromSlash(cfg.TestsDir()))
+	}
+	err = copyDir(chunkdir, filepath.Join(outdir, chunksDir, name))
+	if err != nil {
+		return err
+	}
+	tfs, err := filepath.Glob(filepath.Join(testsdir, srcname+"--*"))
+	if err != nil {
+		return err
+	}
+	for _, tf := range append(tfs, filepath.Join(testsdir, srcname+".yaml"), filepath.Join(testsdir, srcname)) {
+		stat, err := os.Stat(tf)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(outdir, defaultTestsDir, name+strings.TrimPrefix(filepath.Base(tf), srcname))
+		if stat.IsDir() {
+			err = copyDir(tf, dst)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		fc, err := os.ReadFile(tf)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(dst, fc, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.WriteFile(filepath.Join(outdir, importSourceFN), []byte(imp.source()), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(dst)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(outdir, dst)
+	if err != nil {
+		// the temp dir might be on another file system
+		err = copyDir(outdir, dst)
+	}
+	return err
+}
+
+func fetchGitImport(ctx context.Context, url, ref, dst string) error {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet", dst},
+		{"-C", dst, "fetch", "--quiet", "--depth", "1", url, ref},
+		{"-C", dst, "checkout", "--quiet", "FETCH_HEAD"},
+	} {
+		out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+func fetchOCIImport(ctx context.Context, resolver remotes.Resolver, ociref, dst string) error {
+	ref, err := reference.ParseNormalizedNamed(ociref)
+	if err != nil {
+		return err
+	}
+	ref = reference.TagNameOnly(ref)
+
+	_, _, mf, err := fetchManifest(ctx, resolver, ref)
+	if err != nil {
+		return err
+	}
+	fetcher, err := resolver.Fetcher(ctx, ref.String())
+	if err != nil {
+		return err
+	}
+	for _, layer := range mf.Layers {
+		rc, err := fetcher.Fetch(ctx, layer)
+		if err != nil {
+			return err
+		}
+		err = extractTar(rc, dst)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("cannot extract layer %s: %w", layer.Digest, err)
+		}
+	}
+	return nil
+}
+
+// extractTar extracts a (possibly gzipped) tar archive to dst, refusing entries which would end up outside of dst
+func extractTar(r io.Reader, dst string) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	} else {
+		r = br
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if name == "." {
+			continue
+		}
+		if !fs.ValidPath(name) {
+			return fmt.Errorf("%s is outside of the archive", hdr.Name)
+		}
+		fn := filepath.Join(dst, filepath.FromSlash(name))
+		err = checkNoSymlinks(dst, name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(fn, 0755)
+		case tar.TypeReg:
+			err = os.MkdirAll(filepath.Dir(fn), 0755)
+			if err != nil {
+				return err
+			}
+			var f *os.File
+			f, err = os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		case tar.TypeSymlink:
+			// no entry is written through a symlink, hence the link path is where the symlink ends up on disk
+			// and checking it as text suffices to keep readers of the import within dst
+			if path.IsAbs(hdr.Linkname) || !fs.ValidPath(path.Join(path.Dir(name), hdr.Linkname)) {
+				return fmt.Errorf("symlink %s points outside of the archive", hdr.Name)
+			}
+			err = os.MkdirAll(filepath.Dir(fn), 0755)
+			if err != nil {
+				return err
+			}
+			err = os.Symlink(hdr.Linkname, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkNoSymlinks makes sure that neither an archive entry nor any of its parent directories is a symlink on disk,
+// so that extracting the entry cannot write through a symlink an earlier entry created
+func checkNoSymlinks(dst, name string) error {
+	fn := dst
+	for _, seg := range strings.Split(name, "/") {
+		fn = filepath.Join(fn, seg)
+		stat, err := os.Lstat(fn)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if stat.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%s would be written through symlink %s", name, filepath.ToSlash(strings.TrimPrefix(fn, dst+string(filepath.Separator))))
+		}
+	}
+	return nil
+}
+
+// copyDir copies a directory tree with its regular files, directories and symlinks
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, fn)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(fn)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			fc, err := os.ReadFile(fn)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, fc, info.Mode().Perm())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,257 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadImports(t *testing.T) {
+	var (
+		base       = &fstest.MapFile{Data: []byte("FROM alpine")}
+		dockerfile = &fstest.MapFile{Data: []byte("ARG base\nFROM ${base}")}
+		config     = &fstest.MapFile{Data: []byte("imports:\n- git: https://github.com/example/chunks.git\n  ref: v1\n  path: chunks/go\n  name: golang\n")}
+		source     = &fstest.MapFile{Data: []byte("git https://github.com/example/chunks.git v1 chunks/go")}
+	)
+	type Expectation struct {
+		Err    string
+		Chunks []string
+		Tests  map[string]int
+	}
+	tests := []struct {
+		Name        string
+		FS          map[string]*fstest.MapFile
+		Expectation Expectation
+	}{
+		{
+			Name: "fetched import",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":                                     config,
+				"base/Dockerfile":                                 base,
+				"chunks/node/Dockerfile":                          dockerfile,
+				".dazzle/imports/golang/.source":                  source,
+				".dazzle/imports/golang/chunks/golang/Dockerfile": dockerfile,
+				".dazzle/imports/golang/chunks/golang/chunk.yaml": {Data: []byte("variants:\n- name: \"1.20\"\n- name: \"1.21\"\n")},
+				".dazzle/imports/golang/tests/golang.yaml":        {Data: []byte("- desc: shared\n  command: [go, version]\n")},
+				".dazzle/imports/golang/tests/golang--1.21.yaml":  {Data: []byte("- desc: 1.21 only\n  command: [go, version]\n")},
+			},
+			Expectation: Expectation{
+				Chunks: []string{"node", "golang:1.20", "golang:1.21"},
+				Tests:  map[string]int{"node": 0, "golang:1.20": 1, "golang:1.21": 2},
+			},
+		},
+		{
+			Name: "not fetched",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":     config,
+				"base/Dockerfile": base,
+			},
+			Expectation: Expectation{Err: "chunk golang is imported but not fetched, run dazzle project fetch"},
+		},
+		{
+			Name: "fetched from another source",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":                    config,
+				"base/Dockerfile":                base,
+				".dazzle/imports/golang/.source": {Data: []byte("git https://github.com/example/chunks.git v0 chunks/go")},
+			},
+			Expectation: Expectation{Err: "chunk golang is imported but not fetched, run dazzle project fetch"},
+		},
+		{
+			Name: "conflicting local chunk",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":                    config,
+				"base/Dockerfile":                base,
+				"chunks/golang/Dockerfile":       dockerfile,
+				".dazzle/imports/golang/.source": source,
+			},
+			Expectation: Expectation{Err: "chunk golang is imported but exists in chunks as well"},
+		},
+		{
+			Name: "invalid import",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":     {Data: []byte("imports:\n- git: https://github.com/example/chunks.git\n  oci: example.com/chunks:v1\n  path: chunks/go\n")},
+				"base/Dockerfile": base,
+			},
+			Expectation: Expectation{Err: "cannot load config from dazzle.yaml: import of chunks/go: git and oci are mutually exclusive"},
+		},
+		{
+			Name: "duplicate import",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":     {Data: []byte("imports:\n- git: https://github.com/example/chunks.git\n  path: chunks/go\n- oci: example.com/chunks:v1\n  path: chunks/go\n")},
+				"base/Dockerfile": base,
+			},
+			Expectation: Expectation{Err: "cannot load config from dazzle.yaml: chunk go is imported more than once"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if _, ok := test.FS["chunks/node/Dockerfile"]; !ok {
+				test.FS["chunks/.gitkeep"] = &fstest.MapFile{}
+			}
+			prj, err := LoadFromDir("", LoadFromDirOpts{
+				FS: func(dir string) fs.FS { return fstest.MapFS(test.FS) },
+			})
+			var act Expectation
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Tests = make(map[string]int, len(prj.Chunks))
+				for _, chk := range prj.Chunks {
+					act.Chunks = append(act.Chunks, chk.Name)
+					act.Tests[chk.Name] = len(chk.Tests)
+				}
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("LoadFromDir() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	type entry struct {
+		Name     string
+		Linkname string
+		Content  string
+	}
+	tests := []struct {
+		Name        string
+		Entries     []entry
+		Expectation map[string]string
+		Error       bool
+	}{
+		{
+			Name: "project",
+			Entries: []entry{
+				{Name: "./chunks/go/Dockerfile", Content: "FROM golang"},
+				{Name: "tests/go.yaml", Content: "[]"},
+				{Name: "chunks/go/latest", Linkname: "Dockerfile"},
+			},
+			Expectation: map[string]string{
+				"chunks/go/Dockerfile": "FROM golang",
+				"chunks/go/latest":     "FROM golang",
+				"tests/go.yaml":        "[]",
+			},
+		},
+		{
+			Name:    "path traversal",
+			Entries: []entry{{Name: "../evil", Content: "x"}},
+			Error:   true,
+		},
+		{
+			Name: "symlink traversal",
+			Entries: []entry{
+				{Name: "chunks", Linkname: "../.."},
+				{Name: "chunks/evil", Content: "x"},
+			},
+			Error: true,
+		},
+		{
+			Name: "chained symlinks",
+			Entries: []entry{
+				{Name: "l", Linkname: "."},
+				{Name: "l/m", Linkname: ".."},
+				{Name: "l/m/evil", Content: "x"},
+			},
+			Error: true,
+		},
+		{
+			Name: "write through symlink",
+			Entries: []entry{
+				{Name: "Dockerfile", Content: "FROM golang"},
+				{Name: "latest", Linkname: "Dockerfile"},
+				{Name: "latest", Content: "FROM alpine"},
+			},
+			Error: true,
+		},
+		{
+			Name:    "absolute symlink",
+			Entries: []entry{{Name: "etc", Linkname: "/etc"}},
+			Error:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, e := range test.Entries {
+				hdr := &tar.Header{Name: e.Name, Mode: 0644, Size: int64(len(e.Content)), Typeflag: tar.TypeReg}
+				if e.Linkname != "" {
+					hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.Linkname, 0
+				}
+				err := tw.WriteHeader(hdr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = tw.Write([]byte(e.Content))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := tw.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dst := t.TempDir()
+			err = extractTar(&buf, dst)
+			if test.Error {
+				if err == nil {
+					t.Error("extractTar() succeeded but should have failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			act := make(map[string]string)
+			err = filepath.WalkDir(dst, func(fn string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				fc, err := os.ReadFile(fn)
+				if err != nil {
+					return err
+				}
+				rel, _ := filepath.Rel(dst, fn)
+				act[filepath.ToSlash(rel)] = string(fc)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("extractTar() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// chunkIndex lists the chunks of a project along with the chunks they depend on. It is built from the
+// chunk.yaml files alone, so that LoadFromDir can pick the chunks to load without reading the Dockerfiles
+// and tests of all of them.
+type chunkIndex struct {
+	// dirs are the chunk directories in the order LoadFromDir loads them
+	dirs []indexedChunkDir
+	// ignored lists the chunks excluded by the project's ignore patterns
+	ignored []string
+}
+
+// indexedChunkDir is a chunk directory of the index
+type indexedChunkDir struct {
+	// name is the name of the chunk without its variants
+	name string
+	// imp is the import the chunk comes from, or nil if the chunk lives in the chunks directory
+	imp *ChunkImport
+	// chunks are the names of the chunks which are not ignored, including their variants
+	chunks []string
+	// dependsOn are the chunks the chunks of this directory depend on
+	dependsOn []string
+}
+
+// buildChunkIndex reads the chunk.yaml of all chunks of a project, including imported ones
+func buildChunkIndex(dir fs.FS, cfg *ProjectConfig) (*chunkIndex, error) {
+	chds, err := fs.ReadDir(dir, chunksDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var res chunkIndex
+	add := func(d indexedChunkDir, ccfg *ChunkConfig) {
+		names := []string{d.name}
+		if ccfg != nil && len(ccfg.Variants) > 0 {
+			names = make([]string, 0, len(ccfg.Variants))
+			for _, v := range ccfg.Variants {
+				names = append(names, fmt.Sprintf("%s:%s", d.name, v.Name))
+			}
+		}
+		for _, n := range names {
+			if cfg.chunkIgnores != nil && cfg.chunkIgnores.MatchesPath(n) {
+				res.ignored = append(res.ignored, n)
+				continue
+			}
+			d.chunks = append(d.chunks, n)
+		}
+		if ccfg != nil {
+			for _, dep := range ccfg.DependsOn {
+				d.dependsOn = append(d.dependsOn, dep.Chunk)
+			}
+		}
+		res.dirs = append(res.dirs, d)
+	}
+
+	for _, chd := range chds {
+		if strings.HasPrefix(chd.Name(), "_") || strings.HasPrefix(chd.Name(), ".") {
+			continue
+		}
+		if !chd.IsDir() {
+			continue
+		}
+		ccfg, err := loadChunkConfig(dir, path.Join(chunksDir, chd.Name()))
+		if err != nil {
+			return nil, err
+		}
+		add(indexedChunkDir{name: chd.Name()}, ccfg)
+	}
+	for i := range cfg.Imports {
+		imp := &cfg.Imports[i]
+		name := imp.ChunkName()
+		for _, chd := range chds {
+			if chd.Name() == name {
+				return nil, fmt.Errorf("chunk %s is imported but exists in %s as well", name, chunksDir)
+			}
+		}
+		sub, err := openImport(dir, *imp)
+		if err != nil {
+			return nil, err
+		}
+		ccfg, err := loadChunkConfig(sub, path.Join(chunksDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("cannot load imported chunk %s: %w", name, err)
+		}
+		add(indexedChunkDir{name: name, imp: imp}, ccfg)
+	}
+
+	return &res, nil
+}
+
+// has returns true if name is one of the chunks of the directory
+func (d *indexedChunkDir) has(name string) bool {
+	for _, n := range d.chunks {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedBy returns true if any chunk of the directory is selected
+func (d *indexedChunkDir) selectedBy(selected map[string]bool) bool {
+	for _, n := range d.chunks {
+		if selected[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns the names of all chunks of the index which are not ignored, including their variants
+func (idx *chunkIndex) names() []string {
+	var res []string
+	for _, d := range idx.dirs {
+		res = append(res, d.chunks...)
+	}
+	return res
+}
+
+// selectChunks returns the chunks of the names, along with all chunks they depend on, directly or not.
+// The base is always loaded, hence selecting it does not add anything.
+func (idx *chunkIndex) selectChunks(names []string) (map[string]bool, error) {
+	byName := make(map[string]*indexedChunkDir)
+	for i, d := range idx.dirs {
+		for _, n := range d.chunks {
+			byName[n] = &idx.dirs[i]
+		}
+	}
+
+	var (
+		res   = make(map[string]bool, len(names))
+		visit func(name string)
+	)
+	visit = func(name string) {
+		d, ok := byName[name]
+		if !ok || res[name] {
+			// dependencies which do not exist are reported when the dependencies are resolved
+			return
+		}
+		res[name] = true
+		for _, dep := range d.dependsOn {
+			visit(dep)
+		}
+	}
+	for _, n := range names {
+		if n == "base" {
+			continue
+		}
+		if _, ok := byName[n]; !ok {
+			if containsIgnored(idx.ignored, n) {
+				return nil, fmt.Errorf("chunk %s is ignored", n)
+			}
+			return nil, fmt.Errorf("chunk %s not found", n)
+		}
+		visit(n)
+	}
+	return res, nil
+}
+
+// LoadChunkNames returns the names of the chunks of a project, including their variants, without loading
+// the chunks themselves. Chunks excluded by the project's ignore patterns are left out.
+// All errors it returns are of ErrorKindConfig.
+func LoadChunkNames(contextBase string, opts LoadFromDirOpts) (_ []string, err error) {
+	defer func() {
+		err = withKind(ErrorKindConfig, err)
+	}()
+
+	if opts.FS == nil {
+		opts.FS = os.DirFS
+	}
+	dir := opts.FS(contextBase)
+
+	cfg, err := LoadProjectConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := buildChunkIndex(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return idx.names(), nil
+}
@@ -0,0 +1,172 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadFromDir_chunks(t *testing.T) {
+	project := fstest.MapFS{
+		"dazzle.yaml":               {Data: []byte("ignore: [debug]\ncombiner: {}\n")},
+		"base/Dockerfile":           {Data: []byte("FROM alpine")},
+		"chunks/app/Dockerfile":     {Data: []byte("FROM ubuntu\n# {{ len .Chunks }} chunks")},
+		"chunks/app/chunk.yaml":     {Data: []byte("template: true\ndependsOn:\n- chunk: tools\n")},
+		"chunks/tools/Dockerfile":   {Data: []byte("FROM ubuntu")},
+		"chunks/tools/chunk.yaml":   {Data: []byte("dependsOn:\n- chunk: golang:1.21\n")},
+		"chunks/golang/Dockerfile":  {Data: []byte("FROM ubuntu")},
+		"chunks/golang/chunk.yaml":  {Data: []byte("variants:\n- name: \"1.20\"\n- name: \"1.21\"\n")},
+		"chunks/debug/Dockerfile":   {Data: []byte("FROM ubuntu")},
+		"chunks/node/Dockerfile":    {Data: []byte("FROM ubuntu")},
+		"chunks/broken/chunk.yaml":  {Data: []byte("platforms: [linux/amd64]\n")},
+		"chunks/_shared/Dockerfile": {Data: []byte("RUN true")},
+	}
+
+	type Expectation struct {
+		Err         string
+		Dockerfiles map[string]string
+	}
+	tests := []struct {
+		Name        string
+		Chunks      []string
+		Expectation Expectation
+	}{
+		{
+			Name:        "all chunks",
+			Expectation: Expectation{Err: "open chunks/broken/Dockerfile: file does not exist"},
+		},
+		{
+			Name:   "dependencies",
+			Chunks: []string{"app"},
+			Expectation: Expectation{
+				Dockerfiles: map[string]string{
+					"app":         "FROM ubuntu\n# 6 chunks",
+					"tools":       "FROM ubuntu",
+					"golang:1.21": "FROM ubuntu",
+				},
+			},
+		},
+		{
+			Name:   "variant",
+			Chunks: []string{"golang:1.20", "base"},
+			Expectation: Expectation{
+				Dockerfiles: map[string]string{
+					"golang:1.20": "FROM ubuntu",
+				},
+			},
+		},
+		{
+			Name:        "chunk without variant",
+			Chunks:      []string{"golang"},
+			Expectation: Expectation{Err: "chunk golang not found"},
+		},
+		{
+			Name:        "ignored",
+			Chunks:      []string{"debug"},
+			Expectation: Expectation{Err: "chunk debug is ignored"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			prj, err := LoadFromDir("", LoadFromDirOpts{
+				FS:     func(string) fs.FS { return project },
+				Chunks: test.Chunks,
+			})
+			var act Expectation
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Dockerfiles = make(map[string]string, len(prj.Chunks))
+				for _, chk := range prj.Chunks {
+					act.Dockerfiles[chk.Name] = string(chk.Dockerfile)
+				}
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("LoadFromDir() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadFromDir_chunksMatchFullLoad(t *testing.T) {
+	project := fstest.MapFS{
+		"dazzle.yaml":              {Data: []byte("combiner: {}\n")},
+		"base/Dockerfile":          {Data: []byte("FROM alpine")},
+		"chunks/app/Dockerfile":    {Data: []byte("FROM ubuntu\n# {{ range .Chunks }}{{ . }} {{ end }}")},
+		"chunks/app/chunk.yaml":    {Data: []byte("template: true\ndependsOn:\n- chunk: golang:1.21\n")},
+		"chunks/golang/Dockerfile": {Data: []byte("FROM ubuntu")},
+		"chunks/golang/chunk.yaml": {Data: []byte("variants:\n- name: \"1.20\"\n- name: \"1.21\"\n")},
+		"chunks/node/Dockerfile":   {Data: []byte("FROM ubuntu")},
+		"tests/app.yaml":           {Data: []byte("- desc: app\n  command: [app]\n")},
+	}
+	load := func(chunks []string) map[string]ProjectChunk {
+		prj, err := LoadFromDir("", LoadFromDirOpts{
+			FS:     func(string) fs.FS { return project },
+			Chunks: chunks,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := make(map[string]ProjectChunk, len(prj.Chunks))
+		for _, chk := range prj.Chunks {
+			res[chk.Name] = chk
+		}
+		return res
+	}
+
+	full, partial := load(nil), load([]string{"app"})
+	if len(partial) != 2 {
+		t.Errorf("LoadFromDir() loaded %d chunks, expected 2", len(partial))
+	}
+	for name, chk := range partial {
+		exp := full[name]
+		if diff := cmp.Diff(string(exp.Dockerfile), string(chk.Dockerfile)); diff != "" {
+			t.Errorf("chunk %s: Dockerfile mismatch (-want +got):\n%s", name, diff)
+		}
+		if diff := cmp.Diff(exp.Tests, chk.Tests); diff != "" {
+			t.Errorf("chunk %s: tests mismatch (-want +got):\n%s", name, diff)
+		}
+		if diff := cmp.Diff(exp.DependsOn, chk.DependsOn); diff != "" {
+			t.Errorf("chunk %s: dependencies mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+}
+
+func TestLoadChunkNames(t *testing.T) {
+	project := fstest.MapFS{
+		"dazzle.yaml":              {Data: []byte("ignore: [\"golang:1.20\"]\ncombiner: {}\n")},
+		"chunks/golang/chunk.yaml": {Data: []byte("variants:\n- name: \"1.20\"\n- name: \"1.21\"\n")},
+		"chunks/node/Dockerfile":   {Data: []byte("FROM ubuntu")},
+		"chunks/.git/Dockerfile":   {Data: []byte("FROM ubuntu")},
+	}
+	act, err := LoadChunkNames("", LoadFromDirOpts{FS: func(string) fs.FS { return project }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"golang:1.21", "node"}, act); diff != "" {
+		t.Errorf("LoadChunkNames() mismatch (-want +got):\n%s", diff)
+	}
+}
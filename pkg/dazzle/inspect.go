@@ -0,0 +1,199 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// TestStatus describes which tests ran before an image was pushed
+type TestStatus string
+
+const (
+	// TestStatusPassed means all tests ran and passed
+	TestStatusPassed TestStatus = "passed"
+	// TestStatusPartial means only a subset of the tests ran, e.g. because of test filters
+	TestStatusPartial TestStatus = "partial"
+	// TestStatusSkipped means tests were disabled
+	TestStatusSkipped TestStatus = "skipped"
+	// TestStatusNone means there are no tests
+	TestStatusNone TestStatus = "none"
+	// TestStatusUnknown means the image does not record its test status, e.g. because it was built by an older dazzle version
+	TestStatusUnknown TestStatus = "unknown"
+)
+
+const baseLayerOwner = "base"
+
+// ImageKind describes what kind of dazzle image we look at
+type ImageKind string
+
+const (
+	// ImageKindChunk is a chunk image with the base layers removed
+	ImageKindChunk ImageKind = "chunk"
+	// ImageKindCombination is a combination of chunks
+	ImageKindCombination ImageKind = "combination"
+	// ImageKindOther is an image dazzle did not produce, e.g. a base image
+	ImageKindOther ImageKind = "other"
+)
+
+// ImageInspection is the dazzle metadata of an image
+type ImageInspection struct {
+	Ref     string              `yaml:"ref" json:"ref"`
+	Kind    ImageKind           `yaml:"kind" json:"kind"`
+	Chunk   string              `yaml:"chunk,omitempty" json:"chunk,omitempty"`
+	BaseRef string              `yaml:"baseRef,omitempty" json:"baseRef,omitempty"`
+	Tests   TestStatus          `yaml:"tests" json:"tests"`
+	EnvVars []EnvVarDescription `yaml:"envvars,omitempty" json:"envvars,omitempty"`
+	// Chunks lists the members of a combination in layer order
+	Chunks []ChunkProvenance `yaml:"chunks,omitempty" json:"chunks,omitempty"`
+	Layers []LayerProvenance `yaml:"layers" json:"layers"`
+}
+
+// ChunkProvenance describes a member of a combination: the base or a chunk and the layers it contributed
+type ChunkProvenance struct {
+	Chunk string `yaml:"chunk" json:"chunk"`
+	// Hash is the chunk hash which is also part of the chunk image tag
+	Hash string `yaml:"hash,omitempty" json:"hash,omitempty"`
+	// Layers is the number of consecutive layers the chunk contributed
+	Layers int `yaml:"layers" json:"layers"`
+}
+
+// LayerProvenance describes where a layer of an image comes from
+type LayerProvenance struct {
+	Digest digest.Digest `yaml:"digest" json:"digest"`
+	Size   int64         `yaml:"size" json:"size"`
+	// Chunk is the chunk which contributed the layer, "base" for base layers or empty if unknown
+	Chunk string `yaml:"chunk,omitempty" json:"chunk,omitempty"`
+	// ChunkHash is the hash of the chunk which contributed the layer, if known
+	ChunkHash string `yaml:"chunkHash,omitempty" json:"chunkHash,omitempty"`
+	// CreatedBy is the build step which produced the layer, taken from the image history
+	CreatedBy string `yaml:"createdBy,omitempty" json:"createdBy,omitempty"`
+}
+
+// InspectImage assembles the dazzle metadata of a chunk or combination image from its manifest, annotations and config
+func InspectImage(ctx context.Context, registry Registry, ref reference.Named) (*ImageInspection, error) {
+	_, mf, cfg, err := getImageMetadata(ctx, ref, registry)
+	if err != nil {
+		return nil, err
+	}
+	res := inspectManifest(mf, cfg)
+	res.Ref = ref.String()
+	return res, nil
+}
+
+func inspectManifest(mf *ociv1.Manifest, cfg *ociv1.Image) *ImageInspection {
+	res := &ImageInspection{
+		Kind:    ImageKindOther,
+		Chunk:   mf.Annotations[mfAnnotationChunk],
+		BaseRef: mf.Annotations[mfAnnotationBaseRef],
+		Tests:   TestStatus(mf.Annotations[mfAnnotationTests]),
+	}
+	if res.Tests == "" {
+		res.Tests = TestStatusUnknown
+	}
+
+	var owned bool
+	for _, l := range mf.Layers {
+		if _, ok := l.Annotations[mfAnnotationChunk]; ok {
+			owned = true
+			break
+		}
+	}
+	if cfg != nil {
+		if lbl, ok := cfg.Config.Labels[cfgLabelChunks]; ok {
+			// a malformed label only costs us the attribution of layers without annotations
+			_ = json.Unmarshal([]byte(lbl), &res.Chunks)
+		}
+	}
+	switch {
+	case owned || len(res.Chunks) > 0:
+		res.Kind = ImageKindCombination
+	case res.Chunk != "" || res.BaseRef != "":
+		// chunk images built by older dazzle versions only carry the base ref
+		res.Kind = ImageKindChunk
+	}
+
+	for k, v := range mf.Annotations {
+		if !strings.HasPrefix(k, mfAnnotationEnvVar) {
+			continue
+		}
+		res.EnvVars = append(res.EnvVars, EnvVarDescription{
+			Name:   strings.TrimPrefix(k, mfAnnotationEnvVar),
+			Action: EnvVarCombinationAction(v),
+		})
+	}
+	sort.Slice(res.EnvVars, func(i, j int) bool { return res.EnvVars[i].Name < res.EnvVars[j].Name })
+
+	// history entries of empty layers do not correspond to a layer in the manifest
+	var createdBy []string
+	if cfg != nil {
+		for _, h := range cfg.History {
+			if h.EmptyLayer {
+				continue
+			}
+			createdBy = append(createdBy, h.CreatedBy)
+		}
+	}
+	// the label attributes layers whose descriptor annotations got lost, e.g. when the image was copied
+	owners := labelledOwners(res.Chunks, len(mf.Layers))
+	res.Layers = make([]LayerProvenance, len(mf.Layers))
+	for i, l := range mf.Layers {
+		p := LayerProvenance{
+			Digest:    l.Digest,
+			Size:      l.Size,
+			Chunk:     l.Annotations[mfAnnotationChunk],
+			ChunkHash: l.Annotations[mfAnnotationChunkHash],
+		}
+		if p.Chunk == "" && res.Kind == ImageKindChunk {
+			p.Chunk = res.Chunk
+		}
+		if p.Chunk == "" && owners != nil {
+			p.Chunk, p.ChunkHash = owners[i].Chunk, owners[i].Hash
+		}
+		if len(createdBy) == len(mf.Layers) {
+			p.CreatedBy = createdBy[i]
+		}
+		res.Layers[i] = p
+	}
+	return res
+}
+
+// labelledOwners expands the members of a combination to the owner of each layer, or returns nil
+// if the members do not account for exactly n layers
+func labelledOwners(members []ChunkProvenance, n int) []ChunkProvenance {
+	var res []ChunkProvenance
+	for _, m := range members {
+		for i := 0; i < m.Layers; i++ {
+			res = append(res, m)
+		}
+	}
+	if len(res) != n || n == 0 {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,202 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestInspectManifest(t *testing.T) {
+	tests := []struct {
+		name   string
+		mf     *ociv1.Manifest
+		cfg    *ociv1.Image
+		expect *ImageInspection
+	}{
+		{
+			name: "chunk",
+			mf: &ociv1.Manifest{
+				Annotations: map[string]string{
+					mfAnnotationBaseRef: "eu.gcr.io/foo/bar:base--abc",
+					mfAnnotationChunk:   "go:1.19",
+					mfAnnotationTests:   "passed",
+				},
+				Layers: []ociv1.Descriptor{
+					{Digest: "sha256:l1", Size: 10},
+					{Digest: "sha256:l2", Size: 20},
+				},
+			},
+			cfg: &ociv1.Image{
+				History: []ociv1.History{
+					{CreatedBy: "ENV GOPATH=/go", EmptyLayer: true},
+					{CreatedBy: "RUN install-go"},
+					{CreatedBy: "COPY . /"},
+				},
+			},
+			expect: &ImageInspection{
+				Kind:    ImageKindChunk,
+				Chunk:   "go:1.19",
+				BaseRef: "eu.gcr.io/foo/bar:base--abc",
+				Tests:   TestStatusPassed,
+				Layers: []LayerProvenance{
+					{Digest: "sha256:l1", Size: 10, Chunk: "go:1.19", CreatedBy: "RUN install-go"},
+					{Digest: "sha256:l2", Size: 20, Chunk: "go:1.19", CreatedBy: "COPY . /"},
+				},
+			},
+		},
+		{
+			name: "combination",
+			mf: &ociv1.Manifest{
+				Annotations: map[string]string{
+					mfAnnotationBaseRef:         "eu.gcr.io/foo/bar:base--abc",
+					mfAnnotationTests:           "skipped",
+					mfAnnotationEnvVar + "PATH": "merge",
+					mfAnnotationEnvVar + "HOME": "use-last",
+				},
+				Layers: []ociv1.Descriptor{
+					withLayerOwner(ociv1.Descriptor{Digest: "sha256:b1"}, ChunkProvenance{Chunk: baseLayerOwner, Hash: "abc"}),
+					withLayerOwner(ociv1.Descriptor{Digest: "sha256:l1"}, ChunkProvenance{Chunk: "go", Hash: "def"}),
+					withLayerOwner(ociv1.Descriptor{Digest: "sha256:n1"}, ChunkProvenance{Chunk: "node"}),
+				},
+			},
+			expect: &ImageInspection{
+				Kind:    ImageKindCombination,
+				BaseRef: "eu.gcr.io/foo/bar:base--abc",
+				Tests:   TestStatusSkipped,
+				EnvVars: []EnvVarDescription{
+					{Name: "HOME", Action: EnvVarCombineUseLast},
+					{Name: "PATH", Action: EnvVarCombineMerge},
+				},
+				Layers: []LayerProvenance{
+					{Digest: "sha256:b1", Chunk: baseLayerOwner, ChunkHash: "abc"},
+					{Digest: "sha256:l1", Chunk: "go", ChunkHash: "def"},
+					{Digest: "sha256:n1", Chunk: "node"},
+				},
+			},
+		},
+		{
+			name: "combination without layer annotations",
+			mf: &ociv1.Manifest{
+				Layers: []ociv1.Descriptor{
+					{Digest: "sha256:b1"},
+					{Digest: "sha256:l1"},
+					{Digest: "sha256:l2"},
+				},
+			},
+			cfg: &ociv1.Image{
+				Config: ociv1.ImageConfig{
+					Labels: map[string]string{
+						cfgLabelChunks: `[{"chunk":"base","hash":"abc","layers":1},{"chunk":"go","hash":"def","layers":2}]`,
+					},
+				},
+			},
+			expect: &ImageInspection{
+				Kind:  ImageKindCombination,
+				Tests: TestStatusUnknown,
+				Chunks: []ChunkProvenance{
+					{Chunk: baseLayerOwner, Hash: "abc", Layers: 1},
+					{Chunk: "go", Hash: "def", Layers: 2},
+				},
+				Layers: []LayerProvenance{
+					{Digest: "sha256:b1", Chunk: baseLayerOwner, ChunkHash: "abc"},
+					{Digest: "sha256:l1", Chunk: "go", ChunkHash: "def"},
+					{Digest: "sha256:l2", Chunk: "go", ChunkHash: "def"},
+				},
+			},
+		},
+		{
+			name: "label does not match layers",
+			mf: &ociv1.Manifest{
+				Layers: []ociv1.Descriptor{{Digest: "sha256:b1"}},
+			},
+			cfg: &ociv1.Image{
+				Config: ociv1.ImageConfig{
+					Labels: map[string]string{
+						cfgLabelChunks: `[{"chunk":"base","hash":"abc","layers":2}]`,
+					},
+				},
+			},
+			expect: &ImageInspection{
+				Kind:   ImageKindCombination,
+				Tests:  TestStatusUnknown,
+				Chunks: []ChunkProvenance{{Chunk: baseLayerOwner, Hash: "abc", Layers: 2}},
+				Layers: []LayerProvenance{{Digest: "sha256:b1"}},
+			},
+		},
+		{
+			name: "other image",
+			mf: &ociv1.Manifest{
+				Layers: []ociv1.Descriptor{{Digest: "sha256:b1"}},
+			},
+			expect: &ImageInspection{
+				Kind:   ImageKindOther,
+				Tests:  TestStatusUnknown,
+				Layers: []LayerProvenance{{Digest: "sha256:b1"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act := inspectManifest(test.mf, test.cfg)
+			if diff := cmp.Diff(test.expect, act); diff != "" {
+				t.Errorf("inspectManifest() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCombinationAnnotations(t *testing.T) {
+	base := &ociv1.Manifest{Annotations: map[string]string{mfAnnotationEnvVar + "PATH": "merge"}}
+	chunks := []*ociv1.Manifest{
+		base,
+		{Annotations: map[string]string{mfAnnotationBaseRef: "base", mfAnnotationChunk: "go", mfAnnotationTests: "passed"}},
+		{Annotations: map[string]string{mfAnnotationBaseRef: "base", mfAnnotationChunk: "node", mfAnnotationTests: "none", mfAnnotationExpiresAfter: "336h"}},
+	}
+	act := combinationAnnotations(base, chunks, TestStatusSkipped)
+	expect := map[string]string{
+		mfAnnotationEnvVar + "PATH": "merge",
+		mfAnnotationBaseRef:         "base",
+		mfAnnotationTests:           "skipped",
+	}
+	if diff := cmp.Diff(expect, act); diff != "" {
+		t.Errorf("combinationAnnotations() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCombinationLabels(t *testing.T) {
+	members := []ChunkProvenance{
+		{Chunk: baseLayerOwner, Hash: "abc", Layers: 3},
+		{Chunk: "go:1.19", Hash: "def", Layers: 1},
+	}
+	act, err := combinationLabels(members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		cfgLabelChunks: `[{"chunk":"base","hash":"abc","layers":3},{"chunk":"go:1.19","hash":"def","layers":1}]`,
+	}
+	if diff := cmp.Diff(expect, act); diff != "" {
+		t.Errorf("combinationLabels() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	dpkgStatusFile   = "var/lib/dpkg/status"
+	apkInstalledFile = "lib/apk/db/installed"
+	goVersionFile    = "usr/local/go/VERSION"
+)
+
+// inventoryFiles are the files an inventory is made from
+var inventoryFiles = map[string]struct{}{
+	dpkgStatusFile:   {},
+	apkInstalledFile: {},
+	goVersionFile:    {},
+}
+
+// runtimeEnvVars are the env vars which by convention announce the version of a language runtime
+var runtimeEnvVars = map[string]string{
+	"GO_VERSION":     "go",
+	"NODE_VERSION":   "node",
+	"PYTHON_VERSION": "python",
+	"JAVA_VERSION":   "java",
+	"RUBY_VERSION":   "ruby",
+	"RUST_VERSION":   "rust",
+	"PHP_VERSION":    "php",
+	"DOTNET_VERSION": "dotnet",
+}
+
+// runtimePackages maps OS package names, or their prefix if they end in -, to language runtimes
+var runtimePackages = map[string]string{
+	"golang-go": "go",
+	"go":        "go",
+	"nodejs":    "node",
+	"python3":   "python",
+	"openjdk-":  "java",
+	"openjdk":   "java",
+	"ruby":      "ruby",
+	"rustc":     "rust",
+	"rust":      "rust",
+	"php":       "php",
+	"dotnet-":   "dotnet",
+}
+
+// ImageInventory lists the OS packages and language runtimes installed in an image
+type ImageInventory struct {
+	Ref      string    `yaml:"ref" json:"ref"`
+	Packages []Package `yaml:"packages" json:"packages"`
+	Runtimes []Runtime `yaml:"runtimes" json:"runtimes"`
+}
+
+// Package is an installed OS package
+type Package struct {
+	Name    string `yaml:"name" json:"name"`
+	Version string `yaml:"version" json:"version"`
+	// Manager is the package manager which installed the package, i.e. dpkg or apk
+	Manager string `yaml:"manager" json:"manager"`
+}
+
+// Runtime is an installed language runtime
+type Runtime struct {
+	Name    string `yaml:"name" json:"name"`
+	Version string `yaml:"version" json:"version"`
+	// Source tells how the runtime was found, e.g. "env GO_VERSION" or "dpkg nodejs"
+	Source string `yaml:"source" json:"source"`
+}
+
+// InventoryImage downloads all layers of an image and lists the OS packages recorded in the dpkg or apk
+// database of the resulting file system, as well as the language runtimes found in files, env vars and packages.
+func InventoryImage(ctx context.Context, resolver remotes.Resolver, ref reference.Named) (*ImageInventory, error) {
+	var cfg ociv1.Image
+	mf, _, err := NewResolverRegistry(resolver).Pull(ctx, ref, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot pull %s: %w", ref, err)
+	}
+
+	files := make(map[string][]byte)
+	for _, l := range mf.Layers {
+		err = readLayer(ctx, resolver, ref, l, func(r io.Reader) error {
+			return collectInventoryFiles(r, files)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &ImageInventory{Ref: ref.String()}
+	if fc, ok := files[dpkgStatusFile]; ok {
+		pkgs, err := parseDpkgStatus(fc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", dpkgStatusFile, err)
+		}
+		res.Packages = append(res.Packages, pkgs...)
+	}
+	if fc, ok := files[apkInstalledFile]; ok {
+		pkgs, err := parseApkInstalled(fc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", apkInstalledFile, err)
+		}
+		res.Packages = append(res.Packages, pkgs...)
+	}
+	sort.Slice(res.Packages, func(i, j int) bool { return res.Packages[i].Name < res.Packages[j].Name })
+	res.Runtimes = detectRuntimes(files, cfg.Config.Env, res.Packages)
+	return res, nil
+}
+
+// collectInventoryFiles reads a layer and updates files with the inventory files it adds, changes or deletes
+func collectInventoryFiles(r io.Reader, files map[string][]byte) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		dir, base := path.Split(name)
+		if base == ".wh..wh..opq" {
+			// opaque whiteout: the directory content of lower layers is gone
+			for fn := range files {
+				if strings.HasPrefix(fn, dir) {
+					delete(files, fn)
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(base, ".wh.") {
+			delete(files, dir+strings.TrimPrefix(base, ".wh."))
+			continue
+		}
+		if _, ok := inventoryFiles[name]; !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		fc, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		files[name] = fc
+	}
+}
+
+// parseDpkgStatus parses the dpkg status database and returns all installed packages
+func parseDpkgStatus(fc []byte) ([]Package, error) {
+	var (
+		res    []Package
+		cur    Package
+		status string
+	)
+	flush := func() {
+		if cur.Name != "" && strings.HasSuffix(status, " installed") {
+			cur.Manager = "dpkg"
+			res = append(res, cur)
+		}
+		cur, status = Package{}, ""
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(fc)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			// continuation of a multi-line field, e.g. the description
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch k {
+		case "Package":
+			cur.Name = v
+		case "Version":
+			cur.Version = v
+		case "Status":
+			status = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return res, nil
+}
+
+// parseApkInstalled parses the apk database of installed packages
+func parseApkInstalled(fc []byte) ([]Package, error) {
+	var (
+		res []Package
+		cur Package
+	)
+	flush := func() {
+		if cur.Name != "" {
+			cur.Manager = "apk"
+			res = append(res, cur)
+		}
+		cur = Package{}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(fc)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "P:"):
+			cur.Name = strings.TrimPrefix(line, "P:")
+		case strings.HasPrefix(line, "V:"):
+			cur.Version = strings.TrimPrefix(line, "V:")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return res, nil
+}
+
+// detectRuntimes finds language runtimes in well-known files, env vars and OS packages
+func detectRuntimes(files map[string][]byte, env []string, pkgs []Package) []Runtime {
+	var res []Runtime
+	if fc, ok := files[goVersionFile]; ok {
+		version := strings.TrimSpace(strings.SplitN(string(fc), "\n", 2)[0])
+		res = append(res, Runtime{Name: "go", Version: strings.TrimPrefix(version, "go"), Source: "file /" + goVersionFile})
+	}
+
+	for k, v := range envMap(env) {
+		if name, ok := runtimeEnvVars[k]; ok {
+			res = append(res, Runtime{Name: name, Version: v, Source: "env " + k})
+		}
+	}
+
+	// runtimes such as java come in several packages of the same version, of which we list the first only
+	seen := make(map[string]struct{})
+	for _, p := range pkgs {
+		for pn, name := range runtimePackages {
+			if p.Name != pn && !(strings.HasSuffix(pn, "-") && strings.HasPrefix(p.Name, pn)) {
+				continue
+			}
+			if _, exists := seen[name+"@"+p.Version]; !exists {
+				seen[name+"@"+p.Version] = struct{}{}
+				res = append(res, Runtime{Name: name, Version: p.Version, Source: p.Manager + " " + p.Name})
+			}
+			break
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].Source < res[j].Source
+	})
+	return res
+}
@@ -0,0 +1,129 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDpkgStatus(t *testing.T) {
+	fc := []byte(`Package: adduser
+Status: install ok installed
+Priority: important
+Version: 3.118
+Description: add and remove users and groups
+ This package includes the 'adduser' and 'deluser' commands.
+
+Package: removed-pkg
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: nodejs
+Status: install ok installed
+Version: 18.17.1-1nodesource1
+`)
+	act, err := parseDpkgStatus(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []Package{
+		{Name: "adduser", Version: "3.118", Manager: "dpkg"},
+		{Name: "nodejs", Version: "18.17.1-1nodesource1", Manager: "dpkg"},
+	}
+	if diff := cmp.Diff(expect, act); diff != "" {
+		t.Errorf("parseDpkgStatus() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseApkInstalled(t *testing.T) {
+	fc := []byte(`C:Q1abc=
+P:musl
+V:1.2.4-r1
+A:x86_64
+
+C:Q1def=
+P:python3
+V:3.11.5-r0
+`)
+	act, err := parseApkInstalled(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []Package{
+		{Name: "musl", Version: "1.2.4-r1", Manager: "apk"},
+		{Name: "python3", Version: "3.11.5-r0", Manager: "apk"},
+	}
+	if diff := cmp.Diff(expect, act); diff != "" {
+		t.Errorf("parseApkInstalled() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDetectRuntimes(t *testing.T) {
+	files := map[string][]byte{goVersionFile: []byte("go1.21.0\ntime 2023-08-04T20:14:06Z\n")}
+	env := []string{"PATH=/usr/bin", "NODE_VERSION=18.17.1"}
+	pkgs := []Package{
+		{Name: "adduser", Version: "3.118", Manager: "dpkg"},
+		{Name: "openjdk-17-jdk", Version: "17.0.8", Manager: "dpkg"},
+		{Name: "openjdk-17-jre", Version: "17.0.8", Manager: "dpkg"},
+	}
+	act := detectRuntimes(files, env, pkgs)
+	expect := []Runtime{
+		{Name: "go", Version: "1.21.0", Source: "file /usr/local/go/VERSION"},
+		{Name: "java", Version: "17.0.8", Source: "dpkg openjdk-17-jdk"},
+		{Name: "node", Version: "18.17.1", Source: "env NODE_VERSION"},
+	}
+	if diff := cmp.Diff(expect, act); diff != "" {
+		t.Errorf("detectRuntimes() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCollectInventoryFiles(t *testing.T) {
+	layer := func(files map[string]string) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for name, content := range files {
+			_ = tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+			_, _ = tw.Write([]byte(content))
+		}
+		_ = tw.Close()
+		return &buf
+	}
+
+	files := make(map[string][]byte)
+	for _, l := range []map[string]string{
+		{"var/lib/dpkg/status": "v1", "usr/local/go/VERSION": "go1.20", "etc/hostname": "foo"},
+		{"./var/lib/dpkg/status": "v2"},
+		{"usr/local/go/.wh.VERSION": ""},
+	} {
+		err := collectInventoryFiles(layer(l), files)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := map[string][]byte{dpkgStatusFile: []byte("v2")}
+	if diff := cmp.Diff(expect, files); diff != "" {
+		t.Errorf("collectInventoryFiles() mismatch (-want +got):\n%s", diff)
+	}
+}
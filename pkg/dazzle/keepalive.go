@@ -0,0 +1,160 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// heartbeatMisses is the number of heartbeats in a row buildkitd may miss before a build fails
+const heartbeatMisses = 2
+
+// solve builds on cl, forwarding the build's status to ch, which it closes when done. Builds whose connection to
+// buildkitd dropped are retried up to opts.SolveRetries times. Retries resume from the steps which buildkitd
+// cached, and their status shows those steps as cached.
+func (s *BuildSession) solve(ctx context.Context, cl *client.Client, opt client.SolveOpt, ch chan *client.SolveStatus) (*client.SolveResponse, error) {
+	defer close(ch)
+
+	for attempt := 1; ; attempt++ {
+		attemptCh := make(chan *client.SolveStatus)
+		forwarded := make(chan struct{})
+		go func() {
+			defer close(forwarded)
+			for st := range attemptCh {
+				ch <- st
+			}
+		}()
+
+		resp, err := s.solveWithHeartbeat(ctx, cl, opt, attemptCh)
+		<-forwarded
+		if err == nil || !isConnectionLost(err) || ctx.Err() != nil {
+			return resp, err
+		}
+		if attempt > s.opts.SolveRetries {
+			return nil, fmt.Errorf("lost the connection to buildkitd %d times: %w", attempt, err)
+		}
+
+		delay := retryDelay(attempt)
+		s.opts.Logger.WithError(err).WithField("attempt", attempt).WithField("retry in", delay).Warn("lost the connection to buildkitd - resuming the build")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// solveWithHeartbeat builds on cl while checking every opts.Heartbeat that buildkitd still responds. If it
+// misses heartbeatMisses heartbeats in a row the build is cancelled and fails with ErrBuildkitGone.
+func (s *BuildSession) solveWithHeartbeat(ctx context.Context, cl *client.Client, opt client.SolveOpt, ch chan *client.SolveStatus) (*client.SolveResponse, error) {
+	if s.opts.Heartbeat == 0 {
+		return cl.Solve(ctx, nil, opt, ch)
+	}
+
+	solveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		gone error
+		wg   sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := heartbeat(solveCtx, s.opts.Heartbeat, func(ctx context.Context) error {
+			_, err := cl.ListWorkers(ctx)
+			return err
+		})
+		if err != nil {
+			gone = err
+			cancel()
+		}
+	}()
+
+	resp, err := cl.Solve(solveCtx, nil, opt, ch)
+	cancel()
+	wg.Wait()
+	if gone != nil {
+		return nil, gone
+	}
+	return resp, err
+}
+
+// heartbeat pings every interval until ctx is done, giving each ping the interval to answer. It returns
+// ErrBuildkitGone once heartbeatMisses pings in a row failed, and nil when ctx is done.
+func heartbeat(ctx context.Context, interval time.Duration, ping func(context.Context) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var misses int
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			misses = 0
+			continue
+		}
+		misses++
+		if misses >= heartbeatMisses {
+			return fmt.Errorf("%w: no response for %s: %v", ErrBuildkitGone, time.Duration(misses)*interval, err)
+		}
+	}
+}
+
+// isConnectionLost returns true if a build failed because its connection to buildkitd dropped rather than
+// because of the build itself
+func isConnectionLost(err error) bool {
+	if errors.Is(err, ErrBuildkitGone) {
+		return true
+	}
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return se.GRPCStatus().Code() == codes.Unavailable
+	}
+	return false
+}
+
+// retryDelay is the time to wait before the attempt-th retry of a build, which gives buildkitd time to come back
+func retryDelay(attempt int) time.Duration {
+	delay := time.Duration(attempt) * 5 * time.Second
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	return delay
+}
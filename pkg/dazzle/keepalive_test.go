@@ -0,0 +1,100 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsConnectionLost(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		expectation bool
+	}{
+		{name: "unavailable", err: status.Error(codes.Unavailable, "connection reset by peer"), expectation: true},
+		{name: "wrapped unavailable", err: fmt.Errorf("solve: %w", status.Error(codes.Unavailable, "transport is closing")), expectation: true},
+		{name: "heartbeat", err: fmt.Errorf("%w: no response for 1m0s", ErrBuildkitGone), expectation: true},
+		{name: "build failure", err: status.Error(codes.Unknown, "process \"/bin/sh -c false\" did not complete successfully")},
+		{name: "cancelled", err: status.Error(codes.Canceled, "context canceled")},
+		{name: "plain", err: errors.New("failed to compute cache key")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act := isConnectionLost(test.err)
+			if act != test.expectation {
+				t.Errorf("isConnectionLost() = %v, expected %v", act, test.expectation)
+			}
+		})
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []bool
+		gone     bool
+	}{
+		{name: "responsive", failures: []bool{false, false, false, false}},
+		{name: "single miss", failures: []bool{false, true, false, true, false}},
+		{name: "gone", failures: []bool{false, true, true}, gone: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var pings int
+			err := heartbeat(ctx, time.Millisecond, func(context.Context) error {
+				if pings == len(test.failures)-1 && !test.gone {
+					cancel()
+				}
+				failed := test.failures[pings]
+				pings++
+				if failed {
+					return status.Error(codes.Unavailable, "connection refused")
+				}
+				return nil
+			})
+			if errors.Is(err, ErrBuildkitGone) != test.gone {
+				t.Fatalf("heartbeat() error = %v, expected gone: %v", err, test.gone)
+			}
+			if pings != len(test.failures) {
+				t.Errorf("heartbeat() pinged %d times, expected %d", pings, len(test.failures))
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt, expectation := range map[int]time.Duration{1: 5 * time.Second, 3: 15 * time.Second, 10: 30 * time.Second} {
+		if act := retryDelay(attempt); act != expectation {
+			t.Errorf("retryDelay(%d) = %s, expected %s", attempt, act, expectation)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxImageLayers is the number of layers an image may have. Container runtimes stack the layers using overlayfs,
+// which limits the number of lower directories, and fail to run images with more layers.
+const maxImageLayers = 127
+
+// checkBaseLayers fails if the base image has more layers than the project allows, or leaves no room for chunks
+func checkBaseLayers(cfg LayersConfig, base int) error {
+	if cfg.MaxBase > 0 && base > cfg.MaxBase {
+		return withKind(ErrorKindBuild, fmt.Errorf("base image has %d layers, more than the %d allowed by layers.maxBase in dazzle.yaml - consider merging RUN instructions of the base Dockerfile", base, cfg.MaxBase))
+	}
+	if base >= maxImageLayers {
+		return withKind(ErrorKindBuild, fmt.Errorf("base image has %d layers, which leaves no room for chunks in an image of at most %d layers", base, maxImageLayers))
+	}
+	return nil
+}
+
+// checkCombinationLayers fails if a combination of the base image and the chunks would have more layers than an image
+// may have. layers maps chunk names to the number of layers of their chunked image, chunks which are missing count as none.
+func checkCombinationLayers(name string, base int, chunks []string, layers map[string]int) error {
+	total := base
+	for _, c := range chunks {
+		total += layers[c]
+	}
+	if total <= maxImageLayers {
+		return nil
+	}
+
+	// list the chunks with the most layers first, as they are the ones to look at
+	sorted := append([]string(nil), chunks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return layers[sorted[i]] > layers[sorted[j]] })
+	parts := make([]string, 0, len(sorted)+1)
+	parts = append(parts, fmt.Sprintf("base: %d", base))
+	for _, c := range sorted {
+		parts = append(parts, fmt.Sprintf("%s: %d", c, layers[c]))
+	}
+	return withKind(ErrorKindBuild, fmt.Errorf("combination %s would have %d layers, more than the %d an image may have (%s)", name, total, maxImageLayers, strings.Join(parts, ", ")))
+}
@@ -0,0 +1,88 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckBaseLayers(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         LayersConfig
+		base        int
+		expectation string
+	}{
+		{name: "no limit", base: 40},
+		{name: "within limit", cfg: LayersConfig{MaxBase: 40}, base: 40},
+		{name: "above limit", cfg: LayersConfig{MaxBase: 40}, base: 41, expectation: "base image has 41 layers, more than the 40 allowed by layers.maxBase"},
+		{name: "no room for chunks", base: 127, expectation: "base image has 127 layers, which leaves no room for chunks"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkBaseLayers(test.cfg, test.base)
+			var act string
+			if err != nil {
+				act = err.Error()
+				if KindOf(err) != ErrorKindBuild {
+					t.Errorf("checkBaseLayers() error kind = %v, expected %v", KindOf(err), ErrorKindBuild)
+				}
+			}
+			if test.expectation == "" && act != "" || !strings.HasPrefix(act, test.expectation) {
+				t.Errorf("checkBaseLayers() error = %q, expected %q", act, test.expectation)
+			}
+		})
+	}
+}
+
+func TestCheckCombinationLayers(t *testing.T) {
+	layers := map[string]int{"go": 30, "node": 60, "python": 20}
+	tests := []struct {
+		name        string
+		base        int
+		chunks      []string
+		expectation string
+	}{
+		{name: "fits", base: 47, chunks: []string{"go", "python"}},
+		{name: "exactly the limit", base: 47, chunks: []string{"node", "python", "unknown"}},
+		{
+			name:        "too many",
+			base:        48,
+			chunks:      []string{"go", "node", "python"},
+			expectation: "combination full would have 158 layers, more than the 127 an image may have (base: 48, node: 60, go: 30, python: 20)",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkCombinationLayers("full", test.base, test.chunks, layers)
+			var act string
+			if err != nil {
+				act = err.Error()
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("checkCombinationLayers() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
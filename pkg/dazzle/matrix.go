@@ -0,0 +1,59 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// MatrixCell is the outcome of running the tests of one chunk against one combination
+type MatrixCell struct {
+	Combination string
+	Chunk       string
+	Tests       int
+	Passed      bool
+	Cached      bool
+	// Skipped is true if the tests did not run because the buildkit workers cannot run the platform
+	Skipped bool
+}
+
+// matrix runs the tests of all chunks against the combined image. Unlike regular combination tests
+// it does not stop at the first failing chunk and records the outcome for every chunk in the session.
+func (ct combinationTest) matrix(ctx context.Context, cs []ProjectChunk, sess *BuildSession) error {
+	var failed []string
+	for _, chk := range cs {
+		cell, err := ct.run(ctx, chk, sess)
+		if err != nil {
+			return err
+		}
+		sess.recordMatrixCell(cell)
+		if !cell.Passed {
+			failed = append(failed, chk.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return withKind(ErrorKindTest, fmt.Errorf("combination %s fails the tests of %s: %w", ct.Name, strings.Join(failed, ", "), ErrTestsFailed))
+	}
+	return nil
+}
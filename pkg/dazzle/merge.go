@@ -0,0 +1,191 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// mergedImage is an image to merge on top of the base image, with the layers it shares with the base removed
+type mergedImage struct {
+	Ref      reference.Named
+	Manifest *ociv1.Manifest
+	Config   *ociv1.Image
+}
+
+// MergeImages merges addon images on top of a base image and pushes the result to dest. Addons are either
+// built from the base image, in which case the layers they share with it are dropped, or do not contain the
+// base layers at all, like chunked images. Everything happens in the registry: blobs are mounted from their
+// source repository where the registry supports it and never downloaded otherwise.
+func MergeImages(ctx context.Context, logger log.FieldLogger, resolver remotes.Resolver, dest, base reference.Named, addons []reference.Named, envVars []EnvVarCombination) (absref reference.Digested, err error) {
+	defer func() {
+		if err != nil {
+			err = registryError(dest.String(), fmt.Errorf("cannot merge images into %s: %w", dest, err))
+		}
+	}()
+
+	registry := resolverRegistry{resolver: resolver, logger: logger}
+	_, basemf, basecfg, err := getImageMetadata(ctx, base, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]mergedImage, 0, len(addons))
+	for _, ref := range addons {
+		logger.WithField("ref", ref.String()).Info("pulling addon metadata")
+		_, mf, cfg, err := getImageMetadata(ctx, ref, registry)
+		if err != nil {
+			return nil, err
+		}
+		img, err := withoutBaseLayers(basemf, basecfg, mergedImage{Ref: ref, Manifest: mf, Config: cfg})
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+
+	pusher, err := resolver.Pusher(ctx, dest.String())
+	if err != nil {
+		return nil, err
+	}
+	var (
+		layers = append([]ociv1.Descriptor{}, basemf.Layers...)
+		diffs  = append([]digest.Digest{}, basecfg.RootFS.DiffIDs...)
+		hist   = append([]ociv1.History{}, basecfg.History...)
+		mfs    = make([]*ociv1.Manifest, 0, len(images))
+		cfgs   = make([]*ociv1.Image, 0, len(images))
+	)
+	sources := []mergedImage{{Ref: base, Manifest: basemf, Config: basecfg}}
+	for _, img := range images {
+		layers = append(layers, img.Manifest.Layers...)
+		diffs = append(diffs, img.Config.RootFS.DiffIDs...)
+		hist = append(hist, img.Config.History...)
+		mfs = append(mfs, img.Manifest)
+		cfgs = append(cfgs, img.Config)
+		sources = append(sources, img)
+	}
+	for _, src := range sources {
+		fetcher, err := resolver.Fetcher(ctx, src.Ref.String())
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range src.Manifest.Layers {
+			logger.WithField("layer", l.Digest).WithField("dest", dest.String()).Debug("copying layer")
+			err = copyLayer(ctx, fetcher, pusher, withDistributionSource(l, src.Ref))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	env, err := mergeEnv(logger, basecfg, cfgs, envVars)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	cfg := ociv1.Image{
+		Created:      &now,
+		Architecture: basecfg.Architecture,
+		OS:           basecfg.OS,
+		History:      hist,
+		Config: ociv1.ImageConfig{
+			StopSignal:   basecfg.Config.StopSignal,
+			Cmd:          basecfg.Config.Cmd,
+			Entrypoint:   basecfg.Config.Entrypoint,
+			ExposedPorts: mergeExposedPorts(basecfg, cfgs),
+			Env:          env,
+			User:         basecfg.Config.User,
+			WorkingDir:   basecfg.Config.WorkingDir,
+		},
+		RootFS: ociv1.RootFS{
+			Type:    basecfg.RootFS.Type,
+			DiffIDs: diffs,
+		},
+	}
+	rawcfg, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfgdesc := ociv1.Descriptor{
+		MediaType: ociv1.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(rawcfg),
+		Size:      int64(len(rawcfg)),
+	}
+
+	mf := ociv1.Manifest{
+		Versioned:   basemf.Versioned,
+		MediaType:   ociv1.MediaTypeImageManifest,
+		Annotations: mergeAnnotations(basemf, mfs),
+		Config:      cfgdesc,
+		Layers:      layers,
+	}
+	rawmf, err := json.Marshal(mf)
+	if err != nil {
+		return nil, err
+	}
+	mfdesc := ociv1.Descriptor{
+		MediaType: ociv1.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(rawmf),
+		Size:      int64(len(rawmf)),
+		Platform:  basemf.Config.Platform,
+	}
+
+	logger.WithField("dest", dest.String()).Info("pushing merged image")
+	err = pushCombination(ctx, resolver, dest, cfgdesc, rawcfg, mfdesc, rawmf)
+	if err != nil {
+		return nil, err
+	}
+	return reference.WithDigest(dest, mfdesc.Digest)
+}
+
+// withoutBaseLayers removes the layers, diffIDs and history an addon image shares with the base image.
+// An addon must either contain all base layers or none of them.
+func withoutBaseLayers(basemf *ociv1.Manifest, basecfg *ociv1.Image, img mergedImage) (mergedImage, error) {
+	var n int
+	for n < len(basemf.Layers) && n < len(img.Manifest.Layers) && basemf.Layers[n].Digest == img.Manifest.Layers[n].Digest {
+		n++
+	}
+	if n == 0 {
+		return img, nil
+	}
+	if n != len(basemf.Layers) {
+		return img, fmt.Errorf("%s shares only %d of the %d base image layers", img.Ref, n, len(basemf.Layers))
+	}
+	if len(img.Config.RootFS.DiffIDs) < n || len(img.Config.History) < len(basecfg.History) {
+		return img, fmt.Errorf("%s was not built from the base image", img.Ref)
+	}
+
+	mf := *img.Manifest
+	mf.Layers = mf.Layers[n:]
+	cfg := *img.Config
+	cfg.RootFS.DiffIDs = cfg.RootFS.DiffIDs[n:]
+	cfg.History = cfg.History[len(basecfg.History):]
+	img.Manifest, img.Config = &mf, &cfg
+	return img, nil
+}
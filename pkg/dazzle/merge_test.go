@@ -0,0 +1,93 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestWithoutBaseLayers(t *testing.T) {
+	image := func(ls ...string) (*ociv1.Manifest, *ociv1.Image) {
+		var (
+			mf  ociv1.Manifest
+			cfg ociv1.Image
+		)
+		for _, l := range ls {
+			mf.Layers = append(mf.Layers, ociv1.Descriptor{Digest: digest.FromString(l)})
+			cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs, digest.FromString("diff-"+l))
+			cfg.History = append(cfg.History, ociv1.History{CreatedBy: l})
+		}
+		return &mf, &cfg
+	}
+
+	ref, err := reference.ParseNamed("localhost:9999/addon:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	basemf, basecfg := image("b1", "b2")
+
+	tests := []struct {
+		name   string
+		addon  []string
+		expect []string
+		err    string
+	}{
+		{name: "built from base", addon: []string{"b1", "b2", "a1", "a2"}, expect: []string{"a1", "a2"}},
+		{name: "without base layers", addon: []string{"a1"}, expect: []string{"a1"}},
+		{name: "only base layers", addon: []string{"b1", "b2"}},
+		{name: "partial base", addon: []string{"b1", "a1"}, err: "localhost:9999/addon:latest shares only 1 of the 2 base image layers"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mf, cfg := image(test.addon...)
+			act, err := withoutBaseLayers(basemf, basecfg, mergedImage{Ref: ref, Manifest: mf, Config: cfg})
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+			}
+			if diff := cmp.Diff(test.err, errmsg); diff != "" {
+				t.Fatalf("withoutBaseLayers() error mismatch (-want +got):\n%s", diff)
+			}
+			if err != nil {
+				return
+			}
+
+			expmf, expcfg := image(test.expect...)
+			if diff := cmp.Diff(expmf.Layers, act.Manifest.Layers, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("withoutBaseLayers() layers mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(expcfg.RootFS.DiffIDs, act.Config.RootFS.DiffIDs, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("withoutBaseLayers() diffIDs mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(expcfg.History, act.Config.History, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("withoutBaseLayers() history mismatch (-want +got):\n%s", diff)
+			}
+			if len(mf.Layers) != len(test.addon) {
+				t.Errorf("withoutBaseLayers() modified the original manifest")
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxCachedMetadataSize is the size up to which manifests and configs are kept in the metadata cache
+const maxCachedMetadataSize = 4 << 20
+
+// DefaultMetadataCacheDir returns the directory of the metadata cache, which is in the user's cache dir
+func DefaultMetadataCacheDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "dazzle", "metadata"), nil
+}
+
+// NewCachingResolver wraps a resolver so that the descriptors it resolves refs to and the manifests,
+// indexes and configs it fetches are kept in dir. Layers are never cached.
+// Offline the resolver serves from dir only, does not use the wrapped resolver, which may be nil, and fails
+// everything it cannot serve from dir with ErrOffline. An empty dir disables the cache.
+// Failures to write the cache are logged to logger.
+func NewCachingResolver(resolver remotes.Resolver, dir string, offline bool, logger log.FieldLogger) remotes.Resolver {
+	if dir == "" && !offline {
+		return resolver
+	}
+	return cachingResolver{resolver: resolver, dir: dir, offline: offline, logger: logger}
+}
+
+type cachingResolver struct {
+	resolver remotes.Resolver
+	dir      string
+	offline  bool
+	logger   log.FieldLogger
+}
+
+// cachedRef is what the metadata cache keeps for a resolved ref
+type cachedRef struct {
+	Ref        string           `json:"ref"`
+	Descriptor ociv1.Descriptor `json:"descriptor"`
+}
+
+func (r cachingResolver) Resolve(ctx context.Context, ref string) (name string, desc ociv1.Descriptor, err error) {
+	fn := r.refPath(ref)
+	if r.offline {
+		var cached cachedRef
+		fc, err := r.read(fn)
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", desc, fmt.Errorf("cannot resolve %s: %w", ref, ErrOffline)
+		}
+		if err != nil {
+			return "", desc, err
+		}
+		err = json.Unmarshal(fc, &cached)
+		if err != nil {
+			return "", desc, fmt.Errorf("cannot read metadata cache %s: %w", fn, err)
+		}
+		return ref, cached.Descriptor, nil
+	}
+
+	name, desc, err = r.resolver.Resolve(ctx, ref)
+	if err != nil {
+		return
+	}
+	fc, err := json.Marshal(cachedRef{Ref: ref, Descriptor: desc})
+	if err != nil {
+		return
+	}
+	r.store(fn, fc)
+	return name, desc, nil
+}
+
+func (r cachingResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	if r.offline {
+		return cachingFetcher{resolver: r}, nil
+	}
+	fetcher, err := r.resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return cachingFetcher{resolver: r, fetcher: fetcher}, nil
+}
+
+func (r cachingResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	if r.offline {
+		return nil, fmt.Errorf("cannot push %s: %w", ref, ErrOffline)
+	}
+	return r.resolver.Pusher(ctx, ref)
+}
+
+func (r cachingResolver) refPath(ref string) string {
+	hash := sha256.Sum256([]byte(ref))
+	return filepath.Join(r.dir, "refs", hex.EncodeToString(hash[:])+".json")
+}
+
+func (r cachingResolver) blobPath(dgst digest.Digest) string {
+	return filepath.Join(r.dir, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// read reads a cache file
+func (r cachingResolver) read(fn string) ([]byte, error) {
+	if r.dir == "" {
+		return nil, fs.ErrNotExist
+	}
+	return os.ReadFile(fn)
+}
+
+// store writes a cache file. The cache only saves round trips, hence failing to write it is logged but no error.
+func (r cachingResolver) store(fn string, content []byte) {
+	err := os.MkdirAll(filepath.Dir(fn), 0755)
+	if err != nil {
+		r.logger.WithError(err).WithField("dir", r.dir).Debug("cannot write metadata cache")
+		return
+	}
+	// write to a temp file first so that concurrent dazzle runs never see partial content
+	tmp, err := os.CreateTemp(filepath.Dir(fn), ".tmp-*")
+	if err != nil {
+		r.logger.WithError(err).WithField("dir", r.dir).Debug("cannot write metadata cache")
+		return
+	}
+	_, err = tmp.Write(content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fn)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		r.logger.WithError(err).WithField("dir", r.dir).Debug("cannot write metadata cache")
+	}
+}
+
+type cachingFetcher struct {
+	resolver cachingResolver
+	fetcher  remotes.Fetcher
+}
+
+func (f cachingFetcher) Fetch(ctx context.Context, desc ociv1.Descriptor) (io.ReadCloser, error) {
+	fn := f.resolver.blobPath(desc.Digest)
+	if f.resolver.offline {
+		content, err := f.resolver.read(fn)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("cannot fetch %s: %w", desc.Digest, ErrOffline)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+
+	rc, err := f.fetcher.Fetch(ctx, desc)
+	if err != nil || images.IsLayerType(desc.MediaType) || desc.Size > maxCachedMetadataSize {
+		return rc, err
+	}
+	content, err := io.ReadAll(io.LimitReader(rc, maxCachedMetadataSize+1))
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	if len(content) <= maxCachedMetadataSize && desc.Digest.Validate() == nil && desc.Digest.Algorithm().FromBytes(content) == desc.Digest {
+		f.resolver.store(fn, content)
+	}
+	// content which exceeds the cache limit continues where the cache stopped reading
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(content), rc), rc}, nil
+}
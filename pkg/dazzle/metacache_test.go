@@ -0,0 +1,159 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// contentResolver serves refs and blobs from memory
+type contentResolver struct {
+	fakeResolver
+	refs  map[string]ociv1.Descriptor
+	blobs map[digest.Digest][]byte
+}
+
+func (t contentResolver) Resolve(ctx context.Context, ref string) (name string, desc ociv1.Descriptor, err error) {
+	desc, ok := t.refs[ref]
+	if !ok {
+		return "", desc, fmt.Errorf("%s: %w", ref, errdefs.ErrNotFound)
+	}
+	return ref, desc, nil
+}
+
+func (t contentResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	return t, nil
+}
+
+func (t contentResolver) Fetch(ctx context.Context, desc ociv1.Descriptor) (io.ReadCloser, error) {
+	content, ok := t.blobs[desc.Digest]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", desc.Digest, errdefs.ErrNotFound)
+	}
+	return io.NopCloser(strings.NewReader(string(content))), nil
+}
+
+func TestCachingResolver(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		layer  = []byte("layer")
+		cfg    = ociv1.Image{Architecture: "amd64", OS: "linux"}
+		cfgraw []byte
+		mfraw  []byte
+		err    error
+	)
+	cfgraw, err = json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerdesc := ociv1.Descriptor{MediaType: ociv1.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+	mf := ociv1.Manifest{
+		Config: ociv1.Descriptor{MediaType: ociv1.MediaTypeImageConfig, Digest: digest.FromBytes(cfgraw), Size: int64(len(cfgraw))},
+		Layers: []ociv1.Descriptor{layerdesc},
+	}
+	mfraw, err = json.Marshal(mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfdesc := ociv1.Descriptor{MediaType: ociv1.MediaTypeImageManifest, Digest: digest.FromBytes(mfraw), Size: int64(len(mfraw))}
+	src := contentResolver{
+		refs: map[string]ociv1.Descriptor{"localhost:9999/test:base--abc": mfdesc},
+		blobs: map[digest.Digest][]byte{
+			mfdesc.Digest:    mfraw,
+			mf.Config.Digest: cfgraw,
+			layerdesc.Digest: layer,
+		},
+	}
+	ref, err := reference.ParseNamed("localhost:9999/test:base--abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	online := NewCachingResolver(src, dir, false, log.StandardLogger())
+	var onlineCfg ociv1.Image
+	_, _, err = NewResolverRegistry(online).Pull(ctx, ref, &onlineCfg)
+	if err != nil {
+		t.Fatalf("online Pull() failed: %v", err)
+	}
+	fetcher, err := online.Fetcher(ctx, ref.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := fetcher.Fetch(ctx, layerdesc)
+	if err != nil {
+		t.Fatalf("online Fetch() of layer failed: %v", err)
+	}
+	rc.Close()
+
+	offline := NewCachingResolver(nil, dir, true, log.StandardLogger())
+	var offlineCfg ociv1.Image
+	actMF, absref, err := NewResolverRegistry(offline).Pull(ctx, ref, &offlineCfg)
+	if err != nil {
+		t.Fatalf("offline Pull() failed: %v", err)
+	}
+	if diff := cmp.Diff(&mf, actMF); diff != "" {
+		t.Errorf("offline Pull() manifest mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(cfg, offlineCfg); diff != "" {
+		t.Errorf("offline Pull() config mismatch (-want +got):\n%s", diff)
+	}
+	if absref.Digest() != mfdesc.Digest {
+		t.Errorf("offline Pull() digest = %s, expected %s", absref.Digest(), mfdesc.Digest)
+	}
+
+	other, err := reference.ParseNamed("localhost:9999/test:base--def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = NewResolverRegistry(offline).Pull(ctx, other, &offlineCfg)
+	if !errors.Is(err, ErrOffline) {
+		t.Errorf("offline Pull() of uncached ref: error = %v, expected %v", err, ErrOffline)
+	}
+
+	fetcher, err = offline.Fetcher(ctx, ref.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fetcher.Fetch(ctx, layerdesc)
+	if !errors.Is(err, ErrOffline) {
+		t.Errorf("offline Fetch() of layer: error = %v, expected %v", err, ErrOffline)
+	}
+
+	_, err = offline.Pusher(ctx, ref.String())
+	if !errors.Is(err, ErrOffline) {
+		t.Errorf("offline Pusher() error = %v, expected %v", err, ErrOffline)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ProjectMetadata is what external build systems, e.g. Bazel or Make, need to know about a project to decide
+// which chunks to rebuild
+type ProjectMetadata struct {
+	// Target is the target-ref the image names derive from
+	Target string          `json:"target"`
+	Base   BaseMetadata    `json:"base"`
+	Chunks []ChunkMetadata `json:"chunks"`
+}
+
+// BaseMetadata describes the base image
+type BaseMetadata struct {
+	// Ref is the ref of the base image, which is tagged with its hash
+	Ref  string `json:"ref"`
+	Hash string `json:"hash"`
+	// Digest is the digest of the base image, if it exists already
+	Digest      string `json:"digest,omitempty"`
+	ContextPath string `json:"contextPath"`
+}
+
+// ChunkMetadata describes a chunk, or a variant of a chunk
+type ChunkMetadata struct {
+	// Name is the name of the chunk including the variant, e.g. golang:1.16
+	Name string `json:"name"`
+	// Chunk and Variant are the parts of the name
+	Chunk       string            `json:"chunk"`
+	Variant     string            `json:"variant,omitempty"`
+	ContextPath string            `json:"contextPath"`
+	Args        map[string]string `json:"args,omitempty"`
+	Platforms   []string          `json:"platforms,omitempty"`
+	DependsOn   []string          `json:"dependsOn,omitempty"`
+	// Hashes and Images are missing until the base image exists, as they depend on its digest
+	Hashes *ChunkHashes              `json:"hashes,omitempty"`
+	Images map[ChunkImageType]string `json:"images,omitempty"`
+}
+
+// metadataImageTypes are the image types ChunkMetadata names
+var metadataImageTypes = []ChunkImageType{
+	ImageTypeTest,
+	ImageTypeFull,
+	ImageTypeChunked,
+	ImageTypeChunkedNoHash,
+}
+
+// Metadata lists the chunks of the project with their hashes and image names for the session's target-ref.
+// Context paths are relative to dir, the project's directory. Like Plan it only reads from the registry.
+func (p *Project) Metadata(ctx context.Context, sess *BuildSession, dir string) (*ProjectMetadata, error) {
+	baseref, err := p.BaseRef(sess.Dest)
+	if err != nil {
+		return nil, err
+	}
+	res := &ProjectMetadata{
+		Target: sess.Dest.String(),
+		Base: BaseMetadata{
+			Ref:         baseref.String(),
+			Hash:        strings.TrimPrefix(baseref.Tag(), "base--"),
+			ContextPath: relativePath(dir, p.Base.ContextPath),
+		},
+		Chunks: make([]ChunkMetadata, 0, len(p.Chunks)),
+	}
+	if absbaseref, exists := sess.useExisting(ctx, baseref); exists {
+		_, mf, cfg, err := sess.imageMetadata(ctx, absbaseref)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch base image: %w", err)
+		}
+		sess.baseBuildFinished(absbaseref, mf, cfg)
+		res.Base.Digest = absbaseref.Digest().String()
+	}
+
+	for i := range p.Chunks {
+		chk := &p.Chunks[i]
+		md := ChunkMetadata{
+			Name:        chk.Name,
+			Chunk:       chk.Name,
+			ContextPath: relativePath(dir, chk.ContextPath),
+			Args:        chk.Args,
+			Platforms:   chk.Platforms,
+		}
+		if name, variant, ok := strings.Cut(chk.Name, ":"); ok {
+			md.Chunk, md.Variant = name, variant
+		}
+		for _, dep := range chk.DependsOn {
+			md.DependsOn = append(md.DependsOn, dep.Chunk)
+		}
+
+		if sess.baseRef != nil {
+			hashes, err := chk.Hashes(sess)
+			if err != nil {
+				return nil, fmt.Errorf("chunk %s: %w", chk.Name, err)
+			}
+			md.Hashes = &hashes
+			md.Images = make(map[ChunkImageType]string, len(metadataImageTypes))
+			for _, tpe := range metadataImageTypes {
+				ref, err := chk.ImageName(tpe, sess)
+				if err != nil {
+					return nil, fmt.Errorf("chunk %s: %w", chk.Name, err)
+				}
+				md.Images[tpe] = ref.String()
+			}
+		}
+		res.Chunks = append(res.Chunks, md)
+	}
+	return res, nil
+}
+
+// relativePath returns path relative to dir, or path itself if it is not within dir
+func relativePath(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
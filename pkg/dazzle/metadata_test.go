@@ -0,0 +1,111 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/google/go-cmp/cmp"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestProject_Metadata(t *testing.T) {
+	const baseDigest = "sha256:b25ab047a146b43a7a1bdd2b3346a05fd27dd2730af8ab06a9b8acca0f15b378"
+	chunk := func(hashes *ChunkHashes, images map[ChunkImageType]string) ChunkMetadata {
+		return ChunkMetadata{
+			Name:        "golang:1.16",
+			Chunk:       "golang",
+			Variant:     "1.16",
+			ContextPath: "chunks/golang",
+			Args:        map[string]string{"GO_VERSION": "1.16"},
+			DependsOn:   []string{"tools"},
+			Hashes:      hashes,
+			Images:      images,
+		}
+	}
+	tests := []struct {
+		name        string
+		resolver    staticResolver
+		expectation *ProjectMetadata
+	}{
+		{
+			name:     "no base image",
+			resolver: staticResolver{err: errdefs.ErrNotFound},
+			expectation: &ProjectMetadata{
+				Target: "localhost:9999/test",
+				Base:   BaseMetadata{Ref: "localhost:9999/test:base--abc", Hash: "abc", ContextPath: "base"},
+				Chunks: []ChunkMetadata{chunk(nil, nil)},
+			},
+		},
+		{
+			name:     "base image exists",
+			resolver: staticResolver{desc: ociv1.Descriptor{Digest: baseDigest}},
+			expectation: &ProjectMetadata{
+				Target: "localhost:9999/test",
+				Base:   BaseMetadata{Ref: "localhost:9999/test:base--abc", Hash: "abc", Digest: baseDigest, ContextPath: "base"},
+				Chunks: []ChunkMetadata{chunk(
+					&ChunkHashes{WithTests: "tst", ExcludeTests: "def"},
+					map[ChunkImageType]string{
+						ImageTypeTest:          "localhost:9999/test:golang-1.16--tst--test",
+						ImageTypeFull:          "localhost:9999/test:golang-1.16--def--full",
+						ImageTypeChunked:       "localhost:9999/test:golang-1.16--def--chunked",
+						ImageTypeChunkedNoHash: "localhost:9999/test/golang:1.16",
+					},
+				)},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prj := &Project{
+				Base: ProjectChunk{Name: "base", ContextPath: "/workspace/base"},
+				Chunks: []ProjectChunk{
+					{
+						Name:        "golang:1.16",
+						ContextPath: "/workspace/chunks/golang",
+						Args:        map[string]string{"GO_VERSION": "1.16"},
+						DependsOn:   []ChunkDependency{{Chunk: "tools", Arg: "TOOLS_IMAGE"}},
+					},
+				},
+			}
+			prj.Base.cachedHash.ExcludeTests = "abc"
+			prj.Chunks[0].cachedHash.ExcludeTests = "def"
+			prj.Chunks[0].cachedHash.WithTests = "tst"
+
+			sess, err := NewSession(nil, "localhost:9999/test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess.opts.Resolver = test.resolver
+			sess.opts.Registry = metadataRegistry{}
+
+			act, err := prj.Metadata(context.Background(), sess, "/workspace")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("Metadata() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
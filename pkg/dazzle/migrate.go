@@ -0,0 +1,137 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectConfigVersion is the version of the project layout this version of dazzle understands
+const ProjectConfigVersion = 1
+
+const legacyBaseDir = "_base"
+
+// migrations upgrade a project from the version of their index to the next one. Each returns the changes
+// it made, or would make during a dry run.
+var migrations = []func(dir string, dryRun bool) ([]string, error){
+	migrateLegacyLayout,
+}
+
+// MigrateProject upgrades the project in dir to ProjectConfigVersion and records the version in its dazzle.yaml.
+// It returns the changes it made, or would make if dryRun is true.
+func MigrateProject(dir string, dryRun bool) (changes []string, err error) {
+	var version int
+	fc, err := os.ReadFile(filepath.Join(dir, "dazzle.yaml"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var cfg struct {
+			Version int `yaml:"version"`
+		}
+		err = yaml.Unmarshal(fc, &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load config from dazzle.yaml: %w", err)
+		}
+		version = cfg.Version
+	}
+	if version > ProjectConfigVersion {
+		return nil, fmt.Errorf("project has version %d, but this version of dazzle supports up to %d", version, ProjectConfigVersion)
+	}
+
+	for v := version; v < ProjectConfigVersion; v++ {
+		res, err := migrations[v](dir, dryRun)
+		changes = append(changes, res...)
+		if err != nil {
+			return changes, fmt.Errorf("cannot migrate project from version %d to %d: %w", v, v+1, err)
+		}
+	}
+	if version == ProjectConfigVersion {
+		return changes, nil
+	}
+
+	changes = append(changes, fmt.Sprintf("set version %d in dazzle.yaml", ProjectConfigVersion))
+	if dryRun {
+		return changes, nil
+	}
+	if fc == nil {
+		return changes, os.WriteFile(filepath.Join(dir, "dazzle.yaml"), []byte(fmt.Sprintf("version: %d\n", ProjectConfigVersion)), 0644)
+	}
+	return changes, editProjectConfig(dir, func(root *yaml.Node) error {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(ProjectConfigVersion)}
+		if n := yamlMappingValue(root, "version"); n != nil {
+			*n = *value
+			return nil
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+		root.Content = append([]*yaml.Node{key, value}, root.Content...)
+		return nil
+	})
+}
+
+// migrateLegacyLayout moves the base image from _base to base and renames <chunk>-tests.yaml test suites to <chunk>.yaml
+func migrateLegacyLayout(dir string, dryRun bool) (changes []string, err error) {
+	rename := func(from, to string) error {
+		if _, err := os.Stat(filepath.Join(dir, to)); err == nil {
+			return fmt.Errorf("cannot move %s to %s: %s exists already", from, to, to)
+		}
+		changes = append(changes, fmt.Sprintf("move %s to %s", filepath.ToSlash(from), filepath.ToSlash(to)))
+		if dryRun {
+			return nil
+		}
+		return os.Rename(filepath.Join(dir, from), filepath.Join(dir, to))
+	}
+
+	if stat, err := os.Stat(filepath.Join(dir, legacyBaseDir)); err == nil && stat.IsDir() {
+		err = rename(legacyBaseDir, "base")
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	tfs, err := filepath.Glob(filepath.Join(dir, defaultTestsDir, "*-tests.yaml"))
+	if err != nil {
+		return changes, err
+	}
+	for _, tf := range tfs {
+		if _, err := os.Stat(filepath.Join(dir, chunksDir, strings.TrimSuffix(filepath.Base(tf), ".yaml"))); err == nil {
+			// tests of a chunk whose name ends with -tests
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(tf), "-tests.yaml")
+		if name == legacyBaseDir {
+			name = "base"
+		}
+		err = rename(filepath.Join(defaultTestsDir, filepath.Base(tf)), filepath.Join(defaultTestsDir, name+".yaml"))
+		if err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
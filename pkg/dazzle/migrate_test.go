@@ -0,0 +1,183 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigrateProject(t *testing.T) {
+	type Expectation struct {
+		Err     string
+		Changes []string
+		Files   map[string]string
+	}
+	tests := []struct {
+		Name        string
+		Files       map[string]string
+		DryRun      bool
+		Expectation Expectation
+	}{
+		{
+			Name: "legacy layout",
+			Files: map[string]string{
+				"dazzle.yaml":                 "# our images\ncombiner:\n  combinations: []\n",
+				"_base/Dockerfile":            "FROM ubuntu",
+				"chunks/go/Dockerfile":        "FROM base",
+				"chunks/e2e-tests/Dockerfile": "FROM base",
+				"tests/_base-tests.yaml":      "[]",
+				"tests/go-tests.yaml":         "[]",
+				"tests/e2e-tests.yaml":        "[]",
+			},
+			Expectation: Expectation{
+				Changes: []string{
+					"move _base to base",
+					"move tests/_base-tests.yaml to tests/base.yaml",
+					"move tests/go-tests.yaml to tests/go.yaml",
+					"set version 1 in dazzle.yaml",
+				},
+				Files: map[string]string{
+					"dazzle.yaml":                 "version: 1\n# our images\ncombiner:\n  combinations: []\n",
+					"base/Dockerfile":             "FROM ubuntu",
+					"chunks/go/Dockerfile":        "FROM base",
+					"chunks/e2e-tests/Dockerfile": "FROM base",
+					"tests/base.yaml":             "[]",
+					"tests/go.yaml":               "[]",
+					"tests/e2e-tests.yaml":        "[]",
+				},
+			},
+		},
+		{
+			Name: "dry run",
+			Files: map[string]string{
+				"_base/Dockerfile":    "FROM ubuntu",
+				"tests/go-tests.yaml": "[]",
+			},
+			DryRun: true,
+			Expectation: Expectation{
+				Changes: []string{
+					"move _base to base",
+					"move tests/go-tests.yaml to tests/go.yaml",
+					"set version 1 in dazzle.yaml",
+				},
+				Files: map[string]string{
+					"_base/Dockerfile":    "FROM ubuntu",
+					"tests/go-tests.yaml": "[]",
+				},
+			},
+		},
+		{
+			Name: "current layout",
+			Files: map[string]string{
+				"dazzle.yaml":     "combiner:\n  combinations: []\n",
+				"base/Dockerfile": "FROM ubuntu",
+			},
+			Expectation: Expectation{
+				Changes: []string{"set version 1 in dazzle.yaml"},
+				Files: map[string]string{
+					"dazzle.yaml":     "version: 1\ncombiner:\n  combinations: []\n",
+					"base/Dockerfile": "FROM ubuntu",
+				},
+			},
+		},
+		{
+			Name: "up to date",
+			Files: map[string]string{
+				"dazzle.yaml": "version: 1\n",
+			},
+			Expectation: Expectation{
+				Files: map[string]string{"dazzle.yaml": "version: 1\n"},
+			},
+		},
+		{
+			Name: "newer version",
+			Files: map[string]string{
+				"dazzle.yaml": "version: 2\n",
+			},
+			Expectation: Expectation{
+				Err:   "project has version 2, but this version of dazzle supports up to 1",
+				Files: map[string]string{"dazzle.yaml": "version: 2\n"},
+			},
+		},
+		{
+			Name: "conflict",
+			Files: map[string]string{
+				"_base/Dockerfile": "FROM ubuntu",
+				"base/Dockerfile":  "FROM alpine",
+			},
+			Expectation: Expectation{
+				Err: "cannot migrate project from version 0 to 1: cannot move _base to base: base exists already",
+				Files: map[string]string{
+					"_base/Dockerfile": "FROM ubuntu",
+					"base/Dockerfile":  "FROM alpine",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			for fn, fc := range test.Files {
+				err := os.MkdirAll(filepath.Dir(filepath.Join(dir, fn)), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(filepath.Join(dir, fn), []byte(fc), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var act Expectation
+			changes, err := MigrateProject(dir, test.DryRun)
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Changes = changes
+			}
+			act.Files = make(map[string]string)
+			err = filepath.WalkDir(dir, func(fn string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				fc, err := os.ReadFile(fn)
+				if err != nil {
+					return err
+				}
+				rel, _ := filepath.Rel(dir, fn)
+				act.Files[filepath.ToSlash(rel)] = string(fc)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("MigrateProject() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
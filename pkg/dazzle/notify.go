@@ -0,0 +1,128 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+// webhookTimeout is how long posting a notification may take
+const webhookTimeout = 30 * time.Second
+
+// SessionSummary describes the outcome of a build or combine run, as posted to a notification webhook
+type SessionSummary struct {
+	// Text is a one line description of the outcome, which is what Slack incoming webhooks display
+	Text        string        `json:"text"`
+	Command     string        `json:"command"`
+	Success     bool          `json:"success"`
+	Error       string        `json:"error,omitempty"`
+	Refs        []string      `json:"refs"`
+	Duration    float64       `json:"duration"`
+	Timings     []PhaseTiming `json:"timings,omitempty"`
+	FailedTests []FailedTest  `json:"failedTests,omitempty"`
+	Cache       []CacheStats  `json:"cache,omitempty"`
+}
+
+// FailedTest is a test which failed or could not be run
+type FailedTest struct {
+	Suite   string `json:"suite"`
+	Desc    string `json:"desc"`
+	Message string `json:"message"`
+}
+
+// Summary summarises the outcome of the session: the images it pushed, the tests which failed and how
+// long it took. command names what ran, e.g. build, and err is the error it ended with, if any.
+func (s *BuildSession) Summary(command string, duration time.Duration, err error) SessionSummary {
+	res := SessionSummary{
+		Command:  command,
+		Success:  err == nil,
+		Refs:     s.PushedRefs(),
+		Duration: duration.Seconds(),
+	}
+	if timings := s.Timings(); len(timings) > 0 {
+		res.Timings = timings
+	}
+	if cache := s.CacheStats(); len(cache) > 0 {
+		res.Cache = cache
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	for _, suite := range s.TestResults() {
+		for _, r := range suite.Result {
+			if r == nil {
+				continue
+			}
+			if msg, failed := failureMessage(r); failed {
+				res.FailedTests = append(res.FailedTests, FailedTest{Suite: suite.Name, Desc: r.Desc, Message: msg})
+			}
+		}
+	}
+
+	d := duration.Round(time.Second)
+	if res.Success {
+		res.Text = fmt.Sprintf("dazzle %s succeeded after %s, pushed %d images", command, d, len(res.Refs))
+	} else {
+		res.Text = fmt.Sprintf("dazzle %s failed after %s: %s", command, d, res.Error)
+	}
+	if len(res.FailedTests) > 0 {
+		res.Text += fmt.Sprintf(" (%d tests failed)", len(res.FailedTests))
+	}
+	return res
+}
+
+// PostWebhook posts the summary as JSON to a webhook, e.g. a Slack incoming webhook
+func PostWebhook(ctx context.Context, url string, summary SessionSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot notify webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// the URL of a webhook usually contains a secret, hence we don't include it in the error
+		var uerr *neturl.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("cannot notify webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("cannot notify webhook: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
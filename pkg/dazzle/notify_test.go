@@ -0,0 +1,130 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/gitpod-io/dazzle/pkg/test"
+)
+
+func TestBuildSession_Summary(t *testing.T) {
+	sess, err := NewSession(nil, "localhost:9999/test")
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	sess.recordChunk("localhost:9999/test:foo--abc", &ociv1.Manifest{})
+	sess.recordChunk("localhost:9999/test:bar--def", &ociv1.Manifest{})
+	sess.recordCombination("full", "localhost:9999/test:full", []string{"foo", "bar"})
+	sess.recordTestResults("foo", test.Results{Result: []*test.Result{
+		{Desc: "it runs"},
+		{Desc: "it prints", Failure: &test.ErrResult{Message: "wrong output"}},
+	}})
+
+	tests := []struct {
+		name   string
+		err    error
+		expect SessionSummary
+	}{
+		{
+			name: "success",
+			expect: SessionSummary{
+				Text:        "dazzle build succeeded after 1m30s, pushed 3 images (1 tests failed)",
+				Command:     "build",
+				Success:     true,
+				Refs:        []string{"localhost:9999/test:bar--def", "localhost:9999/test:foo--abc", "localhost:9999/test:full"},
+				Duration:    90,
+				FailedTests: []FailedTest{{Suite: "foo", Desc: "it prints", Message: "wrong output"}},
+			},
+		},
+		{
+			name: "failure",
+			err:  errors.New("foo: tests failed"),
+			expect: SessionSummary{
+				Text:        "dazzle build failed after 1m30s: foo: tests failed (1 tests failed)",
+				Command:     "build",
+				Error:       "foo: tests failed",
+				Refs:        []string{"localhost:9999/test:bar--def", "localhost:9999/test:foo--abc", "localhost:9999/test:full"},
+				Duration:    90,
+				FailedTests: []FailedTest{{Suite: "foo", Desc: "it prints", Message: "wrong output"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act := sess.Summary("build", 90*time.Second, test.err)
+			if diff := cmp.Diff(test.expect, act); diff != "" {
+				t.Errorf("Summary() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    string
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "rejected", status: http.StatusForbidden, err: "cannot notify webhook: 403 Forbidden: invalid_token"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received SessionSummary
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+					t.Errorf("unexpected content type %q", ct)
+				}
+				err := json.NewDecoder(r.Body).Decode(&received)
+				if err != nil {
+					t.Errorf("cannot decode request: %v", err)
+				}
+				w.WriteHeader(test.status)
+				if test.status != http.StatusOK {
+					w.Write([]byte("invalid_token\n"))
+				}
+			}))
+			defer srv.Close()
+
+			summary := SessionSummary{Text: "dazzle build succeeded", Command: "build", Success: true, Refs: []string{"localhost:9999/test:full"}}
+			err := PostWebhook(context.Background(), srv.URL, summary)
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+			}
+			if diff := cmp.Diff(test.err, errmsg); diff != "" {
+				t.Errorf("PostWebhook() error mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(summary, received); diff != "" {
+				t.Errorf("PostWebhook() body mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
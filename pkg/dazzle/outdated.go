@@ -0,0 +1,330 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// BaseImageState describes whether an image the base Dockerfile builds FROM has moved on
+type BaseImageState string
+
+const (
+	// BaseImageUpToDate means the image has not changed since the base image was built, or since it was pinned
+	BaseImageUpToDate BaseImageState = "up-to-date"
+	// BaseImageOutdated means the tag of the image points to a newer digest
+	BaseImageOutdated BaseImageState = "outdated"
+	// BaseImageUnknown means dazzle cannot tell, e.g. because the image is pinned by digest only
+	BaseImageUnknown BaseImageState = "unknown"
+)
+
+// BaseImageStatus is the state of an image the base Dockerfile builds FROM
+type BaseImageStatus struct {
+	// Image is the image as written in the Dockerfile, with build args expanded
+	Image string `yaml:"image" json:"image"`
+	// Pinned is the digest the Dockerfile pins the image to, if any
+	Pinned digest.Digest `yaml:"pinned,omitempty" json:"pinned,omitempty"`
+	// Current is the digest the tag of the image points to now
+	Current digest.Digest  `yaml:"current,omitempty" json:"current,omitempty"`
+	State   BaseImageState `yaml:"state" json:"state"`
+	Reason  string         `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// BaseOutdatedReport lists the images of the base Dockerfile and what a base bump would invalidate
+type BaseOutdatedReport struct {
+	Images []BaseImageStatus `yaml:"images" json:"images"`
+	// Chunks are the chunks whose hashes change with the base image, which is all of them
+	// as the digest of the base image is part of every chunk hash
+	Chunks []string `yaml:"chunks" json:"chunks"`
+	// Combinations are the combinations which have to be rebuilt after a base bump
+	Combinations []string `yaml:"combinations" json:"combinations"`
+}
+
+// Outdated reports true if any of the images is outdated
+func (r *BaseOutdatedReport) Outdated() bool {
+	for _, img := range r.Images {
+		if img.State == BaseImageOutdated {
+			return true
+		}
+	}
+	return false
+}
+
+// BaseOutdated checks whether the images the base Dockerfile builds FROM point to newer digests by now.
+// Images pinned by digest are compared against the digest their tag points to. The image of the final stage,
+// if not pinned, is compared against the base image built for the session's target-ref: the base image is
+// outdated if it no longer starts with the layers of that image.
+func (p *Project) BaseOutdated(ctx context.Context, sess *BuildSession) (*BaseOutdatedReport, error) {
+	froms, err := parseFromImages(p.Base.Dockerfile)
+	if err != nil {
+		return nil, withKind(ErrorKindConfig, fmt.Errorf("cannot parse base Dockerfile: %w", err))
+	}
+
+	err = sess.DownloadBaseInfo(ctx, p)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return nil, err
+	}
+
+	res := &BaseOutdatedReport{}
+	for _, from := range froms {
+		sess.opts.Logger.WithField("image", from.Image).Debug("checking base Dockerfile image")
+		status, err := checkFromImage(ctx, sess, from)
+		if err != nil {
+			return nil, registryError(from.Image, fmt.Errorf("cannot check %s: %w", from.Image, err))
+		}
+		res.Images = append(res.Images, status)
+	}
+
+	for _, chk := range p.Chunks {
+		res.Chunks = append(res.Chunks, chk.Name)
+	}
+	for _, comb := range p.Config.Combiner.Combinations {
+		res.Combinations = append(res.Combinations, comb.Name)
+	}
+	return res, nil
+}
+
+func checkFromImage(ctx context.Context, sess *BuildSession, from fromImage) (BaseImageStatus, error) {
+	res := BaseImageStatus{Image: from.Image, State: BaseImageUnknown}
+
+	ref, err := reference.ParseNormalizedNamed(from.Image)
+	if err != nil {
+		return res, err
+	}
+	if d, ok := ref.(reference.Digested); ok {
+		res.Pinned = d.Digest()
+	}
+	tagged, ok := ref.(reference.Tagged)
+	if !ok {
+		if res.Pinned != "" {
+			res.Reason = "pinned by digest without tag"
+			return res, nil
+		}
+		tagged, _ = reference.WithTag(ref, "latest")
+	}
+	tagref, err := reference.WithTag(reference.TrimNamed(ref), tagged.Tag())
+	if err != nil {
+		return res, err
+	}
+
+	_, desc, err := sess.opts.Resolver.Resolve(ctx, tagref.String())
+	if err != nil {
+		return res, err
+	}
+	res.Current = desc.Digest
+
+	switch {
+	case res.Pinned != "" && res.Pinned == res.Current:
+		res.State = BaseImageUpToDate
+	case res.Pinned != "":
+		res.State = BaseImageOutdated
+		res.Reason = fmt.Sprintf("%s points to a newer digest", tagref)
+	case !from.Final:
+		res.Reason = "not pinned and not the final stage"
+	case sess.baseMF == nil:
+		res.Reason = "base image not built yet"
+	default:
+		layers, err := platformLayers(ctx, sess.opts.Resolver, tagref, desc, sess.opts.Platform)
+		if err != nil {
+			return res, err
+		}
+		if hasLayerPrefix(sess.baseMF.Layers, layers) {
+			res.State = BaseImageUpToDate
+		} else {
+			res.State = BaseImageOutdated
+			res.Reason = fmt.Sprintf("base image %s was built on an older version of %s", sess.baseRef, tagref)
+		}
+	}
+	return res, nil
+}
+
+// platformLayers returns the layers of the image desc, choosing the manifest of the platform for image indexes
+func platformLayers(ctx context.Context, resolver remotes.Resolver, ref reference.Named, desc ociv1.Descriptor, platform ociv1.Platform) ([]ociv1.Descriptor, error) {
+	fetcher, err := resolver.Fetcher(ctx, ref.String())
+	if err != nil {
+		return nil, err
+	}
+	fetchJSON := func(desc ociv1.Descriptor, dst interface{}) error {
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return json.NewDecoder(rc).Decode(dst)
+	}
+
+	switch desc.MediaType {
+	case ociv1.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var idx ociv1.Index
+		err = fetchJSON(desc, &idx)
+		if err != nil {
+			return nil, err
+		}
+		var found bool
+		desc, found = platformManifest(&idx, platform)
+		if !found {
+			return nil, fmt.Errorf("%s has no manifest for %s", ref, platforms.Format(platform))
+		}
+	case ociv1.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+	default:
+		return nil, fmt.Errorf("unsupported manifest media type %s", desc.MediaType)
+	}
+
+	var mf ociv1.Manifest
+	err = fetchJSON(desc, &mf)
+	if err != nil {
+		return nil, err
+	}
+	return mf.Layers, nil
+}
+
+func hasLayerPrefix(layers, prefix []ociv1.Descriptor) bool {
+	if len(prefix) > len(layers) {
+		return false
+	}
+	for i, l := range prefix {
+		if layers[i].Digest != l.Digest {
+			return false
+		}
+	}
+	return true
+}
+
+// fromImage is an image a Dockerfile builds FROM
+type fromImage struct {
+	Image string
+	// Final is true if the image is the one the last stage builds on
+	Final bool
+}
+
+// parseFromImages finds the images a Dockerfile builds FROM. Stages which build on earlier stages
+// are followed to their image, "scratch" is skipped and build args are expanded using their defaults.
+func parseFromImages(dockerfile []byte) ([]fromImage, error) {
+	var (
+		args     = make(map[string]string)
+		stages   = make(map[string]string)
+		res      []fromImage
+		final    string
+		lines    []string
+		cont     string
+		sc       = bufio.NewScanner(bytes.NewReader(dockerfile))
+		inStages bool
+	)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			cont += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		lines = append(lines, cont+line)
+		cont = ""
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if cont != "" {
+		lines = append(lines, cont)
+	}
+
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			if k, def, ok := strings.Cut(name, ":-"); ok {
+				if v := args[k]; v != "" {
+					return v
+				}
+				return def
+			}
+			return args[name]
+		})
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if inStages {
+				// args declared within a stage are not available to FROM
+				continue
+			}
+			for _, a := range fields[1:] {
+				k, v, _ := strings.Cut(a, "=")
+				args[k] = strings.Trim(expand(v), `"'`)
+			}
+		case "FROM":
+			inStages = true
+			var img, name string
+			for i := 1; i < len(fields); i++ {
+				if strings.HasPrefix(fields[i], "--") {
+					continue
+				}
+				img = expand(fields[i])
+				if i+2 < len(fields) && strings.EqualFold(fields[i+1], "AS") {
+					name = strings.ToLower(fields[i+2])
+				}
+				break
+			}
+			if img == "" {
+				return nil, fmt.Errorf("FROM without image: %s", line)
+			}
+			if earlier, ok := stages[strings.ToLower(img)]; ok {
+				img = earlier
+			}
+			if name != "" {
+				stages[name] = img
+			}
+			final = img
+			if img == "scratch" {
+				continue
+			}
+			var known bool
+			for _, r := range res {
+				known = known || r.Image == img
+			}
+			if !known {
+				res = append(res, fromImage{Image: img})
+			}
+		}
+	}
+	for i := range res {
+		res[i].Final = res[i].Image == final
+	}
+	return res, nil
+}
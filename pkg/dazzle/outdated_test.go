@@ -0,0 +1,111 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseFromImages(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Dockerfile  string
+		Expectation []fromImage
+		Error       bool
+	}{
+		{
+			Name:        "single stage",
+			Dockerfile:  "FROM ubuntu:22.04\nRUN apt-get update\n",
+			Expectation: []fromImage{{Image: "ubuntu:22.04", Final: true}},
+		},
+		{
+			Name: "multi stage",
+			Dockerfile: `FROM --platform=linux/amd64 golang:1.20 AS builder
+RUN go build
+
+from builder as test
+RUN go test
+
+FROM ubuntu@sha256:0bced47fffa3361afa981854fcabcd4577cd43cebbb808cea2b1f33a3dd7f508
+COPY --from=builder /app /app
+`,
+			Expectation: []fromImage{
+				{Image: "golang:1.20"},
+				{Image: "ubuntu@sha256:0bced47fffa3361afa981854fcabcd4577cd43cebbb808cea2b1f33a3dd7f508", Final: true},
+			},
+		},
+		{
+			Name: "final stage from earlier stage",
+			Dockerfile: `FROM ubuntu:22.04 AS base
+FROM alpine:3.18 AS tools
+FROM base
+COPY --from=tools /bin/busybox /bin/
+`,
+			Expectation: []fromImage{
+				{Image: "ubuntu:22.04", Final: true},
+				{Image: "alpine:3.18"},
+			},
+		},
+		{
+			Name: "build args",
+			Dockerfile: `ARG VERSION=22.04
+ARG REGISTRY
+# FROM commented:out
+FROM ${REGISTRY:-docker.io}/library/ubuntu:$VERSION
+ARG VERSION=ignored
+`,
+			Expectation: []fromImage{{Image: "docker.io/library/ubuntu:22.04", Final: true}},
+		},
+		{
+			Name:        "line continuation",
+			Dockerfile:  "FROM \\\n  ubuntu:22.04 \\\n  AS base\n",
+			Expectation: []fromImage{{Image: "ubuntu:22.04", Final: true}},
+		},
+		{
+			Name:       "scratch",
+			Dockerfile: "FROM scratch\nCOPY . /\n",
+		},
+		{
+			Name:       "missing image",
+			Dockerfile: "FROM --platform=linux/amd64\n",
+			Error:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act, err := parseFromImages([]byte(test.Dockerfile))
+			if test.Error {
+				if err == nil {
+					t.Error("parseFromImages() succeeded but should have failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("parseFromImages() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
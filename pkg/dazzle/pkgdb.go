@@ -0,0 +1,117 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"bytes"
+	"strings"
+)
+
+// PackageConflict is a package listed in several package databases with different versions
+type PackageConflict struct {
+	Name string `json:"name"`
+	// Old is the version the merge replaced
+	Old string `json:"old"`
+	// New is the version the merge kept
+	New string `json:"new"`
+	// Source is the index of the database New comes from
+	Source int `json:"source"`
+}
+
+// MergeApkInstalled merges apk databases of installed packages, e.g. those of the chunks of a combination.
+// Packages are kept in the order they first appear in. If several databases list the same package,
+// the entry of the later database wins and differing versions are reported as conflicts.
+func MergeApkInstalled(dbs ...[]byte) ([]byte, []PackageConflict) {
+	return mergePackageDBs(dbs, func(fields map[string]string) (name, version string) {
+		return fields["P"], fields["V"]
+	})
+}
+
+// MergeDpkgStatus merges dpkg status databases, e.g. those of the chunks of a combination.
+// Packages are kept in the order they first appear in. If several databases list the same package
+// for the same architecture, the entry of the later database wins and differing versions are reported as conflicts.
+func MergeDpkgStatus(dbs ...[]byte) ([]byte, []PackageConflict) {
+	return mergePackageDBs(dbs, func(fields map[string]string) (name, version string) {
+		name = fields["Package"]
+		if arch := fields["Architecture"]; arch != "" && name != "" {
+			name += ":" + arch
+		}
+		return name, fields["Version"]
+	})
+}
+
+// mergePackageDBs merges databases which consist of entries separated by blank lines, where ident
+// names the package of an entry and its version. Entries which name no package are kept as they are.
+func mergePackageDBs(dbs [][]byte, ident func(fields map[string]string) (name, version string)) ([]byte, []PackageConflict) {
+	type entry struct {
+		text    string
+		version string
+	}
+	var (
+		order     []string
+		entries   = make(map[string]entry)
+		conflicts []PackageConflict
+	)
+	for i, db := range dbs {
+		for _, text := range strings.Split(strings.ReplaceAll(string(db), "\r\n", "\n"), "\n\n") {
+			text = strings.Trim(text, "\n")
+			if text == "" {
+				continue
+			}
+			name, version := ident(packageDBFields(text))
+			if name == "" {
+				name = text
+			}
+			if prev, exists := entries[name]; !exists {
+				order = append(order, name)
+			} else if prev.version != version {
+				conflicts = append(conflicts, PackageConflict{Name: name, Old: prev.version, New: version, Source: i})
+			}
+			entries[name] = entry{text, version}
+		}
+	}
+
+	var res bytes.Buffer
+	for _, name := range order {
+		res.WriteString(entries[name].text)
+		res.WriteString("\n\n")
+	}
+	return res.Bytes(), conflicts
+}
+
+// packageDBFields returns the single-line fields of a database entry, e.g. "P:musl" or "Package: libc6"
+func packageDBFields(text string) map[string]string {
+	res := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			// continuation of a multi-line field, e.g. the description
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if _, exists := res[k]; !exists {
+			res[k] = strings.TrimSpace(v)
+		}
+	}
+	return res
+}
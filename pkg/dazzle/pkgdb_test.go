@@ -0,0 +1,134 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeApkInstalled(t *testing.T) {
+	tests := []struct {
+		name        string
+		dbs         []string
+		expectation string
+		conflicts   []PackageConflict
+	}{
+		{
+			name:        "empty",
+			expectation: "",
+		},
+		{
+			name:        "single",
+			dbs:         []string{"C:Q1abc=\nP:musl\nV:1.2.3-r0\n\nC:Q1def=\nP:busybox\nV:1.35.0-r1\n\n"},
+			expectation: "C:Q1abc=\nP:musl\nV:1.2.3-r0\n\nC:Q1def=\nP:busybox\nV:1.35.0-r1\n\n",
+		},
+		{
+			name: "union",
+			dbs: []string{
+				"P:musl\nV:1.2.3-r0\n\nP:busybox\nV:1.35.0-r1\n\n",
+				"P:musl\nV:1.2.3-r0\n\nP:go\nV:1.20.1-r0\nF:usr/lib/go\nR:VERSION\n\n",
+				"P:musl\nV:1.2.3-r0\n\nP:nodejs\nV:18.14.0-r0\n",
+			},
+			expectation: "P:musl\nV:1.2.3-r0\n\nP:busybox\nV:1.35.0-r1\n\nP:go\nV:1.20.1-r0\nF:usr/lib/go\nR:VERSION\n\nP:nodejs\nV:18.14.0-r0\n\n",
+		},
+		{
+			name: "later database wins",
+			dbs: []string{
+				"P:musl\nV:1.2.3-r0\n\nP:openssl\nV:3.0.7-r0\n\n",
+				"P:openssl\nV:3.0.8-r0\n\n",
+			},
+			expectation: "P:musl\nV:1.2.3-r0\n\nP:openssl\nV:3.0.8-r0\n\n",
+			conflicts:   []PackageConflict{{Name: "openssl", Old: "3.0.7-r0", New: "3.0.8-r0", Source: 1}},
+		},
+		{
+			name:        "crlf",
+			dbs:         []string{"P:musl\r\nV:1.2.3-r0\r\n\r\n"},
+			expectation: "P:musl\nV:1.2.3-r0\n\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbs := make([][]byte, len(test.dbs))
+			for i, db := range test.dbs {
+				dbs[i] = []byte(db)
+			}
+			act, conflicts := MergeApkInstalled(dbs...)
+			if diff := cmp.Diff(test.expectation, string(act)); diff != "" {
+				t.Errorf("MergeApkInstalled() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.conflicts, conflicts); diff != "" {
+				t.Errorf("MergeApkInstalled() conflicts mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMergeDpkgStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		dbs         []string
+		expectation string
+		conflicts   []PackageConflict
+	}{
+		{
+			name: "union",
+			dbs: []string{
+				"Package: libc6\nStatus: install ok installed\nArchitecture: amd64\nVersion: 2.35-0ubuntu3\nDescription: GNU C Library\n Contains the standard libraries.\n\n",
+				"Package: libc6\nStatus: install ok installed\nArchitecture: amd64\nVersion: 2.35-0ubuntu3\nDescription: GNU C Library\n Contains the standard libraries.\n\nPackage: golang-go\nStatus: install ok installed\nArchitecture: amd64\nVersion: 2:1.18~0ubuntu2\n",
+			},
+			expectation: "Package: libc6\nStatus: install ok installed\nArchitecture: amd64\nVersion: 2.35-0ubuntu3\nDescription: GNU C Library\n Contains the standard libraries.\n\nPackage: golang-go\nStatus: install ok installed\nArchitecture: amd64\nVersion: 2:1.18~0ubuntu2\n\n",
+		},
+		{
+			name: "conflict",
+			dbs: []string{
+				"Package: openssl\nArchitecture: amd64\nVersion: 3.0.2-0ubuntu1.7\n\n",
+				"Package: openssl\nArchitecture: amd64\nVersion: 3.0.2-0ubuntu1.8\n\n",
+			},
+			expectation: "Package: openssl\nArchitecture: amd64\nVersion: 3.0.2-0ubuntu1.8\n\n",
+			conflicts:   []PackageConflict{{Name: "openssl:amd64", Old: "3.0.2-0ubuntu1.7", New: "3.0.2-0ubuntu1.8", Source: 1}},
+		},
+		{
+			name: "multi-arch",
+			dbs: []string{
+				"Package: libc6\nArchitecture: amd64\nMulti-Arch: same\nVersion: 2.35-0ubuntu3\n\n",
+				"Package: libc6\nArchitecture: i386\nMulti-Arch: same\nVersion: 2.35-0ubuntu3\n\n",
+			},
+			expectation: "Package: libc6\nArchitecture: amd64\nMulti-Arch: same\nVersion: 2.35-0ubuntu3\n\nPackage: libc6\nArchitecture: i386\nMulti-Arch: same\nVersion: 2.35-0ubuntu3\n\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbs := make([][]byte, len(test.dbs))
+			for i, db := range test.dbs {
+				dbs[i] = []byte(db)
+			}
+			act, conflicts := MergeDpkgStatus(dbs...)
+			if diff := cmp.Diff(test.expectation, string(act)); diff != "" {
+				t.Errorf("MergeDpkgStatus() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.conflicts, conflicts); diff != "" {
+				t.Errorf("MergeDpkgStatus() conflicts mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
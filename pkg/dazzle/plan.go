@@ -0,0 +1,78 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"fmt"
+)
+
+// PlannedImage is an image a build produces
+type PlannedImage struct {
+	// Chunk is the chunk the image belongs to, or base for the base image
+	Chunk string `json:"chunk"`
+	// Ref is the image ref. The refs of chunked images are unknown until the base image exists.
+	Ref string `json:"ref,omitempty"`
+	// Exists is true if the build uses the image in the registry rather than building and pushing it
+	Exists bool `json:"exists"`
+}
+
+// Plan lists the base image and the chunked images a build produces for the session's target-ref, and whether
+// they exist already. Unlike Build it neither builds nor pushes anything, hence works with read-only registry
+// credentials and without buildkit. Chunks which do not support the session's platform are not listed.
+func (p *Project) Plan(ctx context.Context, sess *BuildSession) ([]PlannedImage, error) {
+	baseref, err := p.BaseRef(sess.Dest)
+	if err != nil {
+		return nil, err
+	}
+	absbaseref, exists := sess.useExisting(ctx, baseref)
+	res := []PlannedImage{{Chunk: baseLayerOwner, Ref: baseref.String(), Exists: exists}}
+	if exists {
+		_, mf, cfg, err := sess.imageMetadata(ctx, absbaseref)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch base image: %w", err)
+		}
+		sess.baseBuildFinished(absbaseref, mf, cfg)
+	}
+
+	chktpe := ImageTypeChunked
+	if sess.opts.ChunkedWithoutHash {
+		chktpe = ImageTypeChunkedNoHash
+	}
+	for _, chk := range p.Chunks {
+		if !chk.SupportsPlatform(sess.opts.Platform) {
+			continue
+		}
+		if sess.baseRef == nil {
+			// the chunk hashes depend on the digest of the base image, which the build produces first
+			res = append(res, PlannedImage{Chunk: chk.Name})
+			continue
+		}
+
+		ref, err := chk.ImageName(chktpe, sess)
+		if err != nil {
+			return nil, err
+		}
+		_, exists := sess.useExisting(ctx, ref)
+		res = append(res, PlannedImage{Chunk: chk.Name, Ref: ref.String(), Exists: exists})
+	}
+	return res, nil
+}
@@ -0,0 +1,82 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/google/go-cmp/cmp"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestProject_Plan(t *testing.T) {
+	tests := []struct {
+		name        string
+		resolver    staticResolver
+		expectation []PlannedImage
+	}{
+		{
+			name:     "nothing exists",
+			resolver: staticResolver{err: errdefs.ErrNotFound},
+			expectation: []PlannedImage{
+				{Chunk: "base", Ref: "localhost:9999/test:base--abc"},
+				{Chunk: "foo"},
+			},
+		},
+		{
+			name:     "everything exists",
+			resolver: staticResolver{desc: ociv1.Descriptor{Digest: "sha256:b25ab047a146b43a7a1bdd2b3346a05fd27dd2730af8ab06a9b8acca0f15b378"}},
+			expectation: []PlannedImage{
+				{Chunk: "base", Ref: "localhost:9999/test:base--abc", Exists: true},
+				{Chunk: "foo", Ref: "localhost:9999/test:foo--def--" + string(ImageTypeChunked), Exists: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prj := &Project{
+				Chunks: []ProjectChunk{
+					{Name: "foo"},
+					{Name: "bar", Platforms: []string{"linux/s390x"}},
+				},
+			}
+			prj.Base.cachedHash.ExcludeTests = "abc"
+			prj.Chunks[0].cachedHash.ExcludeTests = "def"
+
+			sess, err := NewSession(nil, "localhost:9999/test", WithPlatform("linux/amd64"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess.opts.Resolver = test.resolver
+			sess.opts.Registry = metadataRegistry{}
+
+			act, err := prj.Plan(context.Background(), sess)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("Plan() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
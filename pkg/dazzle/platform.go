@@ -0,0 +1,89 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/platforms"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// normalizePlatforms checks the platforms a chunk declares and brings them into their canonical form
+func normalizePlatforms(specs []string) ([]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	res := make([]string, 0, len(specs))
+	for _, s := range specs {
+		p, err := platforms.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform %q: %w", s, err)
+		}
+		res = append(res, platforms.Format(platforms.Normalize(p)))
+	}
+	return res, nil
+}
+
+// platformManifest picks the manifest which matches the platform best from an image index
+func platformManifest(idx *ociv1.Index, platform ociv1.Platform) (res ociv1.Descriptor, found bool) {
+	matcher := platforms.Only(platform)
+	for _, m := range idx.Manifests {
+		if m.Platform == nil || !matcher.Match(*m.Platform) {
+			continue
+		}
+		if !found || matcher.Less(*m.Platform, *res.Platform) {
+			res, found = m, true
+		}
+	}
+	return res, found
+}
+
+// checkImagePlatform returns an error if an image was built for another platform than the session's.
+// Images which do not record their platform pass.
+func checkImagePlatform(ref string, cfg *ociv1.Image, platform ociv1.Platform) error {
+	if cfg.OS == "" || cfg.Architecture == "" {
+		return nil
+	}
+	img := platforms.Normalize(ociv1.Platform{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant})
+	if platforms.NewMatcher(platform).Match(img) {
+		return nil
+	}
+	return withKind(ErrorKindConfig, fmt.Errorf("%s was built for %s, not %s: use a separate target ref for each platform", ref, platforms.Format(img), platforms.Format(platform)))
+}
+
+// SupportsPlatform returns true if the chunk can be built for the platform
+func (p *ProjectChunk) SupportsPlatform(platform ociv1.Platform) bool {
+	if len(p.Platforms) == 0 {
+		return true
+	}
+	for _, s := range p.Platforms {
+		spec, err := platforms.Parse(s)
+		if err != nil {
+			continue
+		}
+		if platforms.NewMatcher(spec).Match(platform) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSupportsPlatform(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Platforms   []string
+		Platform    ociv1.Platform
+		Expectation bool
+	}{
+		{
+			Name:        "all platforms",
+			Platform:    ociv1.Platform{OS: "linux", Architecture: "arm64"},
+			Expectation: true,
+		},
+		{
+			Name:        "supported",
+			Platforms:   []string{"linux/amd64", "linux/arm64"},
+			Platform:    ociv1.Platform{OS: "linux", Architecture: "arm64"},
+			Expectation: true,
+		},
+		{
+			Name:        "supported variant",
+			Platforms:   []string{"linux/arm64"},
+			Platform:    ociv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+			Expectation: true,
+		},
+		{
+			Name:      "unsupported",
+			Platforms: []string{"linux/amd64"},
+			Platform:  ociv1.Platform{OS: "linux", Architecture: "arm64"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			chk := ProjectChunk{Platforms: test.Platforms}
+			if act := chk.SupportsPlatform(test.Platform); act != test.Expectation {
+				t.Errorf("SupportsPlatform() = %v, want %v", act, test.Expectation)
+			}
+		})
+	}
+}
+
+func TestNormalizePlatforms(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Platforms   []string
+		Expectation []string
+		Error       bool
+	}{
+		{Name: "none"},
+		{Name: "normalized", Platforms: []string{"linux/amd64", "linux/arm64/v8"}, Expectation: []string{"linux/amd64", "linux/arm64"}},
+		{Name: "invalid", Platforms: []string{"linux/amd64/v1/x"}, Error: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act, err := normalizePlatforms(test.Platforms)
+			if test.Error {
+				if err == nil {
+					t.Error("normalizePlatforms() succeeded but should have failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("normalizePlatforms() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPlatformManifest(t *testing.T) {
+	idx := &ociv1.Index{
+		Manifests: []ociv1.Descriptor{
+			{Digest: "sha256:attestation"},
+			{Digest: "sha256:amd64", Platform: &ociv1.Platform{OS: "linux", Architecture: "amd64"}},
+			{Digest: "sha256:armv7", Platform: &ociv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+			{Digest: "sha256:arm64", Platform: &ociv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		},
+	}
+	tests := []struct {
+		Name        string
+		Platform    ociv1.Platform
+		Expectation string
+	}{
+		{Name: "amd64", Platform: ociv1.Platform{OS: "linux", Architecture: "amd64"}, Expectation: "sha256:amd64"},
+		{Name: "arm64", Platform: ociv1.Platform{OS: "linux", Architecture: "arm64"}, Expectation: "sha256:arm64"},
+		{Name: "missing", Platform: ociv1.Platform{OS: "linux", Architecture: "s390x"}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act, found := platformManifest(idx, test.Platform)
+			if found != (test.Expectation != "") {
+				t.Fatalf("platformManifest() found = %v, want %v", found, test.Expectation != "")
+			}
+			if string(act.Digest) != test.Expectation {
+				t.Errorf("platformManifest() = %s, want %s", act.Digest, test.Expectation)
+			}
+		})
+	}
+}
+
+func TestCheckImagePlatform(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Config ociv1.Image
+		Error  bool
+	}{
+		{Name: "matching", Config: ociv1.Image{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{Name: "unknown"},
+		{Name: "other platform", Config: ociv1.Image{OS: "linux", Architecture: "amd64"}, Error: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			err := checkImagePlatform("localhost:9999/test:base--abc", &test.Config, ociv1.Platform{OS: "linux", Architecture: "arm64"})
+			if (err != nil) != test.Error {
+				t.Fatalf("checkImagePlatform() error = %v, want error %v", err, test.Error)
+			}
+			if err != nil && KindOf(err) != ErrorKindConfig {
+				t.Errorf("checkImagePlatform() error kind = %v, want %v", KindOf(err), ErrorKindConfig)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/containerd/console"
+	"github.com/mattn/go-isatty"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/util/progress/progressui"
+	"github.com/opencontainers/go-digest"
+)
+
+// ProgressSummary sums up the build steps buildkit ran for a chunk
+type ProgressSummary struct {
+	// Steps is the number of Dockerfile instructions, Cached the number of those buildkit took from its cache
+	Steps  int
+	Cached int
+	Errors int
+	// Executed lists the Dockerfile instructions which were not cached
+	Executed []string
+}
+
+// CacheHitRatio is the share of steps which were cached, or 0 if there were none
+func (p ProgressSummary) CacheHitRatio() float64 {
+	if p.Steps == 0 {
+		return 0
+	}
+	return float64(p.Cached) / float64(p.Steps)
+}
+
+// dockerfileStep matches the names of the vertices which execute a Dockerfile instruction, e.g. [2/3] RUN go version
+// or [stage-1 2/3] COPY . /app, as opposed to the internal ones like loading the build context or exporting the image
+var dockerfileStep = regexp.MustCompile(`^\[[^\]]*\d+/\d+\] `)
+
+// stepCounter counts the steps of a solve, each vertex once it is complete
+type stepCounter struct {
+	sum  ProgressSummary
+	done map[digest.Digest]bool
+}
+
+func (c *stepCounter) add(v *client.Vertex) {
+	if c.done == nil {
+		c.done = make(map[digest.Digest]bool)
+	}
+	if c.done[v.Digest] {
+		return
+	}
+	instruction := dockerfileStep.ReplaceAllString(v.Name, "")
+	isStep := instruction != v.Name
+	switch {
+	case v.Error != "":
+		c.sum.Errors++
+	case v.Cached:
+		if isStep {
+			c.sum.Steps++
+			c.sum.Cached++
+		}
+	case v.Completed != nil:
+		if isStep {
+			c.sum.Steps++
+			c.sum.Executed = append(c.sum.Executed, instruction)
+		}
+	default:
+		return
+	}
+	c.done[v.Digest] = true
+}
+
+// displayStatus shows the progress of a solve which builds the chunk name until ch is closed
+func (s *BuildSession) displayStatus(name string, ch chan *client.SolveStatus) error {
+	w := s.opts.ProgressWriter
+	if s.opts.PrefixedOutput {
+		s.recordProgress(name, displayPrefixedStatus(w, name, ch))
+		return nil
+	}
+
+	// count the steps while progressui displays them
+	var (
+		counter stepCounter
+		display = make(chan *client.SolveStatus)
+	)
+	go func() {
+		defer close(display)
+		for st := range ch {
+			for _, v := range st.Vertexes {
+				counter.add(v)
+			}
+			display <- st
+		}
+	}()
+	defer func() {
+		// drain the status in case the display stopped early, so that the solve does not block.
+		// display is closed once all status has been counted.
+		for range display {
+		}
+		s.recordProgress(name, counter.sum)
+	}()
+
+	var c console.Console
+
+	f, isFile := w.(*os.File)
+	if !s.opts.PlainOutput && isFile && isatty.IsTerminal(f.Fd()) {
+		cf, err := console.ConsoleFromFile(f)
+		if err != nil {
+			return err
+		}
+		c = cf
+	}
+
+	// not using shared context to not disrupt display but let is finish reporting errors
+	_, err := progressui.DisplaySolveStatus(context.TODO(), "", c, w, display)
+	return err
+}
+
+// displayPrefixedStatus writes every line of the solve status to w prefixed with the chunk name,
+// so that the output of several solves can be told apart
+func displayPrefixedStatus(w io.Writer, prefix string, ch chan *client.SolveStatus) ProgressSummary {
+	type vertex struct {
+		idx     int
+		printed bool
+		done    bool
+	}
+	var (
+		counter  stepCounter
+		vertices = make(map[digest.Digest]*vertex)
+	)
+	get := func(dgst digest.Digest) *vertex {
+		v, ok := vertices[dgst]
+		if !ok {
+			v = &vertex{idx: len(vertices) + 1}
+			vertices[dgst] = v
+		}
+		return v
+	}
+
+	for st := range ch {
+		for _, v := range st.Vertexes {
+			counter.add(v)
+			vtx := get(v.Digest)
+			if !vtx.printed && (v.Started != nil || v.Cached) {
+				vtx.printed = true
+				fmt.Fprintf(w, "[%s] #%d %s\n", prefix, vtx.idx, v.Name)
+			}
+			if vtx.done {
+				continue
+			}
+			switch {
+			case v.Error != "":
+				vtx.done = true
+				fmt.Fprintf(w, "[%s] #%d ERROR: %s\n", prefix, vtx.idx, v.Error)
+			case v.Cached:
+				vtx.done = true
+				fmt.Fprintf(w, "[%s] #%d CACHED\n", prefix, vtx.idx)
+			case v.Completed != nil:
+				vtx.done = true
+				if v.Started != nil {
+					fmt.Fprintf(w, "[%s] #%d DONE %.1fs\n", prefix, vtx.idx, v.Completed.Sub(*v.Started).Seconds())
+				} else {
+					fmt.Fprintf(w, "[%s] #%d DONE\n", prefix, vtx.idx)
+				}
+			}
+		}
+		for _, l := range st.Logs {
+			vtx := get(l.Vertex)
+			for _, line := range strings.Split(strings.TrimRight(string(l.Data), "\n"), "\n") {
+				fmt.Fprintf(w, "[%s] #%d %s\n", prefix, vtx.idx, line)
+			}
+		}
+	}
+	return counter.sum
+}
+
+func (s *BuildSession) recordProgress(name string, sum ProgressSummary) {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+
+	if s.progress == nil {
+		s.progress = make(map[string]ProgressSummary)
+	}
+	total := s.progress[name]
+	total.Steps += sum.Steps
+	total.Cached += sum.Cached
+	total.Errors += sum.Errors
+	// the test and full image of a chunk share their instructions, which we list once
+	known := make(map[string]bool, len(total.Executed))
+	for _, e := range total.Executed {
+		known[e] = true
+	}
+	for _, e := range sum.Executed {
+		if !known[e] {
+			known[e] = true
+			total.Executed = append(total.Executed, e)
+		}
+	}
+	s.progress[name] = total
+}
+
+// CacheStats sums up how many Dockerfile steps of a chunk buildkit took from its cache
+type CacheStats struct {
+	Chunk    string  `json:"chunk"`
+	Steps    int     `json:"steps"`
+	Cached   int     `json:"cached"`
+	HitRatio float64 `json:"hitRatio"`
+	// Executed lists the instructions which were not cached, i.e. those which keep invalidating the cache
+	Executed []string `json:"executed,omitempty"`
+}
+
+// CacheStats returns the build cache statistics of the base image and each chunk built during this session
+func (s *BuildSession) CacheStats() []CacheStats {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+
+	res := make([]CacheStats, 0, len(s.progress))
+	for name, sum := range s.progress {
+		if sum.Steps == 0 {
+			continue
+		}
+		res = append(res, CacheStats{
+			Chunk:    name,
+			Steps:    sum.Steps,
+			Cached:   sum.Cached,
+			HitRatio: sum.CacheHitRatio(),
+			Executed: sum.Executed,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Chunk < res[j].Chunk })
+	return res
+}
@@ -0,0 +1,126 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/client"
+)
+
+func TestDisplayPrefixedStatus(t *testing.T) {
+	var (
+		started   = time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+		completed = started.Add(1500 * time.Millisecond)
+	)
+	status := []*client.SolveStatus{
+		{
+			Vertexes: []*client.Vertex{
+				{Digest: "sha256:a", Name: "[1/2] FROM base", Cached: true},
+				{Digest: "sha256:b", Name: "[2/2] RUN go version", Started: &started},
+				{Digest: "sha256:c", Name: "exporting to image"},
+			},
+		},
+		{
+			Logs: []*client.VertexLog{
+				{Vertex: "sha256:b", Data: []byte("go version go1.16\nlinux/amd64\n")},
+			},
+		},
+		{
+			Vertexes: []*client.Vertex{
+				{Digest: "sha256:b", Name: "[2/2] RUN go version", Started: &started, Completed: &completed},
+				{Digest: "sha256:c", Name: "exporting to image", Started: &completed, Error: "failed to push"},
+			},
+		},
+		{
+			Vertexes: []*client.Vertex{
+				{Digest: "sha256:b", Name: "[2/2] RUN go version", Started: &started, Completed: &completed},
+			},
+		},
+	}
+	ch := make(chan *client.SolveStatus, len(status))
+	for _, s := range status {
+		ch <- s
+	}
+	close(ch)
+
+	var out bytes.Buffer
+	sum := displayPrefixedStatus(&out, "golang", ch)
+
+	expectation := `[golang] #1 [1/2] FROM base
+[golang] #1 CACHED
+[golang] #2 [2/2] RUN go version
+[golang] #2 go version go1.16
+[golang] #2 linux/amd64
+[golang] #2 DONE 1.5s
+[golang] #3 exporting to image
+[golang] #3 ERROR: failed to push
+`
+	if diff := cmp.Diff(expectation, out.String()); diff != "" {
+		t.Errorf("displayPrefixedStatus() output mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(ProgressSummary{Steps: 2, Cached: 1, Errors: 1, Executed: []string{"RUN go version"}}, sum); diff != "" {
+		t.Errorf("displayPrefixedStatus() summary mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStepCounter(t *testing.T) {
+	completed := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	vertices := []*client.Vertex{
+		{Digest: "sha256:a", Name: "[internal] load build definition from Dockerfile", Completed: &completed},
+		{Digest: "sha256:b", Name: "[1/3] FROM docker.io/library/ubuntu", Cached: true},
+		{Digest: "sha256:c", Name: "[stage-1 2/3] COPY . /app", Cached: true},
+		{Digest: "sha256:d", Name: "[stage-1 3/3] RUN make"},
+		{Digest: "sha256:d", Name: "[stage-1 3/3] RUN make", Completed: &completed},
+		{Digest: "sha256:d", Name: "[stage-1 3/3] RUN make", Completed: &completed},
+		{Digest: "sha256:e", Name: "exporting to image", Completed: &completed},
+	}
+
+	var counter stepCounter
+	for _, v := range vertices {
+		counter.add(v)
+	}
+	if diff := cmp.Diff(ProgressSummary{Steps: 3, Cached: 2, Executed: []string{"RUN make"}}, counter.sum); diff != "" {
+		t.Errorf("stepCounter mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBuildSession_CacheStats(t *testing.T) {
+	sess, err := NewSession(nil, "localhost:9999/test")
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	sess.recordProgress("node", ProgressSummary{Steps: 3, Cached: 3})
+	sess.recordProgress("go", ProgressSummary{Steps: 4, Cached: 2, Executed: []string{"RUN go get", "RUN go build"}})
+	sess.recordProgress("go", ProgressSummary{Steps: 4, Cached: 3, Executed: []string{"RUN go build"}})
+	sess.recordProgress("base", ProgressSummary{Errors: 1})
+
+	expectation := []CacheStats{
+		{Chunk: "go", Steps: 8, Cached: 5, HitRatio: 0.625, Executed: []string{"RUN go get", "RUN go build"}},
+		{Chunk: "node", Steps: 3, Cached: 3, HitRatio: 1},
+	}
+	if diff := cmp.Diff(expectation, sess.CacheStats()); diff != "" {
+		t.Errorf("CacheStats() mismatch (-want +got):\n%s", diff)
+	}
+}
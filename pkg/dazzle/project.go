@@ -21,15 +21,21 @@
 package dazzle
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"text/template"
 
 	"github.com/bmatcuk/doublestar"
 	"github.com/docker/distribution/reference"
@@ -41,33 +47,100 @@ import (
 )
 
 const (
-	testsDir     = "tests"
-	chunksDir    = "chunks"
-	chunksYamlFN = "chunk.yaml"
+	defaultTestsDir = "tests"
+	chunksDir       = "chunks"
+	chunksYamlFN    = "chunk.yaml"
 )
 
 // ProjectConfig is the structure of a project's dazzle.yaml
 type ProjectConfig struct {
-	Combiner struct {
-		Combinations []ChunkCombination  `yaml:"combinations"`
-		EnvVars      []EnvVarCombination `yaml:"envvars,omitempty"`
-	} `yaml:"combiner"`
-	ChunkIgnore []string `yaml:"ignore,omitempty"`
+	// Version is the version of the project layout, see ProjectConfigVersion. dazzle project migrate upgrades
+	// older projects. Projects without version are treated as current unless they use the legacy layout.
+	Version     int            `yaml:"version,omitempty"`
+	Combiner    CombinerConfig `yaml:"combiner"`
+	ChunkIgnore []string       `yaml:"ignore,omitempty"`
+	// Imports are chunks from other projects, which dazzle project fetch downloads
+	Imports []ChunkImport `yaml:"imports,omitempty"`
+	Tests   TestsConfig   `yaml:"tests,omitempty"`
+	Hash    HashConfig    `yaml:"hash,omitempty"`
+	Layers  LayersConfig  `yaml:"layers,omitempty"`
 
 	chunkIgnores *ignore.GitIgnore
 }
 
+// CombinerConfig configures the combinations of a project
+type CombinerConfig struct {
+	Combinations []ChunkCombination  `yaml:"combinations"`
+	EnvVars      []EnvVarCombination `yaml:"envvars,omitempty"`
+	// Aliases map names combinations can use in place of a chunk to the chunk, e.g. go: golang:1.22,
+	// so that bumping a variant is a single change
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+	// Tag is the template of the tag of the combined images, e.g. {{.Name}}-{{.BaseHash}}-{{.Date}}.
+	// Defaults to the name of the combination.
+	Tag string `yaml:"tag,omitempty"`
+}
+
+// TestsConfig configures the tests of a project
+type TestsConfig struct {
+	// Dir is the directory which holds the test suites, relative to the project. Defaults to tests.
+	Dir string `yaml:"dir,omitempty"`
+	// Env lists the host environment variables which test specs may reference as ${NAME}
+	Env []string `yaml:"env,omitempty"`
+	// MaxOutput is the number of bytes of stdout and stderr captured for tests which don't configure their own limit
+	MaxOutput int `yaml:"maxOutput,omitempty"`
+}
+
+// HashConfig configures how chunk hashes are computed
+type HashConfig struct {
+	// Algorithm is the hash function, highwayhash (default) or sha256
+	Algorithm HashAlgorithm `yaml:"algorithm,omitempty" jsonschema:"enum=highwayhash,enum=sha256"`
+	// Key is mixed into the hash of every chunk. Changing it rebuilds all chunks, and projects which share
+	// a repository can use different keys to avoid colliding tags.
+	Key string `yaml:"key,omitempty"`
+}
+
+// LayersConfig guards the number of layers of the images a project produces
+type LayersConfig struct {
+	// MaxBase is the number of layers the base image may have at most, or 0 for no limit other than the one of all images
+	MaxBase int `yaml:"maxBase,omitempty"`
+}
+
+// HashAlgorithm is a hash function chunk hashes can be computed with
+type HashAlgorithm string
+
+const (
+	// HashAlgorithmHighwayHash computes chunk hashes using HighwayHash-256
+	HashAlgorithmHighwayHash HashAlgorithm = "highwayhash"
+	// HashAlgorithmSHA256 computes chunk hashes using SHA-256
+	HashAlgorithmSHA256 HashAlgorithm = "sha256"
+)
+
+func (c HashConfig) newHash() (hash.Hash, error) {
+	switch c.Algorithm {
+	case "", HashAlgorithmHighwayHash:
+		return highwayhash.New(hashKey)
+	case HashAlgorithmSHA256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm %q, must be %s or %s", c.Algorithm, HashAlgorithmHighwayHash, HashAlgorithmSHA256)
+	}
+}
+
 // ChunkCombination combines several chunks to a new image
 type ChunkCombination struct {
-	Name   string   `yaml:"name"`
+	Name   string   `yaml:"name" jsonschema:"required"`
 	Ref    []string `yaml:"ref"`
 	Chunks []string `yaml:"chunks"`
+	// Default marks the combination dazzle combine builds if none is selected explicitly
+	Default bool `yaml:"default,omitempty"`
+	// Tag overrides the tag template of the combiner for this combination
+	Tag string `yaml:"tag,omitempty"`
 }
 
 // EnvVarCombination describes how env vars are combined
 type EnvVarCombination struct {
-	Name   string                  `yaml:"name"`
-	Action EnvVarCombinationAction `yaml:"action"`
+	Name   string                  `yaml:"name" jsonschema:"required"`
+	Action EnvVarCombinationAction `yaml:"action" jsonschema:"required"`
 }
 
 // EnvVarCombinationAction defines mode by which an env var is combined
@@ -87,13 +160,55 @@ const (
 // ChunkConfig configures a chunk
 type ChunkConfig struct {
 	Variants []ChunkVariant `yaml:"variants"`
+	// Platforms lists the platforms the chunk can be built for, e.g. linux/amd64. Empty means all platforms.
+	Platforms []string `yaml:"platforms,omitempty"`
+	// Workers are the labels of the buildkit worker which builds and tests the chunk, e.g.
+	// org.mobyproject.buildkit.worker.executor: containerd. Empty means the default worker.
+	Workers map[string]string `yaml:"workers,omitempty"`
+	// Template processes the Dockerfile as Go template with DockerfileTemplateData before it is built and hashed
+	Template bool `yaml:"template,omitempty"`
+	// EnvVars declares how the env vars the chunk contributes are combined, unless combiner.envvars of the project
+	// configures them
+	EnvVars []EnvVarCombination `yaml:"envvars,omitempty"`
+	// DependsOn lists the chunks whose images the chunk builds on. They are built first.
+	DependsOn []ChunkDependency `yaml:"dependsOn,omitempty"`
+	// MaxSize is the compressed size the layers of the chunk may have at most, e.g. 500MiB. Zero means no limit.
+	MaxSize ByteSize `yaml:"maxSize,omitempty"`
+	// MaxSizeAction is what happens when the chunk exceeds MaxSize: the build fails (the default) or warns
+	MaxSizeAction SizeBudgetAction `yaml:"maxSizeAction,omitempty" jsonschema:"enum=fail,enum=warn"`
+}
+
+// SizeBudgetAction is what happens when a chunk exceeds its size budget
+type SizeBudgetAction string
+
+const (
+	// SizeBudgetFail fails the build of a chunk which exceeds its size budget
+	SizeBudgetFail SizeBudgetAction = "fail"
+	// SizeBudgetWarn logs a warning for a chunk which exceeds its size budget
+	SizeBudgetWarn SizeBudgetAction = "warn"
+)
+
+// ChunkDependency is a chunk whose full image another chunk uses, e.g. to copy files from it
+type ChunkDependency struct {
+	// Chunk is the name of the chunk, including the variant if it has variants
+	Chunk string `yaml:"chunk" jsonschema:"required"`
+	// Arg is the build arg which receives the ref of the chunk's full image. Defaults to the chunk name in upper case
+	// with everything but letters and digits replaced by underscores, followed by _IMAGE, e.g. GOLANG_1_16_IMAGE.
+	Arg string `yaml:"arg,omitempty"`
 }
 
 // ChunkVariant is a variant of a chunk
 type ChunkVariant struct {
-	Name       string            `yaml:"name"`
+	Name       string            `yaml:"name" jsonschema:"required"`
 	Args       map[string]string `yaml:"args,omitempty"`
 	Dockerfile string            `yaml:"dockerfile,omitempty"`
+	// Tests names a file in the tests directory with tests that run for this variant only, in addition
+	// to the tests shared by all variants. Defaults to <chunk>--<variant>.yaml, which is optional.
+	Tests string `yaml:"tests,omitempty"`
+	// Platforms overrides the platforms of the chunk for this variant
+	Platforms []string `yaml:"platforms,omitempty"`
+	// Workers overrides the worker labels of the chunk for this variant
+	Workers map[string]string `yaml:"workers,omitempty"`
 }
 
 // Write writes this config as YAML to a file
@@ -117,6 +232,13 @@ type Project struct {
 	Base   ProjectChunk
 	Chunks []ProjectChunk
 	Config ProjectConfig
+
+	// ignored lists the chunks excluded by the project's ignore patterns
+	ignored []string
+	// chunkNames are the names of all chunks of the project, including those LoadFromDir did not load
+	chunkNames []string
+	// baseLock pins the images the base Dockerfile builds FROM, if the project has a dazzle.lock
+	baseLock *LockFile
 }
 
 // ProjectChunk represents a layer chunk in a project
@@ -126,11 +248,27 @@ type ProjectChunk struct {
 	ContextPath string
 	Tests       []*test.Spec
 	Args        map[string]string
-
+	// Platforms lists the platforms the chunk can be built for. Empty means all platforms.
+	Platforms []string
+	// Workers are the labels of the buildkit worker which builds and tests the chunk. Empty means the default worker.
+	Workers map[string]string
+	// EnvVars are the env var combination hints of the chunk's chunk.yaml
+	EnvVars []EnvVarCombination
+	// DependsOn are the chunks the chunk's chunk.yaml depends on, with the build arg of each set
+	DependsOn []ChunkDependency
+	// MaxSize is the size budget of the chunk's layers, and MaxSizeAction what happens when they exceed it
+	MaxSize       ByteSize
+	MaxSizeAction SizeBudgetAction
+
+	hashCfg    HashConfig
 	cachedHash struct {
 		ExcludeTests string
 		WithTests    string
 	}
+	// template is true if the Dockerfile is a template which still needs rendering
+	template bool
+	// deps are the chunks of DependsOn, in the same order
+	deps []*ProjectChunk
 }
 
 // LoadProjectConfig loads a dazzle project config file from disk
@@ -151,7 +289,17 @@ func LoadProjectConfig(dir fs.FS) (*ProjectConfig, error) {
 		return nil, fmt.Errorf("cannot load config from %s: %w", cfgfn, err)
 	}
 
+	if cfg.Version > ProjectConfigVersion {
+		return nil, fmt.Errorf("cannot load config from %s: project has version %d, but this version of dazzle supports up to %d", cfgfn, cfg.Version, ProjectConfigVersion)
+	}
 	cfg.chunkIgnores = ignore.CompileIgnoreLines(cfg.ChunkIgnore...)
+	if _, err := cfg.Hash.newHash(); err != nil {
+		return nil, fmt.Errorf("cannot load config from %s: %w", cfgfn, err)
+	}
+	if cfg.Tests.Dir != "" && !fs.ValidPath(path.Clean(cfg.Tests.Dir)) {
+		return nil, fmt.Errorf("cannot load config from %s: tests.dir must be a relative path within the project", cfgfn)
+	}
+	err = validateImports(cfg.Imports)
 	if err != nil {
 		return nil, fmt.Errorf("cannot load config from %s: %w", cfgfn, err)
 	}
@@ -159,13 +307,29 @@ func LoadProjectConfig(dir fs.FS) (*ProjectConfig, error) {
 	return &cfg, nil
 }
 
+// TestsDir returns the directory which holds the test suites of the project
+func (pc *ProjectConfig) TestsDir() string {
+	if pc.Tests.Dir == "" {
+		return defaultTestsDir
+	}
+	return path.Clean(pc.Tests.Dir)
+}
+
 // LoadFromDirOpts configures LoadFromDir
 type LoadFromDirOpts struct {
 	FS func(dir string) fs.FS
+	// Chunks are the names of the chunks to load, including their variants. The chunks they depend on are loaded
+	// as well. Empty means all chunks. Commands which need a few chunks only use this to skip reading the
+	// Dockerfiles and tests of all others.
+	Chunks []string
 }
 
-// LoadFromDir loads a dazzle project from disk
-func LoadFromDir(contextBase string, opts LoadFromDirOpts) (*Project, error) {
+// LoadFromDir loads a dazzle project from disk. All errors it returns are of ErrorKindConfig.
+func LoadFromDir(contextBase string, opts LoadFromDirOpts) (_ *Project, err error) {
+	defer func() {
+		err = withKind(ErrorKindConfig, err)
+	}()
+
 	if opts.FS == nil {
 		opts.FS = os.DirFS
 	}
@@ -175,12 +339,21 @@ func LoadFromDir(contextBase string, opts LoadFromDirOpts) (*Project, error) {
 	if err != nil {
 		return nil, err
 	}
+	cfg.Combiner.Combinations, err = resolveAliases(cfg.Combiner.Combinations, cfg.Combiner.Aliases)
+	if err != nil {
+		return nil, err
+	}
 	cfg.Combiner.Combinations, err = resolveCombinations(cfg.Combiner.Combinations)
 	if err != nil {
 		return nil, err
 	}
 
-	base, err := loadChunks(dir, contextBase, "", "base")
+	if _, err := fs.Stat(dir, "base"); os.IsNotExist(err) {
+		if _, err := fs.Stat(dir, legacyBaseDir); err == nil {
+			return nil, fmt.Errorf("project uses the legacy layout with %s, run dazzle project migrate", legacyBaseDir)
+		}
+	}
+	base, err := loadChunks(dir, contextBase, "", "base", cfg.TestsDir())
 	if err != nil {
 		return nil, err
 	}
@@ -192,51 +365,119 @@ func LoadFromDir(contextBase string, opts LoadFromDirOpts) (*Project, error) {
 		Config: *cfg,
 		Base:   base[0],
 	}
-	chds, err := fs.ReadDir(dir, chunksDir)
+	res.baseLock, err = loadLockFile(dir)
 	if err != nil {
 		return nil, err
 	}
+	idx, err := buildChunkIndex(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var selected map[string]bool
+	if len(opts.Chunks) > 0 {
+		selected, err = idx.selectChunks(opts.Chunks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.chunkNames = idx.names()
+	res.ignored = idx.ignored
 
-	res.Chunks = make([]ProjectChunk, 0, len(chds))
-	for _, chd := range chds {
-		if strings.HasPrefix(chd.Name(), "_") || strings.HasPrefix(chd.Name(), ".") {
+	res.Chunks = make([]ProjectChunk, 0, len(res.chunkNames))
+	for _, d := range idx.dirs {
+		if selected != nil && !d.selectedBy(selected) {
 			continue
 		}
-		if !chd.IsDir() {
-			continue
+
+		var chnk []ProjectChunk
+		if d.imp == nil {
+			chnk, err = loadChunks(dir, contextBase, chunksDir, d.name, cfg.TestsDir())
+		} else {
+			chnk, err = loadImportedChunks(dir, contextBase, *d.imp)
+		}
+		if err != nil {
+			return nil, err
 		}
-		chnk, err := loadChunks(dir, contextBase, chunksDir, chd.Name())
+
+		for _, chk := range chnk {
+			if !d.has(chk.Name) {
+				// ignored
+				continue
+			}
+			if selected != nil && !selected[chk.Name] {
+				continue
+			}
+			res.Chunks = append(res.Chunks, chk)
+		}
+	}
+
+	if len(res.Base.DependsOn) > 0 {
+		return nil, fmt.Errorf("the base image cannot depend on chunks")
+	}
+	err = res.resolveDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	err = res.Base.renderDockerfile(res)
+	if err != nil {
+		return nil, err
+	}
+	res.Base.hashCfg = cfg.Hash
+	for i := range res.Chunks {
+		err = res.Chunks[i].renderDockerfile(res)
 		if err != nil {
 			return nil, err
 		}
+		res.Chunks[i].hashCfg = cfg.Hash
+	}
 
-		res.Chunks = append(res.Chunks, filterChunks(chnk, cfg.chunkIgnores)...)
+	if cfg.Tests.MaxOutput != 0 {
+		for _, chk := range res.Chunks {
+			for _, spec := range chk.Tests {
+				if spec.MaxOutput == 0 {
+					spec.MaxOutput = cfg.Tests.MaxOutput
+				}
+			}
+		}
 	}
 
 	return res, nil
 }
 
-func filterChunks(chunks []ProjectChunk, ignores *ignore.GitIgnore) []ProjectChunk {
-	if ignores == nil {
-		return chunks
+// resolveAliases replaces the chunk aliases used by combinations with the chunks they stand for
+func resolveAliases(ipt []ChunkCombination, aliases map[string]string) ([]ChunkCombination, error) {
+	for alias, chk := range aliases {
+		if _, ok := aliases[chk]; ok {
+			return nil, fmt.Errorf("alias %s refers to alias %s rather than a chunk", alias, chk)
+		}
+	}
+	if len(aliases) == 0 {
+		return ipt, nil
 	}
 
-	filtered := make([]ProjectChunk, 0)
-	for _, chunk := range chunks {
-		if ignores.MatchesPath(chunk.Name) {
-			continue
+	res := make([]ChunkCombination, len(ipt))
+	for i, c := range ipt {
+		chunks := make([]string, len(c.Chunks))
+		for j, chk := range c.Chunks {
+			if target, ok := aliases[chk]; ok {
+				chk = target
+			}
+			chunks[j] = chk
 		}
-		filtered = append(filtered, chunk)
+		c.Chunks = chunks
+		res[i] = c
 	}
-
-	return filtered
+	return res, nil
 }
 
 func resolveCombinations(ipt []ChunkCombination) ([]ChunkCombination, error) {
 	type Comb struct {
-		Chunks map[string]struct{}
-		Ref    []string
-		Combs  []*Comb
+		Chunks  map[string]struct{}
+		Ref     []string
+		Combs   []*Comb
+		Default bool
+		Tag     string
 	}
 	idx := make(map[string]*Comb)
 	for _, c := range ipt {
@@ -245,8 +486,10 @@ func resolveCombinations(ipt []ChunkCombination) ([]ChunkCombination, error) {
 			chks[ck] = struct{}{}
 		}
 		idx[c.Name] = &Comb{
-			Ref:    c.Ref,
-			Chunks: chks,
+			Ref:     c.Ref,
+			Chunks:  chks,
+			Default: c.Default,
+			Tag:     c.Tag,
 		}
 	}
 	for n, c := range idx {
@@ -291,8 +534,10 @@ func resolveCombinations(ipt []ChunkCombination) ([]ChunkCombination, error) {
 		}
 		sort.Strings(chunks)
 		res = append(res, ChunkCombination{
-			Name:   n,
-			Chunks: chunks,
+			Name:    n,
+			Chunks:  chunks,
+			Default: c.Default,
+			Tag:     c.Tag,
 		})
 	}
 
@@ -301,12 +546,13 @@ func resolveCombinations(ipt []ChunkCombination) ([]ChunkCombination, error) {
 	return res, nil
 }
 
-func loadChunks(dir fs.FS, contextBase, base, name string) (res []ProjectChunk, err error) {
+func loadChunks(dir fs.FS, contextBase, base, name, testsDir string) (res []ProjectChunk, err error) {
 	load := func(name string, v ChunkVariant) (*ProjectChunk, error) {
 		chk := ProjectChunk{
 			Name:        name,
 			ContextPath: filepath.Join(contextBase, base, name),
 			Args:        v.Args,
+			Workers:     v.Workers,
 		}
 
 		dfn := "Dockerfile"
@@ -320,58 +566,251 @@ func loadChunks(dir fs.FS, contextBase, base, name string) (res []ProjectChunk,
 		}
 
 		var err error
+		chk.Platforms, err = normalizePlatforms(v.Platforms)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %s: %w", name, err)
+		}
 		chk.Dockerfile, err = fs.ReadFile(dir, dockerfn)
 		if err != nil {
 			return nil, err
 		}
-
-		tf, err := fs.ReadFile(dir, filepath.Join(testsDir, fmt.Sprintf("%s.yaml", name)))
-		if os.IsNotExist(err) {
-			// no tests - we're good
-			return &chk, nil
-		} else if err != nil {
-			return nil, fmt.Errorf("%s: cannot read tests.yaml: %w", dir, err)
+		chk.Dockerfile, err = spliceIncludes(dir, dockerfn, chk.Dockerfile, nil)
+		if err != nil {
+			return nil, err
 		}
 
-		decoder := yaml.NewDecoder(bytes.NewReader(tf))
-		decoder.KnownFields(true)
-		err = decoder.Decode(&chk.Tests)
+		chk.Tests, err = loadTests(dir, testsDir, name, false)
 		if err != nil {
-			return &chk, fmt.Errorf("%s: cannot read tests.yaml: %w", dir, err)
+			return &chk, err
 		}
-		return &chk, nil
-	}
 
-	fd, err := dir.Open(filepath.Join(base, name, chunksYamlFN))
-	if err == nil {
-		defer fd.Close()
-		var cfg ChunkConfig
-		err = yaml.NewDecoder(fd).Decode(&cfg)
+		if v.Name == "" {
+			return &chk, nil
+		}
+		vtfn, required := strings.TrimSuffix(v.Tests, ".yaml"), true
+		if vtfn == "" {
+			vtfn, required = variantTestsName(name, v.Name), false
+		}
+		vtests, err := loadTests(dir, testsDir, vtfn, required)
 		if err != nil {
-			return nil, fmt.Errorf("cannot load config from %s: %w", chunksYamlFN, err)
+			return &chk, err
 		}
+		chk.Tests = append(chk.Tests, vtests...)
+		return &chk, nil
+	}
 
+	cfg, err := loadChunkConfig(dir, filepath.Join(base, name))
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil && len(cfg.Variants) > 0 {
 		for _, v := range cfg.Variants {
+			if len(v.Platforms) == 0 {
+				v.Platforms = cfg.Platforms
+			}
+			if len(v.Workers) == 0 {
+				v.Workers = cfg.Workers
+			}
 			chk, err := load(name, v)
 			if err != nil {
 				return nil, err
 			}
 			chk.Name = fmt.Sprintf("%s:%s", name, v.Name)
+			chk.EnvVars = cfg.EnvVars
+			chk.DependsOn = withDependencyArgs(cfg.DependsOn)
+			chk.MaxSize, chk.MaxSizeAction = cfg.MaxSize, cfg.MaxSizeAction
+			chk.template = cfg.Template
 			res = append(res, *chk)
 		}
 		return res, nil
-	} else if !os.IsNotExist(err) {
-		return nil, err
 	}
 
 	// not a variant chunk
-	chk, err := load(name, ChunkVariant{})
+	var v ChunkVariant
+	if cfg != nil {
+		v.Platforms = cfg.Platforms
+		v.Workers = cfg.Workers
+	}
+	chk, err := load(name, v)
 	if err != nil {
 		return nil, err
 	}
+	if cfg != nil {
+		chk.EnvVars = cfg.EnvVars
+		chk.DependsOn = withDependencyArgs(cfg.DependsOn)
+		chk.MaxSize, chk.MaxSizeAction = cfg.MaxSize, cfg.MaxSizeAction
+		chk.template = cfg.Template
+	}
 	return []ProjectChunk{*chk}, nil
 }
 
+var dockerfileInclude = regexp.MustCompile(`^\s*#\s*dazzle:include\s+(\S+)\s*$`)
+
+// spliceIncludes replaces "#dazzle:include <path>" lines of a Dockerfile with the content of the file at path,
+// relative to the Dockerfile, so that fragments shared by many chunks become part of their Dockerfile and hash.
+// Fragments can include further fragments.
+func spliceIncludes(dir fs.FS, fn string, dockerfile []byte, includedBy []string) ([]byte, error) {
+	if !bytes.Contains(dockerfile, []byte("dazzle:include")) {
+		return dockerfile, nil
+	}
+	for _, f := range includedBy {
+		if f == fn {
+			return nil, fmt.Errorf("%s: include cycle: %s", fn, strings.Join(append(includedBy, fn), " -> "))
+		}
+	}
+
+	var (
+		res     bytes.Buffer
+		scanner = bufio.NewScanner(bytes.NewReader(dockerfile))
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		match := dockerfileInclude.FindStringSubmatch(line)
+		if match == nil {
+			res.WriteString(line)
+			res.WriteString("\n")
+			continue
+		}
+
+		ifn := path.Join(path.Dir(fn), match[1])
+		if !fs.ValidPath(ifn) {
+			return nil, fmt.Errorf("%s: cannot include %s: outside of the project", fn, match[1])
+		}
+		fc, err := fs.ReadFile(dir, ifn)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot include %s: %w", fn, match[1], err)
+		}
+		fc, err = spliceIncludes(dir, ifn, fc, append(includedBy, fn))
+		if err != nil {
+			return nil, err
+		}
+		res.Write(fc)
+		if len(fc) > 0 && fc[len(fc)-1] != '\n' {
+			res.WriteString("\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return res.Bytes(), nil
+}
+
+// DockerfileTemplateData is what the Dockerfile of a chunk which opts into templating can refer to
+type DockerfileTemplateData struct {
+	// Chunk is the name of the chunk without the variant
+	Chunk string
+	// Variant is the name of the variant, or empty if the chunk has no variants
+	Variant string
+	// Args are the build args of the variant
+	Args map[string]string
+	// Combinations are the names of the combinations the chunk is part of
+	Combinations []string
+	// Chunks are the names of all chunks of the project, including their variants
+	Chunks []string
+}
+
+// renderDockerfile processes the Dockerfile of a chunk as template, so that the rendered output is
+// what buildkit builds and what the chunk hash is computed from
+func (p *ProjectChunk) renderDockerfile(prj *Project) error {
+	if !p.template {
+		return nil
+	}
+
+	data := DockerfileTemplateData{Args: p.Args}
+	data.Chunk, data.Variant, _ = strings.Cut(p.Name, ":")
+	if data.Args == nil {
+		data.Args = make(map[string]string)
+	}
+	for _, comb := range prj.Config.Combiner.Combinations {
+		for _, c := range comb.Chunks {
+			if c == p.Name {
+				data.Combinations = append(data.Combinations, comb.Name)
+				break
+			}
+		}
+	}
+	data.Chunks = prj.chunkNames
+
+	tpl, err := template.New(p.Name).Option("missingkey=error").Parse(string(p.Dockerfile))
+	if err != nil {
+		return fmt.Errorf("chunk %s: cannot parse Dockerfile template: %w", p.Name, err)
+	}
+	var out bytes.Buffer
+	err = tpl.Execute(&out, data)
+	if err != nil {
+		return fmt.Errorf("chunk %s: cannot render Dockerfile template: %w", p.Name, err)
+	}
+	p.Dockerfile = out.Bytes()
+	p.template = false
+	return nil
+}
+
+// loadChunkConfig reads the chunk.yaml of a chunk directory, returning nil if the chunk has none
+func loadChunkConfig(dir fs.FS, chunkDir string) (*ChunkConfig, error) {
+	fd, err := dir.Open(filepath.Join(chunkDir, chunksYamlFN))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var cfg ChunkConfig
+	err = yaml.NewDecoder(fd).Decode(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load config from %s: %w", chunksYamlFN, err)
+	}
+	switch cfg.MaxSizeAction {
+	case "", SizeBudgetFail, SizeBudgetWarn:
+	default:
+		return nil, fmt.Errorf("cannot load config from %s: unknown maxSizeAction %q, must be %s or %s", chunksYamlFN, cfg.MaxSizeAction, SizeBudgetFail, SizeBudgetWarn)
+	}
+	return &cfg, nil
+}
+
+// variantTestsName is the name of the suite in the tests directory which holds the tests of a chunk variant
+func variantTestsName(chunk, variant string) string {
+	return fmt.Sprintf("%s--%s", chunk, variant)
+}
+
+// loadTests reads the test suite of a name from the tests directory: the file <name>.yaml followed by all
+// <name>/*.yaml files in lexical order. No such file means there are no tests, unless the suite is required.
+func loadTests(dir fs.FS, testsDir, name string, required bool) ([]*test.Spec, error) {
+	fns := []string{path.Join(testsDir, name+".yaml")}
+	sfns, err := fs.Glob(dir, path.Join(testsDir, name, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(sfns)
+	fns = append(fns, sfns...)
+
+	var (
+		res     []*test.Spec
+		found   bool
+		missing error
+	)
+	for _, fn := range fns {
+		tf, err := fs.ReadFile(dir, fn)
+		if os.IsNotExist(err) {
+			missing = err
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %w", fn, err)
+		}
+		found = true
+
+		suite, err := test.ParseSuite(tf)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %w", fn, err)
+		}
+		res = append(res, suite.Specs()...)
+	}
+	if !found && required {
+		return nil, fmt.Errorf("cannot read %s.yaml: %w", name, missing)
+	}
+	return res, nil
+}
+
 func (p *ProjectChunk) hash(baseref string, excludeTests bool) (res string, err error) {
 	var cachedHash *string
 	if excludeTests {
@@ -389,7 +828,7 @@ func (p *ProjectChunk) hash(baseref string, excludeTests bool) (res string, err
 		}
 	}()
 
-	hash, err := highwayhash.New(hashKey)
+	hash, err := p.hashCfg.newHash()
 	if err != nil {
 		return
 	}
@@ -404,45 +843,66 @@ func (p *ProjectChunk) hash(baseref string, excludeTests bool) (res string, err
 	return
 }
 
+// sourceRecord describes a file of the build context for the chunk hash: its type and the permission bits
+// which end up in the image, and the hash of the content of regular files or the target of symlinks
+func sourceRecord(src string) (string, error) {
+	stat, err := os.Lstat(src)
+	if err != nil {
+		return "", err
+	}
+	mode := stat.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+
+	switch {
+	case stat.IsDir():
+		return fmt.Sprintf("dir:%o", mode), nil
+	case stat.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("symlink:%s", filepath.ToSlash(target)), nil
+	case !stat.Mode().IsRegular():
+		return fmt.Sprintf("%s:%o", stat.Mode().Type(), mode), nil
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash, err := highwayhash.New(hashKey)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("file:%o:%s", mode, hex.EncodeToString(hash.Sum(nil))), nil
+}
+
 func (p *ProjectChunk) manifest(baseref string, out io.Writer, excludeTests bool) (err error) {
 	sources, err := doublestar.Glob(filepath.Join(p.ContextPath, "**/*"))
 	if err != nil {
 		return
 	}
 
+	// paths are relative to the context with forward slashes and sorted, so that the hash does not depend on
+	// the OS or the way the context path is spelled
 	res := make([]string, 0, len(sources))
 	for _, src := range sources {
-		if stat, err := os.Stat(src); err != nil {
-			return err
-		} else if stat.IsDir() {
-			res = append(res, strings.TrimPrefix(src, p.ContextPath))
-			continue
-		}
-
-		file, err := os.OpenFile(src, os.O_RDONLY, 0644)
-		if err != nil {
-			return err
-		}
-
-		hash, err := highwayhash.New(hashKey)
-		if err != nil {
-			file.Close()
-			return err
-		}
-
-		_, err = io.Copy(hash, file)
+		rec, err := sourceRecord(src)
 		if err != nil {
-			file.Close()
 			return err
 		}
-
-		err = file.Close()
+		rel, err := filepath.Rel(p.ContextPath, src)
 		if err != nil {
 			return err
 		}
-
-		res = append(res, fmt.Sprintf("%s:%s", strings.TrimPrefix(src, p.ContextPath), hex.EncodeToString(hash.Sum(nil))))
+		res = append(res, fmt.Sprintf("/%s:%s", filepath.ToSlash(rel), rec))
 	}
+	sort.Strings(res)
 
 	args := make([]string, 0, len(p.Args))
 	for k, v := range p.Args {
@@ -450,12 +910,29 @@ func (p *ProjectChunk) manifest(baseref string, out io.Writer, excludeTests bool
 	}
 	sort.Strings(args)
 
+	// the images of the dependencies are build args, hence their hashes are part of the chunk's hash
+	deps := make([]string, 0, len(p.deps))
+	for i, dep := range p.deps {
+		h, err := dep.hash(baseref, true)
+		if err != nil {
+			return err
+		}
+		deps = append(deps, fmt.Sprintf("%s=%s@%s", p.DependsOn[i].Arg, dep.Name, h))
+	}
+	sort.Strings(deps)
+
+	if p.hashCfg.Key != "" {
+		fmt.Fprintf(out, "Key: %s\n", p.hashCfg.Key)
+	}
 	if baseref != "" {
 		fmt.Fprintf(out, "Baseref: %s\n", baseref)
 	}
 	fmt.Fprintf(out, "Dockerfile: %s\n", string(p.Dockerfile))
 	fmt.Fprintf(out, "Sources:\n%s\n", strings.Join(res, "\n"))
 	fmt.Fprintf(out, "Args:\n%s\n", strings.Join(args, "\n"))
+	if len(deps) > 0 {
+		fmt.Fprintf(out, "DependsOn:\n%s\n", strings.Join(deps, "\n"))
+	}
 	if !excludeTests {
 		tests, _ := yaml.Marshal(p.Tests)
 		fmt.Fprintf(out, "Tests:\n%s\n", string(tests))
@@ -478,12 +955,14 @@ const (
 
 	// imageTypeTestResult stores the test result of a chunk - for internal use only, not actually a chunk
 	imageTypeTestResult ChunkImageType = "test-result"
+	// imageTypeCombinationTestResult stores the test result of a chunk run against a combination - for internal use only
+	imageTypeCombinationTestResult ChunkImageType = "combination-test-result"
 )
 
 // ImageName produces a chunk image name
 func (p *ProjectChunk) ImageName(tpe ChunkImageType, sess *BuildSession) (reference.NamedTagged, error) {
 	if sess.baseRef == nil {
-		return nil, fmt.Errorf("base ref not set")
+		return nil, ErrBaseNotResolved
 	}
 
 	if tpe == ImageTypeChunkedNoHash {
@@ -511,20 +990,62 @@ func (p *ProjectChunk) ImageName(tpe ChunkImageType, sess *BuildSession) (refere
 	return reference.WithTag(sess.Dest, fmt.Sprintf("%s--%s--%s", safeName, hash, tpe))
 }
 
+// testResultRef produces the name under which the test result of this chunk is stored.
+// env are the values of the host env vars the tests reference, see test.ResolvedEnv.
+func (p *ProjectChunk) testResultRef(env []string, sess *BuildSession) (reference.NamedTagged, error) {
+	ref, err := p.ImageName(imageTypeTestResult, sess)
+	if err != nil || len(env) == 0 {
+		return ref, err
+	}
+
+	hash, err := highwayhash.New(hashKey)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(hash, "Result: %s\n", ref.Tag())
+	for _, e := range env {
+		fmt.Fprintf(hash, "Env: %s\n", e)
+	}
+
+	safeName := strings.ReplaceAll(p.Name, ":", "-")
+	return reference.WithTag(sess.Dest, fmt.Sprintf("%s--%s--%s", safeName, hex.EncodeToString(hash.Sum(nil)), imageTypeTestResult))
+}
+
 // PrintManifest prints the manifest to writer ... this is intended for debugging only
 func (p *ProjectChunk) PrintManifest(out io.Writer, sess *BuildSession) error {
 	if sess.baseRef == nil {
-		return fmt.Errorf("base ref not set")
+		return ErrBaseNotResolved
 	}
 
 	return p.manifest(sess.baseRef.String(), out, false)
 }
 
-// PrintManifest prints the manifest to writer ... this is intended for debugging only
+// Hash computes the hash of the chunk, including its tests unless the session excludes tests
 func (p *ProjectChunk) Hash(out io.Writer, sess *BuildSession) (string, error) {
 	if sess.baseRef == nil {
-		return "", fmt.Errorf("base ref not set")
+		return "", ErrBaseNotResolved
 	}
 
 	return p.hash(sess.baseRef.String(), sess.opts.NoTests)
 }
+
+// ChunkHashes are the hashes of a chunk with and without its tests. The test image of a chunk is tagged
+// with the former, all other images with the latter, so that changing a test does not rebuild the chunk.
+type ChunkHashes struct {
+	WithTests    string `yaml:"withTests" json:"withTests"`
+	ExcludeTests string `yaml:"excludeTests" json:"excludeTests"`
+}
+
+// Hashes computes both hashes of the chunk ... this is intended for debugging only
+func (p *ProjectChunk) Hashes(sess *BuildSession) (res ChunkHashes, err error) {
+	if sess.baseRef == nil {
+		return res, ErrBaseNotResolved
+	}
+
+	res.WithTests, err = p.hash(sess.baseRef.String(), false)
+	if err != nil {
+		return
+	}
+	res.ExcludeTests, err = p.hash(sess.baseRef.String(), true)
+	return
+}
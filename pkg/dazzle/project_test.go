@@ -21,10 +21,16 @@
 package dazzle
 
 import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"testing/fstest"
 
 	"github.com/google/go-cmp/cmp"
+
+	"github.com/gitpod-io/dazzle/pkg/test"
 )
 
 func TestLoadChunk(t *testing.T) {
@@ -114,11 +120,314 @@ func TestLoadChunk(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:  "load variant chunk with variant tests",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM foobar"),
+				},
+				"chunks/foobar/chunk.yaml": {
+					Data: []byte("variants:\n  - name: v1\n  - name: v2\n    tests: go-v2.yaml\n  - name: v3"),
+				},
+				"tests/foobar.yaml": {
+					Data: []byte("- desc: shared\n  command: [true]\n"),
+				},
+				"tests/foobar--v1.yaml": {
+					Data: []byte("- desc: v1 only\n  command: [true]\n"),
+				},
+				"tests/go-v2.yaml": {
+					Data: []byte("- desc: v2 only\n  command: [true]\n"),
+				},
+			},
+			Expectation: Expectation{
+				Chunks: []ProjectChunk{
+					{
+						Name:        "foobar:v1",
+						Dockerfile:  []byte("FROM foobar"),
+						ContextPath: "chunks/foobar",
+						Tests:       []*test.Spec{{Desc: "shared", Command: []string{"true"}}, {Desc: "v1 only", Command: []string{"true"}}},
+					},
+					{
+						Name:        "foobar:v2",
+						Dockerfile:  []byte("FROM foobar"),
+						ContextPath: "chunks/foobar",
+						Tests:       []*test.Spec{{Desc: "shared", Command: []string{"true"}}, {Desc: "v2 only", Command: []string{"true"}}},
+					},
+					{
+						Name:        "foobar:v3",
+						Dockerfile:  []byte("FROM foobar"),
+						ContextPath: "chunks/foobar",
+						Tests:       []*test.Spec{{Desc: "shared", Command: []string{"true"}}},
+					},
+				},
+			},
+		},
+		{
+			Name:  "load chunk with split test suite",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM foobar"),
+				},
+				"tests/foobar.yaml": {
+					Data: []byte("- desc: first\n  command: [true]\n"),
+				},
+				"tests/foobar/b.yaml": {
+					Data: []byte("- desc: third\n  command: [true]\n"),
+				},
+				"tests/foobar/a.yaml": {
+					Data: []byte("- desc: second\n  command: [true]\n"),
+				},
+				"tests/foobar/README.md": {
+					Data: []byte("not a suite"),
+				},
+			},
+			Expectation: Expectation{
+				Chunks: []ProjectChunk{
+					{
+						Name:        "foobar",
+						Dockerfile:  []byte("FROM foobar"),
+						ContextPath: "chunks/foobar",
+						Tests: []*test.Spec{
+							{Desc: "first", Command: []string{"true"}},
+							{Desc: "second", Command: []string{"true"}},
+							{Desc: "third", Command: []string{"true"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "load variant chunk with missing variant tests",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM foobar"),
+				},
+				"chunks/foobar/chunk.yaml": {
+					Data: []byte("variants:\n  - name: v1\n    tests: missing.yaml"),
+				},
+			},
+			Expectation: Expectation{
+				Err: "cannot read missing.yaml: open tests/missing.yaml: file does not exist",
+			},
+		},
+		{
+			Name:  "load variant chunk with env var hints",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM foobar"),
+				},
+				"chunks/foobar/chunk.yaml": {
+					Data: []byte("envvars:\n  - name: PATH\n    action: merge-unique\nvariants:\n  - name: v1\n"),
+				},
+			},
+			Expectation: Expectation{
+				Chunks: []ProjectChunk{
+					{
+						Name:        "foobar:v1",
+						Dockerfile:  []byte("FROM foobar"),
+						ContextPath: "chunks/foobar",
+						EnvVars:     []EnvVarCombination{{Name: "PATH", Action: EnvVarCombineMergeUnique}},
+					},
+				},
+			},
+		},
+		{
+			Name:  "load variant chunk with platforms",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM foobar"),
+				},
+				"chunks/foobar/chunk.yaml": {
+					Data: []byte("platforms: [linux/amd64, linux/arm64/v8]\nvariants:\n  - name: v1\n  - name: v2\n    platforms: [linux/amd64]"),
+				},
+			},
+			Expectation: Expectation{
+				Chunks: []ProjectChunk{
+					{
+						Name:        "foobar:v1",
+						Dockerfile:  []byte("FROM foobar"),
+						ContextPath: "chunks/foobar",
+						Platforms:   []string{"linux/amd64", "linux/arm64"},
+					},
+					{
+						Name:        "foobar:v2",
+						Dockerfile:  []byte("FROM foobar"),
+						ContextPath: "chunks/foobar",
+						Platforms:   []string{"linux/amd64"},
+					},
+				},
+			},
+		},
+		{
+			Name:  "load variant chunk with workers",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM foobar"),
+				},
+				"chunks/foobar/chunk.yaml": {
+					Data: []byte("workers:\n  org.mobyproject.buildkit.worker.executor: containerd\nvariants:\n  - name: v1\n  - name: v2\n    workers:\n      gpu: nvidia"),
+				},
+			},
+			Expectation: Expectation{
+				Chunks: []ProjectChunk{
+					{
+						Name:        "foobar:v1",
+						Dockerfile:  []byte("FROM foobar"),
+						ContextPath: "chunks/foobar",
+						Workers:     map[string]string{"org.mobyproject.buildkit.worker.executor": "containerd"},
+					},
+					{
+						Name:        "foobar:v2",
+						Dockerfile:  []byte("FROM foobar"),
+						ContextPath: "chunks/foobar",
+						Workers:     map[string]string{"gpu": "nvidia"},
+					},
+				},
+			},
+		},
+		{
+			Name:  "load chunk with includes",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM alpine\n#dazzle:include ../_fragments/apt.df\nRUN true"),
+				},
+				"chunks/_fragments/apt.df": {
+					Data: []byte("RUN apt-get update\n  # dazzle:include cleanup.df"),
+				},
+				"chunks/_fragments/cleanup.df": {
+					Data: []byte("RUN rm -rf /var/lib/apt/lists/*\n"),
+				},
+			},
+			Expectation: Expectation{
+				Chunks: []ProjectChunk{
+					{
+						Name:        "foobar",
+						ContextPath: "chunks/foobar",
+						Dockerfile:  []byte("FROM alpine\nRUN apt-get update\nRUN rm -rf /var/lib/apt/lists/*\nRUN true\n"),
+					},
+				},
+			},
+		},
+		{
+			Name:  "load chunk with include cycle",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM alpine\n#dazzle:include a.df"),
+				},
+				"chunks/foobar/a.df": {
+					Data: []byte("#dazzle:include b.df"),
+				},
+				"chunks/foobar/b.df": {
+					Data: []byte("#dazzle:include a.df"),
+				},
+			},
+			Expectation: Expectation{
+				Err: "chunks/foobar/a.df: include cycle: chunks/foobar/Dockerfile -> chunks/foobar/a.df -> chunks/foobar/b.df -> chunks/foobar/a.df",
+			},
+		},
+		{
+			Name:  "load chunk with include outside of the project",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM alpine\n#dazzle:include ../../../etc/passwd"),
+				},
+			},
+			Expectation: Expectation{
+				Err: "chunks/foobar/Dockerfile: cannot include ../../../etc/passwd: outside of the project",
+			},
+		},
+		{
+			Name:  "load chunk with suite setup",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM alpine"),
+				},
+				"tests/foobar.yaml": {
+					Data: []byte("before:\n- [touch, /tmp/suite]\nafter:\n- [rm, /tmp/suite]\ntests:\n- desc: it should find the fixture\n  before:\n  - [touch, /tmp/spec]\n  command: [ls, /tmp/suite, /tmp/spec]\n  assert:\n  - status == 0\n"),
+				},
+			},
+			Expectation: Expectation{
+				Chunks: []ProjectChunk{
+					{
+						Name:        "foobar",
+						ContextPath: "chunks/foobar",
+						Dockerfile:  []byte("FROM alpine"),
+						Tests: []*test.Spec{
+							{
+								Desc:       "it should find the fixture",
+								Command:    []string{"ls", "/tmp/suite", "/tmp/spec"},
+								Before:     [][]string{{"touch", "/tmp/suite"}, {"touch", "/tmp/spec"}},
+								After:      [][]string{{"rm", "/tmp/suite"}},
+								Assertions: []string{"status == 0"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "load chunk with test matrix",
+			Base:  "chunks",
+			Chunk: "foobar",
+			FS: map[string]*fstest.MapFile{
+				"chunks/foobar/Dockerfile": {
+					Data: []byte("FROM alpine"),
+				},
+				"tests/foobar.yaml": {
+					Data: []byte("matrix:\n  JAVA_VERSION: [11, 17]\ntests:\n- desc: it should have java\n  command: [sh, -c, \"java -version\"]\n  assert:\n  - status == 0\n"),
+				},
+			},
+			Expectation: Expectation{
+				Chunks: []ProjectChunk{
+					{
+						Name:        "foobar",
+						ContextPath: "chunks/foobar",
+						Dockerfile:  []byte("FROM alpine"),
+						Tests: []*test.Spec{
+							{
+								Desc:       "it should have java [JAVA_VERSION=11]",
+								Command:    []string{"sh", "-c", "java -version"},
+								Env:        []string{"JAVA_VERSION=11"},
+								Assertions: []string{"status == 0"},
+								Matrix:     "JAVA_VERSION=11",
+							},
+							{
+								Desc:       "it should have java [JAVA_VERSION=17]",
+								Command:    []string{"sh", "-c", "java -version"},
+								Env:        []string{"JAVA_VERSION=17"},
+								Assertions: []string{"status == 0"},
+								Matrix:     "JAVA_VERSION=17",
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
-			chk, err := loadChunks(fstest.MapFS(test.FS), "", test.Base, test.Chunk)
+			chk, err := loadChunks(fstest.MapFS(test.FS), "", test.Base, test.Chunk, defaultTestsDir)
 			var act Expectation
 			if err != nil {
 				act.Err = err.Error()
@@ -189,6 +498,19 @@ func TestResolveCombinations(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "default",
+			Input: []ChunkCombination{
+				{Name: "a", Chunks: []string{"a0"}},
+				{Name: "b", Chunks: []string{"b0"}, Ref: []string{"a"}, Default: true},
+			},
+			Expecation: Expectation{
+				Combinations: []ChunkCombination{
+					{Name: "a", Chunks: []string{"a0"}},
+					{Name: "b", Chunks: []string{"a0", "b0"}, Default: true},
+				},
+			},
+		},
 		{
 			Name: "duplicate combination ref",
 			Input: []ChunkCombination{
@@ -256,6 +578,55 @@ func TestResolveCombinations(t *testing.T) {
 	}
 }
 
+func TestResolveAliases(t *testing.T) {
+	combinations := []ChunkCombination{
+		{Name: "a", Chunks: []string{"go", "node"}},
+		{Name: "b", Chunks: []string{"go"}, Ref: []string{"a"}},
+	}
+	tests := []struct {
+		Name        string
+		Aliases     map[string]string
+		Expectation []ChunkCombination
+		Err         string
+	}{
+		{
+			Name:        "no aliases",
+			Expectation: combinations,
+		},
+		{
+			Name:    "aliases",
+			Aliases: map[string]string{"go": "golang:1.22"},
+			Expectation: []ChunkCombination{
+				{Name: "a", Chunks: []string{"golang:1.22", "node"}},
+				{Name: "b", Chunks: []string{"golang:1.22"}, Ref: []string{"a"}},
+			},
+		},
+		{
+			Name:    "alias of an alias",
+			Aliases: map[string]string{"go": "golang", "golang": "golang:1.22"},
+			Err:     "alias go refers to alias golang rather than a chunk",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act, err := resolveAliases(combinations, test.Aliases)
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+			}
+			if errmsg != test.Err {
+				t.Fatalf("resolveAliases() error = %q, expected %q", errmsg, test.Err)
+			}
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("resolveAliases() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	if combinations[0].Chunks[0] != "go" {
+		t.Errorf("resolveAliases() modified its input")
+	}
+}
+
 func TestProjectChunk_hash(t *testing.T) {
 	var tests = []struct {
 		Name         string
@@ -310,6 +681,27 @@ func TestProjectChunk_hash(t *testing.T) {
   command: ["ls"]
   assert:
   - "status == 0"
+`),
+				},
+			},
+			Base:         "",
+			BaseRef:      "",
+			Chunk:        "base",
+			Expectation:  map[string]string{"base": "11f7021f65b55230c0e1105b1dc013d635a9a6d38e1476277df521400aec375a"},
+			IncludeTests: true,
+		},
+		{
+			Name: "base with tests split into a directory should have same hash as a single file",
+			FS: map[string]*fstest.MapFile{
+				"base/Dockerfile": {
+					Data: []byte("FROM alpine"),
+				},
+				"tests/base/ls.yaml": {
+					Data: []byte(`---
+- desc: "it should run ls"
+  command: ["ls"]
+  assert:
+  - "status == 0"
 `),
 				},
 			},
@@ -451,7 +843,7 @@ func TestProjectChunk_hash(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
-			chks, err := loadChunks(fstest.MapFS(test.FS), "", test.Base, test.Chunk)
+			chks, err := loadChunks(fstest.MapFS(test.FS), "", test.Base, test.Chunk, defaultTestsDir)
 			if err != nil {
 				t.Errorf("could not load chunks: %v", err)
 				return
@@ -473,3 +865,267 @@ func TestProjectChunk_hash(t *testing.T) {
 		})
 	}
 }
+
+func TestProjectChunk_hashSources(t *testing.T) {
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		for fn, fc := range map[string]string{"Dockerfile": "FROM ubuntu", "run.sh": "#!/bin/sh", "a": "a", "b": "b"} {
+			err := os.WriteFile(filepath.Join(dir, fn), []byte(fc), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := os.Symlink("a", filepath.Join(dir, "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	hash := func(t *testing.T, dir string) string {
+		chk := ProjectChunk{Name: "foobar", Dockerfile: []byte("FROM ubuntu"), ContextPath: dir}
+		res, err := chk.hash("", true)
+		if err != nil {
+			t.Fatalf("could not compute hash: %v", err)
+		}
+		return res
+	}
+
+	tests := []struct {
+		Name    string
+		Modify  func(dir string) error
+		Changes bool
+	}{
+		{
+			Name:   "unchanged",
+			Modify: func(dir string) error { return nil },
+		},
+		{
+			Name:    "chmod",
+			Modify:  func(dir string) error { return os.Chmod(filepath.Join(dir, "run.sh"), 0755) },
+			Changes: true,
+		},
+		{
+			Name:    "directory mode",
+			Modify:  func(dir string) error { return os.Chmod(filepath.Join(dir, "sub"), 0700) },
+			Changes: true,
+		},
+		{
+			Name: "symlink retarget",
+			Modify: func(dir string) error {
+				err := os.Remove(filepath.Join(dir, "link"))
+				if err != nil {
+					return err
+				}
+				return os.Symlink("b", filepath.Join(dir, "link"))
+			},
+			Changes: true,
+		},
+		{
+			Name: "symlink replaced by file",
+			Modify: func(dir string) error {
+				err := os.Remove(filepath.Join(dir, "link"))
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join(dir, "link"), []byte("a"), 0644)
+			},
+			Changes: true,
+		},
+	}
+
+	base := hash(t, setup(t))
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dir := setup(t)
+			err := test.Modify(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act := hash(t, dir); (act != base) != test.Changes {
+				t.Errorf("hash() changed = %v, want %v", act != base, test.Changes)
+			}
+		})
+	}
+}
+
+func TestProjectChunk_hashConfig(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Config      string
+		Expectation string
+		Err         string
+	}{
+		{
+			Name:        "default",
+			Config:      "hash: {}\n",
+			Expectation: "6991b773b801a8eafb74dd95d5544d499ba1da5c9a677dbc5084dd6a03e5affa",
+		},
+		{
+			Name:        "explicit highwayhash",
+			Config:      "hash:\n  algorithm: highwayhash\n",
+			Expectation: "6991b773b801a8eafb74dd95d5544d499ba1da5c9a677dbc5084dd6a03e5affa",
+		},
+		{
+			Name:        "key",
+			Config:      "hash:\n  key: team-a\n",
+			Expectation: "1e6ca29594cb0bd3d85b69796b45fac6f2e2ffc7fb31394494b6b6ccbdf42667",
+		},
+		{
+			Name:        "sha256",
+			Config:      "hash:\n  algorithm: sha256\n",
+			Expectation: "9697f1704717764a3c38d95f7539154cfb6eda9bd9ad5d7a7ea1dbde7b43517a",
+		},
+		{
+			Name:   "unknown algorithm",
+			Config: "hash:\n  algorithm: md5\n",
+			Err:    "cannot load config from dazzle.yaml: unknown hash algorithm \"md5\", must be highwayhash or sha256",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dir := fstest.MapFS{
+				"dazzle.yaml":              {Data: []byte(test.Config)},
+				"base/Dockerfile":          {Data: []byte("FROM alpine")},
+				"chunks/foobar/Dockerfile": {Data: []byte("FROM ubuntu")},
+			}
+			prj, err := LoadFromDir("", LoadFromDirOpts{FS: func(string) fs.FS { return dir }})
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+			}
+			if errmsg != test.Err {
+				t.Fatalf("LoadFromDir() error = %q, want %q", errmsg, test.Err)
+			}
+			if err != nil {
+				return
+			}
+
+			hash, err := prj.Chunks[0].hash("", true)
+			if err != nil {
+				t.Fatalf("could not compute hash: %v", err)
+			}
+			if diff := cmp.Diff(test.Expectation, hash); diff != "" {
+				t.Errorf("hash() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProjectChunk_manifestPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, fn := range []string{"Dockerfile", "b/z", "b/a", "a"} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, fn)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir, fn), []byte(fn), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var expectation string
+	for _, ctx := range []string{dir, dir + string(filepath.Separator), filepath.Join(dir, "b") + string(filepath.Separator) + ".."} {
+		chk := ProjectChunk{Name: "foobar", Dockerfile: []byte("FROM ubuntu"), ContextPath: ctx}
+		var buf strings.Builder
+		err := chk.manifest("", &buf, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expectation == "" {
+			expectation = buf.String()
+			if !strings.Contains(expectation, "/a:file:644:") || !strings.Contains(expectation, "\n/b/a:file:644:") {
+				t.Errorf("manifest() does not contain normalized paths:\n%s", expectation)
+			}
+			continue
+		}
+		if diff := cmp.Diff(expectation, buf.String()); diff != "" {
+			t.Errorf("manifest() mismatch for context %s (-want +got):\n%s", ctx, diff)
+		}
+	}
+}
+
+func TestRenderDockerfile(t *testing.T) {
+	var (
+		base   = &fstest.MapFile{Data: []byte("FROM alpine")}
+		config = &fstest.MapFile{Data: []byte("combiner:\n  combinations:\n  - name: full\n    chunks: [go:1.20, node]\n")}
+	)
+	type Expectation struct {
+		Err         string
+		Dockerfiles map[string]string
+	}
+	tests := []struct {
+		Name        string
+		FS          map[string]*fstest.MapFile
+		Expectation Expectation
+	}{
+		{
+			Name: "templated variants",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":            config,
+				"base/Dockerfile":        base,
+				"chunks/go/Dockerfile":   {Data: []byte("FROM {{ .Chunk }}:{{ .Args.GO_VERSION }}\n# {{ .Variant }} in {{ range .Combinations }}{{ . }}{{ end }}")},
+				"chunks/go/chunk.yaml":   {Data: []byte("template: true\nvariants:\n- name: \"1.20\"\n  args:\n    GO_VERSION: 1.20.7\n- name: \"1.21\"\n  args:\n    GO_VERSION: 1.21.1\n")},
+				"chunks/node/Dockerfile": {Data: []byte("FROM node:{{ .Args.NODE_VERSION }}")},
+			},
+			Expectation: Expectation{
+				Dockerfiles: map[string]string{
+					"go:1.20": "FROM go:1.20.7\n# 1.20 in full",
+					"go:1.21": "FROM go:1.21.1\n# 1.21 in ",
+					"node":    "FROM node:{{ .Args.NODE_VERSION }}",
+				},
+			},
+		},
+		{
+			Name: "templated chunk without variants",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":            config,
+				"base/Dockerfile":        base,
+				"chunks/node/Dockerfile": {Data: []byte("FROM node\n# {{ len .Chunks }} chunks")},
+				"chunks/node/chunk.yaml": {Data: []byte("template: true\n")},
+			},
+			Expectation: Expectation{
+				Dockerfiles: map[string]string{
+					"node": "FROM node\n# 1 chunks",
+				},
+			},
+		},
+		{
+			Name: "missing arg",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":            config,
+				"base/Dockerfile":        base,
+				"chunks/node/Dockerfile": {Data: []byte("FROM node:{{ .Args.NODE_VERSION }}")},
+				"chunks/node/chunk.yaml": {Data: []byte("template: true\n")},
+			},
+			Expectation: Expectation{
+				Err: `chunk node: cannot render Dockerfile template: template: node:1:18: executing "node" at <.Args.NODE_VERSION>: map has no entry for key "NODE_VERSION"`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			prj, err := LoadFromDir("", LoadFromDirOpts{
+				FS: func(dir string) fs.FS { return fstest.MapFS(test.FS) },
+			})
+			var act Expectation
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Dockerfiles = make(map[string]string, len(prj.Chunks))
+				for _, chk := range prj.Chunks {
+					act.Dockerfiles[chk.Name] = string(chk.Dockerfile)
+				}
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("renderDockerfile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// distributionSourceLabel tells the containerd pusher which repository it can mount a blob from
+const distributionSourceLabel = "containerd.io/distribution.source."
+
+// Promote copies an image to another repository without rebuilding it, e.g. a combination from a work
+// registry to a release repository. Blobs are mounted from the source repository if the registry supports it,
+// and the manifest is copied verbatim so that the image keeps its digest.
+func Promote(ctx context.Context, logger log.FieldLogger, resolver remotes.Resolver, src, dest reference.Named) (absref reference.Digested, err error) {
+	defer func() {
+		if err != nil {
+			err = registryError(dest.String(), fmt.Errorf("cannot promote %s to %s: %w", src, dest, err))
+		}
+	}()
+
+	mfdesc, rawmf, mf, err := fetchManifest(ctx, resolver, src)
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := resolver.Fetcher(ctx, src.String())
+	if err != nil {
+		return nil, err
+	}
+
+	pusher, err := resolver.Pusher(ctx, dest.String())
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range append([]ociv1.Descriptor{mf.Config}, mf.Layers...) {
+		logger.WithField("blob", desc.Digest).WithField("dest", dest.String()).Debug("copying blob")
+		err = copyLayer(ctx, fetcher, pusher, withDistributionSource(desc, src))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.WithField("dest", dest.String()).WithField("digest", mfdesc.Digest).Info("pushing manifest")
+	err = pushManifest(ctx, pusher, mfdesc, rawmf)
+	if err != nil {
+		return nil, err
+	}
+
+	return reference.WithDigest(dest, mfdesc.Digest)
+}
+
+// fetchManifest downloads the image manifest of ref verbatim, so that it can be copied without changing its digest
+func fetchManifest(ctx context.Context, resolver remotes.Resolver, ref reference.Named) (mfdesc ociv1.Descriptor, rawmf []byte, mf *ociv1.Manifest, err error) {
+	_, mfdesc, err = resolver.Resolve(ctx, ref.String())
+	if err != nil {
+		return
+	}
+	switch mfdesc.MediaType {
+	case ociv1.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+	default:
+		err = fmt.Errorf("unsupported manifest media type %s", mfdesc.MediaType)
+		return
+	}
+
+	fetcher, err := resolver.Fetcher(ctx, ref.String())
+	if err != nil {
+		return
+	}
+	rc, err := fetcher.Fetch(ctx, mfdesc)
+	if err != nil {
+		return
+	}
+	rawmf, err = io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return
+	}
+	mf = new(ociv1.Manifest)
+	err = json.Unmarshal(rawmf, mf)
+	return
+}
+
+// pushManifest pushes a manifest verbatim. A manifest which exists already is not an error.
+func pushManifest(ctx context.Context, pusher remotes.Pusher, mfdesc ociv1.Descriptor, rawmf []byte) error {
+	w, err := pusher.Push(ctx, mfdesc)
+	if errdefs.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = w.Write(rawmf)
+	if err != nil {
+		return err
+	}
+	err = w.Commit(ctx, mfdesc.Size, mfdesc.Digest)
+	if err != nil && !errdefs.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// withDistributionSource marks a blob as mountable from the repository of ref
+func withDistributionSource(desc ociv1.Descriptor, ref reference.Named) ociv1.Descriptor {
+	annotations := make(map[string]string, len(desc.Annotations)+1)
+	for k, v := range desc.Annotations {
+		annotations[k] = v
+	}
+	annotations[distributionSourceLabel+reference.Domain(ref)] = reference.Path(ref)
+	desc.Annotations = annotations
+	return desc
+}
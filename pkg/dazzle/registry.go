@@ -27,15 +27,19 @@ import (
 	"io"
 
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
 	"github.com/containerd/containerd/remotes"
 	"github.com/docker/distribution/reference"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
 	mediaTypeTestResult = "application/vnd.gitpod.dazzle.tests.v1+json"
+	mediaTypeCatalog    = "application/vnd.gitpod.dazzle.catalog.v1+json"
 )
 
 // Registry provides container registry services
@@ -46,24 +50,48 @@ type Registry interface {
 
 type resolverRegistry struct {
 	resolver remotes.Resolver
+	// platform is the platform whose manifest Pull chooses from image indexes, defaulting to the one dazzle runs on
+	platform *ociv1.Platform
+	// logger is the logger of the session the registry belongs to, or the standard logger
+	logger log.FieldLogger
 }
 
 func NewResolverRegistry(resolver remotes.Resolver) Registry {
 	return resolverRegistry{
 		resolver: resolver,
+		logger:   log.StandardLogger(),
 	}
 }
 
+// NewReadOnlyResolver wraps a resolver so that it refuses to push, which fails with ErrReadOnly
+func NewReadOnlyResolver(resolver remotes.Resolver) remotes.Resolver {
+	return readOnlyResolver{resolver}
+}
+
+type readOnlyResolver struct {
+	remotes.Resolver
+}
+
+func (r readOnlyResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	return nil, withKind(ErrorKindConfig, fmt.Errorf("cannot push %s: %w", ref, ErrReadOnly))
+}
+
 type storeInRegistryOptions struct {
 	Config          []byte
 	ConfigMediaType string
 	Manifest        *ociv1.Manifest
+	// Annotations are the annotations of the manifest dazzle produces if Manifest is nil
+	Annotations map[string]string
 }
 
 func (r resolverRegistry) Push(ctx context.Context, ref reference.Named, opts storeInRegistryOptions) (absref reference.Digested, err error) {
+	defer func() {
+		err = registryError(ref.String(), err)
+	}()
+
 	pusher, err := r.resolver.Pusher(ctx, ref.String())
 	if err != nil {
-		return nil, fmt.Errorf("cannot store in registry: %v", err)
+		return nil, fmt.Errorf("cannot store in registry: %w", err)
 	}
 
 	var mf ociv1.Manifest
@@ -77,6 +105,7 @@ func (r resolverRegistry) Push(ctx context.Context, ref reference.Named, opts st
 				Size:      int64(len(opts.Config)),
 				Digest:    digest.FromBytes(opts.Config),
 			},
+			Annotations: opts.Annotations,
 		}
 	} else {
 		mf = *opts.Manifest
@@ -91,7 +120,11 @@ func (r resolverRegistry) Push(ctx context.Context, ref reference.Named, opts st
 		Digest:    digest.FromBytes(mfc),
 	}
 
-	if len(opts.Config) > 0 {
+	cfgref, err := reference.WithDigest(reference.TrimNamed(ref), mf.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Config) > 0 && !contentExists(ctx, r.logger, r.resolver, cfgref.String(), mf.Config.Digest) {
 		cfgW, err := pusher.Push(ctx, mf.Config)
 		if err == nil {
 			n, err := cfgW.Write(opts.Config)
@@ -113,10 +146,12 @@ func (r resolverRegistry) Push(ctx context.Context, ref reference.Named, opts st
 		}
 	}
 
-	mfW, err := pusher.Push(ctx, mfdesc)
-	if err != nil {
-		return nil, err
+	if contentExists(ctx, r.logger, r.resolver, ref.String(), mfdesc.Digest) {
+		// the ref points to this very manifest already - nothing to push
+		return reference.WithDigest(ref, mfdesc.Digest)
 	}
+
+	mfW, err := pusher.Push(ctx, mfdesc)
 	if err == nil {
 		n, err := mfW.Write(mfc)
 		if err != nil {
@@ -144,7 +179,25 @@ func (r resolverRegistry) Push(ctx context.Context, ref reference.Named, opts st
 	return absref, nil
 }
 
+// contentExists checks whether ref resolves to content with digest dgst. Resolving is a HEAD request
+// for the docker resolver, which is much cheaper than opening a writer only to learn that the content
+// exists already. Any error is treated as absence, leaving the decision to the push path.
+func contentExists(ctx context.Context, logger log.FieldLogger, resolver remotes.Resolver, ref string, dgst digest.Digest) bool {
+	_, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			logger.WithError(err).WithField("ref", ref).Debug("cannot check if content exists")
+		}
+		return false
+	}
+	return desc.Digest == dgst
+}
+
 func (r resolverRegistry) Pull(ctx context.Context, ref reference.Reference, cfg interface{}) (manifest *ociv1.Manifest, absref reference.Digested, err error) {
+	defer func() {
+		err = registryError(ref.String(), err)
+	}()
+
 	_, desc, err := r.resolver.Resolve(ctx, ref.String())
 	if err != nil {
 		return
@@ -154,7 +207,13 @@ func (r resolverRegistry) Pull(ctx context.Context, ref reference.Reference, cfg
 		return
 	}
 
-	// TODO: deal with this when the ref points to an image list rather than the image
+	if desc.MediaType == ociv1.MediaTypeImageIndex || desc.MediaType == images.MediaTypeDockerSchema2ManifestList {
+		desc, err = r.platformManifest(ctx, fetcher, ref, desc)
+		if err != nil {
+			return
+		}
+	}
+
 	manifestr, err := fetcher.Fetch(ctx, desc)
 	if err != nil {
 		return
@@ -188,6 +247,7 @@ func (r resolverRegistry) Pull(ctx context.Context, ref reference.Reference, cfg
 	if rr, ok := ref.(reference.Digested); ok {
 		absref = rr
 	} else if rr, ok := ref.(reference.Named); ok {
+		// for image indexes this is the digest of the platform's manifest
 		absref, err = reference.WithDigest(rr, desc.Digest)
 		if err != nil {
 			return
@@ -200,11 +260,35 @@ func (r resolverRegistry) Pull(ctx context.Context, ref reference.Reference, cfg
 	return
 }
 
+// platformManifest fetches an image index and returns the descriptor of the manifest for the registry's platform
+func (r resolverRegistry) platformManifest(ctx context.Context, fetcher remotes.Fetcher, ref reference.Reference, desc ociv1.Descriptor) (ociv1.Descriptor, error) {
+	platform := platforms.DefaultSpec()
+	if r.platform != nil {
+		platform = *r.platform
+	}
+
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return desc, err
+	}
+	defer rc.Close()
+	var idx ociv1.Index
+	err = json.NewDecoder(rc).Decode(&idx)
+	if err != nil {
+		return desc, err
+	}
+	res, found := platformManifest(&idx, platform)
+	if !found {
+		return desc, fmt.Errorf("%s has no manifest for %s", ref, platforms.Format(platform))
+	}
+	return res, nil
+}
+
 type StoredTestResult struct {
 	Passed bool `json:"passed"`
 }
 
-func pushTestResult(ctx context.Context, registry Registry, ref reference.Named, r StoredTestResult) (absref reference.Digested, err error) {
+func pushTestResult(ctx context.Context, registry Registry, ref reference.Named, r StoredTestResult, annotations map[string]string) (absref reference.Digested, err error) {
 	content, err := json.Marshal(r)
 	if err != nil {
 		return nil, err
@@ -212,6 +296,7 @@ func pushTestResult(ctx context.Context, registry Registry, ref reference.Named,
 	return registry.Push(ctx, ref, storeInRegistryOptions{
 		Config:          content,
 		ConfigMediaType: mediaTypeTestResult,
+		Annotations:     annotations,
 	})
 }
 
@@ -0,0 +1,91 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// staticResolver resolves every ref to the same descriptor or error
+type staticResolver struct {
+	fakeResolver
+	desc ocispec.Descriptor
+	err  error
+}
+
+func (t staticResolver) Resolve(ctx context.Context, ref string) (name string, desc ocispec.Descriptor, err error) {
+	return ref, t.desc, t.err
+}
+
+func TestContentExists(t *testing.T) {
+	dgst := digest.FromString("config")
+	tests := []struct {
+		name     string
+		resolver staticResolver
+		expect   bool
+	}{
+		{name: "exists", resolver: staticResolver{desc: ocispec.Descriptor{Digest: dgst}}, expect: true},
+		{name: "different digest", resolver: staticResolver{desc: ocispec.Descriptor{Digest: digest.FromString("other")}}},
+		{name: "not found", resolver: staticResolver{err: fmt.Errorf("cannot resolve: %w", errdefs.ErrNotFound)}},
+		{name: "registry error", resolver: staticResolver{err: fmt.Errorf("unauthorized")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act := contentExists(context.Background(), log.StandardLogger(), test.resolver, "localhost:9999/test@"+dgst.String(), dgst)
+			if act != test.expect {
+				t.Errorf("contentExists() = %v, want %v", act, test.expect)
+			}
+		})
+	}
+}
+
+func TestReadOnlyResolver(t *testing.T) {
+	dgst := digest.FromString("config")
+	resolver := NewReadOnlyResolver(staticResolver{desc: ocispec.Descriptor{Digest: dgst}})
+
+	_, desc, err := resolver.Resolve(context.Background(), "localhost:9999/test:foo")
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+	if desc.Digest != dgst {
+		t.Errorf("Resolve() digest = %s, expected %s", desc.Digest, dgst)
+	}
+
+	ref, err := reference.ParseNamed("localhost:9999/test:foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewResolverRegistry(resolver).Push(context.Background(), ref, storeInRegistryOptions{Config: []byte("{}"), ConfigMediaType: mediaTypeTestResult})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Push() error = %v, expected %v", err, ErrReadOnly)
+	}
+	if KindOf(err) != ErrorKindConfig {
+		t.Errorf("Push() error kind = %v, expected %v", KindOf(err), ErrorKindConfig)
+	}
+}
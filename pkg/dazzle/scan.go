@@ -0,0 +1,251 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Severity is the severity of a vulnerability as reported by a scanner
+type Severity string
+
+// The severities, from least to most severe
+const (
+	SeverityUnknown  Severity = "UNKNOWN"
+	SeverityLow      Severity = "LOW"
+	SeverityMedium   Severity = "MEDIUM"
+	SeverityHigh     Severity = "HIGH"
+	SeverityCritical Severity = "CRITICAL"
+)
+
+var severityRank = map[Severity]int{
+	SeverityUnknown:  0,
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// ParseSeverity parses a severity regardless of its case
+func ParseSeverity(s string) (Severity, error) {
+	res := Severity(strings.ToUpper(s))
+	if _, ok := severityRank[res]; !ok {
+		return "", fmt.Errorf("unknown severity %q, must be one of unknown, low, medium, high or critical", s)
+	}
+	return res, nil
+}
+
+// AtLeast returns true if the severity is the same as or worse than other
+func (s Severity) AtLeast(other Severity) bool {
+	return severityRank[s] >= severityRank[other]
+}
+
+// Vulnerability is a single finding of a scanner
+type Vulnerability struct {
+	ID       string   `json:"id"`
+	Package  string   `json:"package"`
+	Version  string   `json:"version,omitempty"`
+	Severity Severity `json:"severity"`
+}
+
+// ScanResult lists the vulnerabilities found in an image
+type ScanResult struct {
+	Ref             string          `json:"ref"`
+	Vulnerabilities []Vulnerability `json:"vulnerabilities"`
+}
+
+// Count returns the number of vulnerabilities which are at least as severe as min
+func (r *ScanResult) Count(min Severity) (n int) {
+	for _, v := range r.Vulnerabilities {
+		if v.Severity.AtLeast(min) {
+			n++
+		}
+	}
+	return n
+}
+
+// Scanner scans images in a registry for vulnerabilities
+type Scanner interface {
+	Scan(ctx context.Context, ref string) (*ScanResult, error)
+}
+
+// runFunc runs a command and returns its stdout
+type runFunc func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		if eerr, ok := err.(*exec.ExitError); ok && len(eerr.Stderr) > 0 {
+			return nil, fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(string(eerr.Stderr)))
+		}
+		return nil, fmt.Errorf("%s failed: %w", name, err)
+	}
+	return out, nil
+}
+
+// NewScanner produces a scanner which runs trivy or grype. With a server, trivy runs in client mode
+// against that server instead of downloading its vulnerability database. grype does not support servers.
+func NewScanner(name, server string) (Scanner, error) {
+	switch name {
+	case "trivy":
+		return trivyScanner{Server: server, run: runCommand}, nil
+	case "grype":
+		if server != "" {
+			return nil, fmt.Errorf("grype does not support scanning using a server")
+		}
+		return grypeScanner{run: runCommand}, nil
+	default:
+		return nil, fmt.Errorf("unknown scanner %q, must be trivy or grype", name)
+	}
+}
+
+type trivyScanner struct {
+	Server string
+	run    runFunc
+}
+
+type trivyReport struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			Severity         string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+func (s trivyScanner) Scan(ctx context.Context, ref string) (*ScanResult, error) {
+	args := []string{"image", "--quiet", "--format", "json"}
+	if s.Server != "" {
+		args = append(args, "--server", s.Server)
+	}
+	out, err := s.run(ctx, "trivy", append(args, ref)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var report trivyReport
+	err = json.Unmarshal(out, &report)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse trivy report: %w", err)
+	}
+	res := &ScanResult{Ref: ref, Vulnerabilities: []Vulnerability{}}
+	for _, r := range report.Results {
+		for _, v := range r.Vulnerabilities {
+			res.Vulnerabilities = append(res.Vulnerabilities, Vulnerability{
+				ID:       v.VulnerabilityID,
+				Package:  v.PkgName,
+				Version:  v.InstalledVersion,
+				Severity: scannerSeverity(v.Severity),
+			})
+		}
+	}
+	return res, nil
+}
+
+type grypeScanner struct {
+	run runFunc
+}
+
+type grypeReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID       string `json:"id"`
+			Severity string `json:"severity"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+func (s grypeScanner) Scan(ctx context.Context, ref string) (*ScanResult, error) {
+	// the registry: scheme makes grype pull the image from the registry rather than a Docker daemon
+	out, err := s.run(ctx, "grype", "--quiet", "--output", "json", "registry:"+ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var report grypeReport
+	err = json.Unmarshal(out, &report)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse grype report: %w", err)
+	}
+	res := &ScanResult{Ref: ref, Vulnerabilities: []Vulnerability{}}
+	for _, m := range report.Matches {
+		res.Vulnerabilities = append(res.Vulnerabilities, Vulnerability{
+			ID:       m.Vulnerability.ID,
+			Package:  m.Artifact.Name,
+			Version:  m.Artifact.Version,
+			Severity: scannerSeverity(m.Vulnerability.Severity),
+		})
+	}
+	return res, nil
+}
+
+// scannerSeverity maps the severities of trivy and grype to ours. grype reports "Negligible", which we treat as low.
+func scannerSeverity(s string) Severity {
+	if strings.EqualFold(s, "negligible") {
+		return SeverityLow
+	}
+	res, err := ParseSeverity(s)
+	if err != nil {
+		return SeverityUnknown
+	}
+	return res
+}
+
+// ScanImages scans the images using the scanner. If failOn is set, images with vulnerabilities of that
+// severity or worse fail the scan once all images were scanned.
+func ScanImages(ctx context.Context, logger log.FieldLogger, scanner Scanner, refs []string, failOn Severity) (res []*ScanResult, err error) {
+	var failed []string
+	for _, ref := range refs {
+		logger.WithField("ref", ref).Info("scanning image for vulnerabilities")
+		r, err := scanner.Scan(ctx, ref)
+		if err != nil {
+			return res, fmt.Errorf("cannot scan %s: %w", ref, err)
+		}
+		res = append(res, r)
+
+		entry := logger.WithField("ref", ref).WithField("vulnerabilities", len(r.Vulnerabilities))
+		if failOn == "" {
+			entry.Info("scanned image")
+			continue
+		}
+		if n := r.Count(failOn); n > 0 {
+			entry.WithField(strings.ToLower(string(failOn))+"+", n).Error("image has vulnerabilities")
+			failed = append(failed, ref)
+			continue
+		}
+		entry.Info("scanned image")
+	}
+	if len(failed) > 0 {
+		return res, withKind(ErrorKindTest, fmt.Errorf("%w of severity %s or worse: %s", ErrVulnerabilitiesFound, strings.ToLower(string(failOn)), strings.Join(failed, ", ")))
+	}
+	return res, nil
+}
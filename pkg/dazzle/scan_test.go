@@ -0,0 +1,166 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeRun returns canned output for a command and records how it was called
+type fakeRun struct {
+	out  string
+	call []string
+}
+
+func (f *fakeRun) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	f.call = append([]string{name}, args...)
+	return []byte(f.out), nil
+}
+
+func TestScanners(t *testing.T) {
+	tests := []struct {
+		name       string
+		scanner    func(run runFunc) Scanner
+		out        string
+		expectCall []string
+		expect     []Vulnerability
+	}{
+		{
+			name:       "trivy",
+			scanner:    func(run runFunc) Scanner { return trivyScanner{run: run} },
+			out:        `{"Results":[{"Vulnerabilities":[{"VulnerabilityID":"CVE-2023-1","PkgName":"openssl","InstalledVersion":"3.0.2","Severity":"HIGH"}]},{"Vulnerabilities":null}]}`,
+			expectCall: []string{"trivy", "image", "--quiet", "--format", "json", "localhost:9999/test:full"},
+			expect:     []Vulnerability{{ID: "CVE-2023-1", Package: "openssl", Version: "3.0.2", Severity: SeverityHigh}},
+		},
+		{
+			name:       "trivy server",
+			scanner:    func(run runFunc) Scanner { return trivyScanner{Server: "http://trivy:4954", run: run} },
+			out:        `{"Results":[]}`,
+			expectCall: []string{"trivy", "image", "--quiet", "--format", "json", "--server", "http://trivy:4954", "localhost:9999/test:full"},
+			expect:     []Vulnerability{},
+		},
+		{
+			name:       "grype",
+			scanner:    func(run runFunc) Scanner { return grypeScanner{run: run} },
+			out:        `{"matches":[{"vulnerability":{"id":"CVE-2023-2","severity":"Negligible"},"artifact":{"name":"zlib","version":"1.2"}},{"vulnerability":{"id":"CVE-2023-3","severity":"Critical"},"artifact":{"name":"curl","version":"8.0"}}]}`,
+			expectCall: []string{"grype", "--quiet", "--output", "json", "registry:localhost:9999/test:full"},
+			expect: []Vulnerability{
+				{ID: "CVE-2023-2", Package: "zlib", Version: "1.2", Severity: SeverityLow},
+				{ID: "CVE-2023-3", Package: "curl", Version: "8.0", Severity: SeverityCritical},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run := &fakeRun{out: test.out}
+			res, err := test.scanner(run.run).Scan(context.Background(), "localhost:9999/test:full")
+			if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			if diff := cmp.Diff(test.expectCall, run.call); diff != "" {
+				t.Errorf("Scan() call mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expect, res.Vulnerabilities); diff != "" {
+				t.Errorf("Scan() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// staticScanner finds vulnerabilities of the given severities in images whose ref contains the key
+type staticScanner map[string][]Severity
+
+func (s staticScanner) Scan(ctx context.Context, ref string) (*ScanResult, error) {
+	res := &ScanResult{Ref: ref}
+	for k, sevs := range s {
+		if !strings.Contains(ref, k) {
+			continue
+		}
+		for i, sev := range sevs {
+			res.Vulnerabilities = append(res.Vulnerabilities, Vulnerability{ID: fmt.Sprintf("CVE-%d", i), Severity: sev})
+		}
+	}
+	return res, nil
+}
+
+func TestScanImages(t *testing.T) {
+	scanner := staticScanner{
+		"foo": {SeverityLow, SeverityMedium},
+		"bar": {SeverityCritical},
+	}
+	refs := []string{"localhost:9999/test:foo--abc", "localhost:9999/test:bar--def"}
+
+	tests := []struct {
+		name   string
+		failOn Severity
+		err    string
+	}{
+		{name: "no threshold"},
+		{name: "critical", failOn: SeverityCritical, err: "images have vulnerabilities of severity critical or worse: localhost:9999/test:bar--def"},
+		{name: "medium", failOn: SeverityMedium, err: "images have vulnerabilities of severity medium or worse: localhost:9999/test:foo--abc, localhost:9999/test:bar--def"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := ScanImages(context.Background(), log.StandardLogger(), scanner, refs, test.failOn)
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+				if KindOf(err) != ErrorKindTest {
+					t.Errorf("ScanImages() error kind = %v, want %v", KindOf(err), ErrorKindTest)
+				}
+			}
+			if diff := cmp.Diff(test.err, errmsg); diff != "" {
+				t.Errorf("ScanImages() error mismatch (-want +got):\n%s", diff)
+			}
+			if len(res) != len(refs) {
+				t.Errorf("ScanImages() returned %d results, want %d", len(res), len(refs))
+			}
+		})
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect Severity
+		err    bool
+	}{
+		{in: "high", expect: SeverityHigh},
+		{in: "CRITICAL", expect: SeverityCritical},
+		{in: "severe", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			act, err := ParseSeverity(test.in)
+			if (err != nil) != test.err {
+				t.Fatalf("ParseSeverity() error = %v, want error %v", err, test.err)
+			}
+			if act != test.expect {
+				t.Errorf("ParseSeverity() = %q, want %q", act, test.expect)
+			}
+		})
+	}
+}
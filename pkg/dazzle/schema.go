@@ -0,0 +1,108 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+//go:generate sh -c "go run generate-schema.go project > ../../dazzle.schema.json"
+//go:generate sh -c "go run generate-schema.go chunk > ../../chunk.schema.json"
+
+// ProjectConfigSchema returns the JSON schema of dazzle.yaml, as found in dazzle.schema.json
+func ProjectConfigSchema() *jsonschema.Schema {
+	return configReflector.Reflect(&ProjectConfig{})
+}
+
+// ChunkConfigSchema returns the JSON schema of chunk.yaml, as found in chunk.schema.json
+func ChunkConfigSchema() *jsonschema.Schema {
+	return configReflector.Reflect(&ChunkConfig{})
+}
+
+// configReflector does not require fields unless they are tagged jsonschema:"required", as most of the
+// config is optional. Maps of strings, i.e. build args, accept any scalar because YAML reads e.g. 1.20 as number.
+// Sizes are a number of bytes or a string with a unit.
+var configReflector = &jsonschema.Reflector{
+	RequiredFromJSONSchemaTags: true,
+	TypeMapper: func(t reflect.Type) *jsonschema.Type {
+		switch t {
+		case reflect.TypeOf(map[string]string{}):
+			return &jsonschema.Type{
+				Type: "object",
+				PatternProperties: map[string]*jsonschema.Type{
+					".*": {OneOf: []*jsonschema.Type{{Type: "string"}, {Type: "number"}, {Type: "boolean"}}},
+				},
+			}
+		case reflect.TypeOf(ByteSize(0)):
+			return &jsonschema.Type{
+				OneOf: []*jsonschema.Type{{Type: "integer"}, {Type: "string", Pattern: `^\s*[0-9.]+\s*([kKmMgGtT][iI]?[bB]|[bB])?\s*$`}},
+			}
+		default:
+			return nil
+		}
+	},
+}
+
+// lintConfig validates a YAML config file against a schema and returns the violations, e.g. misspelled fields
+func lintConfig(schema *jsonschema.Schema, fc []byte) ([]string, error) {
+	var cfg interface{}
+	err := yaml.Unmarshal(fc, &cfg)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewGoLoader(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("cannot validate against config schema: %w", err)
+	}
+
+	var res []string
+	for _, e := range result.Errors() {
+		msg := e.Description()
+		if field := e.Field(); field != "" && field != "(root)" {
+			// list indices are more readable in brackets, e.g. combiner.combinations[0].name
+			segs := strings.Split(field, ".")
+			field = segs[0]
+			for _, s := range segs[1:] {
+				if _, err := strconv.Atoi(s); err == nil {
+					field += "[" + s + "]"
+				} else {
+					field += "." + s
+				}
+			}
+			msg = field + ": " + msg
+		}
+		res = append(res, msg)
+	}
+	sort.Strings(res)
+	return res, nil
+}
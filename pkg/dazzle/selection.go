@@ -0,0 +1,215 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/docker/distribution/reference"
+)
+
+// combinationNameRegexp matches valid combination names, which become the tag of the combined image
+var combinationNameRegexp = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
+// ParseChunkCombination parses an ad-hoc combination of the form name=chk1,chk2,chkN. Chunks are
+// variant-qualified names, e.g. golang:1.19, aliases or glob patterns which expand to all matching
+// chunks in the order of the project, e.g. golang:*.
+func (p *Project) ParseChunkCombination(expr string) (ChunkCombination, error) {
+	name, list, ok := strings.Cut(expr, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.TrimSpace(list) == "" {
+		return ChunkCombination{}, withKind(ErrorKindConfig, fmt.Errorf("invalid combination %q: must be name=chk1,chk2,chkN", expr))
+	}
+	if !combinationNameRegexp.MatchString(name) {
+		return ChunkCombination{}, withKind(ErrorKindConfig, fmt.Errorf("invalid combination name %q: must be a valid image tag, i.e. letters, digits, _, . and - only", name))
+	}
+
+	names, err := expandChunkPatterns(strings.Split(list, ","), p.Chunks, p.Config.Combiner.Aliases)
+	if err != nil {
+		return ChunkCombination{}, withKind(ErrorKindConfig, err)
+	}
+	return ChunkCombination{Name: name, Chunks: names}, nil
+}
+
+// DefaultCombination returns the combination marked as default
+func (p *Project) DefaultCombination() (ChunkCombination, error) {
+	var (
+		res   ChunkCombination
+		names []string
+	)
+	for _, c := range p.Config.Combiner.Combinations {
+		if c.Default {
+			res = c
+			names = append(names, c.Name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return ChunkCombination{}, withKind(ErrorKindConfig, fmt.Errorf("no default combination: mark one with default: true, or use one of --all, --combination or --chunks"))
+	case 1:
+		return res, nil
+	default:
+		return ChunkCombination{}, withKind(ErrorKindConfig, fmt.Errorf("several default combinations: %s", strings.Join(names, ", ")))
+	}
+}
+
+// CombinationTagData is available to the tag templates of combinations
+type CombinationTagData struct {
+	// Name is the name of the combination
+	Name string
+	// Chunks are the chunks of the combination
+	Chunks []string
+	// BaseHash is the hash of the base image, as in its base--<hash> tag
+	BaseHash string
+	// Date is the UTC date of the combine run, formatted as 20060102
+	Date string
+}
+
+// CombinationTag renders the tag of a combined image. The template is tpl if set, otherwise the tag of the
+// combination or of the combiner. Without any template the tag is the name of the combination.
+func (p *Project) CombinationTag(cmb ChunkCombination, tpl string, now time.Time) (string, error) {
+	tpl = p.tagTemplate(cmb, tpl)
+	if tpl == "" {
+		return cmb.Name, nil
+	}
+
+	basehash, err := p.Base.hash("", true)
+	if err != nil {
+		return "", err
+	}
+	tag, err := renderTag(tpl, CombinationTagData{
+		Name:     cmb.Name,
+		Chunks:   cmb.Chunks,
+		BaseHash: basehash,
+		Date:     now.UTC().Format("20060102"),
+	})
+	if err != nil {
+		return "", withKind(ErrorKindConfig, fmt.Errorf("combination %s: %w", cmb.Name, err))
+	}
+	return tag, nil
+}
+
+// tagTemplate returns the tag template which applies to a combination, or an empty string if it has none
+func (p *Project) tagTemplate(cmb ChunkCombination, tpl string) string {
+	if tpl != "" {
+		return tpl
+	}
+	if cmb.Tag != "" {
+		return cmb.Tag
+	}
+	return p.Config.Combiner.Tag
+}
+
+func renderTag(tpl string, data CombinationTagData) (string, error) {
+	t, err := template.New(data.Name).Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse tag template: %w", err)
+	}
+	var out bytes.Buffer
+	err = t.Execute(&out, data)
+	if err != nil {
+		return "", fmt.Errorf("cannot render tag template: %w", err)
+	}
+	tag := out.String()
+	if !combinationNameRegexp.MatchString(tag) {
+		return "", fmt.Errorf("tag %q is not a valid image tag, i.e. letters, digits, _, . and - only", tag)
+	}
+	return tag, nil
+}
+
+// expandChunkPatterns resolves chunk names, aliases and glob patterns to the names of chunks of the project.
+// Chunks are listed once, in the order in which they are first matched.
+func expandChunkPatterns(patterns []string, chunks []ProjectChunk, aliases map[string]string) ([]string, error) {
+	var (
+		res  []string
+		seen = make(map[string]bool)
+	)
+	add := func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		res = append(res, name)
+	}
+	for _, ptn := range patterns {
+		ptn = strings.TrimSpace(ptn)
+		if ptn == "" {
+			return nil, fmt.Errorf("empty chunk name")
+		}
+
+		if target, ok := aliases[ptn]; ok {
+			ptn = target
+		}
+		if !strings.ContainsAny(ptn, "*?[") {
+			if _, found := findChunk(chunks, ptn); !found {
+				return nil, unknownChunkError(ptn, chunks)
+			}
+			add(ptn)
+			continue
+		}
+
+		var matched bool
+		for _, c := range chunks {
+			ok, err := path.Match(ptn, c.Name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid chunk pattern %q: %w", ptn, err)
+			}
+			if ok {
+				matched = true
+				add(c.Name)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("chunk pattern %q does not match any chunk", ptn)
+		}
+	}
+	return res, nil
+}
+
+// findChunk returns the index of the chunk with the name
+func findChunk(chunks []ProjectChunk, name string) (int, bool) {
+	for i, c := range chunks {
+		if c.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// unknownChunkError explains that a chunk does not exist, listing its variants if the name lacks the variant
+func unknownChunkError(name string, chunks []ProjectChunk) error {
+	var variants []string
+	for _, c := range chunks {
+		if strings.HasPrefix(c.Name, name+":") {
+			variants = append(variants, c.Name)
+		}
+	}
+	if len(variants) > 0 {
+		return fmt.Errorf("chunk %s not found: it has the variants %s, use %s:<variant> or %s:*", name, strings.Join(variants, ", "), name, name)
+	}
+	return fmt.Errorf("chunk %s not found", name)
+}
@@ -0,0 +1,184 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseChunkCombination(t *testing.T) {
+	prj := &Project{
+		Config: ProjectConfig{Combiner: CombinerConfig{Aliases: map[string]string{"go": "golang:1.19"}}},
+		Chunks: []ProjectChunk{
+			{Name: "golang:1.18"},
+			{Name: "golang:1.19"},
+			{Name: "node"},
+			{Name: "python:3.10"},
+		},
+	}
+	tests := []struct {
+		name        string
+		expr        string
+		expectation ChunkCombination
+		err         string
+	}{
+		{
+			name:        "plain",
+			expr:        "go=golang:1.19,node",
+			expectation: ChunkCombination{Name: "go", Chunks: []string{"golang:1.19", "node"}},
+		},
+		{
+			name:        "alias",
+			expr:        "go=go,node",
+			expectation: ChunkCombination{Name: "go", Chunks: []string{"golang:1.19", "node"}},
+		},
+		{
+			name:        "glob",
+			expr:        "all-go=golang:*,node",
+			expectation: ChunkCombination{Name: "all-go", Chunks: []string{"golang:1.18", "golang:1.19", "node"}},
+		},
+		{
+			name:        "glob keeps the first occurrence",
+			expr:        "mixed = golang:1.19, golang:*",
+			expectation: ChunkCombination{Name: "mixed", Chunks: []string{"golang:1.19", "golang:1.18"}},
+		},
+		{name: "no name", expr: "golang:1.19,node", err: `invalid combination "golang:1.19,node": must be name=chk1,chk2,chkN`},
+		{name: "no chunks", expr: "go=", err: `invalid combination "go=": must be name=chk1,chk2,chkN`},
+		{name: "invalid name", expr: "go:1.19=golang:1.19", err: `invalid combination name "go:1.19": must be a valid image tag, i.e. letters, digits, _, . and - only`},
+		{name: "unknown chunk", expr: "rs=rust", err: "chunk rust not found"},
+		{name: "missing variant", expr: "go=golang", err: "chunk golang not found: it has the variants golang:1.18, golang:1.19, use golang:<variant> or golang:*"},
+		{name: "glob without match", expr: "rs=rust:*", err: `chunk pattern "rust:*" does not match any chunk`},
+		{name: "invalid glob", expr: "go=golang:[", err: `invalid chunk pattern "golang:[": syntax error in pattern`},
+		{name: "empty chunk", expr: "go=golang:1.19,,node", err: "empty chunk name"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act, err := prj.ParseChunkCombination(test.expr)
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+				if KindOf(err) != ErrorKindConfig {
+					t.Errorf("ParseChunkCombination() error kind = %v, expected %v", KindOf(err), ErrorKindConfig)
+				}
+			}
+			if errmsg != test.err {
+				t.Fatalf("ParseChunkCombination() error = %q, expected %q", errmsg, test.err)
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("ParseChunkCombination() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProject_DefaultCombination(t *testing.T) {
+	tests := []struct {
+		name         string
+		combinations []ChunkCombination
+		expectation  ChunkCombination
+		err          string
+	}{
+		{
+			name: "default",
+			combinations: []ChunkCombination{
+				{Name: "full", Chunks: []string{"golang", "node"}},
+				{Name: "go", Chunks: []string{"golang"}, Default: true},
+			},
+			expectation: ChunkCombination{Name: "go", Chunks: []string{"golang"}, Default: true},
+		},
+		{
+			name:         "no default",
+			combinations: []ChunkCombination{{Name: "full", Chunks: []string{"golang", "node"}}},
+			err:          "no default combination: mark one with default: true, or use one of --all, --combination or --chunks",
+		},
+		{
+			name: "several defaults",
+			combinations: []ChunkCombination{
+				{Name: "full", Chunks: []string{"golang", "node"}, Default: true},
+				{Name: "go", Chunks: []string{"golang"}, Default: true},
+			},
+			err: "several default combinations: full, go",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prj := &Project{Config: ProjectConfig{Combiner: CombinerConfig{Combinations: test.combinations}}}
+			act, err := prj.DefaultCombination()
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+				if KindOf(err) != ErrorKindConfig {
+					t.Errorf("DefaultCombination() error kind = %v, expected %v", KindOf(err), ErrorKindConfig)
+				}
+			}
+			if errmsg != test.err {
+				t.Fatalf("DefaultCombination() error = %q, expected %q", errmsg, test.err)
+			}
+			if diff := cmp.Diff(test.expectation, act); diff != "" {
+				t.Errorf("DefaultCombination() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProject_CombinationTag(t *testing.T) {
+	now := time.Date(2023, 4, 5, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+	tests := []struct {
+		name        string
+		combinerTag string
+		cmb         ChunkCombination
+		tpl         string
+		expectation string
+		err         string
+	}{
+		{name: "no template", cmb: ChunkCombination{Name: "full"}, expectation: "full"},
+		{name: "combiner template", combinerTag: "{{.Name}}-{{.BaseHash}}-{{.Date}}", cmb: ChunkCombination{Name: "full"}, expectation: "full-abc123-20230406"},
+		{name: "combination template", combinerTag: "{{.Name}}-{{.Date}}", cmb: ChunkCombination{Name: "full", Tag: "latest-{{.Name}}"}, expectation: "latest-full"},
+		{name: "template override", combinerTag: "{{.Name}}-{{.Date}}", cmb: ChunkCombination{Name: "full", Tag: "latest-{{.Name}}"}, tpl: "{{.Name}}-{{len .Chunks}}", expectation: "full-0"},
+		{name: "chunks", cmb: ChunkCombination{Name: "full", Chunks: []string{"golang", "node"}, Tag: `{{join .Chunks "_"}}`}, err: `combination full: cannot parse tag template: template: full:1: function "join" not defined`},
+		{name: "unknown field", cmb: ChunkCombination{Name: "full", Tag: "{{.Version}}"}, err: "combination full: cannot render tag template: template: full:1:2: executing \"full\" at <.Version>: can't evaluate field Version in type dazzle.CombinationTagData"},
+		{name: "invalid tag", cmb: ChunkCombination{Name: "full", Tag: "{{.Name}}:{{.Date}}"}, err: `combination full: tag "full:20230406" is not a valid image tag, i.e. letters, digits, _, . and - only`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prj := &Project{Config: ProjectConfig{Combiner: CombinerConfig{Tag: test.combinerTag}}}
+			prj.Base.cachedHash.ExcludeTests = "abc123"
+
+			act, err := prj.CombinationTag(test.cmb, test.tpl, now)
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+				if KindOf(err) != ErrorKindConfig {
+					t.Errorf("CombinationTag() error kind = %v, expected %v", KindOf(err), ErrorKindConfig)
+				}
+			}
+			if errmsg != test.err {
+				t.Fatalf("CombinationTag() error = %q, expected %q", errmsg, test.err)
+			}
+			if act != test.expectation {
+				t.Errorf("CombinationTag() = %q, expected %q", act, test.expectation)
+			}
+		})
+	}
+}
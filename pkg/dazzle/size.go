@@ -0,0 +1,137 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a number of bytes. In YAML it can have a unit, e.g. 500MB or 1.5GiB.
+type ByteSize int64
+
+// byteUnits are the units a ByteSize can have, decimal and binary ones
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// binaryUnits are the units a ByteSize is written with, largest first
+var binaryUnits = []struct {
+	Name string
+	Size ByteSize
+}{
+	{"TiB", 1 << 40},
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+}
+
+// ParseByteSize parses a number of bytes with an optional unit, e.g. 1024, 500MB or 1.5GiB
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return !(r >= '0' && r <= '9' || r == '.') })
+	if i < 0 {
+		i = len(s)
+	}
+	num, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	mult, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q, must be one of B, KB, MB, GB, TB, KiB, MiB, GiB or TiB", s, s[i:])
+	}
+	res := n * mult
+	if res > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return ByteSize(math.Round(res)), nil
+}
+
+// String formats the size for humans, e.g. 1.5 GiB
+func (b ByteSize) String() string {
+	for _, u := range binaryUnits {
+		if b >= u.Size {
+			return fmt.Sprintf("%.1f %s", float64(b)/float64(u.Size), u.Name)
+		}
+	}
+	return fmt.Sprintf("%d B", int64(b))
+}
+
+// UnmarshalYAML reads a size which is either a number of bytes or a string with a unit
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: size must be a number of bytes or have a unit, e.g. 500MB", value.Line)
+	}
+	res, err := ParseByteSize(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*b = res
+	return nil
+}
+
+// MarshalYAML writes the size with the largest binary unit it is a multiple of, so that it reads back the same
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	for _, u := range binaryUnits {
+		if b != 0 && b%u.Size == 0 {
+			return fmt.Sprintf("%d%s", b/u.Size, u.Name), nil
+		}
+	}
+	return int64(b), nil
+}
+
+// checkSizeBudget compares the compressed size of the layers of a chunked image with the chunk's budget.
+// Depending on the chunk's MaxSizeAction it fails with ErrSizeBudgetExceeded or logs a warning.
+func (p *ProjectChunk) checkSizeBudget(mf *ociv1.Manifest, sess *BuildSession) error {
+	if p.MaxSize <= 0 {
+		return nil
+	}
+	var size ByteSize
+	for _, l := range mf.Layers {
+		size += ByteSize(l.Size)
+	}
+	if size <= p.MaxSize {
+		return nil
+	}
+
+	if p.MaxSizeAction == SizeBudgetWarn {
+		sess.opts.Logger.WithField("chunk", p.Name).WithField("size", size.String()).WithField("maxSize", p.MaxSize.String()).Warn("chunk exceeds its size budget")
+		return nil
+	}
+	return withKind(ErrorKindBuild, fmt.Errorf("%s is %s, more than the %s of maxSize: %w", p.Name, size, p.MaxSize, ErrSizeBudgetExceeded))
+}
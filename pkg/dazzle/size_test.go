@@ -0,0 +1,116 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		Input       string
+		Expectation ByteSize
+		Err         string
+	}{
+		{Input: "1024", Expectation: 1024},
+		{Input: "500MB", Expectation: 500 * 1000 * 1000},
+		{Input: "500 mb", Expectation: 500 * 1000 * 1000},
+		{Input: "1.5GiB", Expectation: 3 << 29},
+		{Input: "12B", Expectation: 12},
+		{Input: "MB", Err: `invalid size "MB"`},
+		{Input: "5PB", Err: `invalid size "5PB": unknown unit "PB", must be one of B, KB, MB, GB, TB, KiB, MiB, GiB or TiB`},
+	}
+	for _, test := range tests {
+		t.Run(test.Input, func(t *testing.T) {
+			act, err := ParseByteSize(test.Input)
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+			}
+			if errmsg != test.Err {
+				t.Fatalf("ParseByteSize() error = %q, want %q", errmsg, test.Err)
+			}
+			if act != test.Expectation {
+				t.Errorf("ParseByteSize() = %d, want %d", act, test.Expectation)
+			}
+		})
+	}
+}
+
+func TestByteSize_YAML(t *testing.T) {
+	var cfg ChunkConfig
+	err := yaml.Unmarshal([]byte("maxSize: 1.5GiB\nmaxSizeAction: warn\n"), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ChunkConfig{MaxSize: 3 << 29, MaxSizeAction: SizeBudgetWarn}, cfg); diff != "" {
+		t.Errorf("Unmarshal() mismatch (-want +got):\n%s", diff)
+	}
+	if act := cfg.MaxSize.String(); act != "1.5 GiB" {
+		t.Errorf("String() = %q, want %q", act, "1.5 GiB")
+	}
+
+	fc, err := yaml.Marshal(cfg.MaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("1536MiB\n", string(fc)); diff != "" {
+		t.Errorf("Marshal() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProjectChunk_checkSizeBudget(t *testing.T) {
+	mf := &ociv1.Manifest{Layers: []ociv1.Descriptor{{Size: 300 << 20}, {Size: 300 << 20}}}
+	tests := []struct {
+		Name   string
+		Chunk  ProjectChunk
+		Exceed bool
+		Warn   bool
+	}{
+		{Name: "no budget", Chunk: ProjectChunk{Name: "go"}},
+		{Name: "within budget", Chunk: ProjectChunk{Name: "go", MaxSize: 600 << 20}},
+		{Name: "exceeds budget", Chunk: ProjectChunk{Name: "go", MaxSize: 500 << 20}, Exceed: true},
+		{Name: "exceeds budget with warning", Chunk: ProjectChunk{Name: "go", MaxSize: 500 << 20, MaxSizeAction: SizeBudgetWarn}, Warn: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			logger, hook := logtest.NewNullLogger()
+			sess := &BuildSession{}
+			sess.opts.Logger = logger
+			err := test.Chunk.checkSizeBudget(mf, sess)
+			if act := errors.Is(err, ErrSizeBudgetExceeded); act != test.Exceed {
+				t.Errorf("checkSizeBudget() error = %v, expected exceeding the budget: %v", err, test.Exceed)
+			}
+			if test.Exceed && KindOf(err) != ErrorKindBuild {
+				t.Errorf("checkSizeBudget() error kind = %v, expected %v", KindOf(err), ErrorKindBuild)
+			}
+			if act := len(hook.AllEntries()) > 0; act != test.Warn {
+				t.Errorf("checkSizeBudget() warned = %v, expected %v", act, test.Warn)
+			}
+		})
+	}
+}
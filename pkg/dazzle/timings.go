@@ -0,0 +1,74 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package dazzle
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// PhaseTiming is the time a single phase of a build took
+type PhaseTiming struct {
+	// Phase names what happened, e.g. "base", "tests" or "metadata pull"
+	Phase string
+	// Subject is the chunk or image ref the phase concerned
+	Subject  string
+	Duration time.Duration
+}
+
+// MarshalJSON renders the duration in a human readable form
+func (t PhaseTiming) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Phase    string  `json:"phase"`
+		Subject  string  `json:"subject,omitempty"`
+		Duration string  `json:"duration"`
+		Seconds  float64 `json:"seconds"`
+	}{t.Phase, t.Subject, t.Duration.String(), t.Duration.Seconds()})
+}
+
+// timePhase starts timing a phase of the build. Call the returned function once the phase is done.
+func (s *BuildSession) timePhase(phase, subject string) (done func()) {
+	start := time.Now()
+	return func() {
+		s.timingsMu.Lock()
+		defer s.timingsMu.Unlock()
+		s.timings = append(s.timings, PhaseTiming{Phase: phase, Subject: subject, Duration: time.Since(start)})
+	}
+}
+
+// Timings returns the durations of all phases of this session, longest first.
+// Phases can contain others, e.g. the chunked image phase includes pushing the image.
+func (s *BuildSession) Timings() []PhaseTiming {
+	s.timingsMu.Lock()
+	defer s.timingsMu.Unlock()
+
+	res := make([]PhaseTiming, len(s.timings))
+	copy(res, s.timings)
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Duration > res[j].Duration })
+	return res
+}
+
+// PrintTimings logs the durations of all phases of this session, longest first
+func (s *BuildSession) PrintTimings() {
+	for _, t := range s.Timings() {
+		s.opts.Logger.WithField("phase", t.Phase).WithField("subject", t.Subject).WithField("duration", t.Duration.Round(time.Millisecond).String()).Info("timing")
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ValidationProblem is a problem found in a project by ValidateProject
+type ValidationProblem struct {
+	// Warning is true for problems which do not break the build
+	Warning bool
+	// Subject is the part of the project the problem concerns, e.g. chunk golang
+	Subject string
+	Message string
+}
+
+func (p ValidationProblem) String() string {
+	severity := "error"
+	if p.Warning {
+		severity = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", severity, p.Subject, p.Message)
+}
+
+// ValidateProject loads a project and checks it for problems which would otherwise only surface
+// during or after a build. It returns an error only if the project cannot be loaded at all.
+func ValidateProject(contextBase string, opts LoadFromDirOpts) ([]ValidationProblem, error) {
+	if opts.FS == nil {
+		opts.FS = os.DirFS
+	}
+	dir := opts.FS(contextBase)
+
+	prj, err := LoadFromDir(contextBase, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []ValidationProblem
+	problem := func(warning bool, subject, format string, args ...interface{}) {
+		res = append(res, ValidationProblem{Warning: warning, Subject: subject, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// unknown fields are ignored when loading, hence misspelled ones like combinatons only show up in the schema
+	cfgfns, err := fs.Glob(dir, path.Join(chunksDir, "*", chunksYamlFN))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range append([]string{"dazzle.yaml", path.Join("base", chunksYamlFN)}, cfgfns...) {
+		fc, err := fs.ReadFile(dir, fn)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		schema := ChunkConfigSchema()
+		if fn == "dazzle.yaml" {
+			schema = ProjectConfigSchema()
+		}
+		msgs, err := lintConfig(schema, fc)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			problem(false, fn, "%s", msg)
+		}
+	}
+
+	chunks := make(map[string]int, len(prj.Chunks))
+	for _, chk := range prj.Chunks {
+		chunks[chk.Name]++
+	}
+	for _, chk := range prj.Chunks {
+		subject := "chunk " + chk.Name
+		if n := chunks[chk.Name]; n > 1 {
+			problem(false, subject, "defined %d times, variant names must be unique", n)
+			chunks[chk.Name] = 0
+		}
+		for _, msg := range lintChunkDockerfile(chk.Dockerfile) {
+			problem(false, subject, "%s", msg)
+		}
+	}
+
+	aliases := make([]string, 0, len(prj.Config.Combiner.Aliases))
+	for alias := range prj.Config.Combiner.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if _, ok := chunks[alias]; ok {
+			problem(false, "alias "+alias, "has the name of a chunk, which combinations cannot refer to anymore")
+		}
+		chk := prj.Config.Combiner.Aliases[alias]
+		if _, ok := chunks[chk]; !ok {
+			problem(false, "alias "+alias, "refers to unknown chunk %s", chk)
+		}
+	}
+
+	var defaults []string
+	for _, comb := range prj.Config.Combiner.Combinations {
+		if comb.Default {
+			defaults = append(defaults, comb.Name)
+		}
+	}
+	if len(defaults) > 1 {
+		problem(false, "dazzle.yaml", "several default combinations: %s", strings.Join(defaults, ", "))
+	}
+
+	used := make(map[string]struct{}, len(prj.Chunks))
+	for _, comb := range prj.Config.Combiner.Combinations {
+		for _, c := range comb.Chunks {
+			used[c] = struct{}{}
+			if _, ok := chunks[c]; !ok {
+				problem(false, "combination "+comb.Name, "references unknown chunk %s", c)
+			}
+		}
+	}
+	if len(prj.Config.Combiner.Combinations) > 0 {
+		for _, chk := range prj.Chunks {
+			if _, ok := used[chk.Name]; !ok {
+				problem(true, "chunk "+chk.Name, "not part of any combination")
+				used[chk.Name] = struct{}{}
+			}
+		}
+	}
+
+	for _, e := range prj.Config.Combiner.EnvVars {
+		switch e.Action {
+		case EnvVarCombineMerge, EnvVarCombineMergeUnique, EnvVarCombineUseLast, EnvVarCombineUseFirst:
+		default:
+			problem(false, "envvar "+e.Name, "invalid action %q", e.Action)
+		}
+	}
+	for _, chk := range prj.Chunks {
+		for _, e := range chk.EnvVars {
+			switch e.Action {
+			case EnvVarCombineMerge, EnvVarCombineMergeUnique, EnvVarCombineUseLast, EnvVarCombineUseFirst:
+			default:
+				problem(false, "chunk "+chk.Name, "invalid action %q for env var %s", e.Action, e.Name)
+			}
+		}
+	}
+	for _, comb := range prj.Config.Combiner.Combinations {
+		var cs []ProjectChunk
+		for _, c := range comb.Chunks {
+			for _, chk := range prj.Chunks {
+				if chk.Name == c {
+					cs = append(cs, chk)
+					break
+				}
+			}
+		}
+		if _, err := combinationEnvVars(prj.Config.Combiner.EnvVars, cs); err != nil {
+			problem(false, "combination "+comb.Name, "%v", err)
+		}
+		// the base hash does not change whether a tag is valid, hence a placeholder of the same length does
+		if tpl := prj.tagTemplate(comb, ""); tpl != "" {
+			_, err := renderTag(tpl, CombinationTagData{Name: comb.Name, Chunks: comb.Chunks, BaseHash: strings.Repeat("0", 64), Date: "20060102"})
+			if err != nil {
+				problem(false, "combination "+comb.Name, "%v", err)
+			}
+		}
+	}
+
+	// tests are named after the chunk directory, which is shared by all variants and might be ignored.
+	// Variant tests are named after the variant, unless the variant names a file which is checked when loading it.
+	var (
+		chunkDirs    = map[string]struct{}{"base": {}}
+		variants     = make(map[string]struct{}, len(prj.Chunks)+len(prj.ignored))
+		variantTests = make(map[string]struct{})
+	)
+	for _, chk := range prj.Chunks {
+		variants[chk.Name] = struct{}{}
+	}
+	for _, chk := range prj.ignored {
+		variants[chk] = struct{}{}
+	}
+	chds, err := fs.ReadDir(dir, chunksDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, chd := range chds {
+		if !chd.IsDir() {
+			continue
+		}
+		chunkDirs[chd.Name()] = struct{}{}
+
+		cfg, err := loadChunkConfig(dir, path.Join(chunksDir, chd.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if cfg == nil {
+			continue
+		}
+		for _, v := range cfg.Variants {
+			if v.Tests != "" {
+				variantTests[strings.TrimSuffix(v.Tests, ".yaml")] = struct{}{}
+			}
+		}
+	}
+	// suites are either a <name>.yaml file or a <name> directory of YAML files
+	testsDir := prj.Config.TestsDir()
+	tfs, err := fs.ReadDir(dir, testsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, tf := range tfs {
+		name := tf.Name()
+		if !tf.IsDir() {
+			if path.Ext(name) != ".yaml" {
+				continue
+			}
+			name = strings.TrimSuffix(name, ".yaml")
+		}
+		if _, ok := variantTests[name]; ok {
+			continue
+		}
+		chk, variant, isVariant := strings.Cut(name, "--")
+		if _, ok := chunkDirs[chk]; !ok {
+			problem(true, path.Join(testsDir, tf.Name()), "no chunk of that name exists, hence the tests never run")
+			continue
+		}
+		if _, ok := variants[chk+":"+variant]; isVariant && !ok {
+			problem(true, path.Join(testsDir, tf.Name()), "chunk %s has no variant %s, hence the tests never run", chk, variant)
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Subject < res[j].Subject })
+	return res, nil
+}
+
+var dockerfileBaseRef = regexp.MustCompile(`^\$(base|\{base\})(\s|$)`)
+
+// lintChunkDockerfile checks that a chunk's Dockerfile builds on the project's base image
+func lintChunkDockerfile(dockerfile []byte) []string {
+	var (
+		hasArg  bool
+		hasFrom bool
+		line    string
+		scanner = bufio.NewScanner(bytes.NewReader(dockerfile))
+	)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if line == "" && strings.HasPrefix(l, "#") {
+			continue
+		}
+		if strings.HasSuffix(l, "\\") {
+			line += strings.TrimSuffix(l, "\\") + " "
+			continue
+		}
+		line, l = "", line+l
+
+		fields := strings.Fields(l)
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if fields[1] == "base" || strings.HasPrefix(fields[1], "base=") {
+				hasArg = true
+			}
+		case "FROM":
+			img := fields[1]
+			if strings.HasPrefix(img, "--platform") && len(fields) > 2 {
+				img = fields[2]
+			}
+			if dockerfileBaseRef.MatchString(img) {
+				hasFrom = true
+			}
+		}
+	}
+
+	var res []string
+	if !hasArg {
+		res = append(res, "Dockerfile does not declare ARG base")
+	}
+	if !hasFrom {
+		res = append(res, "Dockerfile does not build FROM ${base}")
+	}
+	return res
+}
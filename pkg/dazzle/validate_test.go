@@ -0,0 +1,178 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateProject(t *testing.T) {
+	var (
+		dockerfile = &fstest.MapFile{Data: []byte("ARG base\nFROM ${base}\nRUN true")}
+		base       = &fstest.MapFile{Data: []byte("FROM alpine")}
+	)
+	tests := []struct {
+		Name        string
+		FS          map[string]*fstest.MapFile
+		Expectation []ValidationProblem
+	}{
+		{
+			Name: "valid project",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":              {Data: []byte("combiner:\n  combinations:\n  - name: full\n    chunks: [foo, bar:v1]\n")},
+				"base/Dockerfile":          base,
+				"chunks/foo/Dockerfile":    dockerfile,
+				"chunks/bar/Dockerfile":    {Data: []byte("# syntax=docker/dockerfile:1\nARG base=scratch\nFROM --platform=linux/amd64 $base AS build\n")},
+				"chunks/bar/chunk.yaml":    {Data: []byte("variants:\n- name: v1\n")},
+				"tests/foo.yaml":           {Data: []byte("[]")},
+				"tests/foo/more.yaml":      {Data: []byte("[]")},
+				"tests/bar--v1.yaml":       {Data: []byte("[]")},
+				"tests/base.yaml":          {Data: []byte("[]")},
+				"chunks/_ignored/.gitkeep": {},
+			},
+		},
+		{
+			Name: "problems",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":           {Data: []byte("combiner:\n  combinations:\n  - name: full\n    chunks: [foo, baz]\n  envvars:\n  - name: PATH\n    action: append\n")},
+				"base/Dockerfile":       base,
+				"chunks/foo/Dockerfile": {Data: []byte("FROM alpine")},
+				"chunks/bar/Dockerfile": dockerfile,
+				"chunks/bar/chunk.yaml": {Data: []byte("variants:\n- name: v1\n- name: v1\n")},
+				"tests/gone.yaml":       {Data: []byte("[]")},
+				"tests/bar--v2.yaml":    {Data: []byte("[]")},
+				"tests/gone/a.yaml":     {Data: []byte("[]")},
+			},
+			Expectation: []ValidationProblem{
+				{Subject: "chunk bar:v1", Message: "defined 2 times, variant names must be unique"},
+				{Warning: true, Subject: "chunk bar:v1", Message: "not part of any combination"},
+				{Subject: "chunk foo", Message: "Dockerfile does not declare ARG base"},
+				{Subject: "chunk foo", Message: "Dockerfile does not build FROM ${base}"},
+				{Subject: "combination full", Message: "references unknown chunk baz"},
+				{Subject: "envvar PATH", Message: "invalid action \"append\""},
+				{Warning: true, Subject: "tests/bar--v2.yaml", Message: "chunk bar has no variant v2, hence the tests never run"},
+				{Warning: true, Subject: "tests/gone", Message: "no chunk of that name exists, hence the tests never run"},
+				{Warning: true, Subject: "tests/gone.yaml", Message: "no chunk of that name exists, hence the tests never run"},
+			},
+		},
+		{
+			Name: "conflicting env var hints",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":           {Data: []byte("combiner:\n  combinations:\n  - name: full\n    chunks: [foo, bar]\n")},
+				"base/Dockerfile":       base,
+				"chunks/foo/Dockerfile": dockerfile,
+				"chunks/foo/chunk.yaml": {Data: []byte("envvars:\n- name: PATH\n  action: merge\n")},
+				"chunks/bar/Dockerfile": dockerfile,
+				"chunks/bar/chunk.yaml": {Data: []byte("envvars:\n- name: PATH\n  action: use-last\n- name: GOPATH\n  action: append\n")},
+			},
+			Expectation: []ValidationProblem{
+				{Subject: "chunk bar", Message: "invalid action \"append\" for env var GOPATH"},
+				{Subject: "combination full", Message: "chunks bar and foo declare different actions for env var PATH (use-last and merge), configure it in combiner.envvars"},
+			},
+		},
+		{
+			Name: "aliases",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":           {Data: []byte("combiner:\n  aliases:\n    b: bar:v1\n    foo: bar:v1\n    gone: baz\n  combinations:\n  - name: full\n    chunks: [b, foo]\n")},
+				"base/Dockerfile":       base,
+				"chunks/foo/Dockerfile": dockerfile,
+				"chunks/bar/Dockerfile": dockerfile,
+				"chunks/bar/chunk.yaml": {Data: []byte("variants:\n- name: v1\n")},
+			},
+			Expectation: []ValidationProblem{
+				{Subject: "alias foo", Message: "has the name of a chunk, which combinations cannot refer to anymore"},
+				{Subject: "alias gone", Message: "refers to unknown chunk baz"},
+				{Warning: true, Subject: "chunk foo", Message: "not part of any combination"},
+			},
+		},
+		{
+			Name: "several defaults",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":           {Data: []byte("combiner:\n  combinations:\n  - name: a\n    chunks: [foo]\n    default: true\n  - name: b\n    chunks: [foo]\n    default: true\n")},
+				"base/Dockerfile":       base,
+				"chunks/foo/Dockerfile": dockerfile,
+			},
+			Expectation: []ValidationProblem{
+				{Subject: "dazzle.yaml", Message: "several default combinations: a, b"},
+			},
+		},
+		{
+			Name: "tag templates",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":           {Data: []byte("combiner:\n  tag: \"{{.Name}}-{{.BaseHash}}\"\n  combinations:\n  - name: a\n    chunks: [foo]\n  - name: b\n    chunks: [foo]\n    tag: \"{{.Name}}:{{.Date}}\"\n  - name: c\n    chunks: [foo]\n    tag: \"{{.Version}}\"\n")},
+				"base/Dockerfile":       base,
+				"chunks/foo/Dockerfile": dockerfile,
+			},
+			Expectation: []ValidationProblem{
+				{Subject: "combination b", Message: `tag "b:20060102" is not a valid image tag, i.e. letters, digits, _, . and - only`},
+				{Subject: "combination c", Message: `cannot render tag template: template: c:1:2: executing "c" at <.Version>: can't evaluate field Version in type dazzle.CombinationTagData`},
+			},
+		},
+		{
+			Name: "schema violations",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":           {Data: []byte("combiner:\n  combinatons:\n  - name: full\n    chunks: [foo]\nhash:\n  algorithm: sha256\n  salt: x\n")},
+				"base/Dockerfile":       base,
+				"chunks/foo/Dockerfile": dockerfile,
+				"chunks/foo/chunk.yaml": {Data: []byte("variants:\n- name: v1\n  args:\n    GO_VERSION: 1.20\n  dockerfle: Dockerfile.v1\n- args: {}\n")},
+			},
+			Expectation: []ValidationProblem{
+				{Subject: "chunks/foo/chunk.yaml", Message: "variants[0]: Additional property dockerfle is not allowed"},
+				{Subject: "chunks/foo/chunk.yaml", Message: "variants[1]: name is required"},
+				{Subject: "dazzle.yaml", Message: "combiner: Additional property combinatons is not allowed"},
+				{Subject: "dazzle.yaml", Message: "hash: Additional property salt is not allowed"},
+			},
+		},
+		{
+			Name: "custom tests directory",
+			FS: map[string]*fstest.MapFile{
+				"dazzle.yaml":           {Data: []byte("tests:\n  dir: spec\n")},
+				"base/Dockerfile":       base,
+				"chunks/foo/Dockerfile": dockerfile,
+				"spec/foo/a.yaml":       {Data: []byte("[]")},
+				"spec/gone.yaml":        {Data: []byte("[]")},
+				"tests/gone.yaml":       {Data: []byte("[]")},
+			},
+			Expectation: []ValidationProblem{
+				{Warning: true, Subject: "spec/gone.yaml", Message: "no chunk of that name exists, hence the tests never run"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act, err := ValidateProject("", LoadFromDirOpts{
+				FS: func(dir string) fs.FS { return fstest.MapFS(test.FS) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("ValidateProject() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
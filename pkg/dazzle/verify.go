@@ -0,0 +1,122 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/docker/distribution/reference"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Verify checks that the registry holds what the project produces for the session's target-ref:
+// the base image, a chunked image under the recomputed hash of every chunk, which carries the base-ref
+// annotation of the current base image, and every combination with all blobs it references.
+// Chunks which do not support the session's platform are not expected to exist.
+// It returns an error only if the registry cannot be queried, and problems for everything which is amiss.
+func (p *Project) Verify(ctx context.Context, sess *BuildSession) ([]ValidationProblem, error) {
+	err := sess.DownloadBaseInfo(ctx, p)
+	if errdefs.IsNotFound(err) {
+		return []ValidationProblem{{Subject: "base", Message: "base image not found"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res []ValidationProblem
+	for _, chk := range p.Chunks {
+		if !chk.SupportsPlatform(sess.opts.Platform) {
+			continue
+		}
+		subject := "chunk " + chk.Name
+		ref, err := chk.ImageName(ImageTypeChunked, sess)
+		if err != nil {
+			return nil, err
+		}
+
+		sess.opts.Logger.WithField("ref", ref.String()).Debug("verifying chunk")
+		_, mf, _, err := sess.imageMetadata(ctx, ref)
+		if errdefs.IsNotFound(err) {
+			res = append(res, ValidationProblem{Subject: subject, Message: fmt.Sprintf("chunked image %s not found", ref)})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		baseref := mf.Annotations[mfAnnotationBaseRef]
+		switch {
+		case baseref == "":
+			res = append(res, ValidationProblem{Subject: subject, Message: fmt.Sprintf("chunked image %s has no base-ref annotation", ref)})
+		case baseref != sess.baseRef.String():
+			res = append(res, ValidationProblem{Subject: subject, Message: fmt.Sprintf("chunked image %s was built on %s rather than %s", ref, baseref, sess.baseRef)})
+		}
+	}
+
+	for _, comb := range p.Config.Combiner.Combinations {
+		subject := "combination " + comb.Name
+		ref, err := reference.WithTag(reference.TrimNamed(sess.Dest), comb.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		sess.opts.Logger.WithField("ref", ref.String()).Debug("verifying combination")
+		_, mf, _, err := sess.imageMetadata(ctx, ref)
+		if errdefs.IsNotFound(err) {
+			res = append(res, ValidationProblem{Subject: subject, Message: fmt.Sprintf("combination %s not found", ref)})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, desc := range append([]ociv1.Descriptor{mf.Config}, mf.Layers...) {
+			exists, err := blobExists(ctx, sess, ref, desc)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				res = append(res, ValidationProblem{Subject: subject, Message: fmt.Sprintf("blob %s referenced by %s is missing", desc.Digest, ref)})
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// blobExists checks if a blob is present in the repository of ref without downloading it
+func blobExists(ctx context.Context, sess *BuildSession, ref reference.Named, desc ociv1.Descriptor) (bool, error) {
+	blobref, err := reference.WithDigest(reference.TrimNamed(ref), desc.Digest)
+	if err != nil {
+		return false, err
+	}
+	_, _, err = sess.opts.Resolver.Resolve(ctx, blobref.String())
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, registryError(blobref.String(), err)
+	}
+	return true, nil
+}
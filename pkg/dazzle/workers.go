@@ -0,0 +1,110 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/moby/buildkit/client"
+)
+
+// clientFor returns the buildkit client which builds and tests a chunk. That is the session's client, unless the
+// chunk requires worker labels which its default worker lacks, in which case it is the first of the worker clients
+// whose default worker has them.
+func (s *BuildSession) clientFor(ctx context.Context, chk *ProjectChunk) (*client.Client, error) {
+	if len(chk.Workers) == 0 || s.Client == nil {
+		return s.Client, nil
+	}
+
+	candidates := append([]*client.Client{s.Client}, s.opts.WorkerClients...)
+	daemons := make([][]*client.WorkerInfo, 0, len(candidates))
+	for _, cl := range candidates {
+		workers, err := s.listWorkers(ctx, cl)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list buildkit workers: %w", err)
+		}
+		daemons = append(daemons, workers)
+	}
+	i, err := selectDaemon(chk.Workers, daemons)
+	if err != nil {
+		return nil, withKind(ErrorKindConfig, fmt.Errorf("chunk %s: %w", chk.Name, err))
+	}
+	return candidates[i], nil
+}
+
+// listWorkers lists the workers of a buildkit daemon once per session
+func (s *BuildSession) listWorkers(ctx context.Context, cl *client.Client) ([]*client.WorkerInfo, error) {
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+
+	if workers, ok := s.workers[cl]; ok {
+		return workers, nil
+	}
+	workers, err := cl.ListWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.workers == nil {
+		s.workers = make(map[*client.Client][]*client.WorkerInfo)
+	}
+	s.workers[cl] = workers
+	return workers, nil
+}
+
+// selectDaemon returns the index of the first buildkit daemon whose default worker, the first one it lists, has
+// the labels. Daemons build on their default worker only, hence other workers with the labels are of no use.
+func selectDaemon(labels map[string]string, daemons [][]*client.WorkerInfo) (int, error) {
+	for i, workers := range daemons {
+		if len(workers) > 0 && hasLabels(workers[0].Labels, labels) {
+			return i, nil
+		}
+	}
+	for _, workers := range daemons {
+		for _, w := range workers {
+			if hasLabels(w.Labels, labels) {
+				return 0, fmt.Errorf("worker %s has the labels %s, but is not the default worker of its buildkitd: run a buildkitd with just that worker and add it using --worker-addr", w.ID, formatLabels(labels))
+			}
+		}
+	}
+	return 0, fmt.Errorf("no buildkit worker has the labels %s", formatLabels(labels))
+}
+
+func hasLabels(actual, required map[string]string) bool {
+	for k, v := range required {
+		if actual[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// formatLabels formats labels as k=v pairs in a stable order
+func formatLabels(labels map[string]string) string {
+	res := make([]string, 0, len(labels))
+	for k, v := range labels {
+		res = append(res, k+"="+v)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ", ")
+}
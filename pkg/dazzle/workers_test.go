@@ -0,0 +1,96 @@
+// Copyright © 2020 Gitpod
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dazzle
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moby/buildkit/client"
+)
+
+func TestSelectDaemon(t *testing.T) {
+	var (
+		oci        = &client.WorkerInfo{ID: "oci", Labels: map[string]string{"org.mobyproject.buildkit.worker.executor": "oci"}}
+		containerd = &client.WorkerInfo{ID: "containerd", Labels: map[string]string{"org.mobyproject.buildkit.worker.executor": "containerd", "gpu": "nvidia"}}
+	)
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		daemons     [][]*client.WorkerInfo
+		expectation int
+		err         string
+	}{
+		{
+			name:        "default daemon",
+			labels:      map[string]string{"org.mobyproject.buildkit.worker.executor": "oci"},
+			daemons:     [][]*client.WorkerInfo{{oci, containerd}, {containerd}},
+			expectation: 0,
+		},
+		{
+			name:        "worker daemon",
+			labels:      map[string]string{"org.mobyproject.buildkit.worker.executor": "containerd", "gpu": "nvidia"},
+			daemons:     [][]*client.WorkerInfo{{oci}, {containerd}},
+			expectation: 1,
+		},
+		{
+			name:    "not the default worker",
+			labels:  map[string]string{"gpu": "nvidia"},
+			daemons: [][]*client.WorkerInfo{{oci, containerd}},
+			err:     "worker containerd has the labels gpu=nvidia, but is not the default worker of its buildkitd: run a buildkitd with just that worker and add it using --worker-addr",
+		},
+		{
+			name:    "no worker",
+			labels:  map[string]string{"gpu": "amd", "org.mobyproject.buildkit.worker.executor": "containerd"},
+			daemons: [][]*client.WorkerInfo{{oci}, {}, {containerd}},
+			err:     "no buildkit worker has the labels gpu=amd, org.mobyproject.buildkit.worker.executor=containerd",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			act, err := selectDaemon(test.labels, test.daemons)
+			var errmsg string
+			if err != nil {
+				errmsg = err.Error()
+			}
+			if errmsg != test.err {
+				t.Fatalf("selectDaemon() error = %q, expected %q", errmsg, test.err)
+			}
+			if err == nil && act != test.expectation {
+				t.Errorf("selectDaemon() = %d, expected %d", act, test.expectation)
+			}
+		})
+	}
+}
+
+func TestBuildSession_clientFor(t *testing.T) {
+	sess, err := NewSession(nil, "localhost:9999/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := sess.clientFor(context.Background(), &ProjectChunk{Name: "foo", Workers: map[string]string{"gpu": "nvidia"}})
+	if err != nil {
+		t.Fatalf("clientFor() failed: %v", err)
+	}
+	if cl != nil {
+		t.Errorf("clientFor() = %v, expected the session's client", cl)
+	}
+}
@@ -1,20 +1,56 @@
 package fancylog
 
 import (
+	"fmt"
+	"io"
+	"os"
 	"sort"
+	"strings"
+	"sync"
 
+	"github.com/containerd/console"
 	"github.com/gookit/color"
+	"github.com/mattn/go-isatty"
 	"github.com/sirupsen/logrus"
 )
 
 // Formatter formats log output
 type Formatter struct {
 	Level int
+
+	// NoColor disables the color escape sequences
+	NoColor bool
+	// Width is the width of the terminal messages are wrapped to, or 0 to disable wrapping
+	Width int
+}
+
+// NewFormatter produces a formatter for output written to f, which uses colors only if f is a terminal
+// and NO_COLOR is not set, and wraps messages to the width of the terminal
+func NewFormatter(f *os.File) *Formatter {
+	res := &Formatter{NoColor: !ColorEnabled(f)}
+	if c, err := console.ConsoleFromFile(f); err == nil {
+		if sz, err := c.Size(); err == nil {
+			res.Width = int(sz.Width)
+		}
+	}
+	return res
+}
+
+// ColorEnabled returns true if output written to f should be colored, i.e. f is a terminal
+// and the NO_COLOR env var is not set (see https://no-color.org)
+func ColorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
 }
 
 // DefaultIndent is the spacing for any output
 const DefaultIndent = "              "
 
+// messageWidth is the width messages are padded to so that the fields line up
+const messageWidth = 44
+
 // Format renders a single log entry
 func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
 	var res []byte
@@ -24,7 +60,11 @@ func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
 
 	step, ok := entry.Data["step"]
 	if ok {
-		res = append(res, []byte(color.Sprintf("<fg=black;bg=white> step %02d </>", step))...)
+		if f.NoColor {
+			res = append(res, []byte(fmt.Sprintf(" step %02d ", step))...)
+		} else {
+			res = append(res, []byte(color.Sprintf("<fg=black;bg=white> step %02d </>", step))...)
+		}
 		res = append(res, ' ')
 	} else {
 		res = append(res, []byte("          ")...)
@@ -37,6 +77,7 @@ func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
 	} else {
 		res = append(res, []byte("    ")...)
 	}
+	indent := 2*f.Level + 14
 
 	var cl *color.Theme
 	switch entry.Level {
@@ -48,10 +89,11 @@ func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
 		cl = &color.Theme{Name: "warning", Style: color.Style{color.Yellow}}
 	case logrus.ErrorLevel:
 		cl = color.Error
-	case logrus.FatalLevel:
+	case logrus.FatalLevel, logrus.PanicLevel:
 		cl = color.Danger
+	default:
+		cl = color.Info
 	}
-	res = append(res, []byte(cl.Sprintf("%-44s", entry.Message))...)
 
 	var keys []string
 	for k := range entry.Data {
@@ -61,20 +103,91 @@ func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
 		keys = append(keys, k)
 	}
 	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	fields := make([]string, 0, len(keys))
 	for _, k := range keys {
 		v := entry.Data[k]
 
 		if _, ok := v.(string); ok {
-			res = append(res, []byte(color.FgDarkGray.Sprintf("%s=\"%s\" ", k, v))...)
+			fields = append(fields, fmt.Sprintf("%s=\"%s\"", k, v))
+		} else {
+			fields = append(fields, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
+
+	lines := f.wrap(entry.Message, indent)
+	for _, line := range lines[:len(lines)-1] {
+		res = append(res, []byte(f.paint(cl, line))...)
+		res = append(res, '\n')
+		res = append(res, []byte(strings.Repeat(" ", indent))...)
+	}
+	last := lines[len(lines)-1]
+	width := messageWidth
+	if f.Width > 0 && indent+width > f.Width {
+		width = 0
+	}
+	res = append(res, []byte(f.paint(cl, fmt.Sprintf("%-*s", width, last)))...)
+
+	col := indent + len(last)
+	if len(last) < width {
+		col = indent + width
+	}
+	for _, fld := range fields {
+		if f.Width > 0 && col > indent && col+len(fld) > f.Width {
+			// fields which do not fit into the line start a line of their own
+			res = append(res, '\n')
+			res = append(res, []byte(strings.Repeat(" ", indent))...)
+			col = indent
+		}
+		if f.NoColor {
+			res = append(res, []byte(fld+" ")...)
 		} else {
-			res = append(res, []byte(color.FgDarkGray.Sprintf("%s=%v ", k, v))...)
+			res = append(res, []byte(color.FgDarkGray.Sprint(fld+" "))...)
 		}
+		col += len(fld) + 1
 	}
 
 	res = append(res, '\n')
 	return res, nil
 }
 
+// paint colors s using the theme unless colors are disabled
+func (f *Formatter) paint(cl *color.Theme, s string) string {
+	if f.NoColor {
+		return s
+	}
+	return cl.Sprint(s)
+}
+
+// wrap breaks the message into lines which fit into the terminal after the indentation
+func (f *Formatter) wrap(msg string, indent int) []string {
+	avail := f.Width - indent
+	if f.Width <= 0 || avail < 20 {
+		return strings.Split(msg, "\n")
+	}
+
+	var res []string
+	for _, para := range strings.Split(msg, "\n") {
+		var line string
+		for _, word := range strings.Fields(para) {
+			switch {
+			case line == "":
+				line = word
+			case len(line)+1+len(word) <= avail:
+				line += " " + word
+			default:
+				res = append(res, line)
+				line = word
+			}
+			for len(line) > avail {
+				res = append(res, line[:avail])
+				line = line[avail:]
+			}
+		}
+		res = append(res, line)
+	}
+	return res
+}
+
 // Push increases the level by one
 func (f *Formatter) Push() {
 	f.Level++
@@ -84,3 +197,36 @@ func (f *Formatter) Push() {
 func (f *Formatter) Pop() {
 	f.Level--
 }
+
+// FileHook writes all log entries uncolored to a file, in addition to the regular log output
+type FileHook struct {
+	mu        sync.Mutex
+	out       io.Writer
+	formatter *Formatter
+}
+
+// NewFileHook produces a hook which writes log entries to out
+func NewFileHook(out io.Writer) *FileHook {
+	return &FileHook{
+		out:       out,
+		formatter: &Formatter{NoColor: true},
+	}
+}
+
+// Levels returns all levels, the logger decides which entries are written
+func (h *FileHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire writes the entry to the file
+func (h *FileHook) Fire(entry *logrus.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = h.out.Write(line)
+	return err
+}
@@ -2,6 +2,7 @@ package buildkit
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"strings"
 
@@ -9,11 +10,11 @@ import (
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/session"
-	"github.com/moby/buildkit/session/auth/authprovider"
 	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/gitpod-io/dazzle/pkg/auth"
 	"github.com/gitpod-io/dazzle/pkg/test"
 	"github.com/gitpod-io/dazzle/pkg/test/runner"
 )
@@ -34,9 +35,14 @@ type Executor struct {
 	cfg *ociv1.Image
 }
 
+// ImageConfig returns the configuration of the image the tests run in
+func (b *Executor) ImageConfig(ctx context.Context) (*ociv1.ImageConfig, error) {
+	return &b.cfg.Config, nil
+}
+
 // Run executes the test
 func (b *Executor) Run(ctx context.Context, spec *test.Spec) (rr *test.RunResult, err error) {
-	rb, err := runner.GetRunner("linux_amd64")
+	rb, err := runner.GetRunner(runner.Platform(b.cfg.OS, b.cfg.Architecture))
 	if err != nil {
 		return
 	}
@@ -45,7 +51,21 @@ func (b *Executor) Run(ctx context.Context, spec *test.Spec) (rr *test.RunResult
 		return
 	}
 
-	state := llb.Image(b.ref)
+	// run the image for its own platform rather than the one of the buildkit worker
+	var opts []llb.ImageOption
+	if b.cfg.Architecture != "" {
+		imgOS := b.cfg.OS
+		if imgOS == "" {
+			imgOS = "linux"
+		}
+		opts = append(opts, llb.Platform(ociv1.Platform{
+			OS:           imgOS,
+			Architecture: b.cfg.Architecture,
+			Variant:      b.cfg.Variant,
+		}))
+	}
+	image := llb.Image(b.ref, opts...)
+	state := image
 	if user := b.cfg.Config.User; user != "" {
 		state = state.User(user)
 		log.WithField("user", user).Debug("running test as user")
@@ -54,10 +74,27 @@ func (b *Executor) Run(ctx context.Context, spec *test.Spec) (rr *test.RunResult
 		segs := strings.Split(e, "=")
 		state = state.AddEnv(segs[0], segs[1])
 	}
+	runOpts := []llb.RunOption{llb.Args(append([]string{"/dazzle/runner"}, espec...)), llb.IgnoreCache}
+	testUser := b.cfg.Config.User
+	if spec.User != "" {
+		testUser = spec.User
+	}
+	for i, m := range spec.Mounts {
+		tpe, merr := m.Validate()
+		if merr != nil {
+			return nil, merr
+		}
+		if tpe == test.MountTypeTmpfs {
+			runOpts = append(runOpts, llb.AddMount(m.Path, llb.Scratch(), llb.Tmpfs()))
+			continue
+		}
+		src, mountOpts := scratchMount(image, i, testUser)
+		runOpts = append(runOpts, llb.AddMount(m.Path, src, mountOpts...))
+	}
 	def, err := state.
 		File(llb.Mkdir("/dazzle", 0755)).
 		File(llb.Mkfile("/dazzle/runner", 0777, rb)).
-		Run(llb.Args(append([]string{"/dazzle/runner"}, espec...)), llb.IgnoreCache).
+		Run(runOpts...).
 		Root().
 		Marshal(ctx)
 	if err != nil {
@@ -76,7 +113,7 @@ func (b *Executor) Run(ctx context.Context, spec *test.Spec) (rr *test.RunResult
 		dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
 		_, err := b.cl.Solve(bctx, def, client.SolveOpt{
 			Session: []session.Attachable{
-				authprovider.NewDockerAuthProvider(dockerConfig),
+				auth.NewProvider(dockerConfig),
 			},
 		}, ch)
 		if err != nil {
@@ -106,6 +143,9 @@ func (b *Executor) Run(ctx context.Context, spec *test.Spec) (rr *test.RunResult
 		}
 	})
 	err = eg.Wait()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("test did not complete: %w", ctx.Err())
+	}
 	if err != nil {
 		log.WithError(err).Info("ignored error group error")
 	}
@@ -118,3 +158,14 @@ func (b *Executor) Run(ctx context.Context, spec *test.Spec) (rr *test.RunResult
 	}
 	return res, nil
 }
+
+// scratchMount produces the source of a scratch mount the user a test runs as can write to. Buildkit creates
+// empty mounts owned by root with mode 0755, hence for any other user we create a directory owned by them in
+// the image, which is where buildkit looks up user and group names, and mount that directory instead.
+func scratchMount(image llb.State, idx int, user string) (llb.State, []llb.MountOption) {
+	if name, _, _ := strings.Cut(user, ":"); name == "" || name == "root" || name == "0" {
+		return llb.Scratch(), nil
+	}
+	dir := fmt.Sprintf("/.dazzle-mount-%d", idx)
+	return image.File(llb.Mkdir(dir, 0755, llb.WithUser(user))), []llb.MountOption{llb.SourcePath(dir)}
+}
@@ -0,0 +1,95 @@
+package buildkit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+)
+
+func TestScratchMount(t *testing.T) {
+	type Expectation struct {
+		Dir      string
+		Owner    string
+		Selector string
+	}
+	tests := []struct {
+		Name        string
+		User        string
+		Expectation Expectation
+	}{
+		{Name: "default user"},
+		{Name: "root", User: "root"},
+		{Name: "root by uid", User: "0:0"},
+		{
+			Name:        "user name",
+			User:        "gitpod",
+			Expectation: Expectation{Dir: "/.dazzle-mount-1", Owner: "gitpod", Selector: "/.dazzle-mount-1"},
+		},
+		{
+			Name:        "uid and gid",
+			User:        "33333:33333",
+			Expectation: Expectation{Dir: "/.dazzle-mount-1", Owner: "33333:33333", Selector: "/.dazzle-mount-1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			image := llb.Image("example.com/image:latest")
+			src, opts := scratchMount(image, 1, test.User)
+			def, err := image.Run(llb.Args([]string{"/dazzle/runner"}), llb.AddMount("/workspace", src, opts...)).Root().Marshal(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var act Expectation
+			for _, dt := range def.Def {
+				var op pb.Op
+				err := op.Unmarshal(dt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				switch o := op.Op.(type) {
+				case *pb.Op_File:
+					for _, a := range o.File.Actions {
+						mkdir := a.GetMkdir()
+						if mkdir == nil {
+							continue
+						}
+						act.Dir = mkdir.Path
+						act.Owner = formatChown(mkdir.Owner)
+					}
+				case *pb.Op_Exec:
+					for _, m := range o.Exec.Mounts {
+						if m.Dest == "/workspace" {
+							act.Selector = m.Selector
+						}
+					}
+				}
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("scratchMount() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func formatChown(co *pb.ChownOpt) string {
+	if co == nil {
+		return ""
+	}
+	format := func(u *pb.UserOpt) string {
+		if n := u.GetByName(); n != nil {
+			return n.Name
+		}
+		return fmt.Sprint(u.GetByID())
+	}
+	res := format(co.User)
+	if co.Group != nil {
+		res += ":" + format(co.Group)
+	}
+	return res
+}
@@ -0,0 +1,29 @@
+package buildkit
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/moby/buildkit/client"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// SupportsPlatform returns true if one of the buildkit workers can run containers of the platform. Workers list
+// their native platform first, followed by the platforms they can run using emulation, e.g. because QEMU is
+// registered with binfmt_misc. emulated is true if the platform is not the native platform of the worker.
+func SupportsPlatform(ctx context.Context, cl *client.Client, platform ociv1.Platform) (ok, emulated bool, err error) {
+	workers, err := cl.ListWorkers(ctx)
+	if err != nil {
+		return false, false, err
+	}
+
+	matcher := platforms.NewMatcher(platform)
+	for _, w := range workers {
+		for i, p := range w.Platforms {
+			if matcher.Match(p) {
+				return true, i > 0, nil
+			}
+		}
+	}
+	return false, false, nil
+}
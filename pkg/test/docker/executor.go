@@ -0,0 +1,413 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gitpod-io/dazzle/pkg/test"
+	"github.com/gitpod-io/dazzle/pkg/test/runner"
+)
+
+const pollInterval = time.Second
+
+// NewExecutor creates a new executor which runs tests in containers using the Docker daemon cl talks to
+func NewExecutor(cl client.APIClient, ref string, cfg *ociv1.Image) *Executor {
+	return &Executor{
+		cl:  cl,
+		ref: ref,
+		cfg: cfg,
+	}
+}
+
+// Executor runs each test in its own container using the Docker Engine API. This does not require buildkit,
+// only a Docker daemon which has the image available.
+type Executor struct {
+	cl  client.APIClient
+	ref string
+	cfg *ociv1.Image
+}
+
+// ImageConfig returns the configuration of the image the tests run in
+func (e *Executor) ImageConfig(ctx context.Context) (*ociv1.ImageConfig, error) {
+	return &e.cfg.Config, nil
+}
+
+// Run executes the test
+func (e *Executor) Run(ctx context.Context, spec *test.Spec) (rr *test.RunResult, err error) {
+	rb, err := runner.GetRunner(runner.Platform(e.cfg.OS, e.cfg.Architecture))
+	if err != nil {
+		return
+	}
+	espec, err := runner.Args(spec)
+	if err != nil {
+		return
+	}
+	archive, err := runnerArchive(rb)
+	if err != nil {
+		return
+	}
+
+	cfg, hostCfg, err := e.containerConfig(spec)
+	if err != nil {
+		return
+	}
+	cfg.Entrypoint = []string{"/dazzle/runner"}
+	cfg.Cmd = espec
+	id, err := e.create(ctx, cfg, hostCfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create test container: %w", err)
+	}
+	defer e.remove(id)
+
+	err = e.cl.CopyToContainer(ctx, id, "/", archive, types.CopyToContainerOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot copy runner into test container: %w", err)
+	}
+
+	// we must start waiting before the container starts, otherwise we might miss its exit
+	waitC, errC := e.cl.ContainerWait(ctx, id, container.WaitConditionNextExit)
+	log.WithField("container", id).WithField("args", espec).Debug("running test using Docker")
+	err = e.cl.ContainerStart(ctx, id, types.ContainerStartOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot run test container: %w", err)
+	}
+	var resp container.WaitResponse
+	select {
+	case resp = <-waitC:
+	case err = <-errC:
+		return nil, fmt.Errorf("cannot wait for test container: %w", err)
+	}
+
+	stdout, stderr, err := e.logs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	log.WithField("buf", string(stdout)).Debug("received test run output")
+	return runResult(resp, stdout, stderr)
+}
+
+// runResult maps the exit of a test container to the result the runner reported on stdout.
+// The runner exits with a non-zero status only if it could not run the test at all.
+func runResult(resp container.WaitResponse, stdout, stderr []byte) (*test.RunResult, error) {
+	if resp.Error != nil && resp.Error.Message != "" {
+		return nil, fmt.Errorf("test container failed: %s", resp.Error.Message)
+	}
+	if resp.StatusCode != 0 {
+		return nil, fmt.Errorf("test runner exited with status %d: %s", resp.StatusCode, strings.TrimSpace(string(stderr)))
+	}
+	return runner.UnmarshalRunResult(stdout)
+}
+
+// RunService starts the image's entrypoint as a service and waits for it to become ready
+func (e *Executor) RunService(ctx context.Context, spec *test.Spec) (rr *test.RunResult, err error) {
+	timeout, err := spec.Service.ReadyTimeout()
+	if err != nil {
+		return
+	}
+
+	cfg, hostCfg, err := e.containerConfig(spec)
+	if err != nil {
+		return
+	}
+	cfg.Env = spec.Env
+	cfg.User = spec.User
+	if len(spec.Entrypoint) > 0 {
+		cfg.Entrypoint = spec.Entrypoint
+	}
+	if len(spec.Command) > 0 {
+		cfg.Cmd = spec.Command
+	}
+	id, err := e.create(ctx, cfg, hostCfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create service container: %w", err)
+	}
+	defer e.remove(id)
+
+	err = e.cl.ContainerStart(ctx, id, types.ContainerStartOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot start service container: %w", err)
+	}
+
+	log.WithField("container", id).WithField("timeout", timeout).Debug("waiting for service to become ready")
+	ready, err := e.waitReady(ctx, id, spec.Service, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stderr, err := e.logs(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get service logs: %w", err)
+	}
+	rr = &test.RunResult{
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	if !ready {
+		rr.StatusCode = 1
+	}
+	return rr, nil
+}
+
+// waitReady polls a service container until it is ready, has stopped or the timeout has passed
+func (e *Executor) waitReady(ctx context.Context, id string, svc *test.ServiceSpec, timeout time.Duration) (bool, error) {
+	deadline := time.After(timeout)
+	for {
+		info, err := e.cl.ContainerInspect(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if info.State == nil || info.State.Status != "running" {
+			log.WithField("container", id).Debug("service stopped before it became ready")
+			return false, nil
+		}
+
+		if svc != nil && len(svc.Ready) > 0 {
+			err = e.exec(ctx, id, svc.Ready)
+			if err == nil {
+				return true, nil
+			}
+			log.WithError(err).WithField("container", id).Debug("service is not ready yet")
+		} else {
+			if info.State.Health == nil {
+				return false, fmt.Errorf("image has no HEALTHCHECK - configure service.ready instead")
+			}
+			switch info.State.Health.Status {
+			case types.Healthy:
+				return true, nil
+			case types.Unhealthy:
+				return false, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-deadline:
+			return false, nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// exec runs a command in a running container and fails if it exits with a non-zero status
+func (e *Executor) exec(ctx context.Context, id string, cmd []string) error {
+	ex, err := e.cl.ContainerExecCreate(ctx, id, types.ExecConfig{Cmd: cmd, AttachStdout: true, AttachStderr: true})
+	if err != nil {
+		return err
+	}
+	resp, err := e.cl.ContainerExecAttach(ctx, ex.ID, types.ExecStartCheck{})
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	var out bytes.Buffer
+	_, err = stdcopy.StdCopy(&out, &out, resp.Reader)
+	if err != nil {
+		return err
+	}
+
+	st, err := e.cl.ContainerExecInspect(ctx, ex.ID)
+	if err != nil {
+		return err
+	}
+	if st.ExitCode != 0 {
+		return fmt.Errorf("%s exited with status %d: %s", strings.Join(cmd, " "), st.ExitCode, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// containerConfig produces the configuration of a test container with the spec's mounts
+func (e *Executor) containerConfig(spec *test.Spec) (*container.Config, *container.HostConfig, error) {
+	var hostCfg container.HostConfig
+	for _, m := range spec.Mounts {
+		tpe, err := m.Validate()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch tpe {
+		case test.MountTypeTmpfs:
+			if hostCfg.Tmpfs == nil {
+				hostCfg.Tmpfs = make(map[string]string)
+			}
+			hostCfg.Tmpfs[m.Path] = ""
+		case test.MountTypeScratch:
+			hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{Type: mount.TypeVolume, Target: m.Path})
+		}
+	}
+	return &container.Config{Image: e.ref}, &hostCfg, nil
+}
+
+// create creates a container for the image's platform and returns its ID
+func (e *Executor) create(ctx context.Context, cfg *container.Config, hostCfg *container.HostConfig) (string, error) {
+	var plat *ociv1.Platform
+	if e.cfg.Architecture != "" {
+		plat = platform(e.cfg)
+	}
+	resp, err := e.cl.ContainerCreate(ctx, cfg, hostCfg, nil, plat, "")
+	if err != nil {
+		return "", err
+	}
+	for _, w := range resp.Warnings {
+		log.WithField("container", resp.ID).Warn(w)
+	}
+	return resp.ID, nil
+}
+
+// remove removes a container together with its anonymous volumes
+func (e *Executor) remove(id string) {
+	// we must not use the test's context here as it might be cancelled already
+	err := e.cl.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+	if err != nil {
+		log.WithError(err).WithField("container", id).Warn("cannot remove container")
+	}
+}
+
+// logs returns everything a container has written to stdout and stderr so far
+func (e *Executor) logs(ctx context.Context, id string) (stdout, stderr []byte, err error) {
+	rc, err := e.cl.ContainerLogs(ctx, id, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get container logs: %w", err)
+	}
+	defer rc.Close()
+
+	var outBuf, errBuf bytes.Buffer
+	_, err = stdcopy.StdCopy(&outBuf, &errBuf, rc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read container logs: %w", err)
+	}
+	return outBuf.Bytes(), errBuf.Bytes(), nil
+}
+
+// PullImage pulls an image into the Docker daemon. creds returns the credentials for a registry host,
+// or empty credentials if the pull should be anonymous.
+func PullImage(ctx context.Context, cl client.APIClient, ref string, creds func(host string) (user, pwd string, err error)) error {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return fmt.Errorf("cannot parse image ref: %w", err)
+	}
+	var opts types.ImagePullOptions
+	user, pwd, err := creds(reference.Domain(named))
+	if err != nil {
+		return err
+	}
+	if user != "" || pwd != "" {
+		opts.RegistryAuth, err = encodeAuth(user, pwd)
+		if err != nil {
+			return err
+		}
+	}
+
+	rc, err := cl.ImagePull(ctx, named.String(), opts)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return pullError(rc)
+}
+
+// encodeAuth encodes credentials the way the Docker Engine API expects them in the X-Registry-Auth header
+func encodeAuth(user, pwd string) (string, error) {
+	fc, err := json.Marshal(types.AuthConfig{Username: user, Password: pwd})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(fc), nil
+}
+
+// pullError consumes the progress stream of an image pull and returns the error it reports, if any.
+// The daemon reports errors which occur after the pull started in the stream rather than the response status.
+func pullError(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg struct {
+			Error       string `json:"error"`
+			ErrorDetail struct {
+				Message string `json:"message"`
+			} `json:"errorDetail"`
+		}
+		err := dec.Decode(&msg)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read pull progress: %w", err)
+		}
+		if msg.ErrorDetail.Message != "" {
+			return errors.New(msg.ErrorDetail.Message)
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+// InspectImage returns the configuration of an image available to the Docker daemon
+func InspectImage(ctx context.Context, cl client.APIClient, ref string) (*ociv1.Image, error) {
+	_, raw, err := cl.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fields Docker reports for an image match those of the OCI image config,
+	// except for their capitalisation which does not matter to encoding/json.
+	var res ociv1.Image
+	err = json.Unmarshal(raw, &res)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal image config: %w", err)
+	}
+	return &res, nil
+}
+
+func platform(cfg *ociv1.Image) *ociv1.Platform {
+	os := cfg.OS
+	if os == "" {
+		os = "linux"
+	}
+	return &ociv1.Platform{
+		OS:           os,
+		Architecture: cfg.Architecture,
+		Variant:      cfg.Variant,
+	}
+}
+
+// runnerArchive produces a tar archive containing the runner at /dazzle/runner as expected by CopyToContainer
+func runnerArchive(rb []byte) (io.Reader, error) {
+	var (
+		buf bytes.Buffer
+		w   = tar.NewWriter(&buf)
+	)
+	err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dazzle/", Mode: 0755})
+	if err != nil {
+		return nil, err
+	}
+	err = w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "dazzle/runner", Mode: 0755, Size: int64(len(rb))})
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(rb)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
@@ -0,0 +1,255 @@
+package docker
+
+import (
+	"archive/tar"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/gitpod-io/dazzle/pkg/test"
+)
+
+func TestExecutor_containerConfig(t *testing.T) {
+	type Expectation struct {
+		Config     *container.Config
+		HostConfig *container.HostConfig
+		Err        string
+	}
+	tests := []struct {
+		Name        string
+		Spec        test.Spec
+		Expectation Expectation
+	}{
+		{
+			Name: "no mounts",
+			Expectation: Expectation{
+				Config:     &container.Config{Image: "example.com/image:latest"},
+				HostConfig: &container.HostConfig{},
+			},
+		},
+		{
+			Name: "mounts",
+			Spec: test.Spec{Mounts: []test.Mount{{Path: "/workspace"}, {Path: "/tmp", Type: test.MountTypeTmpfs}}},
+			Expectation: Expectation{
+				Config: &container.Config{Image: "example.com/image:latest"},
+				HostConfig: &container.HostConfig{
+					Mounts: []mount.Mount{{Type: mount.TypeVolume, Target: "/workspace"}},
+					Tmpfs:  map[string]string{"/tmp": ""},
+				},
+			},
+		},
+		{
+			Name:        "invalid mount",
+			Spec:        test.Spec{Mounts: []test.Mount{{Path: "workspace"}}},
+			Expectation: Expectation{Err: `mount path "workspace" must be absolute`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			e := NewExecutor(nil, "example.com/image:latest", &ociv1.Image{})
+			var act Expectation
+			cfg, hostCfg, err := e.containerConfig(&test.Spec)
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Config, act.HostConfig = cfg, hostCfg
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("containerConfig() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPlatform(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Config      ociv1.Image
+		Expectation ociv1.Platform
+	}{
+		{
+			Name:        "default os",
+			Config:      ociv1.Image{Architecture: "amd64"},
+			Expectation: ociv1.Platform{OS: "linux", Architecture: "amd64"},
+		},
+		{
+			Name:        "variant",
+			Config:      ociv1.Image{OS: "linux", Architecture: "arm", Variant: "v7"},
+			Expectation: ociv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if diff := cmp.Diff(&test.Expectation, platform(&test.Config)); diff != "" {
+				t.Errorf("platform() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunnerArchive(t *testing.T) {
+	r, err := runnerArchive([]byte("#!runner"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		Name    string
+		Type    byte
+		Mode    int64
+		Content string
+	}
+	var act []entry
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, entry{Name: hdr.Name, Type: hdr.Typeflag, Mode: hdr.Mode, Content: string(content)})
+	}
+
+	expectation := []entry{
+		{Name: "dazzle/", Type: tar.TypeDir, Mode: 0755},
+		{Name: "dazzle/runner", Type: tar.TypeReg, Mode: 0755, Content: "#!runner"},
+	}
+	if diff := cmp.Diff(expectation, act); diff != "" {
+		t.Errorf("runnerArchive() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	type Expectation struct {
+		Result *test.RunResult
+		Err    string
+	}
+	tests := []struct {
+		Name        string
+		Response    container.WaitResponse
+		Stdout      string
+		Stderr      string
+		Expectation Expectation
+	}{
+		{
+			Name:        "passing test",
+			Stdout:      `{"Stdout":"aGVsbG8K","StatusCode":0}`,
+			Expectation: Expectation{Result: &test.RunResult{Stdout: []byte("hello\n")}},
+		},
+		{
+			// the test command failing is a result, not an error
+			Name:        "failing test",
+			Stdout:      `{"Stderr":"b29wcwo=","StatusCode":1}`,
+			Expectation: Expectation{Result: &test.RunResult{Stderr: []byte("oops\n"), StatusCode: 1}},
+		},
+		{
+			Name:        "runner failed",
+			Response:    container.WaitResponse{StatusCode: 2},
+			Stderr:      "cannot decode spec\n",
+			Expectation: Expectation{Err: "test runner exited with status 2: cannot decode spec"},
+		},
+		{
+			Name:        "container failed",
+			Response:    container.WaitResponse{StatusCode: 137, Error: &container.WaitExitError{Message: "container was killed"}},
+			Expectation: Expectation{Err: "test container failed: container was killed"},
+		},
+		{
+			Name:        "empty wait error",
+			Response:    container.WaitResponse{Error: &container.WaitExitError{}},
+			Stdout:      `{"StatusCode":0}`,
+			Expectation: Expectation{Result: &test.RunResult{}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var act Expectation
+			res, err := runResult(test.Response, []byte(test.Stdout), []byte(test.Stderr))
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Result = res
+			}
+
+			if diff := cmp.Diff(test.Expectation, act, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("runResult() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPullError(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Stream      string
+		Expectation string
+	}{
+		{
+			Name: "success",
+			Stream: `{"status":"Pulling from library/alpine","id":"latest"}
+{"status":"Digest: sha256:abc"}
+{"status":"Status: Downloaded newer image for alpine:latest"}`,
+		},
+		{
+			Name: "error detail",
+			Stream: `{"status":"Pulling from library/alpine","id":"latest"}
+{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown: manifest unknown"}`,
+			Expectation: "manifest unknown",
+		},
+		{
+			Name:        "plain error",
+			Stream:      `{"error":"unauthorized"}`,
+			Expectation: "unauthorized",
+		},
+		{
+			Name:        "garbage",
+			Stream:      `not json`,
+			Expectation: "cannot read pull progress: invalid character 'o' in literal null (expecting 'u')",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var act string
+			if err := pullError(strings.NewReader(test.Stream)); err != nil {
+				act = err.Error()
+			}
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("pullError() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEncodeAuth(t *testing.T) {
+	act, err := encodeAuth("user", "p+ss/word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err := base64.URLEncoding.DecodeString(act)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(`{"username":"user","password":"p+ss/word"}`, string(fc)); diff != "" {
+		t.Errorf("encodeAuth() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecutor_runsServices(t *testing.T) {
+	var e test.Executor = NewExecutor(nil, "example.com/image:latest", &ociv1.Image{})
+	if _, ok := e.(test.ServiceRunner); !ok {
+		t.Errorf("docker executor does not implement test.ServiceRunner, hence service specs would be skipped")
+	}
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+var interpolationExpr = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Interpolate returns a copy of the spec where ${NAME} references in env, commands and assertions are replaced
+// by the value of the host environment variable NAME. Only variables in the allowlist are expanded; all other
+// references are left untouched so that a shell inside the image can still expand them.
+// Referencing an allowed variable that is not set on the host is an error.
+func (s *Spec) Interpolate(allowlist []string) (*Spec, error) {
+	if len(allowlist) == 0 {
+		return s, nil
+	}
+
+	allowed := make(map[string]struct{}, len(allowlist))
+	for _, n := range allowlist {
+		allowed[n] = struct{}{}
+	}
+
+	var err error
+	expand := func(in string) string {
+		return interpolationExpr.ReplaceAllStringFunc(in, func(ref string) string {
+			name := interpolationExpr.FindStringSubmatch(ref)[1]
+			if _, ok := allowed[name]; !ok {
+				return ref
+			}
+			val, ok := os.LookupEnv(name)
+			if !ok && err == nil {
+				err = fmt.Errorf("%s: environment variable %s is not set", s.Desc, name)
+			}
+			return val
+		})
+	}
+	expandAll := func(in []string) []string {
+		if in == nil {
+			return nil
+		}
+		res := make([]string, len(in))
+		for i, v := range in {
+			res[i] = expand(v)
+		}
+		return res
+	}
+	expandCommands := func(in [][]string) [][]string {
+		if in == nil {
+			return nil
+		}
+		res := make([][]string, len(in))
+		for i, c := range in {
+			res[i] = expandAll(c)
+		}
+		return res
+	}
+
+	res := *s
+	res.Env = expandAll(s.Env)
+	res.Command = expandAll(s.Command)
+	res.Entrypoint = expandAll(s.Entrypoint)
+	res.Before = expandCommands(s.Before)
+	res.After = expandCommands(s.After)
+	res.Assertions = expandAll(s.Assertions)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// InterpolateSpecs interpolates all specs using Interpolate
+func InterpolateSpecs(specs []*Spec, allowlist []string) ([]*Spec, error) {
+	if len(allowlist) == 0 {
+		return specs, nil
+	}
+
+	res := make([]*Spec, len(specs))
+	for i, s := range specs {
+		is, err := s.Interpolate(allowlist)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = is
+	}
+	return res, nil
+}
+
+// ResolvedEnv returns NAME=value for each allowlisted host environment variable the specs reference, sorted
+// by name. Variables which are not set are left out. The outcome of interpolated specs depends on these values,
+// hence a cached test result is only valid as long as they do not change.
+func ResolvedEnv(specs []*Spec, allowlist []string) []string {
+	if len(allowlist) == 0 {
+		return nil
+	}
+
+	allowed := make(map[string]struct{}, len(allowlist))
+	for _, n := range allowlist {
+		allowed[n] = struct{}{}
+	}
+	referenced := make(map[string]string)
+	collect := func(in []string) {
+		for _, v := range in {
+			for _, m := range interpolationExpr.FindAllStringSubmatch(v, -1) {
+				if _, ok := allowed[m[1]]; !ok {
+					continue
+				}
+				if val, ok := os.LookupEnv(m[1]); ok {
+					referenced[m[1]] = val
+				}
+			}
+		}
+	}
+	for _, s := range specs {
+		collect(s.Env)
+		collect(s.Command)
+		collect(s.Entrypoint)
+		for _, c := range s.Before {
+			collect(c)
+		}
+		for _, c := range s.After {
+			collect(c)
+		}
+		collect(s.Assertions)
+	}
+
+	res := make([]string, 0, len(referenced))
+	for n, v := range referenced {
+		res = append(res, n+"="+v)
+	}
+	sort.Strings(res)
+	return res
+}
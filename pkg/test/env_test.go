@@ -0,0 +1,135 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSpec_Interpolate(t *testing.T) {
+	t.Setenv("DAZZLE_TEST_VERSION", "1.21")
+	t.Setenv("DAZZLE_TEST_SECRET", "s3cret")
+
+	spec := &Spec{
+		Desc:       "go version",
+		Env:        []string{"GO_VERSION=${DAZZLE_TEST_VERSION}", "HOME=${HOME}"},
+		Command:    []string{"sh", "-c", "go version | grep ${DAZZLE_TEST_VERSION}"},
+		Entrypoint: []string{"/bin/${DAZZLE_TEST_VERSION}"},
+		Before:     [][]string{{"echo", "${DAZZLE_TEST_SECRET}"}},
+		After:      [][]string{{"echo", "$DAZZLE_TEST_VERSION"}},
+		Assertions: []string{`stdout.indexOf("${DAZZLE_TEST_VERSION}") != -1`},
+	}
+
+	type Expectation struct {
+		Spec *Spec
+		Err  string
+	}
+	tests := []struct {
+		Name        string
+		Spec        *Spec
+		Allowlist   []string
+		Expectation Expectation
+	}{
+		{
+			Name:        "no allowlist",
+			Spec:        spec,
+			Expectation: Expectation{Spec: spec},
+		},
+		{
+			Name:      "allowed variables only",
+			Spec:      spec,
+			Allowlist: []string{"DAZZLE_TEST_VERSION"},
+			Expectation: Expectation{Spec: &Spec{
+				Desc:       "go version",
+				Env:        []string{"GO_VERSION=1.21", "HOME=${HOME}"},
+				Command:    []string{"sh", "-c", "go version | grep 1.21"},
+				Entrypoint: []string{"/bin/1.21"},
+				Before:     [][]string{{"echo", "${DAZZLE_TEST_SECRET}"}},
+				After:      [][]string{{"echo", "$DAZZLE_TEST_VERSION"}},
+				Assertions: []string{`stdout.indexOf("1.21") != -1`},
+			}},
+		},
+		{
+			Name:      "unset variable",
+			Spec:      &Spec{Desc: "unset", Command: []string{"echo", "${DAZZLE_TEST_UNSET}"}},
+			Allowlist: []string{"DAZZLE_TEST_UNSET"},
+			Expectation: Expectation{
+				Err: "unset: environment variable DAZZLE_TEST_UNSET is not set",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var act Expectation
+			res, err := test.Spec.Interpolate(test.Allowlist)
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Spec = res
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("Interpolate() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if spec.Command[2] != "go version | grep ${DAZZLE_TEST_VERSION}" {
+		t.Errorf("Interpolate() modified the original spec: %q", spec.Command[2])
+	}
+}
+
+func TestInterpolateSpecs(t *testing.T) {
+	t.Setenv("DAZZLE_TEST_VERSION", "1.21")
+
+	specs := []*Spec{
+		{Desc: "a", Command: []string{"echo", "${DAZZLE_TEST_VERSION}"}},
+		{Desc: "b", Command: []string{"echo", "${DAZZLE_TEST_UNSET}"}},
+	}
+	res, err := InterpolateSpecs(specs[:1], []string{"DAZZLE_TEST_VERSION"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"echo", "1.21"}, res[0].Command); diff != "" {
+		t.Errorf("InterpolateSpecs() mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = InterpolateSpecs(specs, []string{"DAZZLE_TEST_VERSION", "DAZZLE_TEST_UNSET"})
+	if err == nil {
+		t.Error("InterpolateSpecs() succeeded despite an unset variable")
+	}
+}
+
+func TestResolvedEnv(t *testing.T) {
+	t.Setenv("DAZZLE_TEST_VERSION", "1.21")
+	t.Setenv("DAZZLE_TEST_ARCH", "amd64")
+	t.Setenv("DAZZLE_TEST_OTHER", "other")
+
+	specs := []*Spec{
+		{Desc: "a", Command: []string{"go", "version"}, Assertions: []string{`stdout.indexOf("${DAZZLE_TEST_VERSION}") != -1`}},
+		{Desc: "b", Env: []string{"GOARCH=${DAZZLE_TEST_ARCH}"}, After: [][]string{{"echo", "${DAZZLE_TEST_UNSET}"}}},
+		{Desc: "c", Command: []string{"echo", "${DAZZLE_TEST_OTHER}", "${HOME}"}},
+	}
+	tests := []struct {
+		Name        string
+		Allowlist   []string
+		Expectation []string
+	}{
+		{
+			Name: "no allowlist",
+		},
+		{
+			Name:        "referenced variables",
+			Allowlist:   []string{"DAZZLE_TEST_VERSION", "DAZZLE_TEST_ARCH", "DAZZLE_TEST_UNSET", "DAZZLE_TEST_UNUSED"},
+			Expectation: []string{"DAZZLE_TEST_ARCH=amd64", "DAZZLE_TEST_VERSION=1.21"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act := ResolvedEnv(specs, test.Allowlist)
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("ResolvedEnv() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FailureLog remembers which tests failed in previous runs so that only those can be rerun
+type FailureLog struct {
+	// Suites maps suite names to the descriptions of the tests which failed in that suite
+	Suites map[string][]string `json:"suites"`
+
+	mu sync.Mutex
+}
+
+// DefaultFailureLogPath returns the location of the failure log for a scope, e.g. a project directory
+func DefaultFailureLogPath(scope string) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if abs, err := filepath.Abs(scope); err == nil {
+		scope = abs
+	}
+	hash := sha256.Sum256([]byte(scope))
+	return filepath.Join(cache, "dazzle", "failures", hex.EncodeToString(hash[:8])+".json"), nil
+}
+
+// LoadFailureLog loads a failure log from disk. If the file does not exist, an empty log is returned.
+func LoadFailureLog(fn string) (*FailureLog, error) {
+	var res FailureLog
+	fc, err := os.ReadFile(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(fc, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Save writes the failure log to disk
+func (l *FailureLog) Save(fn string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fc, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(fn), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fn, fc, 0644)
+}
+
+// Failed returns the specs of a suite which failed previously. If the suite has no record in the log
+// all specs are returned, as we cannot know which of them would fail.
+func (l *FailureLog) Failed(suite string, specs []*Spec) []*Spec {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	failed, ok := l.Suites[suite]
+	if !ok {
+		return specs
+	}
+
+	idx := make(map[string]struct{}, len(failed))
+	for _, desc := range failed {
+		idx[desc] = struct{}{}
+	}
+	res := make([]*Spec, 0, len(failed))
+	for _, s := range specs {
+		if _, ok := idx[s.Desc]; ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Update records the results of a run of a suite. Tests which passed are removed from the log,
+// tests which failed or errored are added. Tests which did not run keep their previous state.
+func (l *FailureLog) Update(suite string, res Results) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Suites == nil {
+		l.Suites = make(map[string][]string)
+	}
+
+	state := make(map[string]bool)
+	for _, desc := range l.Suites[suite] {
+		state[desc] = true
+	}
+	for _, r := range res.Result {
+		if r == nil {
+			continue
+		}
+		switch r.Outcome() {
+		case OutcomePassed:
+			delete(state, r.Desc)
+		case OutcomeFailed, OutcomeError:
+			state[r.Desc] = true
+		}
+	}
+
+	var failed []string
+	for _, desc := range l.Suites[suite] {
+		if state[desc] {
+			failed = append(failed, desc)
+			delete(state, desc)
+		}
+	}
+	for _, r := range res.Result {
+		if r != nil && state[r.Desc] {
+			failed = append(failed, r.Desc)
+			delete(state, r.Desc)
+		}
+	}
+	if failed == nil {
+		failed = []string{}
+	}
+	l.Suites[suite] = failed
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFailureLog_Update(t *testing.T) {
+	var (
+		passed  = func(desc string) *Result { return &Result{Desc: desc} }
+		failed  = func(desc string) *Result { return &Result{Desc: desc, Failure: &ErrResult{}} }
+		errored = func(desc string) *Result { return &Result{Desc: desc, Error: &ErrResult{}} }
+		skipped = func(desc string) *Result { return &Result{Desc: desc, Skipped: true} }
+	)
+	tests := []struct {
+		Name        string
+		Suites      map[string][]string
+		Results     []*Result
+		Expectation map[string][]string
+	}{
+		{
+			Name:        "first run",
+			Results:     []*Result{passed("a"), failed("b"), errored("c"), skipped("d"), nil},
+			Expectation: map[string][]string{"go": {"b", "c"}},
+		},
+		{
+			Name:        "suite passes now",
+			Suites:      map[string][]string{"go": {"b", "c"}, "node": {"x"}},
+			Results:     []*Result{passed("b"), passed("c")},
+			Expectation: map[string][]string{"go": {}, "node": {"x"}},
+		},
+		{
+			Name:        "tests which did not run keep their state",
+			Suites:      map[string][]string{"go": {"b", "c"}},
+			Results:     []*Result{passed("b"), skipped("c"), failed("e")},
+			Expectation: map[string][]string{"go": {"c", "e"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			l := &FailureLog{Suites: test.Suites}
+			l.Update("go", Results{Result: test.Results})
+			if diff := cmp.Diff(test.Expectation, l.Suites); diff != "" {
+				t.Errorf("Update() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFailureLog_Failed(t *testing.T) {
+	var (
+		specs = []*Spec{{Desc: "a"}, {Desc: "b"}, {Desc: "c"}}
+		l     = &FailureLog{Suites: map[string][]string{"go": {"c", "a"}, "node": {}}}
+		descs = func(specs []*Spec) []string {
+			res := []string{}
+			for _, s := range specs {
+				res = append(res, s.Desc)
+			}
+			return res
+		}
+	)
+	if diff := cmp.Diff([]string{"a", "c"}, descs(l.Failed("go", specs))); diff != "" {
+		t.Errorf("Failed() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{}, descs(l.Failed("node", specs))); diff != "" {
+		t.Errorf("Failed() of a passing suite mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"a", "b", "c"}, descs(l.Failed("unknown", specs))); diff != "" {
+		t.Errorf("Failed() of an unknown suite mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFailureLog_roundTrip(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "failures", "log.json")
+
+	l, err := LoadFailureLog(fn)
+	if err != nil {
+		t.Fatalf("LoadFailureLog() of a missing file: %v", err)
+	}
+	if len(l.Suites) != 0 {
+		t.Errorf("LoadFailureLog() of a missing file = %v, expected an empty log", l.Suites)
+	}
+
+	l.Update("go", Results{Result: []*Result{{Desc: "a", Failure: &ErrResult{}}}})
+	l.Update("node", Results{Result: []*Result{{Desc: "x"}}})
+	err = l.Save(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFailureLog(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string][]string{"go": {"a"}, "node": {}}, loaded.Suites); diff != "" {
+		t.Errorf("LoadFailureLog() mismatch (-want +got):\n%s", diff)
+	}
+
+	err = os.WriteFile(fn, []byte("{not json"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadFailureLog(fn)
+	if err == nil {
+		t.Error("LoadFailureLog() of a corrupt file succeeded")
+	}
+}
+
+func TestDefaultFailureLogPath(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := func(scope string) string {
+		res, err := DefaultFailureLogPath(scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	if a, b := path("."), path(wd); a != b {
+		t.Errorf("DefaultFailureLogPath() differs for the same scope: %s != %s", a, b)
+	}
+	if a, b := path(wd), path(wd); a != b {
+		t.Errorf("DefaultFailureLogPath() is not stable: %s != %s", a, b)
+	}
+	if a, b := path(wd), path(filepath.Dir(wd)); a == b {
+		t.Errorf("DefaultFailureLogPath() is the same for different scopes: %s", a)
+	}
+}
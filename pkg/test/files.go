@@ -0,0 +1,135 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"syscall"
+)
+
+// maxFileContentSize is the largest file whose content is made available to assertions
+const maxFileContentSize = 1024 * 1024
+
+var fileAssertionExpr = regexp.MustCompile(`file\(\s*(?:"([^"]*)"|'([^']*)')\s*\)`)
+
+// FileInfo describes a file inside the test environment as seen by the file() assertion helper
+type FileInfo struct {
+	Exists  bool   `yaml:"exists" json:"exists"`
+	IsDir   bool   `yaml:"isDir,omitempty" json:"isDir"`
+	Mode    string `yaml:"mode,omitempty" json:"mode"`
+	Size    int64  `yaml:"size,omitempty" json:"size"`
+	Owner   string `yaml:"owner,omitempty" json:"owner"`
+	Group   string `yaml:"group,omitempty" json:"group"`
+	UID     uint32 `yaml:"uid,omitempty" json:"uid"`
+	GID     uint32 `yaml:"gid,omitempty" json:"gid"`
+	Link    string `yaml:"link,omitempty" json:"link,omitempty"`
+	Content string `yaml:"-" json:"content,omitempty"`
+}
+
+// referencedFiles returns all paths used with file("...") in the assertions
+func referencedFiles(assertions []string) []string {
+	var (
+		res []string
+		idx = make(map[string]struct{})
+	)
+	for _, a := range assertions {
+		for _, m := range fileAssertionExpr.FindAllStringSubmatch(a, -1) {
+			p := m[1]
+			if p == "" {
+				p = m[2]
+			}
+			if _, exists := idx[p]; exists || p == "" {
+				continue
+			}
+			idx[p] = struct{}{}
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// statFiles collects the file information for all paths referenced by the assertions
+func statFiles(assertions []string) map[string]*FileInfo {
+	paths := referencedFiles(assertions)
+	if len(paths) == 0 {
+		return nil
+	}
+
+	res := make(map[string]*FileInfo, len(paths))
+	for _, p := range paths {
+		res[p] = statFile(p)
+	}
+	return res
+}
+
+// statFile describes a file as the user the runner runs as sees it, which may be able to access more than
+// the user the test command ran as
+func statFile(path string) *FileInfo {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return &FileInfo{Exists: false}
+	}
+
+	res := &FileInfo{
+		Exists: true,
+		IsDir:  stat.IsDir(),
+		Size:   stat.Size(),
+		Mode:   fmt.Sprintf("%04o", stat.Mode().Perm()),
+	}
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		res.Mode = fmt.Sprintf("%04o", sys.Mode&07777)
+		res.UID, res.GID = sys.Uid, sys.Gid
+		res.Owner = strconv.FormatUint(uint64(sys.Uid), 10)
+		if u, err := user.LookupId(res.Owner); err == nil {
+			res.Owner = u.Username
+		}
+		res.Group = strconv.FormatUint(uint64(sys.Gid), 10)
+		if g, err := user.LookupGroupId(res.Group); err == nil {
+			res.Group = g.Name
+		}
+	}
+	if lnk, err := os.Readlink(path); err == nil {
+		res.Link = lnk
+	}
+
+	if stat.Mode().IsRegular() && stat.Size() <= maxFileContentSize {
+		f, err := os.Open(path)
+		if err != nil {
+			return res
+		}
+		defer f.Close()
+
+		content, err := io.ReadAll(io.LimitReader(f, maxFileContentSize))
+		if err != nil {
+			return res
+		}
+		res.Content = string(content)
+	}
+
+	return res
+}
+
+// fileHelper is the JavaScript implementation of the file() assertion helper. It expects
+// the file information to be available as JSON in __files.
+const fileHelper = `var file = (function(files) {
+	return function(path) {
+		var f = files[path] || { exists: false };
+		f.contains = function(s) { return typeof f.content === "string" && f.content.indexOf(s) !== -1; };
+		return f;
+	};
+})(JSON.parse(__files));`
+
+func marshalFiles(files map[string]*FileInfo) (string, error) {
+	if files == nil {
+		return "{}", nil
+	}
+	fc, err := json.Marshal(files)
+	if err != nil {
+		return "", err
+	}
+	return string(fc), nil
+}
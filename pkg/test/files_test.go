@@ -0,0 +1,110 @@
+package test
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReferencedFiles(t *testing.T) {
+	act := referencedFiles([]string{
+		`file("/etc/foo").exists`,
+		`file( '/etc/bar' ).mode == "0644" && file("/etc/foo").size > 0`,
+		`file("").exists`,
+		`stdout.indexOf("file") != -1`,
+	})
+	if diff := cmp.Diff([]string{"/etc/foo", "/etc/bar"}, act); diff != "" {
+		t.Errorf("referencedFiles() mismatch (-want +got):\n%s", diff)
+	}
+	if res := statFiles([]string{"status == 0"}); res != nil {
+		t.Errorf("statFiles() = %v, expected nil without file()", res)
+	}
+}
+
+func TestStatFile(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		fn  = filepath.Join(dir, "foo.conf")
+		lnk = filepath.Join(dir, "link")
+		sub = filepath.Join(dir, "sub")
+	)
+	err := os.Mkdir(sub, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chmod(sub, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(fn, []byte("enabled=true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chmod(fn, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("foo.conf", lnk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, _ := strconv.ParseUint(usr.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(usr.Gid, 10, 32)
+	group := usr.Gid
+	if g, err := user.LookupGroupId(usr.Gid); err == nil {
+		group = g.Name
+	}
+	owned := func(f FileInfo) *FileInfo {
+		f.Owner, f.Group = usr.Username, group
+		f.UID, f.GID = uint32(uid), uint32(gid)
+		return &f
+	}
+
+	tests := []struct {
+		Name        string
+		Path        string
+		Expectation *FileInfo
+	}{
+		{
+			Name:        "regular file",
+			Path:        fn,
+			Expectation: owned(FileInfo{Exists: true, Mode: "0640", Size: 13, Content: "enabled=true\n"}),
+		},
+		{
+			Name:        "symlink",
+			Path:        lnk,
+			Expectation: owned(FileInfo{Exists: true, Mode: "0640", Size: 13, Link: "foo.conf", Content: "enabled=true\n"}),
+		},
+		{
+			Name:        "directory",
+			Path:        sub,
+			Expectation: owned(FileInfo{Exists: true, IsDir: true, Mode: "0750"}),
+		},
+		{
+			Name:        "missing file",
+			Path:        filepath.Join(dir, "missing"),
+			Expectation: &FileInfo{Exists: false},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act := statFile(test.Path)
+			if test.Expectation.IsDir {
+				// the size of directories depends on the file system
+				act.Size = 0
+			}
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("statFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
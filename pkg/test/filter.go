@@ -0,0 +1,140 @@
+package test
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FilterOp is the comparison a filter performs
+type FilterOp string
+
+const (
+	// FilterEquals matches if the field equals the value. For tags this means the spec carries the tag.
+	FilterEquals FilterOp = "="
+	// FilterNotEquals is the inverse of FilterEquals
+	FilterNotEquals FilterOp = "!="
+	// FilterMatches matches if the field matches the regular expression value
+	FilterMatches FilterOp = "~="
+)
+
+// Filter selects test specs by tag or description
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value string
+
+	re *regexp.Regexp
+}
+
+// ParseFilter parses a filter expression of the form <field><op><value>, e.g. tag=gpu, tag!=slow or desc~=python.
+// Supported fields are "tag" and "desc".
+func ParseFilter(expr string) (*Filter, error) {
+	idx := strings.Index(expr, "=")
+	if idx <= 0 {
+		return nil, fmt.Errorf("invalid filter \"%s\": expected <field>=<value>, <field>!=<value> or <field>~=<regexp>", expr)
+	}
+
+	var (
+		field = expr[:idx]
+		op    = FilterEquals
+	)
+	switch field[len(field)-1] {
+	case '!':
+		op = FilterNotEquals
+		field = field[:len(field)-1]
+	case '~':
+		op = FilterMatches
+		field = field[:len(field)-1]
+	}
+	res := Filter{
+		Field: strings.TrimSpace(field),
+		Op:    op,
+		Value: strings.TrimSpace(expr[idx+1:]),
+	}
+
+	switch res.Field {
+	case "tag", "desc":
+	default:
+		return nil, fmt.Errorf("invalid filter \"%s\": unknown field %s", expr, res.Field)
+	}
+
+	if res.Op == FilterMatches {
+		re, err := regexp.Compile(res.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter \"%s\": %w", expr, err)
+		}
+		res.re = re
+	}
+
+	return &res, nil
+}
+
+// ParseFilters parses a list of filter expressions
+func ParseFilters(exprs []string) ([]*Filter, error) {
+	res := make([]*Filter, 0, len(exprs))
+	for _, e := range exprs {
+		f, err := ParseFilter(e)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+// Matches returns true if the spec is selected by this filter
+func (f *Filter) Matches(s *Spec) bool {
+	var vals []string
+	switch f.Field {
+	case "tag":
+		vals = s.Tags
+	case "desc":
+		vals = []string{s.Desc}
+	}
+
+	var match bool
+	for _, v := range vals {
+		switch f.Op {
+		case FilterEquals, FilterNotEquals:
+			match = v == f.Value
+		case FilterMatches:
+			match = f.re.MatchString(v)
+		}
+		if match {
+			break
+		}
+	}
+
+	if f.Op == FilterNotEquals {
+		return !match
+	}
+	return match
+}
+
+// String returns the filter expression
+func (f *Filter) String() string {
+	return f.Field + string(f.Op) + f.Value
+}
+
+// FilterSpecs returns all specs that match all filters
+func FilterSpecs(specs []*Spec, filters []*Filter) []*Spec {
+	if len(filters) == 0 {
+		return specs
+	}
+
+	res := make([]*Spec, 0, len(specs))
+	for _, s := range specs {
+		matches := true
+		for _, f := range filters {
+			if !f.Matches(s) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,109 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseFilter(t *testing.T) {
+	type Expectation struct {
+		Filter string
+		Op     FilterOp
+		Err    string
+	}
+	tests := []struct {
+		Name        string
+		Expr        string
+		Expectation Expectation
+	}{
+		{Name: "tag equals", Expr: "tag=gpu", Expectation: Expectation{Filter: "tag=gpu", Op: FilterEquals}},
+		{Name: "tag not equals", Expr: "tag!=slow", Expectation: Expectation{Filter: "tag!=slow", Op: FilterNotEquals}},
+		{Name: "desc matches", Expr: "desc~=^python", Expectation: Expectation{Filter: "desc~=^python", Op: FilterMatches}},
+		{Name: "whitespace", Expr: " tag = gpu ", Expectation: Expectation{Filter: "tag=gpu", Op: FilterEquals}},
+		{Name: "value with equals sign", Expr: "desc=a=b", Expectation: Expectation{Filter: "desc=a=b", Op: FilterEquals}},
+		{
+			Name:        "no operator",
+			Expr:        "tag",
+			Expectation: Expectation{Err: `invalid filter "tag": expected <field>=<value>, <field>!=<value> or <field>~=<regexp>`},
+		},
+		{
+			Name:        "no field",
+			Expr:        "=gpu",
+			Expectation: Expectation{Err: `invalid filter "=gpu": expected <field>=<value>, <field>!=<value> or <field>~=<regexp>`},
+		},
+		{
+			Name:        "unknown field",
+			Expr:        "user=root",
+			Expectation: Expectation{Err: `invalid filter "user=root": unknown field user`},
+		},
+		{
+			Name:        "invalid regexp",
+			Expr:        "desc~=(",
+			Expectation: Expectation{Err: "invalid filter \"desc~=(\": error parsing regexp: missing closing ): `(`"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var act Expectation
+			f, err := ParseFilter(test.Expr)
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Filter = f.String()
+				act.Op = f.Op
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("ParseFilter() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	_, err := ParseFilters([]string{"tag=gpu", "foo=bar"})
+	if err == nil {
+		t.Error("ParseFilters() succeeded despite an invalid filter")
+	}
+}
+
+func TestFilterSpecs(t *testing.T) {
+	specs := []*Spec{
+		{Desc: "python version", Tags: []string{"python"}},
+		{Desc: "cuda works", Tags: []string{"gpu", "slow"}},
+		{Desc: "python cuda bindings", Tags: []string{"python", "gpu"}},
+		{Desc: "no tags"},
+	}
+
+	tests := []struct {
+		Name        string
+		Filters     []string
+		Expectation []string
+	}{
+		{Name: "no filter", Expectation: []string{"python version", "cuda works", "python cuda bindings", "no tags"}},
+		{Name: "tag", Filters: []string{"tag=gpu"}, Expectation: []string{"cuda works", "python cuda bindings"}},
+		{Name: "not tag", Filters: []string{"tag!=slow"}, Expectation: []string{"python version", "python cuda bindings", "no tags"}},
+		{Name: "desc regexp", Filters: []string{"desc~=^python"}, Expectation: []string{"python version", "python cuda bindings"}},
+		{Name: "desc equals", Filters: []string{"desc=cuda works"}, Expectation: []string{"cuda works"}},
+		{Name: "all filters must match", Filters: []string{"tag=gpu", "tag!=slow"}, Expectation: []string{"python cuda bindings"}},
+		{Name: "nothing matches", Filters: []string{"tag=arm"}, Expectation: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filters, err := ParseFilters(test.Filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			act := make([]string, 0)
+			for _, s := range FilterSpecs(specs, filters) {
+				act = append(act, s.Desc)
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("FilterSpecs() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -8,15 +8,11 @@ import (
 	"fmt"
 	"log"
 
-	"github.com/alecthomas/jsonschema"
 	"github.com/gitpod-io/dazzle/pkg/test"
 )
 
 func main() {
-	var root []test.Spec
-	schema := jsonschema.Reflect(&root)
-
-	fc, err := json.MarshalIndent(schema, "", "  ")
+	fc, err := json.MarshalIndent(test.Schema(), "", "  ")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// SpecKind determines what a test spec asserts on
+type SpecKind string
+
+const (
+	// SpecKindCommand specs run a command and assert on its output. This is the default.
+	SpecKindCommand SpecKind = "command"
+	// SpecKindImage specs assert on the configuration of the image, e.g. its environment or exposed ports,
+	// without running a command.
+	SpecKindImage SpecKind = "image"
+)
+
+// ImageConfigProvider is implemented by executors which run tests in a container image and can provide its configuration
+type ImageConfigProvider interface {
+	ImageConfig(ctx context.Context) (*ociv1.ImageConfig, error)
+}
+
+// ImageInfo describes the configuration of the image under test as seen by the image assertion variable
+type ImageInfo struct {
+	User       string            `yaml:"user,omitempty" json:"user"`
+	Env        map[string]string `yaml:"env,omitempty" json:"env"`
+	Entrypoint []string          `yaml:"entrypoint,omitempty" json:"entrypoint"`
+	Cmd        []string          `yaml:"cmd,omitempty" json:"cmd"`
+	WorkingDir string            `yaml:"workdir,omitempty" json:"workdir"`
+	Labels     map[string]string `yaml:"labels,omitempty" json:"labels"`
+	Ports      []string          `yaml:"ports,omitempty" json:"ports"`
+	StopSignal string            `yaml:"stopSignal,omitempty" json:"stopSignal"`
+}
+
+func newImageInfo(cfg *ociv1.ImageConfig) *ImageInfo {
+	res := &ImageInfo{
+		User:       cfg.User,
+		Env:        make(map[string]string, len(cfg.Env)),
+		Entrypoint: cfg.Entrypoint,
+		Cmd:        cfg.Cmd,
+		WorkingDir: cfg.WorkingDir,
+		Labels:     cfg.Labels,
+		Ports:      make([]string, 0, len(cfg.ExposedPorts)),
+		StopSignal: cfg.StopSignal,
+	}
+	for _, e := range cfg.Env {
+		segs := strings.SplitN(e, "=", 2)
+		if len(segs) != 2 {
+			continue
+		}
+		res.Env[segs[0]] = segs[1]
+	}
+	for p := range cfg.ExposedPorts {
+		res.Ports = append(res.Ports, p)
+	}
+	sort.Strings(res.Ports)
+	if res.Entrypoint == nil {
+		res.Entrypoint = []string{}
+	}
+	if res.Cmd == nil {
+		res.Cmd = []string{}
+	}
+	if res.Labels == nil {
+		res.Labels = make(map[string]string)
+	}
+	return res
+}
+
+// inspectImage produces the run result of an image spec
+func inspectImage(ctx context.Context, executor Executor) (*RunResult, error) {
+	provider, ok := executor.(ImageConfigProvider)
+	if !ok {
+		return nil, errors.New("image assertions are not supported when running tests this way")
+	}
+
+	cfg, err := provider.ImageConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &RunResult{Image: newImageInfo(cfg)}, nil
+}
+
+// imageHelper makes the image configuration available to assertions as image. It expects the
+// image information to be available as JSON in __image.
+const imageHelper = `var image = JSON.parse(__image);`
+
+func marshalImage(img *ImageInfo) (string, error) {
+	if img == nil {
+		return "null", nil
+	}
+	fc, err := json.Marshal(img)
+	if err != nil {
+		return "", err
+	}
+	return string(fc), nil
+}
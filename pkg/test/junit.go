@@ -0,0 +1,164 @@
+package test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	SystemOut *junitCDATA   `xml:"system-out,omitempty"`
+	SystemErr *junitCDATA   `xml:"system-err,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",cdata"`
+}
+
+// junitCDATA is a CDATA section. Its body must be passed through junitText, as encoding/xml
+// does not escape CDATA but only splits "]]>".
+type junitCDATA struct {
+	Body string `xml:",cdata"`
+}
+
+// MarshalJUnit renders test results as JUnit XML. Each Results becomes a testsuite
+// named after Results.Name, each Result a testcase within that suite.
+func MarshalJUnit(suites ...Results) ([]byte, error) {
+	var (
+		res   junitTestSuites
+		total time.Duration
+	)
+	for _, s := range suites {
+		js := &junitTestSuite{Name: s.Name}
+
+		var duration time.Duration
+		for _, r := range s.Result {
+			if r == nil {
+				continue
+			}
+
+			classname := s.Name
+			if r.Matrix != "" {
+				classname = fmt.Sprintf("%s[%s]", s.Name, r.Matrix)
+			}
+			tc := &junitTestCase{
+				Name:      r.Desc,
+				Classname: classname,
+				Time:      junitTime(r.Duration),
+			}
+			switch {
+			case r.Skipped:
+				tc.Skipped = &junitSkipped{Message: r.SkipReason}
+				js.Skipped++
+			case r.Error != nil:
+				tc.Error = &junitFailure{Message: r.Error.Message, Type: r.Error.Type, Body: junitDetails(r.Error, r.RunResult)}
+				js.Errors++
+			case r.Failure != nil:
+				tc.Failure = &junitFailure{Message: r.Failure.Message, Type: r.Failure.Type, Body: junitDetails(r.Failure, r.RunResult)}
+				js.Failures++
+			}
+			if r.RunResult != nil {
+				if len(r.Stdout) > 0 {
+					tc.SystemOut = &junitCDATA{Body: junitText(string(r.Stdout))}
+				}
+				if len(r.Stderr) > 0 {
+					tc.SystemErr = &junitCDATA{Body: junitText(string(r.Stderr))}
+				}
+			}
+
+			js.Cases = append(js.Cases, tc)
+			js.Tests++
+			duration += r.Duration
+		}
+		js.Time = junitTime(duration)
+
+		res.Suites = append(res.Suites, js)
+		res.Tests += js.Tests
+		res.Failures += js.Failures
+		res.Errors += js.Errors
+		res.Skipped += js.Skipped
+		total += duration
+	}
+	res.Time = junitTime(total)
+
+	fc, err := xml.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), fc...), nil
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// junitDetails produces the body of a failure or error element so that CI test tabs
+// show what went wrong without having to dig through the system-out of the test case.
+func junitDetails(e *ErrResult, r *RunResult) string {
+	var res strings.Builder
+	res.WriteString(e.Message)
+	if r == nil {
+		return res.String()
+	}
+	fmt.Fprintf(&res, "\n\nstatus: %d", r.StatusCode)
+	if len(r.Stdout) > 0 {
+		fmt.Fprintf(&res, "\n\nstdout:\n%s", r.Stdout)
+	}
+	if len(r.Stderr) > 0 {
+		fmt.Fprintf(&res, "\n\nstderr:\n%s", r.Stderr)
+	}
+	return junitText(res.String())
+}
+
+var ansiEscapeExpr = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// junitText makes test output fit for XML: it strips ANSI escape sequences and replaces
+// the remaining characters which XML 1.0 does not allow, e.g. control characters, with U+FFFD.
+func junitText(s string) string {
+	s = ansiEscapeExpr.ReplaceAllString(s, "")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return r
+		case r < 0x20, r >= 0xD800 && r <= 0xDFFF, r == 0xFFFE, r == 0xFFFF:
+			return utf8.RuneError
+		default:
+			return r
+		}
+	}, s)
+}
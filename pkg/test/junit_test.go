@@ -0,0 +1,87 @@
+package test
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testReportResults are the results the report formats are tested with: one of each outcome
+func testReportResults() Results {
+	return Results{
+		Name: "golang",
+		Result: []*Result{
+			{Desc: "go version", Duration: 1500 * time.Millisecond, RunResult: &RunResult{Stdout: []byte("go1.21\n")}},
+			{Desc: "gofmt # works", Matrix: "GOOS=linux", Duration: 250 * time.Millisecond, Failure: &ErrResult{Message: "assertion failed", Type: "assertion"}, RunResult: &RunResult{StatusCode: 1, Stderr: []byte("oops")}},
+			{Desc: "runner", Error: &ErrResult{Message: "cannot start container", Type: "runner"}},
+			{Desc: "cgo", Skipped: true, SkipReason: "needs gofmt # works"},
+			nil,
+		},
+	}
+}
+
+func TestMarshalJUnit(t *testing.T) {
+	act, err := MarshalJUnit(testReportResults(), Results{Name: "node", Result: []*Result{{Desc: "node version", Duration: time.Second}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectation := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="5" failures="1" errors="1" skipped="1" time="2.750">
+  <testsuite name="golang" tests="4" failures="1" errors="1" skipped="1" time="1.750">
+    <testcase name="go version" classname="golang" time="1.500">
+      <system-out><![CDATA[go1.21
+]]></system-out>
+    </testcase>
+    <testcase name="gofmt # works" classname="golang[GOOS=linux]" time="0.250">
+      <failure message="assertion failed" type="assertion"><![CDATA[assertion failed
+
+status: 1
+
+stderr:
+oops]]></failure>
+      <system-err><![CDATA[oops]]></system-err>
+    </testcase>
+    <testcase name="runner" classname="golang" time="0.000">
+      <error message="cannot start container" type="runner"><![CDATA[cannot start container]]></error>
+    </testcase>
+    <testcase name="cgo" classname="golang" time="0.000">
+      <skipped message="needs gofmt # works"></skipped>
+    </testcase>
+  </testsuite>
+  <testsuite name="node" tests="1" failures="0" errors="0" skipped="0" time="1.000">
+    <testcase name="node version" classname="node" time="1.000"></testcase>
+  </testsuite>
+</testsuites>`
+	if diff := cmp.Diff(expectation, string(act)); diff != "" {
+		t.Errorf("MarshalJUnit() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarshalJUnit_output(t *testing.T) {
+	out := "\x1b[1;32mok\x1b[0m  \x1b[31mfailed\x1b[0m\x07\x00 a[b]]>c \xff\n"
+	act, err := MarshalJUnit(Results{Name: "colors", Result: []*Result{{
+		Desc:      "colored output",
+		Failure:   &ErrResult{Message: "assertion failed"},
+		RunResult: &RunResult{StatusCode: 1, Stdout: []byte(out), Stderr: []byte(out)},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res junitTestSuites
+	err = xml.Unmarshal(act, &res)
+	if err != nil {
+		t.Fatalf("MarshalJUnit() produced invalid XML: %v\n%s", err, act)
+	}
+	const text = "ok  failed�� a[b]]>c �\n"
+	tc := res.Suites[0].Cases[0]
+	if diff := cmp.Diff(text, tc.SystemOut.Body); diff != "" {
+		t.Errorf("MarshalJUnit() system-out mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("assertion failed\n\nstatus: 1\n\nstdout:\n"+text+"\n\nstderr:\n"+text, tc.Failure.Body); diff != "" {
+		t.Errorf("MarshalJUnit() failure mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,360 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gitpod-io/dazzle/pkg/test"
+	"github.com/gitpod-io/dazzle/pkg/test/runner"
+)
+
+const (
+	// DefaultHelperImage is the image of the init container which receives the runner binary
+	DefaultHelperImage = "busybox:1.36"
+
+	runnerContainer = "dazzle-runner"
+	testContainer   = "test"
+	pollInterval    = time.Second
+)
+
+// Option configures a Kubernetes executor
+type Option func(*Executor)
+
+// WithNamespace makes the executor create its pods in a namespace other than the current one
+func WithNamespace(namespace string) Option {
+	return func(e *Executor) {
+		e.namespace = namespace
+	}
+}
+
+// WithKubeconfig makes the executor use a kubeconfig file other than the default one
+func WithKubeconfig(fn string) Option {
+	return func(e *Executor) {
+		e.kubeconfig = fn
+	}
+}
+
+// WithContext makes the executor use a kubeconfig context other than the current one
+func WithContext(name string) Option {
+	return func(e *Executor) {
+		e.context = name
+	}
+}
+
+// WithHelperImage configures the image used to copy the runner into the test pod.
+// The image must contain a shell, cat and chmod.
+func WithHelperImage(ref string) Option {
+	return func(e *Executor) {
+		e.helperImage = ref
+	}
+}
+
+// WithKubectl configures the kubectl binary the executor uses
+func WithKubectl(bin string) Option {
+	return func(e *Executor) {
+		e.kubectl = bin
+	}
+}
+
+// NewExecutor creates a new executor which runs tests as pods in a Kubernetes cluster
+func NewExecutor(ref string, cfg *ociv1.Image, opts ...Option) *Executor {
+	res := &Executor{
+		ref:         ref,
+		cfg:         cfg,
+		helperImage: DefaultHelperImage,
+		kubectl:     "kubectl",
+	}
+	for _, o := range opts {
+		o(res)
+	}
+	return res
+}
+
+// Executor runs each test as a pod using the image under test. This does not require a buildkit daemon,
+// only cluster access using kubectl and a cluster which can pull the image.
+//
+// Each pod has an init container which receives the runner binary through kubectl exec and places it on
+// a shared volume. Once the runner is in place the test container starts the runner which runs the test
+// command and prints the result to its log.
+type Executor struct {
+	ref string
+	cfg *ociv1.Image
+
+	namespace   string
+	kubeconfig  string
+	context     string
+	helperImage string
+	kubectl     string
+}
+
+// ImageConfig returns the configuration of the image the tests run in
+func (e *Executor) ImageConfig(ctx context.Context) (*ociv1.ImageConfig, error) {
+	return &e.cfg.Config, nil
+}
+
+// Run executes the test
+func (e *Executor) Run(ctx context.Context, spec *test.Spec) (rr *test.RunResult, err error) {
+	rb, err := runner.GetRunner(runner.Platform(e.cfg.OS, e.cfg.Architecture))
+	if err != nil {
+		return
+	}
+	espec, err := runner.Args(spec)
+	if err != nil {
+		return
+	}
+
+	name, err := podName()
+	if err != nil {
+		return
+	}
+	pod, err := e.pod(name, espec, spec.Mounts)
+	if err != nil {
+		return
+	}
+	manifest, err := json.Marshal(pod)
+	if err != nil {
+		return
+	}
+
+	log.WithField("pod", name).WithField("args", espec).Debug("running test using Kubernetes")
+	_, err = e.run(ctx, bytes.NewReader(manifest), "create", "-f", "-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create test pod: %w", err)
+	}
+	defer func() {
+		// we must not use ctx here as it might be cancelled already
+		_, derr := e.run(context.Background(), nil, "delete", "pod", name, "--wait=false")
+		if derr != nil {
+			log.WithError(derr).WithField("pod", name).Warn("cannot delete test pod")
+		}
+	}()
+
+	err = e.waitFor(ctx, name, func(st podStatus) (bool, error) {
+		return len(st.InitContainerStatuses) > 0 && st.InitContainerStatuses[0].State.Running != nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("test pod did not start: %w", err)
+	}
+	_, err = e.run(ctx, bytes.NewReader(rb), "exec", "-i", name, "-c", runnerContainer, "--", "sh", "-c", "cat > /dazzle/runner.tmp && chmod 755 /dazzle/runner.tmp && mv /dazzle/runner.tmp /dazzle/runner")
+	if err != nil {
+		return nil, fmt.Errorf("cannot copy runner into test pod: %w", err)
+	}
+
+	err = e.waitFor(ctx, name, func(st podStatus) (bool, error) {
+		switch st.Phase {
+		case "Succeeded":
+			return true, nil
+		case "Failed":
+			return false, fmt.Errorf("runner failed")
+		default:
+			return false, nil
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("test pod did not complete: %w", err)
+	}
+
+	buf, err := e.run(ctx, nil, "logs", name, "-c", testContainer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get test result: %w", err)
+	}
+	log.WithField("buf", string(buf)).Debug("received test run output")
+	return runner.UnmarshalRunResult(buf)
+}
+
+// pod produces the manifest of the pod which runs a test
+func (e *Executor) pod(name string, args []string, mounts []test.Mount) (map[string]interface{}, error) {
+	var (
+		volume  = []map[string]interface{}{{"name": "dazzle", "mountPath": "/dazzle"}}
+		volumes = []map[string]interface{}{
+			{"name": "dazzle", "emptyDir": map[string]interface{}{}},
+		}
+		testVolumes = volume
+	)
+	for i, m := range mounts {
+		tpe, err := m.Validate()
+		if err != nil {
+			return nil, err
+		}
+		emptyDir := map[string]interface{}{}
+		if tpe == test.MountTypeTmpfs {
+			emptyDir["medium"] = "Memory"
+		}
+		vn := fmt.Sprintf("mount-%d", i)
+		volumes = append(volumes, map[string]interface{}{"name": vn, "emptyDir": emptyDir})
+		testVolumes = append(testVolumes, map[string]interface{}{"name": vn, "mountPath": m.Path})
+	}
+
+	spec := map[string]interface{}{
+		"restartPolicy":                "Never",
+		"automountServiceAccountToken": false,
+		"volumes":                      volumes,
+		"initContainers": []map[string]interface{}{
+			{
+				"name":         runnerContainer,
+				"image":        e.helperImage,
+				"command":      []string{"sh", "-c", "until [ -f /dazzle/runner ]; do sleep 1; done"},
+				"volumeMounts": volume,
+			},
+		},
+		"containers": []map[string]interface{}{
+			{
+				"name":         testContainer,
+				"image":        e.ref,
+				"command":      append([]string{"/dazzle/runner"}, args...),
+				"volumeMounts": testVolumes,
+			},
+		},
+	}
+	if arch := e.cfg.Architecture; arch != "" {
+		spec["nodeSelector"] = map[string]string{"kubernetes.io/arch": arch}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name": name,
+			"labels": map[string]string{
+				"app.kubernetes.io/managed-by": "dazzle",
+			},
+		},
+		"spec": spec,
+	}, nil
+}
+
+// podStatus is the part of the status of a pod the executor waits on
+type podStatus struct {
+	Phase      string `json:"phase"`
+	Conditions []struct {
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	} `json:"conditions"`
+	InitContainerStatuses []containerStatus `json:"initContainerStatuses"`
+	ContainerStatuses     []containerStatus `json:"containerStatuses"`
+}
+
+type containerStatus struct {
+	Name  string `json:"name"`
+	State struct {
+		Waiting *struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"waiting"`
+		Running *struct {
+			StartedAt string `json:"startedAt"`
+		} `json:"running"`
+	} `json:"state"`
+}
+
+// terminalWaitingReasons are the reasons for a container to wait which it will not recover from by itself
+var terminalWaitingReasons = map[string]struct{}{
+	"ErrImagePull":               {},
+	"ImagePullBackOff":           {},
+	"ErrImageNeverPull":          {},
+	"InvalidImageName":           {},
+	"CreateContainerConfigError": {},
+	"CreateContainerError":       {},
+	"RunContainerError":          {},
+}
+
+// stuckReason explains why a pod will never start, or returns an empty string if it may still start
+func stuckReason(st podStatus) string {
+	for _, c := range st.Conditions {
+		if c.Type == "PodScheduled" && c.Status == "False" && c.Reason == "Unschedulable" {
+			return fmt.Sprintf("pod is unschedulable: %s", c.Message)
+		}
+	}
+	for _, cs := range append(append([]containerStatus{}, st.InitContainerStatuses...), st.ContainerStatuses...) {
+		w := cs.State.Waiting
+		if w == nil {
+			continue
+		}
+		if _, ok := terminalWaitingReasons[w.Reason]; ok {
+			return fmt.Sprintf("container %s: %s: %s", cs.Name, w.Reason, w.Message)
+		}
+	}
+	return ""
+}
+
+// waitFor polls the status of the pod until done returns true or an error, or the pod cannot start
+func (e *Executor) waitFor(ctx context.Context, name string, done func(st podStatus) (bool, error)) error {
+	for {
+		out, err := e.run(ctx, nil, "get", "pod", name, "-o", "jsonpath={.status}")
+		if err != nil {
+			return err
+		}
+		var st podStatus
+		if len(bytes.TrimSpace(out)) > 0 {
+			err = json.Unmarshal(out, &st)
+			if err != nil {
+				return fmt.Errorf("cannot unmarshal pod status: %w", err)
+			}
+		}
+		if reason := stuckReason(st); reason != "" {
+			return errors.New(reason)
+		}
+		ok, err := done(st)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// run executes kubectl and returns its stdout
+func (e *Executor) run(ctx context.Context, stdin *bytes.Reader, args ...string) ([]byte, error) {
+	var global []string
+	if e.kubeconfig != "" {
+		global = append(global, "--kubeconfig", e.kubeconfig)
+	}
+	if e.context != "" {
+		global = append(global, "--context", e.context)
+	}
+	if e.namespace != "" {
+		global = append(global, "--namespace", e.namespace)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.kubectl, append(global, args...)...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func podName() (string, error) {
+	var buf [6]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return "", err
+	}
+	return "dazzle-test-" + hex.EncodeToString(buf[:]), nil
+}
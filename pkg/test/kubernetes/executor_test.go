@@ -0,0 +1,195 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	ociv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/gitpod-io/dazzle/pkg/test"
+)
+
+func TestExecutor_pod(t *testing.T) {
+	type Expectation struct {
+		Pod string
+		Err string
+	}
+	const (
+		runnerVolume  = `{"name": "dazzle", "mountPath": "/dazzle"}`
+		initContainer = `{
+			"name": "dazzle-runner",
+			"image": "busybox:1.36",
+			"command": ["sh", "-c", "until [ -f /dazzle/runner ]; do sleep 1; done"],
+			"volumeMounts": [` + runnerVolume + `]
+		}`
+		metadata = `{"name": "dazzle-test-0", "labels": {"app.kubernetes.io/managed-by": "dazzle"}}`
+	)
+	tests := []struct {
+		Name        string
+		Config      ociv1.Image
+		Args        []string
+		Mounts      []test.Mount
+		Expectation Expectation
+	}{
+		{
+			Name: "plain",
+			Args: []string{"--", "go", "version"},
+			Expectation: Expectation{Pod: `{
+				"apiVersion": "v1",
+				"kind": "Pod",
+				"metadata": ` + metadata + `,
+				"spec": {
+					"restartPolicy": "Never",
+					"automountServiceAccountToken": false,
+					"volumes": [{"name": "dazzle", "emptyDir": {}}],
+					"initContainers": [` + initContainer + `],
+					"containers": [{
+						"name": "test",
+						"image": "example.com/image:latest",
+						"command": ["/dazzle/runner", "--", "go", "version"],
+						"volumeMounts": [` + runnerVolume + `]
+					}]
+				}
+			}`},
+		},
+		{
+			Name:   "mounts and architecture",
+			Config: ociv1.Image{Architecture: "arm64"},
+			Mounts: []test.Mount{{Path: "/workspace"}, {Path: "/tmp", Type: test.MountTypeTmpfs}},
+			Expectation: Expectation{Pod: `{
+				"apiVersion": "v1",
+				"kind": "Pod",
+				"metadata": ` + metadata + `,
+				"spec": {
+					"restartPolicy": "Never",
+					"automountServiceAccountToken": false,
+					"nodeSelector": {"kubernetes.io/arch": "arm64"},
+					"volumes": [
+						{"name": "dazzle", "emptyDir": {}},
+						{"name": "mount-0", "emptyDir": {}},
+						{"name": "mount-1", "emptyDir": {"medium": "Memory"}}
+					],
+					"initContainers": [` + initContainer + `],
+					"containers": [{
+						"name": "test",
+						"image": "example.com/image:latest",
+						"command": ["/dazzle/runner"],
+						"volumeMounts": [
+							` + runnerVolume + `,
+							{"name": "mount-0", "mountPath": "/workspace"},
+							{"name": "mount-1", "mountPath": "/tmp"}
+						]
+					}]
+				}
+			}`},
+		},
+		{
+			Name:        "invalid mount",
+			Mounts:      []test.Mount{{Path: "workspace"}},
+			Expectation: Expectation{Err: `mount path "workspace" must be absolute`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			e := NewExecutor("example.com/image:latest", &test.Config)
+
+			var act, expectation Expectation
+			pod, err := e.pod("dazzle-test-0", test.Args, test.Mounts)
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Pod = normalizeJSON(t, pod)
+			}
+			expectation.Err = test.Expectation.Err
+			if test.Expectation.Pod != "" {
+				var v interface{}
+				err = json.Unmarshal([]byte(test.Expectation.Pod), &v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expectation.Pod = normalizeJSON(t, v)
+			}
+
+			if diff := cmp.Diff(expectation, act); diff != "" {
+				t.Errorf("pod() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// normalizeJSON marshals v with sorted keys, so that manifests can be compared irrespective of their Go types
+func normalizeJSON(t *testing.T, v interface{}) string {
+	fc, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generic interface{}
+	err = json.Unmarshal(fc, &generic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err = json.MarshalIndent(generic, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(fc)
+}
+
+func TestStuckReason(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Status      string
+		Expectation string
+	}{
+		{
+			Name:   "pending",
+			Status: `{"phase": "Pending"}`,
+		},
+		{
+			Name: "starting",
+			Status: `{"phase": "Pending", "initContainerStatuses": [
+				{"name": "dazzle-runner", "state": {"waiting": {"reason": "PodInitializing"}}}
+			]}`,
+		},
+		{
+			Name: "running",
+			Status: `{"phase": "Running", "containerStatuses": [
+				{"name": "test", "state": {"running": {"startedAt": "2023-01-01T00:00:00Z"}}}
+			]}`,
+		},
+		{
+			Name: "image pull failed",
+			Status: `{"phase": "Pending", "containerStatuses": [
+				{"name": "test", "state": {"waiting": {"reason": "ErrImagePull", "message": "manifest unknown"}}}
+			]}`,
+			Expectation: "container test: ErrImagePull: manifest unknown",
+		},
+		{
+			Name: "helper image pull back-off",
+			Status: `{"phase": "Pending", "initContainerStatuses": [
+				{"name": "dazzle-runner", "state": {"waiting": {"reason": "ImagePullBackOff", "message": "Back-off pulling image"}}}
+			]}`,
+			Expectation: "container dazzle-runner: ImagePullBackOff: Back-off pulling image",
+		},
+		{
+			Name: "unschedulable",
+			Status: `{"phase": "Pending", "conditions": [
+				{"type": "PodScheduled", "status": "False", "reason": "Unschedulable", "message": "0/3 nodes are available"}
+			]}`,
+			Expectation: "pod is unschedulable: 0/3 nodes are available",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var st podStatus
+			err := json.Unmarshal([]byte(test.Status), &st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.Expectation, stuckReason(st)); diff != "" {
+				t.Errorf("stuckReason() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
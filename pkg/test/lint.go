@@ -0,0 +1,149 @@
+package test
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+// LintProblem is an issue found in a test suite
+type LintProblem struct {
+	// Test is the index of the test in the suite or -1 if the problem concerns the suite as a whole
+	Test    int
+	Desc    string
+	Message string
+}
+
+func (p LintProblem) String() string {
+	if p.Test < 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("test %d (%q): %s", p.Test, p.Desc, p.Message)
+}
+
+var suiteFields = map[string]struct{}{"before": {}, "after": {}, "matrix": {}, "tests": {}}
+
+// Lint checks a test suite for problems which would otherwise only surface when running it:
+// violations of the test spec schema, duplicate descriptions and assertions which do not compile.
+func Lint(fc []byte) ([]LintProblem, error) {
+	var raw interface{}
+	err := yaml.Unmarshal(fc, &raw)
+	if err != nil {
+		return []LintProblem{{Test: -1, Message: err.Error()}}, nil
+	}
+
+	var res []LintProblem
+	specs := raw
+	if m, ok := raw.(map[string]interface{}); ok {
+		for k := range m {
+			if _, known := suiteFields[k]; !known {
+				res = append(res, LintProblem{Test: -1, Message: fmt.Sprintf("unknown suite field %q", k)})
+			}
+		}
+		specs = m["tests"]
+	}
+	if specs == nil {
+		specs = []interface{}{}
+	}
+
+	schemaProblems, err := lintSchema(specs)
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, schemaProblems...)
+
+	// the schema covers unknown fields and wrong types already, hence we parse leniently to check the rest
+	suite, err := parseSuite(fc, false)
+	if err != nil {
+		if len(res) == 0 {
+			res = append(res, LintProblem{Test: -1, Message: err.Error()})
+		}
+		return res, nil
+	}
+
+	descs := make(map[string]int, len(suite.Tests))
+	for i, s := range suite.Tests {
+		problem := func(format string, args ...interface{}) {
+			res = append(res, LintProblem{Test: i, Desc: s.Desc, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if prev, exists := descs[s.Desc]; exists {
+			problem("duplicate description, also used by test %d", prev)
+		} else {
+			descs[s.Desc] = i
+		}
+
+		switch s.Kind {
+		case "", SpecKindCommand:
+			if len(s.Command) == 0 && len(s.Entrypoint) == 0 {
+				problem("command is empty")
+			}
+		case SpecKindImage:
+		case SpecKindService:
+			if _, err := s.Service.ReadyTimeout(); err != nil {
+				problem("%v", err)
+			}
+		default:
+			problem("unknown kind %q", s.Kind)
+		}
+
+		for _, m := range s.Mounts {
+			if _, err := m.Validate(); err != nil {
+				problem("%v", err)
+			}
+		}
+
+		for _, a := range s.Assertions {
+			if _, err := goja.Compile("", a, false); err != nil {
+				problem("invalid assertion %q: %v", a, err)
+			}
+		}
+	}
+
+	res = append(res, lintNeeds(suite.Tests)...)
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Test < res[j].Test })
+	return res, nil
+}
+
+// lintSchema validates a list of specs against the test spec schema
+func lintSchema(specs interface{}) ([]LintProblem, error) {
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(Schema()), gojsonschema.NewGoLoader(specs))
+	if err != nil {
+		return nil, fmt.Errorf("cannot validate against test spec schema: %w", err)
+	}
+
+	items, _ := specs.([]interface{})
+	var res []LintProblem
+	for _, e := range result.Errors() {
+		var (
+			field = e.Field()
+			idx   = -1
+		)
+		segs := strings.SplitN(field, ".", 2)
+		if i, err := strconv.Atoi(segs[0]); err == nil {
+			idx = i
+			field = ""
+			if len(segs) == 2 {
+				field = segs[1]
+			}
+		}
+
+		p := LintProblem{Test: idx, Message: e.Description()}
+		if field != "" && field != "(root)" {
+			p.Message = field + ": " + p.Message
+		}
+		if idx >= 0 && idx < len(items) {
+			if spec, ok := items[idx].(map[string]interface{}); ok {
+				p.Desc, _ = spec["desc"].(string)
+			}
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Input       string
+		Expectation []string
+	}{
+		{
+			Name:  "valid",
+			Input: "- desc: a\n  command: [go, version]\n  assert:\n  - status == 0\n",
+		},
+		{
+			Name:  "valid suite",
+			Input: "before:\n- [mkdir, /tmp/x]\ntests:\n- desc: a\n  command: [echo]\n  assert: [status == 0]\n- desc: b\n  command: [echo]\n  assert: [status == 0]\n  needs: [a]\n",
+		},
+		{
+			Name:        "invalid yaml",
+			Input:       "- desc: [a\n",
+			Expectation: []string{"yaml: line 1: did not find expected ',' or ']'"},
+		},
+		{
+			Name:        "unknown suite field",
+			Input:       "tests: []\nsetup: []\n",
+			Expectation: []string{`unknown suite field "setup"`},
+		},
+		{
+			Name:  "duplicate description",
+			Input: "- desc: a\n  command: [echo]\n  assert: [status == 0]\n- desc: a\n  command: [echo]\n  assert: [status == 1]\n",
+			Expectation: []string{
+				`test 1 ("a"): duplicate description, also used by test 0`,
+			},
+		},
+		{
+			Name:  "empty command",
+			Input: "- desc: a\n  assert: [status == 0]\n",
+			Expectation: []string{
+				`test 0 ("a"): command is empty`,
+			},
+		},
+		{
+			Name:  "invalid assertion",
+			Input: "- desc: a\n  command: [echo]\n  assert:\n  - status ==\n",
+			Expectation: []string{
+				`test 0 ("a"): invalid assertion "status ==": SyntaxError: (anonymous): Line 1:10 Unexpected end of input`,
+			},
+		},
+		{
+			Name:  "needs",
+			Input: "- desc: a\n  command: [echo]\n  assert: [status == 0]\n  needs: [b, c]\n- desc: b\n  command: [echo]\n  assert: [status == 0]\n  needs: [a]\n",
+			Expectation: []string{
+				`test 0 ("a"): needs unknown test "c"`,
+				`test 0 ("a"): part of a dependency cycle`,
+				`test 1 ("b"): part of a dependency cycle`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			problems, err := Lint([]byte(test.Input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var act []string
+			for _, p := range problems {
+				act = append(act, p.String())
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("Lint() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLint_schema(t *testing.T) {
+	problems, err := Lint([]byte("- desc: a\n  command: [true]\n  comand: [true]\n- desc: b\n  command: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := make(map[int]int)
+	for _, p := range problems {
+		tests[p.Test]++
+	}
+	if tests[0] == 0 || tests[1] == 0 {
+		t.Errorf("Lint() did not report the schema violations of both tests: %v", problems)
+	}
+	for _, p := range problems {
+		if p.Test == 0 && p.Desc != "a" {
+			t.Errorf("Lint() reported problem %q without the test's description", p)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package test
+
+import (
+	"fmt"
+	"path"
+)
+
+// MountType determines what backs a mount
+type MountType string
+
+const (
+	// MountTypeScratch mounts an empty, writable directory. This is the default.
+	MountTypeScratch MountType = "scratch"
+	// MountTypeTmpfs mounts an in-memory filesystem
+	MountTypeTmpfs MountType = "tmpfs"
+)
+
+// Mount is a writable directory made available to a test. Its content does not outlive the test.
+type Mount struct {
+	Path string    `yaml:"path"`
+	Type MountType `yaml:"type,omitempty"`
+}
+
+// Validate checks that the mount can be provided by an executor and returns its effective type
+func (m Mount) Validate() (MountType, error) {
+	if !path.IsAbs(m.Path) {
+		return "", fmt.Errorf("mount path %q must be absolute", m.Path)
+	}
+	switch m.Type {
+	case "", MountTypeScratch:
+		return MountTypeScratch, nil
+	case MountTypeTmpfs:
+		return MountTypeTmpfs, nil
+	default:
+		return "", fmt.Errorf("unknown mount type %q for %s: must be %s or %s", m.Type, m.Path, MountTypeScratch, MountTypeTmpfs)
+	}
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"fmt"
+)
+
+// orderByNeeds orders tests such that each test runs after the tests it needs, keeping the
+// original order otherwise. Needs which do not refer to one of the tests are ignored, e.g. because
+// the test was filtered out. Tests which are part of a dependency cycle are returned in cycles.
+func orderByNeeds(tests []*Spec) (order []int, cycles map[int]struct{}) {
+	idx := make(map[string]int, len(tests))
+	for i, t := range tests {
+		if _, exists := idx[t.Desc]; !exists {
+			idx[t.Desc] = i
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var (
+		state = make([]int, len(tests))
+		visit func(i int, path []int)
+	)
+	cycles = make(map[int]struct{})
+	visit = func(i int, path []int) {
+		switch state[i] {
+		case visited:
+			return
+		case visiting:
+			for j := len(path) - 1; j >= 0; j-- {
+				cycles[path[j]] = struct{}{}
+				if path[j] == i {
+					break
+				}
+			}
+			return
+		}
+
+		state[i] = visiting
+		for _, n := range tests[i].Needs {
+			if j, ok := idx[n]; ok {
+				visit(j, append(path, i))
+			}
+		}
+		state[i] = visited
+		order = append(order, i)
+	}
+	for i := range tests {
+		visit(i, nil)
+	}
+	return order, cycles
+}
+
+// unmetNeed returns the first need of the test which did not pass, or an empty string if there is none
+func unmetNeed(t *Spec, known map[string]bool) string {
+	for _, n := range t.Needs {
+		if passed, ok := known[n]; ok && !passed {
+			return n
+		}
+	}
+	return ""
+}
+
+// lintNeeds reports needs which refer to no test of the suite and dependency cycles
+func lintNeeds(tests []*Spec) []LintProblem {
+	descs := make(map[string]struct{}, len(tests))
+	for _, t := range tests {
+		descs[t.Desc] = struct{}{}
+	}
+
+	var res []LintProblem
+	for i, t := range tests {
+		for _, n := range t.Needs {
+			if _, ok := descs[n]; !ok {
+				res = append(res, LintProblem{Test: i, Desc: t.Desc, Message: fmt.Sprintf("needs unknown test %q", n)})
+			}
+		}
+	}
+	_, cycles := orderByNeeds(tests)
+	for i, t := range tests {
+		if _, ok := cycles[i]; ok {
+			res = append(res, LintProblem{Test: i, Desc: t.Desc, Message: "part of a dependency cycle"})
+		}
+	}
+	return res
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOrderByNeeds(t *testing.T) {
+	type Expectation struct {
+		Order  []string
+		Cycles []string
+	}
+	tests := []struct {
+		Name        string
+		Tests       []*Spec
+		Expectation Expectation
+	}{
+		{
+			Name:        "no needs",
+			Tests:       []*Spec{{Desc: "a"}, {Desc: "b"}, {Desc: "c"}},
+			Expectation: Expectation{Order: []string{"a", "b", "c"}},
+		},
+		{
+			Name:        "needs later test",
+			Tests:       []*Spec{{Desc: "a", Needs: []string{"c"}}, {Desc: "b"}, {Desc: "c"}},
+			Expectation: Expectation{Order: []string{"c", "a", "b"}},
+		},
+		{
+			Name:        "transitive needs",
+			Tests:       []*Spec{{Desc: "a", Needs: []string{"b"}}, {Desc: "b", Needs: []string{"c"}}, {Desc: "c"}},
+			Expectation: Expectation{Order: []string{"c", "b", "a"}},
+		},
+		{
+			Name:        "unknown need",
+			Tests:       []*Spec{{Desc: "a", Needs: []string{"filtered"}}, {Desc: "b"}},
+			Expectation: Expectation{Order: []string{"a", "b"}},
+		},
+		{
+			Name:        "cycle",
+			Tests:       []*Spec{{Desc: "a", Needs: []string{"b"}}, {Desc: "b", Needs: []string{"a"}}, {Desc: "c"}},
+			Expectation: Expectation{Order: []string{"b", "a", "c"}, Cycles: []string{"a", "b"}},
+		},
+		{
+			Name:        "self",
+			Tests:       []*Spec{{Desc: "a", Needs: []string{"a"}}, {Desc: "b"}},
+			Expectation: Expectation{Order: []string{"a", "b"}, Cycles: []string{"a"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			order, cycles := orderByNeeds(test.Tests)
+
+			var act Expectation
+			for _, i := range order {
+				act.Order = append(act.Order, test.Tests[i].Desc)
+			}
+			for i, s := range test.Tests {
+				if _, ok := cycles[i]; ok {
+					act.Cycles = append(act.Cycles, s.Desc)
+				}
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("orderByNeeds() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnmetNeed(t *testing.T) {
+	known := map[string]bool{"passed": true, "failed": false}
+
+	tests := []struct {
+		Name        string
+		Needs       []string
+		Expectation string
+	}{
+		{Name: "no needs"},
+		{Name: "passed", Needs: []string{"passed"}},
+		{Name: "failed", Needs: []string{"passed", "failed"}, Expectation: "failed"},
+		{Name: "not run", Needs: []string{"unknown"}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act := unmetNeed(&Spec{Needs: test.Needs}, known)
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("unmetNeed() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLintNeeds(t *testing.T) {
+	act := lintNeeds([]*Spec{
+		{Desc: "a", Needs: []string{"b", "missing"}},
+		{Desc: "b", Needs: []string{"a"}},
+		{Desc: "c", Needs: []string{"a"}},
+	})
+	expectation := []LintProblem{
+		{Test: 0, Desc: "a", Message: `needs unknown test "missing"`},
+		{Test: 0, Desc: "a", Message: "part of a dependency cycle"},
+		{Test: 1, Desc: "b", Message: "part of a dependency cycle"},
+	}
+	if diff := cmp.Diff(expectation, act); diff != "" {
+		t.Errorf("lintNeeds() mismatch (-want +got):\n%s", diff)
+	}
+}
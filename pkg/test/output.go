@@ -0,0 +1,62 @@
+package test
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// DefaultMaxOutput is the number of bytes of stdout and stderr each which are captured per test
+// unless the spec configures otherwise
+const DefaultMaxOutput = 1024 * 1024
+
+// maxOutput returns the number of bytes of output captured for this spec. A negative value means unlimited.
+func (s *Spec) maxOutput() int {
+	if s.MaxOutput == 0 {
+		return DefaultMaxOutput
+	}
+	return s.MaxOutput
+}
+
+// truncateOutput limits out to max bytes by keeping its head and tail and replacing the middle
+// with a marker. The result never exceeds max bytes, hence truncating twice is a no-op.
+// The cuts are moved to rune boundaries so that UTF-8 output stays valid.
+func truncateOutput(out []byte, max int) []byte {
+	if max < 0 || len(out) <= max {
+		return out
+	}
+
+	// the marker will never be longer than for dropping all of out
+	markerLen := len(truncationMarker(len(out)))
+	keep := max - markerLen
+	if keep <= 0 {
+		return out[:runeStart(out, max, -1)]
+	}
+
+	var (
+		head = runeStart(out, keep/2, -1)
+		tail = runeStart(out, len(out)-(keep-keep/2), 1)
+		res  = make([]byte, 0, max)
+	)
+	res = append(res, out[:head]...)
+	res = append(res, truncationMarker(tail-head)...)
+	res = append(res, out[tail:]...)
+	return res
+}
+
+// runeStart moves the offset i in out by dir until it is the start of a rune or at either end of out
+func runeStart(out []byte, i, dir int) int {
+	for i > 0 && i < len(out) && !utf8.RuneStart(out[i]) {
+		i += dir
+	}
+	return i
+}
+
+func truncationMarker(dropped int) string {
+	return fmt.Sprintf("\n[... truncated %d bytes ...]\n", dropped)
+}
+
+// truncate limits the captured output of a run result
+func (r *RunResult) truncate(max int) {
+	r.Stdout = truncateOutput(r.Stdout, max)
+	r.Stderr = truncateOutput(r.Stderr, max)
+}
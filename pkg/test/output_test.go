@@ -0,0 +1,67 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTruncateOutput(t *testing.T) {
+	var (
+		long      = strings.Repeat("a", 50) + strings.Repeat("b", 50)
+		multiByte = strings.Repeat("ä", 50) + strings.Repeat("€", 34)
+	)
+
+	tests := []struct {
+		Name        string
+		Out         string
+		Max         int
+		Expectation string
+	}{
+		{Name: "short", Out: "hello", Max: 10, Expectation: "hello"},
+		{Name: "exactly max", Out: "hello", Max: 5, Expectation: "hello"},
+		{Name: "unlimited", Out: long, Max: -1, Expectation: long},
+		{
+			Name:        "head and tail",
+			Out:         long,
+			Max:         60,
+			Expectation: strings.Repeat("a", 14) + "\n[... truncated 71 bytes ...]\n" + strings.Repeat("b", 15),
+		},
+		{Name: "max smaller than marker", Out: long, Max: 10, Expectation: strings.Repeat("a", 10)},
+		{
+			Name:        "multi-byte runes",
+			Out:         multiByte,
+			Max:         61,
+			Expectation: strings.Repeat("ä", 7) + "\n[... truncated 173 bytes ...]\n" + strings.Repeat("€", 5),
+		},
+		{Name: "multi-byte runes smaller than marker", Out: multiByte, Max: 9, Expectation: strings.Repeat("ä", 4)},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act := string(truncateOutput([]byte(test.Out), test.Max))
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("truncateOutput() mismatch (-want +got):\n%s", diff)
+			}
+			if !utf8.ValidString(act) {
+				t.Errorf("truncateOutput() produced invalid UTF-8: %q", act)
+			}
+			if test.Max >= 0 && len(act) > test.Max {
+				t.Errorf("truncateOutput() returned %d bytes, more than %d", len(act), test.Max)
+			}
+			if again := string(truncateOutput([]byte(act), test.Max)); again != act {
+				t.Errorf("truncating twice changed the output: %q", again)
+			}
+		})
+	}
+}
+
+func TestSpec_maxOutput(t *testing.T) {
+	if act := (&Spec{}).maxOutput(); act != DefaultMaxOutput {
+		t.Errorf("maxOutput() = %d, want %d", act, DefaultMaxOutput)
+	}
+	if act := (&Spec{MaxOutput: -1}).maxOutput(); act != -1 {
+		t.Errorf("maxOutput() = %d, want -1", act)
+	}
+}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReportFormats maps the name of a report format to the function that renders it
+var ReportFormats = map[string]func(suites ...Results) ([]byte, error){
+	"xml":  MarshalJUnit,
+	"json": MarshalJSONReport,
+	"tap":  MarshalTAP,
+}
+
+// Outcome describes how a single test ended
+type Outcome string
+
+const (
+	// OutcomePassed means the test ran and all assertions held
+	OutcomePassed Outcome = "passed"
+	// OutcomeFailed means the test ran but an assertion did not hold
+	OutcomeFailed Outcome = "failed"
+	// OutcomeError means the test could not be run or evaluated
+	OutcomeError Outcome = "error"
+	// OutcomeSkipped means the test was not run
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// Outcome returns how the test ended
+func (r *Result) Outcome() Outcome {
+	switch {
+	case r.Skipped:
+		return OutcomeSkipped
+	case r.Error != nil:
+		return OutcomeError
+	case r.Failure != nil:
+		return OutcomeFailed
+	default:
+		return OutcomePassed
+	}
+}
+
+type jsonReport struct {
+	Tests    int                `json:"tests"`
+	Failures int                `json:"failures"`
+	Errors   int                `json:"errors"`
+	Skipped  int                `json:"skipped"`
+	Suites   []*jsonReportSuite `json:"suites"`
+}
+
+type jsonReportSuite struct {
+	Name  string            `json:"name"`
+	Tests []*jsonReportTest `json:"tests"`
+}
+
+type jsonReportTest struct {
+	Desc            string  `json:"desc"`
+	Matrix          string  `json:"matrix,omitempty"`
+	Outcome         Outcome `json:"outcome"`
+	Duration        float64 `json:"duration"`
+	ExpectedFailure bool    `json:"expectedFailure,omitempty"`
+	Message         string  `json:"message,omitempty"`
+	Type            string  `json:"type,omitempty"`
+	StatusCode      *int64  `json:"statusCode,omitempty"`
+	Stdout          string  `json:"stdout,omitempty"`
+	Stderr          string  `json:"stderr,omitempty"`
+}
+
+// MarshalJSONReport renders test results as JSON. Durations are given in seconds.
+func MarshalJSONReport(suites ...Results) ([]byte, error) {
+	res := jsonReport{Suites: make([]*jsonReportSuite, 0, len(suites))}
+	for _, s := range suites {
+		js := &jsonReportSuite{Name: s.Name, Tests: make([]*jsonReportTest, 0, len(s.Result))}
+		for _, r := range s.Result {
+			if r == nil {
+				continue
+			}
+
+			t := &jsonReportTest{
+				Desc:            r.Desc,
+				Matrix:          r.Matrix,
+				Outcome:         r.Outcome(),
+				Duration:        r.Duration.Seconds(),
+				ExpectedFailure: r.ExpectedFailure,
+			}
+			switch t.Outcome {
+			case OutcomeFailed:
+				t.Message, t.Type = r.Failure.Message, r.Failure.Type
+				res.Failures++
+			case OutcomeError:
+				t.Message, t.Type = r.Error.Message, r.Error.Type
+				res.Errors++
+			case OutcomeSkipped:
+				t.Message = r.SkipReason
+				res.Skipped++
+			}
+			if r.RunResult != nil {
+				status := r.StatusCode
+				t.StatusCode = &status
+				t.Stdout = string(r.Stdout)
+				t.Stderr = string(r.Stderr)
+			}
+
+			js.Tests = append(js.Tests, t)
+			res.Tests++
+		}
+		res.Suites = append(res.Suites, js)
+	}
+
+	return json.MarshalIndent(res, "", "  ")
+}
+
+// MarshalTAP renders test results in the Test Anything Protocol (version 13). Failures and errors
+// carry a YAML diagnostic block with the message, exit code and output of the test.
+func MarshalTAP(suites ...Results) ([]byte, error) {
+	var (
+		lines []string
+		n     int
+	)
+	for _, s := range suites {
+		for _, r := range s.Result {
+			if r == nil {
+				continue
+			}
+			n++
+
+			desc := strings.ReplaceAll(r.Desc, "#", "\\#")
+			if s.Name != "" {
+				desc = s.Name + ": " + desc
+			}
+
+			var (
+				outcome = r.Outcome()
+				diag    *ErrResult
+			)
+			switch outcome {
+			case OutcomePassed:
+				lines = append(lines, fmt.Sprintf("ok %d - %s", n, desc))
+			case OutcomeSkipped:
+				skip := "ok %d - %s # SKIP"
+				if r.SkipReason != "" {
+					skip += " " + r.SkipReason
+				}
+				lines = append(lines, fmt.Sprintf(skip, n, desc))
+			case OutcomeFailed:
+				lines = append(lines, fmt.Sprintf("not ok %d - %s", n, desc))
+				diag = r.Failure
+			case OutcomeError:
+				lines = append(lines, fmt.Sprintf("not ok %d - %s", n, desc))
+				diag = r.Error
+			}
+			if diag == nil {
+				continue
+			}
+
+			block := map[string]interface{}{
+				"message":  diag.Message,
+				"severity": string(outcome),
+				"duration": r.Duration.Seconds(),
+			}
+			if r.RunResult != nil {
+				block["status"] = r.StatusCode
+				if len(r.Stdout) > 0 {
+					block["stdout"] = string(r.Stdout)
+				}
+				if len(r.Stderr) > 0 {
+					block["stderr"] = string(r.Stderr)
+				}
+			}
+			fc, err := yaml.Marshal(block)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, "  ---")
+			for _, l := range strings.Split(strings.TrimSuffix(string(fc), "\n"), "\n") {
+				lines = append(lines, "  "+l)
+			}
+			lines = append(lines, "  ...")
+		}
+	}
+
+	res := append([]string{"TAP version 13", fmt.Sprintf("1..%d", n)}, lines...)
+	return []byte(strings.Join(res, "\n") + "\n"), nil
+}
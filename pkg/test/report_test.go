@@ -0,0 +1,110 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResult_Outcome(t *testing.T) {
+	var act []Outcome
+	for _, r := range testReportResults().Result[:4] {
+		act = append(act, r.Outcome())
+	}
+	if diff := cmp.Diff([]Outcome{OutcomePassed, OutcomeFailed, OutcomeError, OutcomeSkipped}, act); diff != "" {
+		t.Errorf("Outcome() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarshalJSONReport(t *testing.T) {
+	act, err := MarshalJSONReport(testReportResults())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectation := `{
+  "tests": 4,
+  "failures": 1,
+  "errors": 1,
+  "skipped": 1,
+  "suites": [
+    {
+      "name": "golang",
+      "tests": [
+        {
+          "desc": "go version",
+          "outcome": "passed",
+          "duration": 1.5,
+          "statusCode": 0,
+          "stdout": "go1.21\n"
+        },
+        {
+          "desc": "gofmt # works",
+          "matrix": "GOOS=linux",
+          "outcome": "failed",
+          "duration": 0.25,
+          "message": "assertion failed",
+          "type": "assertion",
+          "statusCode": 1,
+          "stderr": "oops"
+        },
+        {
+          "desc": "runner",
+          "outcome": "error",
+          "duration": 0,
+          "message": "cannot start container",
+          "type": "runner"
+        },
+        {
+          "desc": "cgo",
+          "outcome": "skipped",
+          "duration": 0,
+          "message": "needs gofmt # works"
+        }
+      ]
+    }
+  ]
+}`
+	if diff := cmp.Diff(expectation, string(act)); diff != "" {
+		t.Errorf("MarshalJSONReport() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarshalTAP(t *testing.T) {
+	act, err := MarshalTAP(testReportResults())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectation := `TAP version 13
+1..4
+ok 1 - golang: go version
+not ok 2 - golang: gofmt \# works
+  ---
+  duration: 0.25
+  message: assertion failed
+  severity: failed
+  status: 1
+  stderr: oops
+  ...
+not ok 3 - golang: runner
+  ---
+  duration: 0
+  message: cannot start container
+  severity: error
+  ...
+ok 4 - golang: cgo # SKIP needs gofmt # works
+`
+	if diff := cmp.Diff(expectation, string(act)); diff != "" {
+		t.Errorf("MarshalTAP() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReportFormats(t *testing.T) {
+	for name, marshal := range ReportFormats {
+		_, err := marshal(testReportResults(), Results{Name: "empty"})
+		if err != nil {
+			t.Errorf("report format %s: %v", name, err)
+		}
+	}
+}
@@ -1,23 +1,21 @@
 #!/bin/sh
 set -e
 
-GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o bin/runner_linux_amd64 main.go
+PLATFORMS="amd64 arm64"
+
+for arch in $PLATFORMS; do
+    GOOS=linux GOARCH=$arch CGO_ENABLED=0 go build -o bin/runner_linux_$arch main.go
+done
+
 curl -L https://github.com/upx/upx/releases/download/v3.96/upx-3.96-amd64_linux.tar.xz | tar xJ
-upx-3.96-amd64_linux/upx bin/runner_linux_amd64 
+for arch in $PLATFORMS; do
+    upx-3.96-amd64_linux/upx bin/runner_linux_$arch
+done
 rm -r upx-3.96-amd64_linux
-go install github.com/GeertJohan/go.rice/rice@v1.0.2
-RICEBIN="$GOBIN"
-if [ -z "$RICEBIN" ]; then
-  if [ -z "$GOPATH" ]; then
-    RICEBIN="$HOME"/go/bin
-  else
-    RICEBIN="$GOPATH"/bin
-  fi
-fi
-
-"$RICEBIN"/rice embed-go -i github.com/gitpod-io/dazzle/pkg/test/runner
 
-if [ $(ls -l bin/runner_linux_amd64 | cut -d ' ' -f 5) -gt 3437900 ]; then
-    echo "runner binary is too big (> gRPC message size)"
-    exit 1
-fi
\ No newline at end of file
+for arch in $PLATFORMS; do
+    if [ $(ls -l bin/runner_linux_$arch | cut -d ' ' -f 5) -gt 3437900 ]; then
+        echo "runner binary for $arch is too big (> gRPC message size)"
+        exit 1
+    fi
+done
@@ -0,0 +1,25 @@
+//go:build !runner_from_source
+// +build !runner_from_source
+
+package runner
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// bin contains the runner binaries produced by build.sh. The directory always contains a placeholder
+// so that dazzle compiles even if the runner binaries were not generated.
+//
+//go:embed bin/*
+var bin embed.FS
+
+func loadRunner(platform string) ([]byte, error) {
+	res, err := bin.ReadFile("bin/runner_" + platform)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("runner for %s is not embedded: run go generate ./... or build with -tags runner_from_source", platform)
+	}
+	return res, err
+}
@@ -4,19 +4,35 @@ package runner
 
 import (
 	"fmt"
-
-	rice "github.com/GeertJohan/go.rice"
 )
 
+// Platforms lists the platforms for which a runner binary is available
+var Platforms = []string{"linux_amd64", "linux_arm64"}
+
 // GetRunner returns the runner binary for a particular platform
 func GetRunner(platform string) ([]byte, error) {
-	if platform != "linux_amd64" {
+	var supported bool
+	for _, p := range Platforms {
+		if p == platform {
+			supported = true
+			break
+		}
+	}
+	if !supported {
 		return nil, fmt.Errorf("unsupported platform %s", platform)
 	}
 
-	box, err := rice.FindBox("bin")
-	if err != nil {
-		return nil, err
+	return loadRunner(platform)
+}
+
+// Platform produces the platform name of a runner binary from an OS and architecture as found in image configs.
+// If either is empty, linux and amd64 are assumed.
+func Platform(os, arch string) string {
+	if os == "" {
+		os = "linux"
+	}
+	if arch == "" {
+		arch = "amd64"
 	}
-	return box.Bytes("runner_" + platform)
+	return os + "_" + arch
 }
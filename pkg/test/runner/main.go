@@ -35,8 +35,9 @@ func main() {
 	res, err := executor.Run(context.Background(), &spec)
 	if err != nil {
 		res = &test.RunResult{
-			Stderr:     []byte(fmt.Sprintf("cannot run command: %+q\nenv: %s\n", err, strings.Join(os.Environ(), "\n\t"))),
-			StatusCode: 255,
+			Stderr:      []byte(fmt.Sprintf("cannot run command: %+q\nenv: %s\n", err, strings.Join(os.Environ(), "\n\t"))),
+			StatusCode:  255,
+			RunnerError: err.Error(),
 		}
 	}
 
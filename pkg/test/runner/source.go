@@ -0,0 +1,62 @@
+//go:build runner_from_source
+// +build runner_from_source
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	sourceRunners   = make(map[string][]byte)
+	sourceRunnersMu sync.Mutex
+)
+
+// loadRunner compiles the runner from source for the platform. This requires a Go toolchain and the
+// dazzle sources at runtime, but makes dazzle usable when the runner binaries cannot be embedded.
+func loadRunner(platform string) ([]byte, error) {
+	sourceRunnersMu.Lock()
+	defer sourceRunnersMu.Unlock()
+
+	if res, ok := sourceRunners[platform]; ok {
+		return res, nil
+	}
+
+	_, self, _, ok := runtime.Caller(0)
+	if !ok {
+		return nil, fmt.Errorf("cannot locate runner sources")
+	}
+	src := filepath.Join(filepath.Dir(self), "main.go")
+	if _, err := os.Stat(src); err != nil {
+		return nil, fmt.Errorf("cannot locate runner sources: %w", err)
+	}
+
+	tmpdir, err := os.MkdirTemp("", "dazzle-runner-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	segs := strings.SplitN(platform, "_", 2)
+	out := filepath.Join(tmpdir, "runner_"+platform)
+	cmd := exec.Command("go", "build", "-tags", "runner", "-ldflags", "-s -w", "-o", out, src)
+	cmd.Dir = filepath.Dir(src)
+	cmd.Env = append(os.Environ(), "GOOS="+segs[0], "GOARCH="+segs[1], "CGO_ENABLED=0")
+	msg, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("cannot build runner for %s: %w\n%s", platform, err, string(msg))
+	}
+
+	res, err := os.ReadFile(out)
+	if err != nil {
+		return nil, err
+	}
+	sourceRunners[platform] = res
+	return res, nil
+}
@@ -0,0 +1,11 @@
+package test
+
+import (
+	"github.com/alecthomas/jsonschema"
+)
+
+// Schema returns the JSON schema of a list of test specs, as found in testspec.schema.json
+func Schema() *jsonschema.Schema {
+	var root []Spec
+	return jsonschema.Reflect(&root)
+}
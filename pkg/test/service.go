@@ -0,0 +1,51 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SpecKindService specs start the image's entrypoint as a long-running process and assert on
+// whether it becomes ready in time. status is 0 if it did, stdout and stderr hold its output.
+const SpecKindService SpecKind = "service"
+
+// DefaultServiceTimeout is the time a service may take to become ready unless the spec configures otherwise
+const DefaultServiceTimeout = 30 * time.Second
+
+// ServiceSpec configures how a service spec determines readiness
+type ServiceSpec struct {
+	// Ready is a command run in the service container until it succeeds. Without it the service
+	// is ready once the image's HEALTHCHECK reports it healthy.
+	Ready []string `yaml:"ready,omitempty,flow"`
+	// Timeout is the time the service may take to become ready, e.g. 1m. Defaults to DefaultServiceTimeout.
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// ReadyTimeout returns the time the service may take to become ready
+func (s *ServiceSpec) ReadyTimeout() (time.Duration, error) {
+	if s == nil || s.Timeout == "" {
+		return DefaultServiceTimeout, nil
+	}
+	res, err := time.ParseDuration(s.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid service timeout: %w", err)
+	}
+	if res <= 0 {
+		return 0, fmt.Errorf("service timeout must be positive")
+	}
+	return res, nil
+}
+
+// ServiceRunner is implemented by executors which can run the image under test as a service
+type ServiceRunner interface {
+	RunService(ctx context.Context, spec *Spec) (*RunResult, error)
+}
+
+// runService produces the run result of a service spec
+func runService(ctx context.Context, runner ServiceRunner, spec *Spec) (*RunResult, error) {
+	if _, err := spec.Service.ReadyTimeout(); err != nil {
+		return nil, err
+	}
+	return runner.RunService(ctx, spec)
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	log "github.com/sirupsen/logrus"
+)
+
+// serviceExecutor runs services like the Docker executor does, reporting a fixed result
+type serviceExecutor struct {
+	LocalExecutor
+	Result *RunResult
+	Specs  []*Spec
+}
+
+func (e *serviceExecutor) RunService(ctx context.Context, spec *Spec) (*RunResult, error) {
+	e.Specs = append(e.Specs, spec)
+	return e.Result, nil
+}
+
+func TestSpec_Run_service(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Executor    Executor
+		Spec        Spec
+		Expectation *Result
+	}{
+		{
+			Name:     "unsupported by executor",
+			Executor: LocalExecutor{},
+			Spec:     Spec{Desc: "it should serve", Kind: SpecKindService, Assertions: []string{"status == 0"}},
+			Expectation: &Result{
+				Desc:       "it should serve",
+				Skipped:    true,
+				SkipReason: "service tests are not supported when running tests this way",
+			},
+		},
+		{
+			Name:     "ready",
+			Executor: &serviceExecutor{Result: &RunResult{Stdout: []byte("listening\n")}},
+			Spec:     Spec{Desc: "it should serve", Kind: SpecKindService, Assertions: []string{"status == 0", `stdout.indexOf("listening") != -1`}},
+			Expectation: &Result{
+				Desc:      "it should serve",
+				RunResult: &RunResult{Stdout: []byte("listening\n")},
+			},
+		},
+		{
+			Name:     "not ready",
+			Executor: &serviceExecutor{Result: &RunResult{StatusCode: 1}},
+			Spec:     Spec{Desc: "it should serve", Kind: SpecKindService, Assertions: []string{"status == 0"}},
+			Expectation: &Result{
+				Desc:      "it should serve",
+				RunResult: &RunResult{StatusCode: 1},
+				Failure:   &ErrResult{Message: "assertion failed: status == 0"},
+			},
+		},
+		{
+			Name:     "invalid timeout",
+			Executor: &serviceExecutor{},
+			Spec:     Spec{Desc: "it should serve", Kind: SpecKindService, Service: &ServiceSpec{Timeout: "-1s"}},
+			Expectation: &Result{
+				Desc:  "it should serve",
+				Error: &ErrResult{Message: "service timeout must be positive", Type: "runtime"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act := test.Spec.Run(context.Background(), log.StandardLogger(), test.Executor)
+
+			if diff := cmp.Diff(test.Expectation, act, cmpopts.IgnoreFields(Result{}, "Duration"), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Run() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunTests_serviceUnsupported(t *testing.T) {
+	specs := []*Spec{
+		{Desc: "it should serve", Kind: SpecKindService},
+		{Desc: "it should run", Command: []string{"true"}},
+	}
+	res, success := RunTests(context.Background(), LocalExecutor{}, specs)
+	if !success {
+		t.Errorf("RunTests() success = false, expected skipped service tests not to fail the run")
+	}
+
+	type Expectation struct {
+		Desc       string
+		Skipped    bool
+		SkipReason string
+	}
+	var act []Expectation
+	for _, r := range res.Result {
+		act = append(act, Expectation{Desc: r.Desc, Skipped: r.Skipped, SkipReason: r.SkipReason})
+	}
+	expectation := []Expectation{
+		{Desc: "it should serve", Skipped: true, SkipReason: "service tests are not supported when running tests this way"},
+		{Desc: "it should run"},
+	}
+	if diff := cmp.Diff(expectation, act); diff != "" {
+		t.Errorf("RunTests() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -5,20 +5,23 @@ package test
 import (
 	"bytes"
 	"context"
-	"encoding/xml"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
-	"os/user"
-	"strconv"
+	"regexp"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/alecthomas/repr"
 	"github.com/creack/pty"
-	"github.com/robertkrimen/otto"
+	"github.com/dop251/goja"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
 // Spec specifies a command execution test against a Docker image
@@ -26,38 +29,195 @@ type Spec struct {
 	Desc string `yaml:"desc"`
 
 	Skip       bool     `yaml:"skip,omitempty"`
+	Tags       []string `yaml:"tags,omitempty,flow"`
+	Kind       SpecKind `yaml:"kind,omitempty"`
 	User       string   `yaml:"user,omitempty"`
-	Command    []string `yaml:"command,flow"`
+	Command    []string `yaml:"command,omitempty,flow"`
 	Entrypoint []string `yaml:"entrypoint,omitempty,flow"`
 	Env        []string `yaml:"env,omitempty"`
 
+	// MaxOutput is the number of bytes of stdout and stderr each which are captured. Longer output is truncated
+	// in the middle. Zero means DefaultMaxOutput, a negative value disables truncation.
+	MaxOutput int `yaml:"maxOutput,omitempty"`
+
+	// ExpectFailure asserts that the command fails, i.e. exits with a non-zero status or does not exist.
+	// A command which is killed by a signal does not fail as expected.
+	// Assertions are still evaluated, e.g. to check the error message.
+	ExpectFailure bool `yaml:"expectFailure,omitempty"`
+
+	// Before and After are commands run in the same container before and after the test command,
+	// e.g. to create fixtures. Their output is not subject to the assertions.
+	Before [][]string `yaml:"before,omitempty"`
+	After  [][]string `yaml:"after,omitempty"`
+
+	// Mounts are writable directories provided to the test, e.g. for tests which write outside of $HOME.
+	// They are provided by the executor running the container, hence LocalExecutor ignores them.
+	Mounts []Mount `yaml:"mounts,omitempty"`
+
+	// Service configures the readiness check of service specs
+	Service *ServiceSpec `yaml:"service,omitempty"`
+
+	// Needs lists the descriptions of tests which must pass before this test runs. If one of them
+	// does not pass this test is skipped.
+	Needs []string `yaml:"needs,omitempty"`
+
 	Assertions []string `yaml:"assert"`
+
+	// Matrix describes the matrix values this spec was expanded with, e.g. JAVA_VERSION=11.
+	// It is set by Suite.Specs and empty for specs of suites without a matrix.
+	Matrix string `yaml:"-"`
+}
+
+// Suite is a collection of test specs which share setup and teardown commands
+type Suite struct {
+	Before [][]string `yaml:"before,omitempty"`
+	After  [][]string `yaml:"after,omitempty"`
+
+	// Matrix maps environment variables to their values. Each test runs once per combination of values.
+	Matrix map[string][]string `yaml:"matrix,omitempty"`
+
+	Tests []*Spec `yaml:"tests"`
+}
+
+// ParseSuite parses a test suite. A suite is either a plain list of specs, or a mapping
+// with shared before/after commands and a list of tests. Unknown fields are rejected.
+func ParseSuite(fc []byte) (*Suite, error) {
+	return parseSuite(fc, true)
+}
+
+func parseSuite(fc []byte, strict bool) (*Suite, error) {
+	var (
+		res  Suite
+		root yaml.Node
+	)
+	err := yaml.Unmarshal(fc, &root)
+	if err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		// empty file - empty suite
+		return &res, nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(fc))
+	decoder.KnownFields(strict)
+	if root.Content[0].Kind == yaml.MappingNode {
+		err = decoder.Decode(&res)
+	} else {
+		err = decoder.Decode(&res.Tests)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Specs returns the tests of this suite with the suite's before/after commands applied to each spec.
+// If the suite has a matrix each spec is expanded into one spec per combination of matrix values.
+func (s *Suite) Specs() []*Spec {
+	if len(s.Before) == 0 && len(s.After) == 0 && len(s.Matrix) == 0 {
+		return s.Tests
+	}
+
+	combinations := s.matrixCombinations()
+	res := make([]*Spec, 0, len(s.Tests)*len(combinations))
+	for _, t := range s.Tests {
+		for _, env := range combinations {
+			spec := *t
+			spec.Before = concatCommands(s.Before, t.Before)
+			spec.After = concatCommands(t.After, s.After)
+			if len(env) > 0 {
+				spec.Matrix = strings.Join(env, ", ")
+				spec.Desc = fmt.Sprintf("%s [%s]", t.Desc, spec.Matrix)
+				// the spec's own env takes precedence over the matrix
+				spec.Env = append(append([]string{}, env...), t.Env...)
+				// needs refer to the tests of the same combination
+				if len(t.Needs) > 0 {
+					spec.Needs = make([]string, 0, len(t.Needs))
+					for _, n := range t.Needs {
+						spec.Needs = append(spec.Needs, fmt.Sprintf("%s [%s]", n, spec.Matrix))
+					}
+				}
+			}
+			res = append(res, &spec)
+		}
+	}
+	return res
+}
+
+// matrixCombinations produces the env of each combination of matrix values in a stable order.
+// Without a matrix there is a single, empty combination.
+func (s *Suite) matrixCombinations() [][]string {
+	keys := make([]string, 0, len(s.Matrix))
+	for k := range s.Matrix {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	res := [][]string{nil}
+	for _, k := range keys {
+		next := make([][]string, 0, len(res)*len(s.Matrix[k]))
+		for _, env := range res {
+			for _, v := range s.Matrix[k] {
+				next = append(next, append(append([]string{}, env...), k+"="+v))
+			}
+		}
+		res = next
+	}
+	return res
+}
+
+func concatCommands(a, b [][]string) [][]string {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+
+	res := make([][]string, 0, len(a)+len(b))
+	res = append(res, a...)
+	res = append(res, b...)
+	return res
+}
+
+// MarshalYAML produces the plain list form if the suite has no shared commands or matrix
+func (s *Suite) MarshalYAML() (interface{}, error) {
+	if len(s.Before) == 0 && len(s.After) == 0 && len(s.Matrix) == 0 {
+		return s.Tests, nil
+	}
+
+	type suite Suite
+	return (*suite)(s), nil
 }
 
 // Result is the result of a test
 type Result struct {
-	XMLName xml.Name `xml:"testsuite"`
+	Desc   string `yaml:"desc"`
+	Matrix string `yaml:"matrix,omitempty"`
 
-	Desc string `yaml:"desc" xml:"name,attr"`
+	Skipped bool `yaml:"skipped,omitempty"`
+	// SkipReason explains why a test was skipped other than being marked skip, e.g. because a test it needs failed
+	SkipReason string        `yaml:"skipReason,omitempty"`
+	Error      *ErrResult    `yaml:"error,omitempty"`
+	Failure    *ErrResult    `yaml:"failure,omitempty"`
+	Duration   time.Duration `yaml:"duration,omitempty"`
 
-	Skipped bool       `yaml:"skipped,omitempty" xml:"skippped"`
-	Error   *ErrResult `yaml:"error,omitempty" xml:"error"`
-	Failure *ErrResult `yaml:"failure,omitempty" xml:"failure"`
+	// ExpectedFailure is true if the command failed as the spec expected
+	ExpectedFailure bool `yaml:"expectedFailure,omitempty"`
 
 	*RunResult
 }
 
 // ErrResult indicates failure
 type ErrResult struct {
-	Message string `yaml:"message" xml:"message,attr"`
-	Type    string `yaml:"type" xml:"type,attr"`
+	Message string `yaml:"message"`
+	Type    string `yaml:"type"`
 }
 
 // Results is a collection of test results
 type Results struct {
-	XMLName xml.Name `xml:"testsuites"`
+	// Name of the suite these results belong to, e.g. the chunk name
+	Name string `yaml:"name,omitempty"`
 
-	Result []*Result `yaml:"results" xml:"testsuite"`
+	Result []*Result `yaml:"results"`
 }
 
 // Executor can run test commands in some environment
@@ -67,16 +227,97 @@ type Executor interface {
 
 // RunResult is the direct output produced by a test container
 type RunResult struct {
-	Stdout     []byte `yaml:"stdout,omitempty" xml:"system-out,omitempty"`
-	Stderr     []byte `yaml:"stderr,omitempty" xml:"system-err,omitempty"`
-	StatusCode int64  `yaml:"statusCode" xml:"-"`
+	Stdout     []byte `yaml:"stdout,omitempty"`
+	Stderr     []byte `yaml:"stderr,omitempty"`
+	StatusCode int64  `yaml:"statusCode"`
+	// Signal is set if the command was killed by a signal, e.g. by the OOM killer, rather than exiting
+	Signal string `yaml:"signal,omitempty"`
+
+	// Files contains the state of all files referenced using file() in the assertions
+	Files map[string]*FileInfo `yaml:"files,omitempty"`
+
+	// Image contains the configuration of the image under test for image specs
+	Image *ImageInfo `yaml:"image,omitempty"`
+
+	// RunnerError is set if the test runner itself failed, as opposed to the command under test
+	RunnerError string `yaml:"runnerError,omitempty"`
+
+	// TeardownError is set if the after commands of the spec failed. The result of the test command is kept.
+	TeardownError string `yaml:"teardownError,omitempty"`
 }
 
 // LocalExecutor executes tests against the current, local environment
 type LocalExecutor struct{}
 
-// Run executes the test
-func (LocalExecutor) Run(ctx context.Context, s *Spec) (res *RunResult, err error) {
+// Run executes the test. The teardown commands run even if the setup or the test command fail.
+// If the teardown fails the result of the test command is kept and the failure reported in TeardownError.
+func (e LocalExecutor) Run(ctx context.Context, s *Spec) (res *RunResult, err error) {
+	defer func() {
+		terr := e.teardown(ctx, s)
+		switch {
+		case terr == nil:
+		case err != nil:
+			err = fmt.Errorf("%w (%v)", err, terr)
+		default:
+			res.TeardownError = terr.Error()
+		}
+	}()
+
+	for _, c := range s.Before {
+		r, err := e.run(ctx, s, c, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot run setup command %v: %w", c, err)
+		}
+		if r.StatusCode != 0 {
+			return nil, fmt.Errorf("setup command %v failed with status %d: %s", c, r.StatusCode, string(r.Stderr))
+		}
+	}
+
+	res, err = e.run(ctx, s, s.Command, s.Entrypoint)
+	if err != nil {
+		return nil, err
+	}
+	res.Files = statFiles(s.Assertions)
+	return res, nil
+}
+
+// teardownTimeout is the time the teardown commands get if the test ran out of time already
+const teardownTimeout = 30 * time.Second
+
+// teardown runs the after commands of a spec, all of them even if one fails
+func (e LocalExecutor) teardown(ctx context.Context, s *Spec) error {
+	if len(s.After) == 0 {
+		return nil
+	}
+	if ctx.Err() != nil {
+		// cleaning up matters most when the test timed out
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), teardownTimeout)
+		defer cancel()
+	}
+
+	var errs []string
+	for _, c := range s.After {
+		r, err := e.run(ctx, s, c, nil)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("cannot run teardown command %v: %v", c, err))
+			continue
+		}
+		if r.StatusCode != 0 {
+			errs = append(errs, fmt.Sprintf("teardown command %v failed with status %d: %s", c, r.StatusCode, string(r.Stderr)))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (LocalExecutor) run(ctx context.Context, s *Spec, command, entrypoint []string) (res *RunResult, err error) {
+	if len(command) == 0 && len(entrypoint) == 0 {
+		return nil, fmt.Errorf("command is empty")
+	}
+
 	env := os.Environ()
 	for _, envvar := range s.Env {
 		segs := strings.Split(envvar, "=")
@@ -103,46 +344,45 @@ func (LocalExecutor) Run(ctx context.Context, s *Spec) (res *RunResult, err erro
 	}
 
 	var cmd *exec.Cmd
-	if len(s.Entrypoint) > 0 {
+	if len(entrypoint) > 0 {
 		var args []string
-		args = append(args, s.Entrypoint[1:]...)
-		args = append(args, s.Command...)
-		cmd = exec.Command(s.Entrypoint[0], args...)
+		args = append(args, entrypoint[1:]...)
+		args = append(args, command...)
+		cmd = exec.CommandContext(ctx, entrypoint[0], args...)
 	} else {
-		cmd = exec.Command(s.Command[0], s.Command[1:]...)
+		cmd = exec.CommandContext(ctx, command[0], command[1:]...)
 	}
 	cmd.Env = env
 	stdout, stderr := bytes.NewBuffer([]byte{}), bytes.NewBuffer([]byte{})
 	if s.User != "" {
-		user, err := user.LookupId(s.User)
-		if err != nil {
-			return nil, err
-		}
-		uid, err := strconv.ParseUint(user.Uid, 10, 32)
-		if err != nil {
-			return nil, err
-		}
-		gid, err := strconv.ParseUint(user.Gid, 10, 32)
+		cred, err := resolveUser(s.User)
 		if err != nil {
 			return nil, err
 		}
-		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
 	}
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 
-	if len(s.Entrypoint) > 0 {
-		_, err := pty.Start(cmd)
-		if err != nil {
-			return nil, err
-		}
+	if len(entrypoint) > 0 {
+		_, err = pty.Start(cmd)
 	} else {
 		err = cmd.Start()
-		if err != nil {
-			return nil, err
-		}
+	}
+	if err != nil && s.ExpectFailure && (errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist)) {
+		// the command does not exist - which is what the test expects. Report this like a shell would.
+		return &RunResult{
+			Stderr:     []byte(err.Error()),
+			StatusCode: 127,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("command %v did not complete: %w", cmd.Args, ctx.Err())
+	}
 	if _, ok := err.(*exec.ExitError); ok {
 		// the command exited with non-zero exit code - that's no reason to fail here
 		err = nil
@@ -155,41 +395,132 @@ func (LocalExecutor) Run(ctx context.Context, s *Spec) (res *RunResult, err erro
 		Stderr:     stderr.Bytes(),
 		StatusCode: int64(cmd.ProcessState.ExitCode()),
 	}
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		res.Signal = ws.Signal().String()
+	}
+	res.truncate(s.maxOutput())
 	return
 }
 
-// RunTests executes a series of tests
-func RunTests(ctx context.Context, executor Executor, tests []*Spec) (res Results, success bool) {
+// DefaultTimeout is the time a single test may take unless configured otherwise
+const DefaultTimeout = 5 * time.Minute
+
+type runOpts struct {
+	Timeout time.Duration
+	Logger  log.FieldLogger
+}
+
+// RunOpt configures how tests are run
+type RunOpt func(*runOpts)
+
+// WithTimeout limits the time each test may take. Zero means DefaultTimeout.
+func WithTimeout(timeout time.Duration) RunOpt {
+	return func(o *runOpts) {
+		if timeout != 0 {
+			o.Timeout = timeout
+		}
+	}
+}
+
+// WithLogger makes the test run log to l instead of the standard logger
+func WithLogger(l log.FieldLogger) RunOpt {
+	return func(o *runOpts) {
+		o.Logger = l
+	}
+}
+
+// RunTests executes a series of tests. Tests run after the tests they need and are skipped if one of those
+// did not pass. Once ctx is done all remaining tests fail with an error.
+func RunTests(ctx context.Context, executor Executor, tests []*Spec, opts ...RunOpt) (res Results, success bool) {
+	options := runOpts{
+		Timeout: DefaultTimeout,
+		Logger:  log.StandardLogger(),
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
 	success = true
 
-	var results []*Result
-	for i, tst := range tests {
+	var (
+		results       []*Result
+		passed        = make(map[string]bool, len(tests))
+		order, cycles = orderByNeeds(tests)
+	)
+	for _, i := range order {
+		tst := tests[i]
+		if ctx.Err() != nil {
+			results = append(results, &Result{
+				Desc:   tst.Desc,
+				Matrix: tst.Matrix,
+				Error: &ErrResult{
+					Message: fmt.Sprintf("not run: %v", ctx.Err()),
+					Type:    "runtime",
+				},
+			})
+			success = false
+			continue
+		}
+		if _, ok := cycles[i]; ok {
+			results = append(results, &Result{
+				Desc:   tst.Desc,
+				Matrix: tst.Matrix,
+				Error: &ErrResult{
+					Message: "not run: part of a dependency cycle of needs",
+					Type:    "runtime",
+				},
+			})
+			passed[tst.Desc] = false
+			success = false
+			continue
+		}
+		if need := unmetNeed(tst, passed); need != "" {
+			options.Logger.WithField("step", i).Warnf("skipping \"%s\" because \"%s\" did not pass", tst.Desc, need)
+			results = append(results, &Result{
+				Desc:       tst.Desc,
+				Matrix:     tst.Matrix,
+				Skipped:    true,
+				SkipReason: fmt.Sprintf("needs %q which did not pass", need),
+			})
+			passed[tst.Desc] = false
+			continue
+		}
+
 		if tst.Skip {
-			log.WithField("step", i).Warnf("skipping \"%s\"", tst.Desc)
+			options.Logger.WithField("step", i).Warnf("skipping \"%s\"", tst.Desc)
 		} else {
-			log.WithField("step", i).WithField("command", tst.Command).Infof("testing \"%s\"", tst.Desc)
+			options.Logger.WithField("step", i).WithField("command", tst.Command).Infof("testing \"%s\"", tst.Desc)
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-		r := tst.Run(ctx, executor)
+		tctx, cancel := context.WithTimeout(ctx, options.Timeout)
+		r := tst.Run(tctx, options.Logger.WithField("step", i), executor)
 		results = append(results, r)
 		cancel()
+		passed[tst.Desc] = r.Error == nil && r.Failure == nil && !r.Skipped
 
 		if r.Error != nil {
 			success = false
-			log.WithField("emoji", "🐲").WithField("message", r.Error.Message).Error("error")
+			options.Logger.WithField("emoji", "🐲").WithField("message", r.Error.Message).Error("error")
 			continue
 		}
 		if r.Failure != nil {
 			success = false
-			log.WithField("result", repr.String(r.RunResult)).WithField("message", r.Failure.Message).Error("failed")
+			options.Logger.WithField("result", repr.String(r.RunResult)).WithField("message", r.Failure.Message).Error("failed")
 			continue
 		}
 		if r.Skipped {
+			if r.SkipReason != "" {
+				options.Logger.WithField("reason", r.SkipReason).Warnf("skipped \"%s\"", tst.Desc)
+			}
 			continue
 		}
 
-		log.Info("passed")
+		if r.ExpectedFailure {
+			options.Logger.WithField("status", r.StatusCode).Info("passed (failed as expected)")
+			continue
+		}
+
+		options.Logger.Info("passed")
 		continue
 	}
 
@@ -197,17 +528,56 @@ func RunTests(ctx context.Context, executor Executor, tests []*Spec) (res Result
 	return
 }
 
-// Run executes the test
-func (s *Spec) Run(ctx context.Context, executor Executor) (res *Result) {
+// SkipTests produces the results of tests which were not run for the reason given
+func SkipTests(tests []*Spec, reason string) Results {
+	res := make([]*Result, len(tests))
+	for i, tst := range tests {
+		res[i] = &Result{
+			Desc:       tst.Desc,
+			Matrix:     tst.Matrix,
+			Skipped:    true,
+			SkipReason: reason,
+		}
+	}
+	return Results{Result: res}
+}
+
+// Run executes the test, logging the assertions it checks to logger
+func (s *Spec) Run(ctx context.Context, logger log.FieldLogger, executor Executor) (res *Result) {
 	res = &Result{
 		Desc:    s.Desc,
+		Matrix:  s.Matrix,
 		Skipped: s.Skip,
 	}
 	if s.Skip {
 		return
 	}
 
-	runres, err := executor.Run(ctx, s)
+	start := time.Now()
+	defer func() {
+		res.Duration = time.Since(start)
+	}()
+
+	var (
+		runres *RunResult
+		err    error
+	)
+	switch s.Kind {
+	case SpecKindImage:
+		runres, err = inspectImage(ctx, executor)
+	case SpecKindService:
+		sr, ok := executor.(ServiceRunner)
+		if !ok {
+			res.Skipped = true
+			res.SkipReason = "service tests are not supported when running tests this way"
+			return
+		}
+		runres, err = runService(ctx, sr, s)
+	case SpecKindCommand, "":
+		runres, err = executor.Run(ctx, s)
+	default:
+		err = fmt.Errorf("unknown spec kind: %s", s.Kind)
+	}
 	if err != nil {
 		res.Error = &ErrResult{
 			Message: err.Error(),
@@ -216,8 +586,38 @@ func (s *Spec) Run(ctx context.Context, executor Executor) (res *Result) {
 		return
 	}
 
+	// executors might not truncate the output themselves
+	runres.truncate(s.maxOutput())
 	res.RunResult = runres
-	err = ValidateAssertions(res, s.Assertions, runres)
+	if runres.RunnerError != "" {
+		res.Error = &ErrResult{
+			Message: runres.RunnerError,
+			Type:    "runtime",
+		}
+		return
+	}
+	if s.ExpectFailure && s.Kind != SpecKindImage {
+		// only a command which exits by itself fails as expected - a crash, OOM kill or timeout does not
+		var msg string
+		switch {
+		case runres.Signal != "":
+			msg = fmt.Sprintf("expected command to fail but it was killed: %s", runres.Signal)
+		case runres.StatusCode < 0:
+			msg = fmt.Sprintf("expected command to fail but it did not exit normally: status %d", runres.StatusCode)
+		case runres.StatusCode == 0:
+			msg = "expected command to fail but it exited with status 0"
+		}
+		if msg != "" {
+			res.Failure = &ErrResult{
+				Message: msg,
+				Type:    "expectFailure",
+			}
+			return
+		}
+		res.ExpectedFailure = true
+	}
+
+	err = ValidateAssertions(logger, res, s.Assertions, runres)
 	if err != nil {
 		res.Error = &ErrResult{
 			Message: err.Error(),
@@ -225,34 +625,42 @@ func (s *Spec) Run(ctx context.Context, executor Executor) (res *Result) {
 		}
 		return
 	}
+	if runres.TeardownError != "" {
+		// a failed teardown must not hide why the test itself failed
+		if res.Failure != nil {
+			res.Failure.Message += "; " + runres.TeardownError
+		} else {
+			res.Error = &ErrResult{
+				Message: runres.TeardownError,
+				Type:    "teardown",
+			}
+		}
+	}
 
 	return
 }
 
-// ValidateAssertions runs the assertions of a test spec against a run result and sets the result appropriately
-func ValidateAssertions(res *Result, assertions []string, runres *RunResult) error {
-	vm := otto.New()
-	_ = vm.Set("stdout", string(runres.Stdout))
-	_ = vm.Set("stderr", string(runres.Stderr))
-	_ = vm.Set("status", runres.StatusCode)
+// ValidateAssertions runs the assertions of a test spec against a run result and sets the result appropriately.
+// Each assertion is logged to logger at debug level before it runs.
+func ValidateAssertions(logger log.FieldLogger, res *Result, assertions []string, runres *RunResult) error {
+	vm, err := newAssertionVM(runres)
+	if err != nil {
+		return err
+	}
 
 	for _, assertion := range assertions {
-		log.Debugf("- %s", assertion)
+		logger.Debugf("- %s", assertion)
 
-		val, err := vm.Run(assertion)
+		val, err := vm.RunString(assertion)
 		if err != nil {
 			return err
 		}
 
-		if !val.IsBoolean() {
+		passed, ok := val.Export().(bool)
+		if !ok {
 			return fmt.Errorf("assertion must evaluate to boolean value")
 		}
 
-		passed, err := val.ToBoolean()
-		if err != nil {
-			return err
-		}
-
 		if !passed {
 			res.Failure = &ErrResult{
 				Message: fmt.Sprintf("assertion failed: %s", assertion),
@@ -263,3 +671,51 @@ func ValidateAssertions(res *Result, assertions []string, runres *RunResult) err
 
 	return nil
 }
+
+// newAssertionVM produces the JavaScript runtime assertions are evaluated in
+func newAssertionVM(runres *RunResult) (*goja.Runtime, error) {
+	vm := goja.New()
+	_ = vm.Set("stdout", string(runres.Stdout))
+	_ = vm.Set("stderr", string(runres.Stderr))
+	_ = vm.Set("status", runres.StatusCode)
+
+	// matches(s, pattern) reports whether s contains a match of the (Go syntax) regular expression
+	_ = vm.Set("matches", func(call goja.FunctionCall) goja.Value {
+		re, err := regexp.Compile(call.Argument(1).String())
+		if err != nil {
+			panic(vm.NewGoError(err))
+		}
+		return vm.ToValue(re.MatchString(call.Argument(0).String()))
+	})
+	// json(s) parses s as JSON, e.g. json(stdout).version == "1.0"
+	_ = vm.Set("json", func(call goja.FunctionCall) goja.Value {
+		var v interface{}
+		err := json.Unmarshal([]byte(call.Argument(0).String()), &v)
+		if err != nil {
+			panic(vm.NewGoError(fmt.Errorf("cannot parse JSON: %w", err)))
+		}
+		return vm.ToValue(v)
+	})
+
+	files, err := marshalFiles(runres.Files)
+	if err != nil {
+		return nil, err
+	}
+	_ = vm.Set("__files", files)
+	_, err = vm.RunString(fileHelper)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := marshalImage(runres.Image)
+	if err != nil {
+		return nil, err
+	}
+	_ = vm.Set("__image", img)
+	_, err = vm.RunString(imageHelper)
+	if err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
@@ -0,0 +1,408 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestParseSuite(t *testing.T) {
+	type Expectation struct {
+		Suite *Suite
+		Err   string
+	}
+	tests := []struct {
+		Name        string
+		Input       string
+		Expectation Expectation
+	}{
+		{
+			Name:        "empty",
+			Input:       "",
+			Expectation: Expectation{Suite: &Suite{}},
+		},
+		{
+			Name:  "list of specs",
+			Input: "- desc: a\n  command: [true]\n",
+			Expectation: Expectation{Suite: &Suite{
+				Tests: []*Spec{{Desc: "a", Command: []string{"true"}}},
+			}},
+		},
+		{
+			Name:  "suite",
+			Input: "before:\n- [mkdir, /tmp/x]\nmatrix:\n  V: [\"1\", \"2\"]\ntests:\n- desc: a\n  command: [true]\n",
+			Expectation: Expectation{Suite: &Suite{
+				Before: [][]string{{"mkdir", "/tmp/x"}},
+				Matrix: map[string][]string{"V": {"1", "2"}},
+				Tests:  []*Spec{{Desc: "a", Command: []string{"true"}}},
+			}},
+		},
+		{
+			Name:  "unknown field",
+			Input: "- desc: a\n  comand: [true]\n",
+			Expectation: Expectation{
+				Err: "yaml: unmarshal errors:\n  line 2: field comand not found in type test.Spec",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var act Expectation
+			suite, err := ParseSuite([]byte(test.Input))
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Suite = suite
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("ParseSuite() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSuite_Specs(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Suite       Suite
+		Expectation []*Spec
+	}{
+		{
+			Name:        "plain",
+			Suite:       Suite{Tests: []*Spec{{Desc: "a"}}},
+			Expectation: []*Spec{{Desc: "a"}},
+		},
+		{
+			Name: "before and after",
+			Suite: Suite{
+				Before: [][]string{{"suite-before"}},
+				After:  [][]string{{"suite-after"}},
+				Tests:  []*Spec{{Desc: "a", Before: [][]string{{"before"}}, After: [][]string{{"after"}}}},
+			},
+			Expectation: []*Spec{{
+				Desc:   "a",
+				Before: [][]string{{"suite-before"}, {"before"}},
+				After:  [][]string{{"after"}, {"suite-after"}},
+			}},
+		},
+		{
+			Name: "matrix",
+			Suite: Suite{
+				Matrix: map[string][]string{"JAVA": {"11", "17"}, "ARCH": {"amd64"}},
+				Tests: []*Spec{
+					{Desc: "build", Env: []string{"JAVA=8"}},
+					{Desc: "run", Needs: []string{"build"}},
+				},
+			},
+			Expectation: []*Spec{
+				{Desc: "build [ARCH=amd64, JAVA=11]", Matrix: "ARCH=amd64, JAVA=11", Env: []string{"ARCH=amd64", "JAVA=11", "JAVA=8"}},
+				{Desc: "build [ARCH=amd64, JAVA=17]", Matrix: "ARCH=amd64, JAVA=17", Env: []string{"ARCH=amd64", "JAVA=17", "JAVA=8"}},
+				{Desc: "run [ARCH=amd64, JAVA=11]", Matrix: "ARCH=amd64, JAVA=11", Env: []string{"ARCH=amd64", "JAVA=11"}, Needs: []string{"build [ARCH=amd64, JAVA=11]"}},
+				{Desc: "run [ARCH=amd64, JAVA=17]", Matrix: "ARCH=amd64, JAVA=17", Env: []string{"ARCH=amd64", "JAVA=17"}, Needs: []string{"build [ARCH=amd64, JAVA=17]"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act := test.Suite.Specs()
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("Specs() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLocalExecutor_teardown(t *testing.T) {
+	type Expectation struct {
+		Stdout        string
+		TeardownError string
+		Err           string
+		TornDown      bool
+	}
+	tests := []struct {
+		Name        string
+		Spec        Spec
+		Expectation Expectation
+	}{
+		{
+			Name: "passes",
+			Spec: Spec{Command: []string{"echo", "hello"}},
+			Expectation: Expectation{
+				Stdout:   "hello\n",
+				TornDown: true,
+			},
+		},
+		{
+			Name: "command cannot run",
+			Spec: Spec{Command: []string{"/does/not/exist"}},
+			Expectation: Expectation{
+				Err:      "fork/exec /does/not/exist: no such file or directory",
+				TornDown: true,
+			},
+		},
+		{
+			Name: "setup fails",
+			Spec: Spec{Command: []string{"echo", "hello"}, Before: [][]string{{"sh", "-c", "echo broken >&2; exit 3"}}},
+			Expectation: Expectation{
+				Err:      "setup command [sh -c echo broken >&2; exit 3] failed with status 3: broken\n",
+				TornDown: true,
+			},
+		},
+		{
+			Name: "teardown fails",
+			Spec: Spec{Command: []string{"echo", "hello"}, After: [][]string{{"sh", "-c", "exit 4"}}},
+			Expectation: Expectation{
+				Stdout:        "hello\n",
+				TeardownError: "teardown command [sh -c exit 4] failed with status 4: ",
+				TornDown:      true,
+			},
+		},
+		{
+			Name: "command and teardown fail",
+			Spec: Spec{Command: []string{"/does/not/exist"}, After: [][]string{{"sh", "-c", "exit 4"}}},
+			Expectation: Expectation{
+				Err:      "fork/exec /does/not/exist: no such file or directory (teardown command [sh -c exit 4] failed with status 4: )",
+				TornDown: true,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			marker := filepath.Join(t.TempDir(), "torn-down")
+			spec := test.Spec
+			spec.After = append([][]string{{"touch", marker}}, spec.After...)
+
+			var act Expectation
+			res, err := LocalExecutor{}.Run(context.Background(), &spec)
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Stdout = string(res.Stdout)
+				act.TeardownError = res.TeardownError
+			}
+			_, err = os.Stat(marker)
+			act.TornDown = err == nil
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("Run() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSpec_Run_teardown(t *testing.T) {
+	type Expectation struct {
+		Failure *ErrResult
+		Error   *ErrResult
+	}
+	tests := []struct {
+		Name        string
+		Spec        Spec
+		Expectation Expectation
+	}{
+		{
+			Name: "teardown fails",
+			Spec: Spec{Command: []string{"echo", "hello"}, After: [][]string{{"false"}}, Assertions: []string{"status == 0"}},
+			Expectation: Expectation{
+				Error: &ErrResult{Message: "teardown command [false] failed with status 1: ", Type: "teardown"},
+			},
+		},
+		{
+			Name: "assertion and teardown fail",
+			Spec: Spec{Command: []string{"echo", "hello"}, After: [][]string{{"false"}}, Assertions: []string{"status == 1"}},
+			Expectation: Expectation{
+				Failure: &ErrResult{Message: "assertion failed: status == 1; teardown command [false] failed with status 1: "},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			res := test.Spec.Run(context.Background(), log.StandardLogger(), LocalExecutor{})
+			act := Expectation{Failure: res.Failure, Error: res.Error}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("Run() mismatch (-want +got):\n%s", diff)
+			}
+			if res.RunResult == nil || string(res.Stdout) != "hello\n" {
+				t.Errorf("Run() dropped the result of the test command: %v", res.RunResult)
+			}
+		})
+	}
+}
+
+func TestValidateAssertions(t *testing.T) {
+	type Expectation struct {
+		Failure *ErrResult
+		Err     string
+	}
+	runres := &RunResult{
+		Stdout:     []byte("{\"version\": \"1.0\", \"go\": \"go1.21.3\"}\n"),
+		Stderr:     []byte("openjdk version \"1.8.0_312\"\n"),
+		StatusCode: 0,
+		Files: map[string]*FileInfo{
+			"/etc/foo.conf": {Exists: true, Mode: "0644", Owner: "root", Content: "enabled=true\n"},
+		},
+	}
+	tests := []struct {
+		Name        string
+		Assertions  []string
+		Expectation Expectation
+	}{
+		{
+			Name: "existing assertions",
+			Assertions: []string{
+				"status == 0",
+				"stdout.indexOf(\"version\") != -1",
+				"stderr.indexOf(\"1.8.0_312\") != -1",
+				"stdout.trim().length > 0",
+				"stderr.split(\"\\n\").length == 2",
+			},
+		},
+		{
+			Name:        "failed assertion stops evaluation",
+			Assertions:  []string{"status == 1", "undefinedVariable"},
+			Expectation: Expectation{Failure: &ErrResult{Message: "assertion failed: status == 1"}},
+		},
+		{
+			Name:        "non-boolean assertion",
+			Assertions:  []string{"stdout"},
+			Expectation: Expectation{Err: "assertion must evaluate to boolean value"},
+		},
+		{
+			Name:        "syntax error",
+			Assertions:  []string{"status =="},
+			Expectation: Expectation{Err: "SyntaxError"},
+		},
+		{
+			Name:       "matches",
+			Assertions: []string{"matches(stdout, \"go1\\\\.2[0-9]\")", "!matches(stdout, \"^go\")"},
+		},
+		{
+			Name:        "matches without match",
+			Assertions:  []string{"matches(stdout, \"go1\\\\.1[0-9]\")"},
+			Expectation: Expectation{Failure: &ErrResult{Message: "assertion failed: matches(stdout, \"go1\\\\.1[0-9]\")"}},
+		},
+		{
+			Name:        "matches with invalid regex",
+			Assertions:  []string{"matches(stdout, \"go(\")"},
+			Expectation: Expectation{Err: "error parsing regexp: missing closing ): `go(`"},
+		},
+		{
+			Name:       "json",
+			Assertions: []string{"json(stdout).version == \"1.0\"", "json(\"[1, 2]\").length == 2"},
+		},
+		{
+			Name:        "json with invalid input",
+			Assertions:  []string{"json(stderr).version == \"1.0\""},
+			Expectation: Expectation{Err: "cannot parse JSON: invalid character 'o' looking for beginning of value"},
+		},
+		{
+			Name: "file",
+			Assertions: []string{
+				"file(\"/etc/foo.conf\").exists",
+				"file(\"/etc/foo.conf\").mode == \"0644\"",
+				"file(\"/etc/foo.conf\").contains(\"enabled=true\")",
+				"!file(\"/etc/missing\").exists",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var (
+				act Expectation
+				res Result
+			)
+			err := ValidateAssertions(log.StandardLogger(), &res, test.Assertions, runres)
+			if err != nil {
+				act.Err = err.Error()
+				if test.Expectation.Err != "" && strings.Contains(act.Err, test.Expectation.Err) {
+					act.Err = test.Expectation.Err
+				}
+			}
+			act.Failure = res.Failure
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("ValidateAssertions() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSpec_Run_expectFailure(t *testing.T) {
+	type Expectation struct {
+		ExpectedFailure bool
+		Failure         *ErrResult
+		StatusCode      int64
+	}
+	tests := []struct {
+		Name        string
+		Command     []string
+		Expectation Expectation
+	}{
+		{
+			Name:        "exit 1",
+			Command:     []string{"sh", "-c", "exit 1"},
+			Expectation: Expectation{ExpectedFailure: true, StatusCode: 1},
+		},
+		{
+			Name:    "exit 0",
+			Command: []string{"true"},
+			Expectation: Expectation{
+				Failure: &ErrResult{Message: "expected command to fail but it exited with status 0", Type: "expectFailure"},
+			},
+		},
+		{
+			Name:    "killed by signal",
+			Command: []string{"sh", "-c", "kill -KILL $$"},
+			Expectation: Expectation{
+				Failure:    &ErrResult{Message: "expected command to fail but it was killed: killed", Type: "expectFailure"},
+				StatusCode: -1,
+			},
+		},
+		{
+			Name:        "missing binary",
+			Command:     []string{"/dazzle-test/does-not-exist"},
+			Expectation: Expectation{ExpectedFailure: true, StatusCode: 127},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			spec := Spec{Desc: test.Name, Command: test.Command, ExpectFailure: true}
+			res := spec.Run(context.Background(), log.StandardLogger(), LocalExecutor{})
+			if res.Error != nil {
+				t.Fatalf("Run() error = %v", res.Error)
+			}
+			act := Expectation{ExpectedFailure: res.ExpectedFailure, Failure: res.Failure, StatusCode: res.StatusCode}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("Run() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateAssertions_logger(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	logger.SetLevel(log.DebugLevel)
+
+	var res Result
+	err := ValidateAssertions(logger, &res, []string{"status == 0", `stdout == "foo"`}, &RunResult{Stdout: []byte("foo")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var act []string
+	for _, e := range hook.AllEntries() {
+		act = append(act, e.Message)
+	}
+	if diff := cmp.Diff([]string{"- status == 0", `- stdout == "foo"`}, act); diff != "" {
+		t.Errorf("ValidateAssertions() log mismatch (-want +got):\n%s", diff)
+	}
+}
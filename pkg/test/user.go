@@ -0,0 +1,94 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// resolveUser produces the credentials for a user as Docker and buildkit interpret it, i.e. as user,
+// uid, user:group, uid:gid or any mix thereof. Unless a group is given the user's primary group is used.
+// Like Docker, a numeric uid which is not in the user database runs with gid 0.
+func resolveUser(spec string) (*syscall.Credential, error) {
+	return resolveUserFrom(spec, osUserDB)
+}
+
+// userDB provides the users and groups resolveUser resolves names and ids against
+type userDB struct {
+	Lookup      func(name string) (*user.User, error)
+	LookupID    func(uid string) (*user.User, error)
+	LookupGroup func(name string) (*user.Group, error)
+	GroupIDs    func(u *user.User) ([]string, error)
+}
+
+var osUserDB = userDB{
+	Lookup:      user.Lookup,
+	LookupID:    user.LookupId,
+	LookupGroup: user.LookupGroup,
+	GroupIDs:    (*user.User).GroupIds,
+}
+
+func resolveUserFrom(spec string, db userDB) (*syscall.Credential, error) {
+	var (
+		segs = strings.SplitN(spec, ":", 2)
+		res  syscall.Credential
+		u    *user.User
+		err  error
+	)
+	if uid, perr := strconv.ParseUint(segs[0], 10, 32); perr == nil {
+		u, err = db.LookupID(segs[0])
+		if errors.As(err, new(user.UnknownUserIdError)) {
+			u, err = nil, nil
+			res.Uid = uint32(uid)
+		}
+	} else {
+		u, err = db.Lookup(segs[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve user %s: %w", segs[0], err)
+	}
+
+	if u != nil {
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		res.Uid, res.Gid = uint32(uid), uint32(gid)
+
+		groups, err := db.GroupIDs(u)
+		if err == nil {
+			for _, g := range groups {
+				gid, err := strconv.ParseUint(g, 10, 32)
+				if err != nil {
+					continue
+				}
+				res.Groups = append(res.Groups, uint32(gid))
+			}
+		}
+	}
+
+	if len(segs) == 2 {
+		group := segs[1]
+		gid, err := strconv.ParseUint(group, 10, 32)
+		if err != nil {
+			g, lerr := db.LookupGroup(group)
+			if lerr != nil {
+				return nil, fmt.Errorf("cannot resolve group %s: %w", group, lerr)
+			}
+			gid, err = strconv.ParseUint(g.Gid, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+		}
+		res.Gid = uint32(gid)
+	}
+
+	return &res, nil
+}
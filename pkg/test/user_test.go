@@ -0,0 +1,145 @@
+package test
+
+import (
+	"errors"
+	"os/user"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveUser(t *testing.T) {
+	var (
+		users = []*user.User{
+			{Uid: "0", Gid: "0", Username: "root"},
+			{Uid: "33333", Gid: "33333", Username: "gitpod"},
+			{Uid: "1000", Gid: "1000", Username: "nogroups"},
+		}
+		groups = []*user.Group{
+			{Gid: "0", Name: "root"},
+			{Gid: "33333", Name: "gitpod"},
+			{Gid: "999", Name: "docker"},
+		}
+		memberships = map[string][]string{
+			"root":   {"0"},
+			"gitpod": {"33333", "999"},
+		}
+	)
+	db := userDB{
+		Lookup: func(name string) (*user.User, error) {
+			for _, u := range users {
+				if u.Username == name {
+					return u, nil
+				}
+			}
+			return nil, user.UnknownUserError(name)
+		},
+		LookupID: func(uid string) (*user.User, error) {
+			for _, u := range users {
+				if u.Uid == uid {
+					return u, nil
+				}
+			}
+			id, _ := strconv.Atoi(uid)
+			return nil, user.UnknownUserIdError(id)
+		},
+		LookupGroup: func(name string) (*user.Group, error) {
+			for _, g := range groups {
+				if g.Name == name {
+					return g, nil
+				}
+			}
+			return nil, user.UnknownGroupError(name)
+		},
+		GroupIDs: func(u *user.User) ([]string, error) {
+			gids, ok := memberships[u.Username]
+			if !ok {
+				return nil, errors.New("no group memberships")
+			}
+			return gids, nil
+		},
+	}
+
+	type Expectation struct {
+		Credential *syscall.Credential
+		Err        string
+	}
+	tests := []struct {
+		Name        string
+		User        string
+		Expectation Expectation
+	}{
+		{
+			Name:        "name",
+			User:        "gitpod",
+			Expectation: Expectation{Credential: &syscall.Credential{Uid: 33333, Gid: 33333, Groups: []uint32{33333, 999}}},
+		},
+		{
+			Name:        "uid",
+			User:        "33333",
+			Expectation: Expectation{Credential: &syscall.Credential{Uid: 33333, Gid: 33333, Groups: []uint32{33333, 999}}},
+		},
+		{
+			Name:        "root",
+			User:        "root",
+			Expectation: Expectation{Credential: &syscall.Credential{Groups: []uint32{0}}},
+		},
+		{
+			Name:        "unknown uid",
+			User:        "4242",
+			Expectation: Expectation{Credential: &syscall.Credential{Uid: 4242}},
+		},
+		{
+			Name:        "without group memberships",
+			User:        "nogroups",
+			Expectation: Expectation{Credential: &syscall.Credential{Uid: 1000, Gid: 1000}},
+		},
+		{
+			Name:        "user and group names",
+			User:        "gitpod:docker",
+			Expectation: Expectation{Credential: &syscall.Credential{Uid: 33333, Gid: 999, Groups: []uint32{33333, 999}}},
+		},
+		{
+			Name:        "uid and gid",
+			User:        "4242:4343",
+			Expectation: Expectation{Credential: &syscall.Credential{Uid: 4242, Gid: 4343}},
+		},
+		{
+			Name:        "name and gid",
+			User:        "gitpod:0",
+			Expectation: Expectation{Credential: &syscall.Credential{Uid: 33333, Gid: 0, Groups: []uint32{33333, 999}}},
+		},
+		{
+			Name:        "uid and group name",
+			User:        "4242:docker",
+			Expectation: Expectation{Credential: &syscall.Credential{Uid: 4242, Gid: 999}},
+		},
+		{
+			Name:        "unknown user",
+			User:        "nobody",
+			Expectation: Expectation{Err: "cannot resolve user nobody: user: unknown user nobody"},
+		},
+		{
+			Name:        "unknown group",
+			User:        "gitpod:nogroup",
+			Expectation: Expectation{Err: "cannot resolve group nogroup: group: unknown group nogroup"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var act Expectation
+			cred, err := resolveUserFrom(test.User, db)
+			if err != nil {
+				act.Err = err.Error()
+			} else {
+				act.Credential = cred
+			}
+
+			if diff := cmp.Diff(test.Expectation, act); diff != "" {
+				t.Errorf("resolveUser() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}